@@ -1,19 +1,43 @@
 module cob/playground
 
+replace bitnet/binance_connector => ../../libs/binance_connector
+
+replace bitnet/bybit_connector => ../../libs/bybit_connector
+
+replace bitnet/bybit_ws_client => ../../libs/bybit_ws_client
+
+replace bitnet/coinbase_connector => ../../libs/coinbase_connector
+
+replace bitnet/exchange => ../../libs/exchange
+
+replace bitnet/kraken_connector => ../../libs/kraken_connector
+
 replace bitnet/kraken_market_data => ../../libs/kraken_market_data
 
 replace bitnet/kraken_ws_client => ../../libs/kraken_ws_client
 
+replace bitnet/market_data => ../../libs/market_data
+
+replace bitnet/market_recorder => ../../libs/market_recorder
+
 go 1.23.1
 
 require (
+	bitnet/binance_connector v0.0.0-00010101000000-000000000000
+	bitnet/bybit_connector v0.0.0-00010101000000-000000000000
+	bitnet/coinbase_connector v0.0.0-00010101000000-000000000000
+	bitnet/exchange v0.0.0-00010101000000-000000000000
 	bitnet/kraken_market_data v0.0.0-00010101000000-000000000000
+	bitnet/market_data v0.0.0-00010101000000-000000000000
+	bitnet/market_recorder v0.0.0-00010101000000-000000000000
 	github.com/joho/godotenv v1.5.1
 	github.com/nats-io/nats-server/v2 v2.10.23
 	github.com/nats-io/nats.go v1.37.0
 )
 
 require (
+	bitnet/bybit_ws_client v0.0.0-00010101000000-000000000000 // indirect
+	bitnet/kraken_connector v0.0.0-00010101000000-000000000000 // indirect
 	bitnet/kraken_ws_client v0.0.0-00010101000000-000000000000 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
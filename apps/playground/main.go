@@ -4,13 +4,21 @@ import (
 	"context"
 	"errors"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
 
+	_ "bitnet/binance_connector"
+	_ "bitnet/bybit_connector"
+	_ "bitnet/coinbase_connector"
+	"bitnet/exchange"
 	krakenMarketDataProvider "bitnet/kraken_market_data"
+	marketdata "bitnet/market_data"
+	marketrecorder "bitnet/market_recorder"
 )
 
 func runEmbeddedNatsServer(inProcess bool, enableLogging bool) (*server.Server, error) {
@@ -81,6 +89,33 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Normalized market data is captured for replay when RECORD_FILE is set
+	if recordFile := os.Getenv("RECORD_FILE"); recordFile != "" {
+		recorder, err := marketrecorder.NewRecorder(recordFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer recorder.Close()
+
+		if err := recorder.RecordNats(natsClient1, "market.>", "market_book.>", "market_trade.>", "market_info.>"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Bybit always runs, additional venues are enabled by connector name, e.g.
+	// CONNECTORS=binance,coinbase
+	for _, name := range strings.Split("bybit,"+os.Getenv("CONNECTORS"), ",") {
+		if name == "" {
+			continue
+		}
+
+		connector, err := exchange.Open(exchange.Config{Name: name})
+		if err != nil {
+			log.Fatal(err)
+		}
+		go marketdata.New(natsClient2, connector).Run(ctx, []string{"BTC/USDT"})
+	}
+
 	krakenMarketDataProvider := krakenMarketDataProvider.New(natsClient2)
 	// runKrakenWs(ctx, enabledPairs, cacheManager)
 	krakenMarketDataProvider.Run(ctx, []string{"BTC/USDT"})
@@ -0,0 +1,484 @@
+package binance_connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitnet/exchange"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	Name = "binance"
+
+	defaultWsUrl   = "wss://stream.binance.com:9443"
+	defaultRestUrl = "https://api.binance.com"
+
+	// Depth of the REST snapshot used to seed the local book, 1000 levels
+	// cost a request weight of 50.
+	snapshotLimit = 1000
+
+	// Pause before fetching a snapshot again after a failure.
+	snapshotRetryDelay = time.Second
+
+	// Diff events buffered per book while its snapshot is fetched.
+	maxBuffered = 1000
+)
+
+func init() {
+	exchange.Register(Name, New)
+}
+
+// BinanceConnector streams Binance spot market data. Trading is not
+// implemented, the connector is a market data adapter only.
+//
+// Options: ws_url and rest_url, falling back to BINANCE_WS_URL and
+// BINANCE_REST_API_URL and then to the public Binance endpoints.
+type BinanceConnector struct {
+	wsUrl      string
+	restUrl    string
+	httpClient *http.Client
+	mutex      sync.RWMutex
+	health     exchange.Health
+}
+
+func New(config exchange.Config) (exchange.ExchangeConnector, error) {
+	return &BinanceConnector{
+		wsUrl:      config.Option("ws_url", envOr("BINANCE_WS_URL", defaultWsUrl)),
+		restUrl:    config.Option("rest_url", envOr("BINANCE_REST_API_URL", defaultRestUrl)),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// streamEnvelope is the wrapper of combined stream payloads.
+type streamEnvelope struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type depthUpdate struct {
+	EventType     string      `json:"e"`
+	EventTime     int64       `json:"E"`
+	Symbol        string      `json:"s"`
+	FirstUpdateId int64       `json:"U"`
+	FinalUpdateId int64       `json:"u"`
+	Bids          [][2]string `json:"b"`
+	Asks          [][2]string `json:"a"`
+}
+
+type depthSnapshot struct {
+	LastUpdateId int64       `json:"lastUpdateId"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+}
+
+type trade struct {
+	EventType    string `json:"e"`
+	EventTime    int64  `json:"E"` // Without it "E" would be decoded into "e", keys match case-insensitively
+	Symbol       string `json:"s"`
+	TradeId      int64  `json:"t"`
+	Price        string `json:"p"`
+	Qty          string `json:"q"`
+	TradeTime    int64  `json:"T"`
+	BuyerIsMaker bool   `json:"m"`
+	Ignore       bool   `json:"M"` // Same reason, "M" would overwrite "m"
+}
+
+type bookTicker struct {
+	UpdateId int64  `json:"u"`
+	Symbol   string `json:"s"`
+	Bid      string `json:"b"`
+	BidQty   string `json:"B"`
+	Ask      string `json:"a"`
+	AskQty   string `json:"A"`
+}
+
+// binanceSymbol converts "BTC/USDT" to "BTCUSDT".
+func binanceSymbol(symbol string) string {
+	return strings.ReplaceAll(symbol, "/", "")
+}
+
+func (b *BinanceConnector) Name() string {
+	return Name
+}
+
+func (b *BinanceConnector) Health() exchange.Health {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.health
+}
+
+func (b *BinanceConnector) setHealth(connected bool, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.health.Connected = connected
+	if connected {
+		b.health.LastMessage = time.Now()
+	}
+	if err != nil {
+		b.health.Error = err.Error()
+	}
+}
+
+func (b *BinanceConnector) dial(ctx context.Context, streams []string) (*websocket.Conn, error) {
+	url := b.wsUrl + "/stream?streams=" + strings.Join(streams, "/")
+
+	for {
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+		if err == nil {
+			go func() {
+				<-ctx.Done()
+				conn.Close()
+			}()
+
+			return conn, nil
+		}
+
+		fmt.Printf("websocket connection failed: %v, retrying...\n", err)
+		b.setHealth(false, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second): // TODO: implement exponential backoff (?)
+		}
+	}
+}
+
+func (b *BinanceConnector) SubscribeMarketData(ctx context.Context, symbols []string) (<-chan exchange.MarketData, error) {
+	streams := []string{}
+	syncs := make(map[string]*bookSync)
+	tickers := make(map[string]*exchange.Ticker)
+	for _, symbol := range symbols {
+		stream := strings.ToLower(binanceSymbol(symbol))
+		streams = append(streams, stream+"@depth@100ms", stream+"@trade", stream+"@bookTicker")
+		syncs[binanceSymbol(symbol)] = newBookSync(symbol)
+		tickers[binanceSymbol(symbol)] = &exchange.Ticker{Symbol: symbol}
+	}
+
+	conn, err := b.dial(ctx, streams)
+	if err != nil {
+		return nil, err
+	}
+
+	marketData := make(chan exchange.MarketData, 20)
+	frames := b.read(ctx, conn, streams)
+	snapshots := make(chan fetchedSnapshot)
+
+	go func() {
+		defer close(marketData)
+
+		send := func(data exchange.MarketData) bool {
+			select {
+			case marketData <- data:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		sendBook := func(bookUpdates []*exchange.BookUpdate) bool {
+			for _, bookUpdate := range bookUpdates {
+				if !send(exchange.MarketData{Venue: Name, Type: exchange.BookData, Book: bookUpdate}) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for {
+			var message frame
+			select {
+			case <-ctx.Done():
+				return
+			case fetched := <-snapshots:
+				bookUpdates, fetch := syncs[fetched.symbol].applySnapshot(fetched.snapshot, fetched.err)
+				if fetch {
+					b.fetchSnapshot(ctx, fetched.symbol, snapshotRetryDelay, snapshots)
+				}
+				if !sendBook(bookUpdates) {
+					return
+				}
+				continue
+			case message = <-frames:
+			}
+
+			if message.reconnected {
+				// The depth stream restarts, every book needs a new snapshot.
+				for _, bs := range syncs {
+					bs.reset()
+				}
+				continue
+			}
+
+			var envelope streamEnvelope
+			if err := json.Unmarshal(message.data, &envelope); err != nil {
+				log.Printf("error unmarshalling binance message: %v\n", err)
+				continue
+			}
+
+			switch {
+			case strings.HasSuffix(envelope.Stream, "@depth@100ms"):
+				var update depthUpdate
+				if err := json.Unmarshal(envelope.Data, &update); err != nil {
+					log.Printf("error unmarshalling depth message: %v\n", err)
+					continue
+				}
+
+				bs, ok := syncs[update.Symbol]
+				if !ok {
+					continue
+				}
+
+				bookUpdates, fetch := bs.handle(update)
+				if fetch {
+					b.fetchSnapshot(ctx, update.Symbol, 0, snapshots)
+				}
+				if !sendBook(bookUpdates) {
+					return
+				}
+			case strings.HasSuffix(envelope.Stream, "@trade"):
+				var t trade
+				if err := json.Unmarshal(envelope.Data, &t); err != nil {
+					log.Printf("error unmarshalling trade message: %v\n", err)
+					continue
+				}
+
+				ticker, ok := tickers[t.Symbol]
+				if !ok {
+					continue
+				}
+
+				price, _ := strconv.ParseFloat(t.Price, 64)
+				qty, _ := strconv.ParseFloat(t.Qty, 64)
+				ticker.Last = price
+
+				// The buyer being the maker means the taker sold.
+				side := "buy"
+				if t.BuyerIsMaker {
+					side = "sell"
+				}
+
+				if !send(exchange.MarketData{
+					Venue: Name,
+					Type:  exchange.TradeData,
+					Trade: &exchange.Trade{
+						Symbol:    ticker.Symbol,
+						Side:      side,
+						Price:     price,
+						Qty:       qty,
+						TradeId:   strconv.FormatInt(t.TradeId, 10),
+						Timestamp: time.UnixMilli(t.TradeTime).UTC(),
+					},
+				}) {
+					return
+				}
+			case strings.HasSuffix(envelope.Stream, "@bookTicker"):
+				var bt bookTicker
+				if err := json.Unmarshal(envelope.Data, &bt); err != nil {
+					log.Printf("error unmarshalling book ticker message: %v\n", err)
+					continue
+				}
+
+				ticker, ok := tickers[bt.Symbol]
+				if !ok {
+					continue
+				}
+
+				ticker.Bid, _ = strconv.ParseFloat(bt.Bid, 64)
+				ticker.BidQty, _ = strconv.ParseFloat(bt.BidQty, 64)
+				ticker.Ask, _ = strconv.ParseFloat(bt.Ask, 64)
+				ticker.AskQty, _ = strconv.ParseFloat(bt.AskQty, 64)
+
+				published := *ticker
+				if !send(exchange.MarketData{Venue: Name, Type: exchange.TickerData, Ticker: &published}) {
+					return
+				}
+			default:
+				//
+			}
+		}
+	}()
+
+	return marketData, nil
+}
+
+// frame is a message of the combined stream, or the notice that the stream
+// was reconnected and restarted.
+type frame struct {
+	data        []byte
+	reconnected bool
+}
+
+// read reads the stream in the background, reconnecting on errors, until ctx
+// is cancelled or no connection can be made.
+func (b *BinanceConnector) read(ctx context.Context, conn *websocket.Conn, streams []string) <-chan frame {
+	frames := make(chan frame, 100)
+
+	go func() {
+		deliver := func(message frame) bool {
+			select {
+			case frames <- message:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				if ctx.Err() != nil {
+					b.setHealth(false, nil)
+					return
+				}
+
+				fmt.Printf("error reading message: %v, reconnecting..\n", err)
+				b.setHealth(false, err)
+				conn.Close()
+				if conn, err = b.dial(ctx, streams); err != nil {
+					return
+				}
+
+				if !deliver(frame{reconnected: true}) {
+					return
+				}
+				continue
+			}
+			b.setHealth(true, nil)
+
+			if !deliver(frame{data: data}) {
+				return
+			}
+		}
+	}()
+
+	return frames
+}
+
+type fetchedSnapshot struct {
+	symbol   string // Binance symbol, e.g. "BTCUSDT"
+	snapshot depthSnapshot
+	err      error
+}
+
+// fetchSnapshot fetches the depth snapshot of symbol after delay, in the
+// background, and delivers it on snapshots.
+func (b *BinanceConnector) fetchSnapshot(ctx context.Context, symbol string, delay time.Duration, snapshots chan<- fetchedSnapshot) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		fetched := fetchedSnapshot{symbol: symbol}
+		url := fmt.Sprintf("%s/api/v3/depth?symbol=%s&limit=%d", b.restUrl, symbol, snapshotLimit)
+		fetched.err = b.getJSON(url, &fetched.snapshot)
+
+		select {
+		case snapshots <- fetched:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (b *BinanceConnector) getJSON(url string, v any) error {
+	resp, err := b.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("binance API error: %s: %s", resp.Status, string(body))
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+type exchangeInfo struct {
+	Symbols []struct {
+		Symbol     string `json:"symbol"`
+		Status     string `json:"status"`
+		BaseAsset  string `json:"baseAsset"`
+		QuoteAsset string `json:"quoteAsset"`
+		Filters    []struct {
+			FilterType  string `json:"filterType"`
+			TickSize    string `json:"tickSize"`
+			StepSize    string `json:"stepSize"`
+			MinQty      string `json:"minQty"`
+			MinNotional string `json:"minNotional"`
+		} `json:"filters"`
+	} `json:"symbols"`
+}
+
+func (b *BinanceConnector) Instruments(ctx context.Context) ([]exchange.Instrument, error) {
+	var info exchangeInfo
+	if err := b.getJSON(b.restUrl+"/api/v3/exchangeInfo?permissions=SPOT", &info); err != nil {
+		return nil, err
+	}
+
+	instruments := make([]exchange.Instrument, 0, len(info.Symbols))
+	for _, symbol := range info.Symbols {
+		instrument := exchange.Instrument{
+			Symbol: symbol.BaseAsset + "/" + symbol.QuoteAsset,
+			Base:   symbol.BaseAsset,
+			Quote:  symbol.QuoteAsset,
+			Status: strings.ToLower(symbol.Status),
+		}
+
+		for _, filter := range symbol.Filters {
+			switch filter.FilterType {
+			case "PRICE_FILTER":
+				instrument.PriceIncrement, _ = strconv.ParseFloat(filter.TickSize, 64)
+				instrument.PricePrecision = exchange.Decimals(filter.TickSize)
+			case "LOT_SIZE":
+				instrument.QtyIncrement, _ = strconv.ParseFloat(filter.StepSize, 64)
+				instrument.QtyPrecision = exchange.Decimals(filter.StepSize)
+				instrument.QtyMin, _ = strconv.ParseFloat(filter.MinQty, 64)
+			case "NOTIONAL", "MIN_NOTIONAL":
+				instrument.CostMin, _ = strconv.ParseFloat(filter.MinNotional, 64)
+			}
+		}
+
+		instruments = append(instruments, instrument)
+	}
+
+	return instruments, nil
+}
+
+func (b *BinanceConnector) PlaceOrder(ctx context.Context, request exchange.OrderRequest) (exchange.OrderAck, error) {
+	return exchange.OrderAck{}, exchange.ErrNotSupported
+}
+
+func (b *BinanceConnector) CancelOrder(ctx context.Context, orderId string) error {
+	return exchange.ErrNotSupported
+}
+
+func (b *BinanceConnector) Balances(ctx context.Context) ([]exchange.Balance, error) {
+	return nil, exchange.ErrNotSupported
+}
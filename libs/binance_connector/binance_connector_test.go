@@ -0,0 +1,181 @@
+package binance_connector
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitnet/exchange"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeBinance serves recorded stream frames on /stream and a recorded depth
+// snapshot on /api/v3/depth. The snapshot is only answered once release is
+// closed, so the stream has to keep flowing while it is fetched.
+type fakeBinance struct {
+	depthRequests atomic.Int32
+	requested     chan struct{} // Signalled on every snapshot request
+	release       chan struct{}
+}
+
+func newFakeBinance(t *testing.T) (*fakeBinance, *httptest.Server) {
+	t.Helper()
+
+	file, err := os.Open("testdata/btcusdt_stream.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	frames := [][]byte{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			frames = append(frames, []byte(line))
+		}
+	}
+
+	snapshot, err := os.ReadFile("testdata/btcusdt_depth.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeBinance{requested: make(chan struct{}, 10), release: make(chan struct{})}
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for _, frame := range frames {
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				return
+			}
+		}
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/api/v3/depth", func(w http.ResponseWriter, r *http.Request) {
+		fake.depthRequests.Add(1)
+		fake.requested <- struct{}{}
+		select {
+		case <-fake.release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write(snapshot)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return fake, server
+}
+
+func next(t *testing.T, updates <-chan exchange.MarketData) exchange.MarketData {
+	t.Helper()
+
+	select {
+	case update := <-updates:
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("no market data received")
+		return exchange.MarketData{}
+	}
+}
+
+func TestSubscribeMarketDataFetchesOneSnapshotWithoutStallingTheStream(t *testing.T) {
+	fake, server := newFakeBinance(t)
+	connector, err := exchange.Open(exchange.Config{
+		Name: Name,
+		Options: map[string]string{
+			"ws_url":   "ws" + strings.TrimPrefix(server.URL, "http"),
+			"rest_url": server.URL,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := connector.SubscribeMarketData(ctx, []string{"BTC/USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Trades and tickers flow while the snapshot is pending.
+	trade := next(t, updates)
+	if trade.Type != exchange.TradeData || trade.Trade.Symbol != "BTC/USDT" || trade.Trade.Side != "buy" || trade.Trade.Price != 68210.12 {
+		t.Fatalf("trade = %+v", trade)
+	}
+	ticker := next(t, updates)
+	if ticker.Type != exchange.TickerData || ticker.Ticker.Bid != 68210.11 || ticker.Ticker.AskQty != 1.0132 || ticker.Ticker.Last != 68210.12 {
+		t.Fatalf("ticker = %+v", ticker)
+	}
+
+	// Several depth events arrived unsynced, a single snapshot is asked for.
+	select {
+	case <-fake.requested:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no snapshot requested")
+	}
+	close(fake.release)
+
+	snapshot := next(t, updates)
+	if snapshot.Type != exchange.BookData || snapshot.Book.Type != "snapshot" || snapshot.Book.Sequence != 52113801208 || len(snapshot.Book.Bids) != 3 {
+		t.Fatalf("snapshot = %+v", snapshot.Book)
+	}
+
+	// The first buffered event is contained in the snapshot, the second one
+	// straddles it and the third follows.
+	for _, sequence := range []int64{52113801210, 52113801215} {
+		update := next(t, updates)
+		if update.Type != exchange.BookData || update.Book.Type != "update" || update.Book.Sequence != sequence {
+			t.Fatalf("update = %+v, expected sequence %d", update.Book, sequence)
+		}
+	}
+
+	if requests := fake.depthRequests.Load(); requests != 1 {
+		t.Fatalf("depth requests = %d", requests)
+	}
+}
+
+func TestBookSyncRefetchesSnapshotOlderThanBufferedEvents(t *testing.T) {
+	bs := newBookSync("BTC/USDT")
+
+	if _, fetch := bs.handle(depthUpdate{FirstUpdateId: 10, FinalUpdateId: 12}); !fetch {
+		t.Fatal("first unsynced event did not ask for a snapshot")
+	}
+	if _, fetch := bs.handle(depthUpdate{FirstUpdateId: 13, FinalUpdateId: 15}); fetch {
+		t.Fatal("second snapshot asked for while one is in flight")
+	}
+
+	if bookUpdates, fetch := bs.applySnapshot(depthSnapshot{LastUpdateId: 8}, nil); bookUpdates != nil || !fetch {
+		t.Fatalf("stale snapshot applied: %+v, fetch %v", bookUpdates, fetch)
+	}
+
+	bookUpdates, fetch := bs.applySnapshot(depthSnapshot{
+		LastUpdateId: 11,
+		Bids:         [][2]string{{"100", "1"}},
+		Asks:         [][2]string{{"101", "2"}},
+	}, nil)
+	if fetch || len(bookUpdates) != 3 || bookUpdates[0].Type != "snapshot" || bookUpdates[2].Sequence != 15 {
+		t.Fatalf("book updates = %+v, fetch %v", bookUpdates, fetch)
+	}
+
+	// A gap resyncs and asks for a new snapshot.
+	if _, fetch := bs.handle(depthUpdate{FirstUpdateId: 20, FinalUpdateId: 22}); !fetch || bs.synced {
+		t.Fatalf("gap did not resync, fetch %v", fetch)
+	}
+}
@@ -0,0 +1,197 @@
+package binance_connector
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"bitnet/exchange"
+)
+
+// bookSync keeps one Binance book in sync following the documented procedure:
+// buffer diff events, fetch a REST snapshot, drop buffered events already
+// contained in the snapshot (u <= lastUpdateId) and from then on require
+// every event to start right after the previous one (U == lastUpdateId+1).
+//
+// The snapshot is fetched by the caller, asynchronously: handle asks for one
+// and keeps buffering until it is passed to applySnapshot. At most one fetch
+// is in flight per book, a snapshot costs a request weight of 50.
+type bookSync struct {
+	symbol       string // Normalized "BASE/QUOTE" symbol
+	book         *exchange.LocalBook
+	lastUpdateId int64
+	synced       bool
+	fetching     bool // A snapshot was asked for and not applied yet
+	buffer       []depthUpdate
+}
+
+func newBookSync(symbol string) *bookSync {
+	return &bookSync{
+		symbol: symbol,
+		book:   exchange.NewLocalBook(),
+	}
+}
+
+// reset drops the book and the buffered events. A snapshot being fetched is
+// still awaited, it is checked against the events buffered from now on.
+func (bs *bookSync) reset() {
+	bs.book.Reset()
+	bs.lastUpdateId = 0
+	bs.synced = false
+	bs.buffer = nil
+}
+
+// handle processes one diff event and returns the normalized book messages
+// to publish. While the book is not synced the event is buffered, and fetch
+// tells the caller to fetch a snapshot for applySnapshot.
+func (bs *bookSync) handle(update depthUpdate) (bookUpdates []*exchange.BookUpdate, fetch bool) {
+	if bs.synced {
+		if update.FinalUpdateId <= bs.lastUpdateId {
+			return nil, false
+		}
+		if update.FirstUpdateId == bs.lastUpdateId+1 {
+			bookUpdate, err := bs.apply(update)
+			if err != nil {
+				log.Printf("binance book %s: %v, resyncing\n", bs.symbol, err)
+				bs.reset()
+				return nil, bs.startFetch()
+			}
+
+			return []*exchange.BookUpdate{bookUpdate}, false
+		}
+
+		log.Printf("binance book %s: update id gap, expected %d, got %d, resyncing\n", bs.symbol, bs.lastUpdateId+1, update.FirstUpdateId)
+		bs.reset()
+	}
+
+	if len(bs.buffer) >= maxBuffered {
+		// The snapshot takes too long, keep the latest events only. Those
+		// dropped are older than any snapshot still to come.
+		bs.buffer = bs.buffer[1:]
+	}
+	bs.buffer = append(bs.buffer, update)
+
+	return nil, bs.startFetch()
+}
+
+func (bs *bookSync) startFetch() bool {
+	if bs.fetching {
+		return false
+	}
+	bs.fetching = true
+
+	return true
+}
+
+// applySnapshot seeds the book from a fetched snapshot and replays the
+// buffered events on it. It returns the normalized book messages to publish,
+// starting with the snapshot, and whether another snapshot has to be fetched
+// because this one failed or does not fit the buffered events.
+func (bs *bookSync) applySnapshot(snapshot depthSnapshot, err error) (bookUpdates []*exchange.BookUpdate, fetch bool) {
+	bs.fetching = false
+	if bs.synced {
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("binance book %s: failed to fetch snapshot: %v\n", bs.symbol, err)
+		return nil, bs.startFetch()
+	}
+	if len(bs.buffer) == 0 {
+		// Reset while fetching, the next event asks for a new snapshot.
+		return nil, false
+	}
+	if snapshot.LastUpdateId < bs.buffer[0].FirstUpdateId {
+		// The snapshot is older than the first buffered event.
+		return nil, bs.startFetch()
+	}
+
+	bids, err := parseLevels(snapshot.Bids)
+	if err != nil {
+		log.Printf("binance book %s: invalid snapshot: %v\n", bs.symbol, err)
+		return nil, bs.startFetch()
+	}
+	asks, err := parseLevels(snapshot.Asks)
+	if err != nil {
+		log.Printf("binance book %s: invalid snapshot: %v\n", bs.symbol, err)
+		return nil, bs.startFetch()
+	}
+
+	bs.book.Reset()
+	bs.book.Apply(bids, asks)
+	bs.lastUpdateId = snapshot.LastUpdateId
+	bs.synced = true
+
+	bookUpdates = []*exchange.BookUpdate{{
+		Venue:     Name,
+		Symbol:    bs.symbol,
+		Type:      "snapshot",
+		Bids:      bids,
+		Asks:      asks,
+		Sequence:  snapshot.LastUpdateId,
+		Timestamp: time.Now().UTC(),
+	}}
+
+	buffered := bs.buffer
+	bs.buffer = nil
+	for _, event := range buffered {
+		if event.FinalUpdateId <= bs.lastUpdateId {
+			continue
+		}
+		if event.FirstUpdateId > bs.lastUpdateId+1 {
+			log.Printf("binance book %s: buffered events do not follow the snapshot, resyncing\n", bs.symbol)
+			bs.reset()
+			return nil, bs.startFetch()
+		}
+
+		bookUpdate, err := bs.apply(event)
+		if err != nil {
+			log.Printf("binance book %s: %v, resyncing\n", bs.symbol, err)
+			bs.reset()
+			return nil, bs.startFetch()
+		}
+		bookUpdates = append(bookUpdates, bookUpdate)
+	}
+
+	return bookUpdates, false
+}
+
+func (bs *bookSync) apply(update depthUpdate) (*exchange.BookUpdate, error) {
+	bids, err := parseLevels(update.Bids)
+	if err != nil {
+		return nil, err
+	}
+	asks, err := parseLevels(update.Asks)
+	if err != nil {
+		return nil, err
+	}
+
+	bs.book.Apply(bids, asks)
+	bs.lastUpdateId = update.FinalUpdateId
+
+	return &exchange.BookUpdate{
+		Venue:     Name,
+		Symbol:    bs.symbol,
+		Type:      "update",
+		Bids:      bids,
+		Asks:      asks,
+		Sequence:  update.FinalUpdateId,
+		Timestamp: time.UnixMilli(update.EventTime).UTC(),
+	}, nil
+}
+
+func parseLevels(levels [][2]string) ([]exchange.BookLevel, error) {
+	parsed := make([]exchange.BookLevel, 0, len(levels))
+	for _, level := range levels {
+		price, err := strconv.ParseFloat(level[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		qty, err := strconv.ParseFloat(level[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, exchange.BookLevel{Price: price, Qty: qty})
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,10 @@
+module bitnet/binance_connector
+
+replace bitnet/exchange => ../../libs/exchange
+
+require (
+	bitnet/exchange v0.0.0-00010101000000-000000000000
+	github.com/gorilla/websocket v1.5.3
+)
+
+go 1.23.1
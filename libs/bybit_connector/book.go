@@ -0,0 +1,111 @@
+package bybit_connector
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	bybitwsclient "bitnet/bybit_ws_client"
+	"bitnet/exchange"
+)
+
+// localBook adds Bybit's sequencing state to the shared local book, so that
+// deltas can be validated and the best bid/ask attached to tickers.
+type localBook struct {
+	*exchange.LocalBook
+	updateId    int64
+	seq         int64
+	hasSnapshot bool
+}
+
+func newLocalBook() *localBook {
+	return &localBook{LocalBook: exchange.NewLocalBook()}
+}
+
+func (lb *localBook) reset() {
+	lb.Reset()
+	lb.updateId = 0
+	lb.seq = 0
+	lb.hasSnapshot = false
+}
+
+// apply applies a snapshot or delta to the local book and returns the
+// normalized update to publish. It returns nil for stale deltas and an error
+// when the stream is out of sequence and the book has to be resynced.
+func (lb *localBook) apply(pair string, update bybitwsclient.ResponseMessage, data bybitwsclient.OrderBookData) (*exchange.BookUpdate, error) {
+	bids, err := normalizeLevels(data.Bids)
+	if err != nil {
+		return nil, err
+	}
+	asks, err := normalizeLevels(data.Asks)
+	if err != nil {
+		return nil, err
+	}
+
+	bookUpdate := &exchange.BookUpdate{
+		Venue:     Name,
+		Symbol:    pair,
+		Type:      "update",
+		Bids:      bids,
+		Asks:      asks,
+		Sequence:  data.Seq,
+		Timestamp: time.UnixMilli(update.Ts).UTC(),
+	}
+
+	// u == 1 means Bybit restarted its book service and the message must be
+	// treated as a snapshot, whatever its type says.
+	if update.Type == "snapshot" || data.UpdateId == 1 {
+		lb.reset()
+		lb.Apply(bids, asks)
+		lb.updateId = data.UpdateId
+		lb.seq = data.Seq
+		lb.hasSnapshot = true
+		bookUpdate.Type = "snapshot"
+
+		return bookUpdate, nil
+	}
+
+	if !lb.hasSnapshot {
+		// Deltas before the first snapshot can not be applied, wait for it.
+		return nil, nil
+	}
+	if data.UpdateId <= lb.updateId || data.Seq < lb.seq {
+		// Stale or duplicated message.
+		return nil, nil
+	}
+	if data.UpdateId != lb.updateId+1 {
+		return nil, fmt.Errorf("update id gap: expected %d, got %d", lb.updateId+1, data.UpdateId)
+	}
+
+	lb.Apply(bids, asks)
+	lb.updateId = data.UpdateId
+	lb.seq = data.Seq
+
+	return bookUpdate, nil
+}
+
+func normalizeLevels(levels []bybitwsclient.PriceSize) ([]exchange.BookLevel, error) {
+	normalized := make([]exchange.BookLevel, 0, len(levels))
+	for _, level := range levels {
+		price, err := level.Price()
+		if err != nil {
+			return nil, err
+		}
+		qty, err := level.Size()
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, exchange.BookLevel{Price: price, Qty: qty})
+	}
+
+	return normalized, nil
+}
+
+func parseOr(value string, fallback float64) float64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fallback
+	}
+
+	return parsed
+}
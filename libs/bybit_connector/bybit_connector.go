@@ -0,0 +1,347 @@
+package bybit_connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bybitwsclient "bitnet/bybit_ws_client"
+	"bitnet/exchange"
+)
+
+const (
+	Name = "bybit"
+
+	defaultWsUrl   = "wss://stream.bybit.com/v5/public/spot"
+	defaultRestUrl = "https://api.bybit.com"
+
+	bookDepth = 50
+)
+
+func init() {
+	exchange.Register(Name, New)
+}
+
+// BybitConnector streams Bybit spot market data. Trading is not implemented,
+// the connector is a market data adapter only.
+//
+// Options: ws_url and rest_url, falling back to BYBIT_PUBLIC_WS_URL and
+// BYBIT_REST_API_URL and then to the public Bybit endpoints.
+type BybitConnector struct {
+	wsUrl      string
+	restUrl    string
+	httpClient *http.Client
+	mutex      sync.RWMutex
+	health     exchange.Health
+}
+
+func New(config exchange.Config) (exchange.ExchangeConnector, error) {
+	return &BybitConnector{
+		wsUrl:      config.Option("ws_url", envOr("BYBIT_PUBLIC_WS_URL", defaultWsUrl)),
+		restUrl:    config.Option("rest_url", envOr("BYBIT_REST_API_URL", defaultRestUrl)),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// bybitSymbol converts a pair like "BTC/USDT" to Bybit's "BTCUSDT".
+func bybitSymbol(pair string) string {
+	return strings.ReplaceAll(pair, "/", "")
+}
+
+func (b *BybitConnector) Name() string {
+	return Name
+}
+
+func (b *BybitConnector) Health() exchange.Health {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.health
+}
+
+func (b *BybitConnector) setHealth(connected bool, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.health.Connected = connected
+	if connected {
+		b.health.LastMessage = time.Now()
+	}
+	if err != nil {
+		b.health.Error = err.Error()
+	}
+}
+
+func (b *BybitConnector) SubscribeMarketData(ctx context.Context, symbols []string) (<-chan exchange.MarketData, error) {
+	// Bybit symbols carry no separator, keep a mapping back to the pair names
+	// used in NATS subjects so all venues publish under the same symbols.
+	pairs := make(map[string]string)
+	books := make(map[string]*localBook)
+	tickers := make(map[string]exchange.Ticker)
+	published := make(map[string]exchange.Ticker) // Last ticker sent per symbol, to send on change only
+	topics := []string{}
+	for _, pair := range symbols {
+		symbol := bybitSymbol(pair)
+		pairs[symbol] = pair
+		books[symbol] = newLocalBook()
+		topics = append(topics,
+			bybitwsclient.OrderBookTopicName(bookDepth, symbol),
+			bybitwsclient.PublicTradeTopicName(symbol),
+			bybitwsclient.TickersTopicName(symbol),
+		)
+	}
+
+	client := bybitwsclient.NewBybitWsClient(bybitwsclient.BybitWsClientConfig{Url: b.wsUrl})
+	updates, err := client.Subscribe(topics...)
+	if err != nil {
+		client.Close()
+		b.setHealth(false, err)
+		return nil, err
+	}
+
+	marketData := make(chan exchange.MarketData, 20)
+
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+
+	go func() {
+		defer close(marketData)
+
+		send := func(data exchange.MarketData) bool {
+			select {
+			case marketData <- data:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		sendTicker := func(symbol string) bool {
+			ticker := tickers[symbol]
+			ticker.Symbol = pairs[symbol]
+			ticker.Bid, ticker.BidQty, ticker.Ask, ticker.AskQty = books[symbol].Best()
+			if ticker.Bid == 0 || ticker.Ask == 0 {
+				return true
+			}
+
+			if previous, ok := published[symbol]; ok && previous == ticker {
+				return true
+			}
+			published[symbol] = ticker
+
+			return send(exchange.MarketData{Venue: Name, Type: exchange.TickerData, Ticker: &ticker})
+		}
+
+		for {
+			var update bybitwsclient.ResponseMessage
+			var ok bool
+			select {
+			case <-ctx.Done():
+				b.setHealth(false, nil)
+				return
+			case update, ok = <-updates:
+			}
+			if !ok {
+				b.setHealth(false, nil)
+				return
+			}
+			b.setHealth(true, nil)
+
+			topic := strings.SplitN(update.Topic, ".", 2)[0]
+			switch bybitwsclient.BybitWsTopic(topic) {
+			case bybitwsclient.OrderBookTopic:
+				var bookData bybitwsclient.OrderBookData
+				if err := json.Unmarshal(update.Data, &bookData); err != nil {
+					log.Printf("error unmarshalling order book message: %v\n", err)
+					continue
+				}
+
+				pair, ok := pairs[bookData.Symbol]
+				if !ok {
+					continue
+				}
+
+				bookUpdate, err := books[bookData.Symbol].apply(pair, update, bookData)
+				if err != nil {
+					log.Printf("bybit book %s out of sync: %v, resubscribing\n", pair, err)
+					books[bookData.Symbol].reset()
+					if err := client.Resubscribe(update.Topic); err != nil {
+						log.Printf("failed to resubscribe to %s: %v\n", update.Topic, err)
+					}
+					continue
+				}
+				if bookUpdate == nil {
+					continue
+				}
+
+				if !send(exchange.MarketData{Venue: Name, Type: exchange.BookData, Book: bookUpdate}) || !sendTicker(bookData.Symbol) {
+					return
+				}
+			case bybitwsclient.PublicTradeTopic:
+				var tradesData []bybitwsclient.PublicTrade
+				if err := json.Unmarshal(update.Data, &tradesData); err != nil {
+					log.Printf("error unmarshalling trade message: %v\n", err)
+					continue
+				}
+
+				for _, trade := range tradesData {
+					pair, ok := pairs[trade.Symbol]
+					if !ok {
+						continue
+					}
+
+					price, _ := strconv.ParseFloat(trade.Price, 64)
+					qty, _ := strconv.ParseFloat(trade.Size, 64)
+					if !send(exchange.MarketData{
+						Venue: Name,
+						Type:  exchange.TradeData,
+						Trade: &exchange.Trade{
+							Symbol:    pair,
+							Side:      strings.ToLower(trade.Side),
+							Price:     price,
+							Qty:       qty,
+							TradeId:   trade.TradeId,
+							Timestamp: time.UnixMilli(trade.Timestamp).UTC(),
+						},
+					}) {
+						return
+					}
+				}
+			case bybitwsclient.TickersTopic:
+				var tickerData bybitwsclient.Ticker
+				if err := json.Unmarshal(update.Data, &tickerData); err != nil {
+					log.Printf("error unmarshalling ticker message: %v\n", err)
+					continue
+				}
+
+				if _, ok := pairs[tickerData.Symbol]; !ok {
+					continue
+				}
+
+				ticker := tickers[tickerData.Symbol]
+				ticker.Last = parseOr(tickerData.LastPrice, ticker.Last)
+				ticker.Volume = parseOr(tickerData.Volume24h, ticker.Volume)
+				ticker.Low = parseOr(tickerData.LowPrice24h, ticker.Low)
+				ticker.High = parseOr(tickerData.HighPrice24h, ticker.High)
+				if prev := parseOr(tickerData.PrevPrice24h, 0); prev != 0 {
+					ticker.Change = ticker.Last - prev
+				}
+				if pcnt := parseOr(tickerData.Price24hPcnt, 0); pcnt != 0 {
+					ticker.ChangePct = pcnt * 100
+				}
+				if turnover := parseOr(tickerData.Turnover24h, 0); turnover != 0 && ticker.Volume != 0 {
+					ticker.VWAP = turnover / ticker.Volume
+				}
+				tickers[tickerData.Symbol] = ticker
+
+				if !sendTicker(tickerData.Symbol) {
+					return
+				}
+			default:
+				//
+			}
+		}
+	}()
+
+	return marketData, nil
+}
+
+type instrumentsInfo struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List []struct {
+			Symbol        string `json:"symbol"`
+			BaseCoin      string `json:"baseCoin"`
+			QuoteCoin     string `json:"quoteCoin"`
+			Status        string `json:"status"`
+			LotSizeFilter struct {
+				BasePrecision string `json:"basePrecision"`
+				MinOrderQty   string `json:"minOrderQty"`
+				MinOrderAmt   string `json:"minOrderAmt"`
+			} `json:"lotSizeFilter"`
+			PriceFilter struct {
+				TickSize string `json:"tickSize"`
+			} `json:"priceFilter"`
+		} `json:"list"`
+	} `json:"result"`
+}
+
+func (b *BybitConnector) Instruments(ctx context.Context) ([]exchange.Instrument, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, b.restUrl+"/v5/market/instruments-info?category=spot", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bybit API error: %s: %s", resp.Status, string(body))
+	}
+
+	var info instrumentsInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+	if info.RetCode != 0 {
+		return nil, fmt.Errorf("bybit API error: %d: %s", info.RetCode, info.RetMsg)
+	}
+
+	instruments := make([]exchange.Instrument, 0, len(info.Result.List))
+	for _, symbol := range info.Result.List {
+		instrument := exchange.Instrument{
+			Symbol:         symbol.BaseCoin + "/" + symbol.QuoteCoin,
+			Base:           symbol.BaseCoin,
+			Quote:          symbol.QuoteCoin,
+			PricePrecision: exchange.Decimals(symbol.PriceFilter.TickSize),
+			QtyPrecision:   exchange.Decimals(symbol.LotSizeFilter.BasePrecision),
+			Status:         strings.ToLower(symbol.Status),
+		}
+		instrument.PriceIncrement, _ = strconv.ParseFloat(symbol.PriceFilter.TickSize, 64)
+		instrument.QtyIncrement, _ = strconv.ParseFloat(symbol.LotSizeFilter.BasePrecision, 64)
+		instrument.QtyMin, _ = strconv.ParseFloat(symbol.LotSizeFilter.MinOrderQty, 64)
+		instrument.CostMin, _ = strconv.ParseFloat(symbol.LotSizeFilter.MinOrderAmt, 64)
+
+		instruments = append(instruments, instrument)
+	}
+
+	return instruments, nil
+}
+
+func (b *BybitConnector) PlaceOrder(ctx context.Context, request exchange.OrderRequest) (exchange.OrderAck, error) {
+	return exchange.OrderAck{}, exchange.ErrNotSupported
+}
+
+func (b *BybitConnector) CancelOrder(ctx context.Context, orderId string) error {
+	return exchange.ErrNotSupported
+}
+
+func (b *BybitConnector) Balances(ctx context.Context) ([]exchange.Balance, error) {
+	return nil, exchange.ErrNotSupported
+}
@@ -0,0 +1,146 @@
+package bybit_connector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bybitwsclient "bitnet/bybit_ws_client"
+	"bitnet/bybit_ws_client/bybittest"
+	"bitnet/exchange"
+)
+
+// gapFrame skips update id 3871213, the connector has to resubscribe.
+const gapFrame = `{"topic":"orderbook.50.BTCUSDT","ts":1729331443200,"type":"delta","data":{"s":"BTCUSDT","b":[["68209.90","0"]],"a":[],"u":3871214,"seq":41380811400},"cts":1729331443198}`
+
+func open(t *testing.T, fake *bybittest.Server) exchange.ExchangeConnector {
+	t.Helper()
+
+	connector, err := exchange.Open(exchange.Config{
+		Name:    Name,
+		Options: map[string]string{"ws_url": fake.WsUrl()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return connector
+}
+
+func next(t *testing.T, updates <-chan exchange.MarketData) exchange.MarketData {
+	t.Helper()
+
+	select {
+	case update := <-updates:
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("no market data received")
+		return exchange.MarketData{}
+	}
+}
+
+func TestSubscribeMarketDataNormalizesRecordedFrames(t *testing.T) {
+	fake := bybittest.NewServer(t, bybittest.Config{Frames: bybittest.SpotBTCUSDT()})
+	connector := open(t, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := connector.SubscribeMarketData(ctx, []string{"BTC/USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := next(t, updates)
+	if snapshot.Type != exchange.BookData || snapshot.Book.Type != "snapshot" || snapshot.Book.Symbol != "BTC/USDT" ||
+		len(snapshot.Book.Bids) != 3 || snapshot.Book.Bids[0] != (exchange.BookLevel{Price: 68210.11, Qty: 0.420143}) {
+		t.Fatalf("snapshot = %+v", snapshot.Book)
+	}
+	if ticker := next(t, updates).Ticker; ticker == nil || ticker.Bid != 68210.11 || ticker.AskQty != 1.074612 {
+		t.Fatalf("ticker after snapshot = %+v", ticker)
+	}
+
+	delta := next(t, updates)
+	if delta.Type != exchange.BookData || delta.Book.Type != "update" || delta.Book.Bids[0].Qty != 0 {
+		t.Fatalf("delta = %+v", delta.Book)
+	}
+	if ticker := next(t, updates).Ticker; ticker == nil || ticker.AskQty != 1.013201 {
+		t.Fatalf("ticker after delta = %+v", ticker)
+	}
+
+	trade := next(t, updates)
+	if trade.Type != exchange.TradeData || trade.Trade.Side != "buy" || trade.Trade.Price != 68210.12 || trade.Trade.Qty != 0.061411 {
+		t.Fatalf("trade = %+v", trade.Trade)
+	}
+
+	ticker := next(t, updates).Ticker
+	if ticker == nil || ticker.Last != 68210.12 || ticker.High != 68920 || ticker.Bid != 68210.11 || ticker.Low != 67110.01 {
+		t.Fatalf("ticker = %+v", ticker)
+	}
+
+	if health := connector.Health(); !health.Connected {
+		t.Fatalf("health = %+v", health)
+	}
+}
+
+func TestSubscribeMarketDataResubscribesOnUpdateIdGap(t *testing.T) {
+	fake := bybittest.NewServer(t, bybittest.Config{Frames: append(bybittest.SpotBTCUSDT(), []byte(gapFrame))})
+	connector := open(t, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := connector.SubscribeMarketData(ctx, []string{"BTC/USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range updates {
+		}
+	}()
+
+	topic := bybitwsclient.OrderBookTopicName(bookDepth, "BTCUSDT")
+	expected := []string{"subscribe", "unsubscribe", "subscribe"}
+	for i, op := range expected {
+		select {
+		case request := <-fake.Requests:
+			if request.Op != op || (i > 0 && (len(request.Args) != 1 || request.Args[0] != topic)) {
+				t.Fatalf("request %d = %+v", i, request)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request %d not received", i)
+		}
+	}
+}
+
+func TestCancelClosesTheStream(t *testing.T) {
+	fake := bybittest.NewServer(t, bybittest.Config{Frames: bybittest.SpotBTCUSDT()})
+	connector := open(t, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := connector.SubscribeMarketData(ctx, []string{"BTC/USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next(t, updates)
+	cancel()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				if health := connector.Health(); health.Connected {
+					t.Fatalf("health = %+v", health)
+				}
+				select {
+				case <-fake.Closed:
+				case <-time.After(5 * time.Second):
+					t.Fatal("connection not closed")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("market data not closed")
+		}
+	}
+}
@@ -0,0 +1,14 @@
+module bitnet/bybit_connector
+
+replace bitnet/bybit_ws_client => ../../libs/bybit_ws_client
+
+replace bitnet/exchange => ../../libs/exchange
+
+require (
+	bitnet/bybit_ws_client v0.0.0-00010101000000-000000000000
+	bitnet/exchange v0.0.0-00010101000000-000000000000
+)
+
+require github.com/gorilla/websocket v1.5.3 // indirect
+
+go 1.23.1
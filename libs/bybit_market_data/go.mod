@@ -0,0 +1,28 @@
+module bitnet/bybit_market_data
+
+replace bitnet/bybit_connector => ../../libs/bybit_connector
+
+replace bitnet/bybit_ws_client => ../../libs/bybit_ws_client
+
+replace bitnet/exchange => ../../libs/exchange
+
+replace bitnet/market_data => ../../libs/market_data
+
+require (
+	bitnet/bybit_connector v0.0.0-00010101000000-000000000000
+	bitnet/exchange v0.0.0-00010101000000-000000000000
+	bitnet/market_data v0.0.0-00010101000000-000000000000
+	github.com/nats-io/nats.go v1.37.0
+)
+
+require (
+	bitnet/bybit_ws_client v0.0.0-00010101000000-000000000000 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/klauspost/compress v1.17.2 // indirect
+	github.com/nats-io/nkeys v0.4.7 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+)
+
+go 1.23.1
@@ -0,0 +1,40 @@
+package bybit_market_data
+
+import (
+	"context"
+	"log"
+	"os"
+
+	bybitconnector "bitnet/bybit_connector"
+	"bitnet/exchange"
+	marketdata "bitnet/market_data"
+
+	"github.com/nats-io/nats.go"
+)
+
+// BybitMarketDataProvider publishes Bybit market data on NATS. It is the
+// generic market_data provider bound to the "bybit" connector.
+type BybitMarketDataProvider struct {
+	natsClient *nats.Conn
+}
+
+func New(natsClient *nats.Conn) *BybitMarketDataProvider {
+	return &BybitMarketDataProvider{
+		natsClient: natsClient,
+	}
+}
+
+func (b *BybitMarketDataProvider) Run(ctx context.Context, enabledPairs []string) {
+	connector, err := exchange.Open(exchange.Config{
+		Name: bybitconnector.Name,
+		Options: map[string]string{
+			"ws_url": os.Getenv("BYBIT_PUBLIC_WS_URL"),
+		},
+	})
+	if err != nil {
+		log.Printf("can not open bybit connector: %v\n", err)
+		return
+	}
+
+	marketdata.New(b.natsClient, connector).Run(ctx, enabledPairs)
+}
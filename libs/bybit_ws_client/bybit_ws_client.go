@@ -0,0 +1,268 @@
+package bybit_ws_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type BybitWsTopic string
+
+const (
+	OrderBookTopic   BybitWsTopic = "orderbook"
+	PublicTradeTopic BybitWsTopic = "publicTrade"
+	TickersTopic     BybitWsTopic = "tickers"
+)
+
+// Bybit closes public connections that stay silent for more than 30 seconds,
+// the recommended ping interval is 20 seconds.
+const pingInterval = 20 * time.Second
+
+// OrderBookTopicName returns the topic name for an order book stream,
+// e.g. "orderbook.50.BTCUSDT". Spot supports depths 1, 50 and 200.
+func OrderBookTopicName(depth int, symbol string) string {
+	return fmt.Sprintf("%s.%d.%s", OrderBookTopic, depth, symbol)
+}
+
+func PublicTradeTopicName(symbol string) string {
+	return fmt.Sprintf("%s.%s", PublicTradeTopic, symbol)
+}
+
+func TickersTopicName(symbol string) string {
+	return fmt.Sprintf("%s.%s", TickersTopic, symbol)
+}
+
+type SubscribeRequest struct {
+	ReqId string   `json:"req_id,omitempty"`
+	Op    string   `json:"op"`
+	Args  []string `json:"args,omitempty"`
+}
+
+// ResponseMessage covers both operation responses (subscribe, pong) and topic
+// pushes. Operation responses carry Op and Success, pushes carry Topic and Data.
+type ResponseMessage struct {
+	Topic   string          `json:"topic"`
+	Type    string          `json:"type"` // "snapshot" or "delta"
+	Ts      int64           `json:"ts"`
+	Cts     int64           `json:"cts"`
+	Data    json.RawMessage `json:"data"`
+	Op      string          `json:"op"`
+	Success bool            `json:"success"`
+	RetMsg  string          `json:"ret_msg"`
+	ConnId  string          `json:"conn_id"`
+}
+
+// PriceSize is a [price, size] pair as sent by Bybit, both encoded as strings.
+type PriceSize [2]string
+
+func (ps PriceSize) Price() (float64, error) {
+	return strconv.ParseFloat(ps[0], 64)
+}
+
+func (ps PriceSize) Size() (float64, error) {
+	return strconv.ParseFloat(ps[1], 64)
+}
+
+type OrderBookData struct {
+	Symbol   string      `json:"s"`
+	Bids     []PriceSize `json:"b"`
+	Asks     []PriceSize `json:"a"`
+	UpdateId int64       `json:"u"`   // Resets to 1 when Bybit restarts the book service
+	Seq      int64       `json:"seq"` // Cross sequence, comparable between depths
+}
+
+type PublicTrade struct {
+	Timestamp    int64  `json:"T"`
+	Symbol       string `json:"s"`
+	Side         string `json:"S"` // "Buy" or "Sell", taker side
+	Size         string `json:"v"`
+	Price        string `json:"p"`
+	TickDir      string `json:"L"`
+	TradeId      string `json:"i"`
+	IsBlockTrade bool   `json:"BT"`
+}
+
+// Ticker is the spot ticker push. Spot tickers carry no best bid/ask, those
+// have to be taken from the order book stream.
+type Ticker struct {
+	Symbol        string `json:"symbol"`
+	LastPrice     string `json:"lastPrice"`
+	HighPrice24h  string `json:"highPrice24h"`
+	LowPrice24h   string `json:"lowPrice24h"`
+	PrevPrice24h  string `json:"prevPrice24h"`
+	Volume24h     string `json:"volume24h"`
+	Turnover24h   string `json:"turnover24h"`
+	Price24hPcnt  string `json:"price24hPcnt"`
+	UsdIndexPrice string `json:"usdIndexPrice"`
+}
+
+type BybitWsClientConfig struct {
+	Url string
+}
+
+type BybitWsClient struct {
+	config BybitWsClientConfig
+	Conn   *websocket.Conn
+	topics []string
+	closed chan struct{}
+	mutex  sync.Mutex // Guards writes to Conn, gorilla allows one concurrent writer
+}
+
+func NewBybitWsClient(config BybitWsClientConfig) *BybitWsClient {
+	closed := make(chan struct{})
+	return &BybitWsClient{
+		config: config,
+		Conn:   reconnect(config.Url, closed),
+		closed: closed,
+	}
+}
+
+// reconnect dials url until it succeeds, nil once closed is closed.
+func reconnect(url string, closed <-chan struct{}) *websocket.Conn {
+	for {
+		wsConn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			return wsConn
+		}
+
+		log.Printf("websocket connection failed: %v, retrying...\n", err)
+		select {
+		case <-closed:
+			return nil
+		case <-time.After(5 * time.Second): // TODO: implement exponential backoff (?)
+		}
+	}
+}
+
+func (b *BybitWsClient) writeJSON(v any) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.Conn.WriteJSON(v)
+}
+
+func (b *BybitWsClient) sendSubscribe() error {
+	return b.writeJSON(SubscribeRequest{
+		Op:   "subscribe",
+		Args: b.topics,
+	})
+}
+
+// Resubscribe sends a fresh subscribe request for every topic. Bybit answers
+// with new order book snapshots, which is how a broken book is resynced.
+func (b *BybitWsClient) Resubscribe(topics ...string) error {
+	if err := b.writeJSON(SubscribeRequest{Op: "unsubscribe", Args: topics}); err != nil {
+		return err
+	}
+
+	return b.writeJSON(SubscribeRequest{Op: "subscribe", Args: topics})
+}
+
+// Subscribe subscribes to the given topics and returns a channel with every
+// message received. On read errors the connection is re-established and the
+// topics are subscribed again, so consumers will see fresh snapshots. The
+// returned channel is closed by Close.
+func (b *BybitWsClient) Subscribe(topics ...string) (chan ResponseMessage, error) {
+	responseMessages := make(chan ResponseMessage, 20)
+
+	b.topics = topics
+	if err := b.sendSubscribe(); err != nil {
+		return nil, err
+	}
+
+	go b.keepAlive()
+
+	go func() {
+		defer close(responseMessages)
+
+		for {
+			_, message, err := b.Conn.ReadMessage()
+			if err != nil {
+				select {
+				case <-b.closed:
+					return
+				default:
+				}
+
+				log.Printf("error reading message: %v, reconnecting..\n", err)
+				b.Conn.Close()
+				conn := reconnect(b.config.Url, b.closed)
+				b.mutex.Lock()
+				select {
+				case <-b.closed:
+					// Closed while reconnecting, Close did not see conn.
+					b.mutex.Unlock()
+					if conn != nil {
+						conn.Close()
+					}
+					return
+				default:
+				}
+				b.Conn = conn
+				b.mutex.Unlock()
+
+				if err := b.sendSubscribe(); err != nil {
+					log.Printf("error resubscribing: %v\n", err)
+				}
+				continue
+			}
+
+			var responseMessage ResponseMessage
+			if err = json.Unmarshal(message, &responseMessage); err != nil {
+				log.Printf("error unmarshalling message: %v\n", err)
+				continue
+			}
+
+			if responseMessage.Op != "" && !responseMessage.Success && responseMessage.Op != "pong" && responseMessage.Op != "ping" {
+				log.Printf("bybit %s request failed: %s\n", responseMessage.Op, responseMessage.RetMsg)
+			}
+
+			select {
+			case responseMessages <- responseMessage:
+			case <-b.closed:
+				return
+			}
+		}
+	}()
+
+	return responseMessages, nil
+}
+
+func (b *BybitWsClient) keepAlive() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if err := b.writeJSON(SubscribeRequest{Op: "ping"}); err != nil {
+			log.Printf("error sending ping: %v\n", err)
+		}
+	}
+}
+
+// Close closes the connection and stops the subscription started by
+// Subscribe and its pings. It holds mutex, the read loop replaces Conn under
+// it on reconnect.
+func (b *BybitWsClient) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	select {
+	case <-b.closed:
+		return nil
+	default:
+	}
+	close(b.closed)
+
+	return b.Conn.Close()
+}
@@ -0,0 +1,179 @@
+package bybit_ws_client_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	. "bitnet/bybit_ws_client"
+	"bitnet/bybit_ws_client/bybittest"
+)
+
+func receive(t *testing.T, messages chan ResponseMessage, n int) []ResponseMessage {
+	t.Helper()
+
+	received := []ResponseMessage{}
+	timeout := time.After(5 * time.Second)
+	for len(received) < n {
+		select {
+		case message := <-messages:
+			received = append(received, message)
+		case <-timeout:
+			t.Fatalf("received %d of %d messages", len(received), n)
+		}
+	}
+	return received
+}
+
+func nextRequest(t *testing.T, fake *bybittest.Server) SubscribeRequest {
+	t.Helper()
+
+	select {
+	case request := <-fake.Requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request received")
+		return SubscribeRequest{}
+	}
+}
+
+func TestSubscribeReplaysRecordedFramesAcrossReconnect(t *testing.T) {
+	frames := bybittest.SpotBTCUSDT()
+	fake := bybittest.NewServer(t, bybittest.Config{Frames: frames, DropFirst: true})
+	topics := []string{OrderBookTopicName(50, "BTCUSDT"), PublicTradeTopicName("BTCUSDT"), TickersTopicName("BTCUSDT")}
+
+	client := NewBybitWsClient(BybitWsClientConfig{Url: fake.WsUrl()})
+	defer client.Close()
+	messages, err := client.Subscribe(topics...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, messages, len(frames))
+	if request := nextRequest(t, fake); request.Op != "subscribe" || strings.Join(request.Args, ",") != strings.Join(topics, ",") {
+		t.Fatalf("first request = %+v", request)
+	}
+
+	// The server drops the connection after the replay, the client reconnects,
+	// subscribes to the same topics and gets fresh snapshots.
+	second := receive(t, messages, len(frames))
+	if connection := <-fake.Conns; connection != 1 {
+		t.Fatalf("first connection = %d", connection)
+	}
+	if connection := <-fake.Conns; connection != 2 {
+		t.Fatalf("second connection = %d", connection)
+	}
+	if request := nextRequest(t, fake); request.Op != "subscribe" || strings.Join(request.Args, ",") != strings.Join(topics, ",") {
+		t.Fatalf("resubscribe request = %+v", request)
+	}
+
+	for _, received := range [][]ResponseMessage{first, second} {
+		if !received[0].Success || received[0].Op != "subscribe" {
+			t.Fatalf("subscribe response = %+v", received[0])
+		}
+
+		snapshot := received[1]
+		if snapshot.Topic != topics[0] || snapshot.Type != "snapshot" {
+			t.Fatalf("snapshot = %+v", snapshot)
+		}
+		book := OrderBookData{}
+		if err := json.Unmarshal(snapshot.Data, &book); err != nil {
+			t.Fatal(err)
+		}
+		if price, _ := book.Bids[0].Price(); price != 68210.11 || book.UpdateId != 3871211 || len(book.Asks) != 3 {
+			t.Fatalf("book = %+v", book)
+		}
+
+		delta := OrderBookData{}
+		if err := json.Unmarshal(received[2].Data, &delta); err != nil {
+			t.Fatal(err)
+		}
+		if size, _ := delta.Bids[0].Size(); received[2].Type != "delta" || size != 0 || delta.UpdateId != book.UpdateId+1 {
+			t.Fatalf("delta = %+v", delta)
+		}
+
+		trades := []PublicTrade{}
+		if err := json.Unmarshal(received[3].Data, &trades); err != nil {
+			t.Fatal(err)
+		}
+		if len(trades) != 1 || trades[0].Side != "Buy" || trades[0].Price != "68210.12" {
+			t.Fatalf("trades = %+v", trades)
+		}
+
+		ticker := Ticker{}
+		if err := json.Unmarshal(received[4].Data, &ticker); err != nil {
+			t.Fatal(err)
+		}
+		if ticker.Symbol != "BTCUSDT" || ticker.LastPrice != "68210.12" {
+			t.Fatalf("ticker = %+v", ticker)
+		}
+	}
+}
+
+func TestResubscribeRequestsFreshSnapshots(t *testing.T) {
+	frames := bybittest.SpotBTCUSDT()
+	fake := bybittest.NewServer(t, bybittest.Config{Frames: frames, DropFirst: true})
+	topic := OrderBookTopicName(50, "BTCUSDT")
+
+	client := NewBybitWsClient(BybitWsClientConfig{Url: fake.WsUrl()})
+	defer client.Close()
+	messages, err := client.Subscribe(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the reconnect, resubscribing works on the new connection.
+	receive(t, messages, 2*len(frames))
+	nextRequest(t, fake)
+	nextRequest(t, fake)
+
+	if err := client.Resubscribe(topic); err != nil {
+		t.Fatal(err)
+	}
+	if request := nextRequest(t, fake); request.Op != "unsubscribe" || len(request.Args) != 1 || request.Args[0] != topic {
+		t.Fatalf("unsubscribe request = %+v", request)
+	}
+	if request := nextRequest(t, fake); request.Op != "subscribe" || len(request.Args) != 1 || request.Args[0] != topic {
+		t.Fatalf("subscribe request = %+v", request)
+	}
+}
+
+func TestCloseEndsTheSubscription(t *testing.T) {
+	frames := bybittest.SpotBTCUSDT()
+	fake := bybittest.NewServer(t, bybittest.Config{Frames: frames})
+
+	client := NewBybitWsClient(BybitWsClientConfig{Url: fake.WsUrl()})
+	messages, err := client.Subscribe(TickersTopicName("BTCUSDT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, messages, len(frames))
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Fatal("message after close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages not closed")
+	}
+	select {
+	case <-fake.Closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+	<-fake.Conns
+	select {
+	case connection := <-fake.Conns:
+		t.Fatalf("reconnected as connection %d", connection)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,107 @@
+// Package bybittest provides a fake Bybit public stream replaying recorded
+// frames, for the tests of the Bybit client and connector.
+package bybittest
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	bybitwsclient "bitnet/bybit_ws_client"
+
+	"github.com/gorilla/websocket"
+)
+
+// spotBTCUSDT is a recorded spot session: subscribe response, order book
+// snapshot and delta, a public trade and a ticker.
+//
+//go:embed testdata/spot_btcusdt.jsonl
+var spotBTCUSDT []byte
+
+// SpotBTCUSDT returns the frames of the recorded BTCUSDT spot session.
+func SpotBTCUSDT() [][]byte {
+	frames := [][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(spotBTCUSDT))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			frames = append(frames, []byte(line))
+		}
+	}
+	return frames
+}
+
+type Config struct {
+	Frames    [][]byte // Replayed to every connection once it has subscribed
+	DropFirst bool     // Close the first connection after the replay, the client has to reconnect
+}
+
+type Server struct {
+	*httptest.Server
+	config   Config
+	Requests chan bybitwsclient.SubscribeRequest // Requests of every connection, in order
+	Conns    chan int                            // Number of each connection once accepted
+	Closed   chan int                            // Number of each connection once gone
+}
+
+// NewServer starts a fake stream, closed when the test ends.
+func NewServer(t testing.TB, config Config) *Server {
+	t.Helper()
+
+	fake := &Server{
+		config:   config,
+		Requests: make(chan bybitwsclient.SubscribeRequest, 100),
+		Conns:    make(chan int, 10),
+		Closed:   make(chan int, 10),
+	}
+
+	var connections atomic.Int32
+	upgrader := websocket.Upgrader{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		connection := int(connections.Add(1))
+		fake.Conns <- connection
+		defer func() { fake.Closed <- connection }()
+
+		replayed := false
+		for {
+			request := bybitwsclient.SubscribeRequest{}
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+			fake.Requests <- request
+
+			if request.Op != "subscribe" || replayed {
+				continue
+			}
+			for _, frame := range fake.config.Frames {
+				if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+					return
+				}
+			}
+			replayed = true
+
+			if fake.config.DropFirst && connection == 1 {
+				return
+			}
+		}
+	}))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+// WsUrl is the websocket url of the server.
+func (s *Server) WsUrl() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
@@ -0,0 +1,5 @@
+module bitnet/bybit_ws_client
+
+go 1.23.1
+
+require github.com/gorilla/websocket v1.5.3
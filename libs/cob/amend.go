@@ -0,0 +1,84 @@
+package cob
+
+import "fmt"
+
+// findResting returns a resting order with the level holding it.
+func (ob *OrderBook) findResting(orderID string) (*Order, *PriceLevel) {
+	for _, side := range []string{"buy", "sell"} {
+		for _, pl := range ob.priceLevels(side) {
+			for _, order := range *pl.Orders {
+				if order.ID == orderID {
+					return order, pl
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// AmendOrder changes the price and open quantity of a resting or stop order,
+// a zero price keeping the current one. Reducing the quantity at the same
+// price keeps the time priority; any other change takes the order out, gives
+// it the last place in its new level and matches it again, so it may trade at
+// once. The order is returned with the fills of that match.
+func (ob *OrderBook) AmendOrder(orderID string, price float64, quantity float64) (*Order, []Fill, error) {
+	if quantity <= 0 || price < 0 {
+		return nil, nil, fmt.Errorf("%w: amended quantity must be positive", ErrInvalidOrder)
+	}
+
+	for _, stop := range ob.StopOrders() {
+		if stop.ID != orderID {
+			continue
+		}
+		if stop.Type == StopLimitOrder && price > 0 {
+			stop.Price = price
+		}
+		stop.Quantity = quantity
+		ob.amended(stop)
+		return stop, []Fill{}, nil
+	}
+
+	order, pl := ob.findResting(orderID)
+	if order == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownOrder, orderID)
+	}
+	if price == 0 {
+		price = order.Price
+	}
+
+	if price == order.Price && quantity <= order.Quantity {
+		pl.decrement(order, order.Quantity-quantity)
+		pl.fix(order) // Size based policies rank it lower now
+		ob.amended(order)
+		ob.levelChanged(order.Side, order.Price)
+		return order, []Fill{}, nil
+	}
+
+	amended := *order
+	amended.Price = price
+	amended.Quantity = quantity
+	amended.Hidden = 0
+	if err := ob.check(&amended); err != nil {
+		return nil, nil, err
+	}
+
+	pl.remove(order.ID)
+	ob.UpdatePriceLevel(order.Side, order.Price)
+	ob.levelChanged(order.Side, order.Price)
+
+	order.Price = price
+	order.Quantity = quantity
+	order.Hidden = 0
+	if target, exists := ob.priceLevels(order.Side)[price]; exists {
+		for _, resting := range *target.Orders {
+			if resting.Timestamp >= order.Timestamp {
+				order.Timestamp = resting.Timestamp + 1
+			}
+		}
+	}
+
+	ob.amended(order)
+	fills := ob.match(order)
+
+	return order, append(fills, ob.TriggerStops()...), nil
+}
@@ -0,0 +1,32 @@
+package cob
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReducingTheLargestOrderReranksIt(t *testing.T) {
+	for _, policy := range []PriorityPolicy{SizePriority{}, ProRata{}} {
+		ob := NewOrderBook()
+		ob.SetPolicy(policy)
+		for i, quantity := range []float64{5, 3, 2} {
+			ob.PlaceOrder(&Order{ID: []string{"a", "b", "c"}[i], Side: "sell", Price: 100, Quantity: quantity, Timestamp: int64(i), Provider: "local"})
+		}
+
+		if _, _, err := ob.AmendOrder("a", 0, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		fills, err := ob.ProcessOrder(&Order{ID: "taker", Side: "buy", Price: 100, Quantity: 4, Provider: "local"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		makers := []string{}
+		for _, fill := range fills {
+			makers = append(makers, fill.MakerOrderID)
+		}
+		if !reflect.DeepEqual(makers, []string{"b", "c"}) {
+			t.Fatalf("%s: matched %v", policy.Name(), makers)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cob"
+
+	"github.com/nats-io/nats.go"
+)
+
+func EventsSubject(symbol string) string {
+	return fmt.Sprintf("cob.events.%s", symbol)
+}
+
+func BookSubject(symbol string) string {
+	return fmt.Sprintf("cob.book.%s", symbol)
+}
+
+// QuerySubject is the request/reply subject of a query: "top", "depth" or
+// "order".
+func QuerySubject(symbol string, query string) string {
+	return fmt.Sprintf("cob.query.%s.%s", symbol, query)
+}
+
+// Order statuses.
+const (
+	StatusOpen      = "open"
+	StatusFilled    = "filled"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+	StatusRejected  = "rejected"
+)
+
+// DepthLevel is the public liquidity at one price, from every provider.
+type DepthLevel struct {
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+}
+
+// Depth is a consolidated depth snapshot, best prices first.
+type Depth struct {
+	Symbol    string       `json:"symbol"`
+	Sequence  uint64       `json:"sequence"` // Sequence of the last event included
+	Bids      []DepthLevel `json:"bids"`
+	Asks      []DepthLevel `json:"asks"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// Top is the best bid and ask, nil for an empty side.
+type Top struct {
+	Symbol   string      `json:"symbol"`
+	Sequence uint64      `json:"sequence"`
+	Bid      *DepthLevel `json:"bid"`
+	Ask      *DepthLevel `json:"ask"`
+}
+
+// OrderStatus is the state of a customer order as seen from the events.
+type OrderStatus struct {
+	OrderID   string  `json:"order_id"`
+	Account   string  `json:"account,omitempty"`
+	Side      string  `json:"side"`
+	Price     float64 `json:"price,omitempty"`
+	Quantity  float64 `json:"quantity"` // Quantity when accepted
+	Filled    float64 `json:"filled"`
+	Remaining float64 `json:"remaining"`
+	AvgPrice  float64 `json:"avg_price,omitempty"`
+	Status    string  `json:"status"`
+	Reason    string  `json:"reason,omitempty"`
+	Sequence  uint64  `json:"sequence"` // Sequence of the last event of the order
+}
+
+// DepthRequest is the payload of a depth query.
+type DepthRequest struct {
+	Levels int `json:"levels"` // Levels per side, Config.DepthLevels when zero
+}
+
+// OrderRequest is the payload of an order query.
+type OrderRequest struct {
+	OrderID string `json:"order_id"`
+}
+
+// ErrorReply is the reply to a query that failed.
+type ErrorReply struct {
+	Error string `json:"error"`
+}
+
+type Config struct {
+	DepthLevels   int           // Levels per side of the snapshots, defaults to 25
+	DepthInterval time.Duration // Minimum time between two snapshots, defaults to 100ms
+	KeepStatuses  int           // Finished orders kept for status queries, defaults to 10000
+}
+
+// Broker publishes the events and the depth of one book on NATS and answers
+// queries about it, so other services can follow the book without linking
+// the library. The book is only read while holding lock, the lock its
+// writers hold.
+type Broker struct {
+	natsClient *nats.Conn
+	book       *cob.OrderBook
+	lock       sync.Locker
+	config     Config
+
+	mutex    sync.Mutex
+	dirty    bool // Levels changed since the last snapshot
+	statuses map[string]*OrderStatus
+	finished []string // Finished orders, oldest first
+}
+
+// New creates a broker and registers it as a sink of book. Call it before
+// the book is shared, or while holding lock.
+func New(natsClient *nats.Conn, book *cob.OrderBook, lock sync.Locker, config Config) *Broker {
+	if config.DepthLevels <= 0 {
+		config.DepthLevels = 25
+	}
+	if config.DepthInterval <= 0 {
+		config.DepthInterval = 100 * time.Millisecond
+	}
+	if config.KeepStatuses <= 0 {
+		config.KeepStatuses = 10000
+	}
+
+	b := &Broker{
+		natsClient: natsClient,
+		book:       book,
+		lock:       lock,
+		config:     config,
+		statuses:   make(map[string]*OrderStatus),
+	}
+	book.AddSink(b)
+
+	return b
+}
+
+// HandleEvent publishes an event on cob.events.<symbol> and updates the order
+// statuses. It runs under the book lock.
+func (b *Broker) HandleEvent(event cob.Event) {
+	b.track(event)
+	b.publish(EventsSubject(event.Symbol), event)
+}
+
+func (b *Broker) track(event cob.Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch event.Type {
+	case cob.BookLevelChanged:
+		b.dirty = true
+
+	case cob.OrderAccepted, cob.OrderRejected:
+		if current, ok := b.statuses[event.Order.OrderID]; ok && current.Status == StatusOpen && event.Type == cob.OrderRejected {
+			return // A duplicate of an open order
+		}
+		status := &OrderStatus{
+			OrderID:   event.Order.OrderID,
+			Account:   event.Order.Account,
+			Side:      event.Order.Side,
+			Price:     event.Order.Price,
+			Quantity:  event.Order.Quantity,
+			Remaining: event.Order.Quantity,
+			Status:    StatusOpen,
+			Sequence:  event.Sequence,
+		}
+		b.statuses[status.OrderID] = status
+		if event.Type == cob.OrderRejected {
+			status.Remaining = 0
+			status.Status = StatusRejected
+			status.Reason = event.Order.Reason
+			b.finish(status.OrderID)
+		}
+
+	case cob.OrderFilled:
+		for _, orderID := range []string{event.Fill.TakerOrderID, event.Fill.MakerOrderID} {
+			status, ok := b.statuses[orderID]
+			if !ok {
+				continue // External liquidity
+			}
+			status.AvgPrice = (status.AvgPrice*status.Filled + event.Fill.Price*event.Fill.Quantity) / (status.Filled + event.Fill.Quantity)
+			status.Filled += event.Fill.Quantity
+			status.Remaining -= event.Fill.Quantity
+			status.Sequence = event.Sequence
+			if status.Remaining <= 1e-12 {
+				status.Remaining = 0
+				status.Status = StatusFilled
+				b.finish(orderID)
+			}
+		}
+
+	case cob.OrderAmended:
+		status, ok := b.statuses[event.Order.OrderID]
+		if !ok {
+			return
+		}
+		status.Price = event.Order.Price
+		status.Remaining = event.Order.Quantity
+		status.Quantity = status.Filled + status.Remaining
+		status.Sequence = event.Sequence
+
+	case cob.OrderReduced:
+		status, ok := b.statuses[event.Order.OrderID]
+		if !ok {
+			return
+		}
+		status.Remaining -= event.Order.Quantity
+		status.Quantity = status.Filled + status.Remaining
+		status.Reason = event.Order.Reason
+		status.Sequence = event.Sequence
+
+	case cob.OrderCancelled, cob.OrderExpired:
+		status, ok := b.statuses[event.Order.OrderID]
+		if !ok {
+			return
+		}
+		status.Remaining = 0
+		status.Status = StatusCancelled
+		if event.Type == cob.OrderExpired {
+			status.Status = StatusExpired
+		}
+		status.Reason = event.Order.Reason
+		status.Sequence = event.Sequence
+		b.finish(status.OrderID)
+	}
+}
+
+// finish keeps the status of a finished order until KeepStatuses newer ones
+// have finished.
+func (b *Broker) finish(orderID string) {
+	b.finished = append(b.finished, orderID)
+	for len(b.finished) > b.config.KeepStatuses {
+		if status, ok := b.statuses[b.finished[0]]; ok && status.Status != StatusOpen {
+			delete(b.statuses, b.finished[0])
+		}
+		b.finished = b.finished[1:]
+	}
+}
+
+// Status returns the status of an order.
+func (b *Broker) Status(orderID string) (OrderStatus, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status, ok := b.statuses[orderID]
+	if !ok {
+		return OrderStatus{}, false
+	}
+	return *status, true
+}
+
+// Depth returns a consolidated snapshot of up to levels per side.
+func (b *Broker) Depth(levels int) Depth {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.depth(levels)
+}
+
+func (b *Broker) depth(levels int) Depth {
+	return Depth{
+		Symbol:    b.book.Symbol,
+		Sequence:  b.book.Sequence(),
+		Bids:      b.side("buy", levels),
+		Asks:      b.side("sell", levels),
+		Timestamp: b.book.Now().UTC(),
+	}
+}
+
+func (b *Broker) side(side string, levels int) []DepthLevel {
+	priceLevels := b.book.Bids
+	if side == "sell" {
+		priceLevels = b.book.Asks
+	}
+
+	depth := []DepthLevel{}
+	for _, price := range b.book.Prices(side) {
+		if len(depth) >= levels {
+			break
+		}
+		if quantity := priceLevels[price].VisibleQuantity(); quantity > 0 {
+			depth = append(depth, DepthLevel{Price: price, Quantity: quantity})
+		}
+	}
+	return depth
+}
+
+// Top returns the best bid and ask.
+func (b *Broker) Top() Top {
+	depth := b.Depth(1)
+
+	top := Top{Symbol: depth.Symbol, Sequence: depth.Sequence}
+	if len(depth.Bids) > 0 {
+		top.Bid = &depth.Bids[0]
+	}
+	if len(depth.Asks) > 0 {
+		top.Ask = &depth.Asks[0]
+	}
+	return top
+}
+
+// Run serves the queries and publishes a depth snapshot on cob.book.<symbol>
+// at most every DepthInterval while the book changes, until ctx is cancelled.
+func (b *Broker) Run(ctx context.Context) error {
+	symbol := b.book.Symbol
+	handlers := map[string]nats.MsgHandler{
+		"top":   b.serveTop,
+		"depth": b.serveDepth,
+		"order": b.serveOrder,
+	}
+
+	for query, handler := range handlers {
+		subscription, err := b.natsClient.Subscribe(QuerySubject(symbol, query), handler)
+		if err != nil {
+			return fmt.Errorf("unable to subscribe to %s: %w", QuerySubject(symbol, query), err)
+		}
+		defer subscription.Unsubscribe()
+	}
+
+	ticker := time.NewTicker(b.config.DepthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		b.mutex.Lock()
+		dirty := b.dirty
+		b.dirty = false
+		b.mutex.Unlock()
+
+		if dirty {
+			b.publish(BookSubject(symbol), b.Depth(b.config.DepthLevels))
+		}
+	}
+}
+
+func (b *Broker) serveTop(msg *nats.Msg) {
+	b.reply(msg, b.Top())
+}
+
+func (b *Broker) serveDepth(msg *nats.Msg) {
+	request := DepthRequest{}
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &request); err != nil {
+			b.reply(msg, ErrorReply{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+	}
+	if request.Levels <= 0 {
+		request.Levels = b.config.DepthLevels
+	}
+
+	b.reply(msg, b.Depth(request.Levels))
+}
+
+func (b *Broker) serveOrder(msg *nats.Msg) {
+	request := OrderRequest{}
+	if err := json.Unmarshal(msg.Data, &request); err != nil {
+		b.reply(msg, ErrorReply{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	status, ok := b.Status(request.OrderID)
+	if !ok {
+		b.reply(msg, ErrorReply{Error: fmt.Sprintf("unknown order %s", request.OrderID)})
+		return
+	}
+	b.reply(msg, status)
+}
+
+func (b *Broker) reply(msg *nats.Msg, reply any) {
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("failed to marshal %+v: %+v\n", reply, err)
+		return
+	}
+
+	if err := msg.Respond(encoded); err != nil {
+		log.Printf("unable to reply on %s: %+v\n", msg.Subject, err)
+	}
+}
+
+func (b *Broker) publish(subject string, message any) {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("failed to marshal %+v: %+v\n", message, err)
+		return
+	}
+
+	if err := b.natsClient.Publish(subject, encoded); err != nil {
+		log.Printf("unable to publish to %s: %+v\n", subject, err)
+	}
+}
@@ -0,0 +1,231 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"cob"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// startServer runs an in-process NATS server on port, a random one when -1.
+func startServer(t *testing.T, port int) *server.Server {
+	t.Helper()
+
+	natsServer, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go natsServer.Start()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(natsServer.Shutdown)
+	return natsServer
+}
+
+func connect(t *testing.T, natsServer *server.Server, options ...nats.Option) *nats.Conn {
+	t.Helper()
+
+	natsClient, err := nats.Connect(natsServer.ClientURL(), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(natsClient.Close)
+	return natsClient
+}
+
+// runBroker starts a broker of a new BTC/USD book on natsClient.
+func runBroker(t *testing.T, natsClient *nats.Conn) (*cob.OrderBook, sync.Locker) {
+	t.Helper()
+
+	book := cob.NewOrderBook()
+	book.Symbol = "BTC/USD"
+	lock := &sync.Mutex{}
+	broker := New(natsClient, book, lock, Config{DepthInterval: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- broker.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	// Run subscribes in the background, wait until queries are answered.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := natsClient.Request(QuerySubject(book.Symbol, "top"), nil, 100*time.Millisecond); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("broker not answering")
+		}
+	}
+	return book, lock
+}
+
+func receive[T any](t *testing.T, messages chan *nats.Msg) T {
+	t.Helper()
+
+	var decoded T
+	select {
+	case msg := <-messages:
+		if err := json.Unmarshal(msg.Data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+	return decoded
+}
+
+func request[T any](t *testing.T, natsClient *nats.Conn, subject string, payload any) T {
+	t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := natsClient.Request(subject, data, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded T
+	if err := json.Unmarshal(msg.Data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func processOrder(t *testing.T, book *cob.OrderBook, lock sync.Locker, order *cob.Order) {
+	t.Helper()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := book.ProcessOrder(order); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventsAndDepthArePublished(t *testing.T) {
+	natsServer := startServer(t, -1)
+	subscriber := connect(t, natsServer)
+	book, lock := runBroker(t, connect(t, natsServer))
+
+	events := make(chan *nats.Msg, 100)
+	snapshots := make(chan *nats.Msg, 100)
+	for subject, messages := range map[string]chan *nats.Msg{EventsSubject(book.Symbol): events, BookSubject(book.Symbol): snapshots} {
+		if _, err := subscriber.ChanSubscribe(subject, messages); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := subscriber.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	processOrder(t, book, lock, &cob.Order{ID: "o1", Account: "a", Side: "buy", Price: 100, Quantity: 2, Provider: "local"})
+
+	accepted := receive[cob.Event](t, events)
+	if accepted.Type != cob.OrderAccepted || accepted.Sequence != 1 || accepted.Symbol != "BTC/USD" || accepted.Order.OrderID != "o1" {
+		t.Fatalf("accepted %+v", accepted)
+	}
+	if level := receive[cob.Event](t, events); level.Type != cob.BookLevelChanged || level.Sequence != 2 || level.Level.Quantity != 2 {
+		t.Fatalf("level %+v", level)
+	}
+
+	depth := receive[Depth](t, snapshots)
+	if depth.Sequence != 2 || len(depth.Bids) != 1 || depth.Bids[0] != (DepthLevel{Price: 100, Quantity: 2}) || len(depth.Asks) != 0 {
+		t.Fatalf("depth %+v", depth)
+	}
+	// Snapshots stop while the book does not change.
+	select {
+	case msg := <-snapshots:
+		t.Fatalf("snapshot of an unchanged book %s", msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestQueriesAreAnswered(t *testing.T) {
+	natsServer := startServer(t, -1)
+	natsClient := connect(t, natsServer)
+	book, lock := runBroker(t, natsClient)
+
+	processOrder(t, book, lock, &cob.Order{ID: "bid", Account: "a", Side: "buy", Price: 99, Quantity: 1, Provider: "local"})
+	processOrder(t, book, lock, &cob.Order{ID: "ask", Account: "b", Side: "sell", Price: 101, Quantity: 3, Provider: "local"})
+	processOrder(t, book, lock, &cob.Order{ID: "take", Account: "c", Side: "buy", Price: 101, Quantity: 1, Provider: "local"})
+
+	top := request[Top](t, natsClient, QuerySubject(book.Symbol, "top"), nil)
+	if top.Bid == nil || *top.Bid != (DepthLevel{Price: 99, Quantity: 1}) || top.Ask == nil || *top.Ask != (DepthLevel{Price: 101, Quantity: 2}) {
+		t.Fatalf("top %+v", top)
+	}
+
+	depth := request[Depth](t, natsClient, QuerySubject(book.Symbol, "depth"), DepthRequest{Levels: 1})
+	if len(depth.Bids) != 1 || len(depth.Asks) != 1 || depth.Sequence != book.Sequence() {
+		t.Fatalf("depth %+v", depth)
+	}
+
+	status := request[OrderStatus](t, natsClient, QuerySubject(book.Symbol, "order"), OrderRequest{OrderID: "ask"})
+	if status.Status != StatusOpen || status.Filled != 1 || status.Remaining != 2 || status.AvgPrice != 101 {
+		t.Fatalf("status %+v", status)
+	}
+	if status := request[OrderStatus](t, natsClient, QuerySubject(book.Symbol, "order"), OrderRequest{OrderID: "take"}); status.Status != StatusFilled {
+		t.Fatalf("taker status %+v", status)
+	}
+
+	if reply := request[ErrorReply](t, natsClient, QuerySubject(book.Symbol, "order"), OrderRequest{OrderID: "unknown"}); reply.Error == "" {
+		t.Fatal("unknown order answered without an error")
+	}
+}
+
+func TestBrokerResumesAfterReconnect(t *testing.T) {
+	natsServer := startServer(t, -1)
+
+	reconnected := make(chan struct{}, 1)
+	natsClient := connect(t, natsServer,
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.ReconnectHandler(func(*nats.Conn) { reconnected <- struct{}{} }),
+	)
+	book, lock := runBroker(t, natsClient)
+
+	port := natsServer.Addr().(*net.TCPAddr).Port
+	natsServer.Shutdown()
+	natsServer.WaitForShutdown()
+	restarted := startServer(t, port)
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker not reconnected")
+	}
+
+	subscriber := connect(t, restarted)
+	events := make(chan *nats.Msg, 100)
+	if _, err := subscriber.ChanSubscribe(EventsSubject(book.Symbol), events); err != nil {
+		t.Fatal(err)
+	}
+	if err := subscriber.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	processOrder(t, book, lock, &cob.Order{ID: "o1", Account: "a", Side: "sell", Price: 101, Quantity: 1, Provider: "local"})
+	if accepted := receive[cob.Event](t, events); accepted.Type != cob.OrderAccepted || accepted.Order.OrderID != "o1" {
+		t.Fatalf("accepted %+v", accepted)
+	}
+
+	// The query subscriptions of Run are restored with the connection.
+	top := request[Top](t, subscriber, QuerySubject(book.Symbol, "top"), nil)
+	if top.Ask == nil || top.Ask.Price != 101 {
+		t.Fatalf("top %+v", top)
+	}
+}
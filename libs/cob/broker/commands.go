@@ -0,0 +1,487 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cob"
+	"cob/journal"
+	"cob/risk"
+
+	"github.com/nats-io/nats.go"
+)
+
+// SchemaVersion is the version of the command schema this engine speaks.
+// Commands of other versions are rejected.
+const SchemaVersion = 1
+
+// Commands.
+const (
+	PlaceCommand  = "place"
+	CancelCommand = "cancel"
+	AmendCommand  = "amend"
+)
+
+// ReplyToHeader carries the subject acks go to for commands read from
+// JetStream, whose own reply subject gets the stream acknowledgement.
+const ReplyToHeader = "Reply-To"
+
+// CommandSubject is the request/reply subject of a command.
+func CommandSubject(symbol string, command string) string {
+	return fmt.Sprintf("cob.cmd.%s.%s", symbol, command)
+}
+
+// Place asks for a new customer order. ClientOrderID is unique per account;
+// placing it again returns the ack of the first attempt.
+type Place struct {
+	Version             int       `json:"version"`
+	ClientOrderID       string    `json:"client_order_id"`
+	Account             string    `json:"account"`
+	Side                string    `json:"side"`
+	Type                string    `json:"type,omitempty"`
+	TimeInForce         string    `json:"time_in_force,omitempty"`
+	Price               float64   `json:"price,omitempty"`
+	Quantity            float64   `json:"quantity"`
+	PostOnly            bool      `json:"post_only,omitempty"`
+	ProtectionPrice     float64   `json:"protection_price,omitempty"`
+	StopPrice           float64   `json:"stop_price,omitempty"`
+	Trigger             string    `json:"trigger,omitempty"`
+	ExpireAt            time.Time `json:"expire_at,omitempty"`
+	DisplayQuantity     float64   `json:"display_quantity,omitempty"`
+	SelfTradePrevention string    `json:"self_trade_prevention,omitempty"`
+}
+
+// Cancel asks for the rest of an order to be cancelled.
+type Cancel struct {
+	Version       int    `json:"version"`
+	ClientOrderID string `json:"client_order_id"`
+	Account       string `json:"account"`
+}
+
+// Amend asks for a new price and open quantity of an order, a zero price
+// keeping the current one.
+type Amend struct {
+	Version       int     `json:"version"`
+	ClientOrderID string  `json:"client_order_id"`
+	Account       string  `json:"account"`
+	Price         float64 `json:"price,omitempty"`
+	Quantity      float64 `json:"quantity"`
+}
+
+// Ack is the synchronous answer to a command.
+type Ack struct {
+	Version       int        `json:"version"`
+	Command       string     `json:"command"`
+	ClientOrderID string     `json:"client_order_id"`
+	OrderID       string     `json:"order_id,omitempty"`
+	Accepted      bool       `json:"accepted"`
+	Reason        string     `json:"reason,omitempty"` // Rejection reason, e.g. a risk.Reason
+	Message       string     `json:"message,omitempty"`
+	Fills         []cob.Fill `json:"fills,omitempty"`
+	Remaining     float64    `json:"remaining"` // Open quantity of the order after the command
+	Sequence      uint64     `json:"sequence"`  // Engine sequence after the command
+	Duplicate     bool       `json:"duplicate,omitempty"`
+}
+
+type CommandConfig struct {
+	Stream   string // JetStream stream to read commands from, core request/reply when empty
+	Durable  string // Durable consumer of the stream, defaults to "cob-<symbol>"
+	KeepAcks int    // Place acks kept to answer repeated places, defaults to 10000
+
+	// Journal of the book, when it has one. Commands are appended to it
+	// before they are applied, the risk rejections included. It must be
+	// opened with the checker of the commands as journal.Config.Checker, so
+	// recovery rebuilds the open orders and positions the limits apply to.
+	Journal *journal.Journal
+}
+
+// Commands executes customer order commands received on NATS against one book,
+// through the risk checks when a checker is given.
+//
+// Without a stream, commands are request/reply on cob.cmd.<symbol>.<command>.
+// With one, they are read from JetStream and acknowledged once executed, so
+// commands sent while the engine is down are executed when it comes back;
+// acks then go to the subject in the Reply-To header.
+//
+// Repeated places are answered with the ack of the first attempt for the last
+// KeepAcks orders. Run rebuilds the acks of the orders still open in the book;
+// older repeats of open orders are rejected by the book as duplicates.
+//
+// With a journal configured, each command is appended to it before it is
+// applied, so replaying the journal rebuilds the book the commands left. Place
+// acks are journaled too: Run restores the last ones from the journal,
+// filled and rejected orders included, and a repeat of any order the journal
+// ever placed is rejected as a duplicate, so a redelivered place never
+// trades twice.
+type Commands struct {
+	natsClient *nats.Conn
+	book       *cob.OrderBook
+	lock       sync.Locker
+	checker    *risk.Checker
+	config     CommandConfig
+
+	acks     map[string]Ack // Place acks per account and client order ID
+	ackOrder []string       // Keys of acks, oldest first
+}
+
+// NewCommands creates the command interface of book, checker may be nil.
+func NewCommands(natsClient *nats.Conn, book *cob.OrderBook, lock sync.Locker, checker *risk.Checker, config CommandConfig) *Commands {
+	if config.Durable == "" {
+		config.Durable = "cob-" + book.Symbol
+	}
+	if config.KeepAcks <= 0 {
+		config.KeepAcks = 10000
+	}
+
+	return &Commands{
+		natsClient: natsClient,
+		book:       book,
+		lock:       lock,
+		checker:    checker,
+		config:     config,
+		acks:       make(map[string]Ack),
+	}
+}
+
+// OrderID is the engine ID of the order of a client order ID.
+func OrderID(account string, clientOrderID string) string {
+	return account + "/" + clientOrderID
+}
+
+// Run serves the commands until ctx is cancelled.
+func (c *Commands) Run(ctx context.Context) error {
+	c.restore()
+	subject := CommandSubject(c.book.Symbol, "*")
+
+	var subscription *nats.Subscription
+	var err error
+	if c.config.Stream == "" {
+		subscription, err = c.natsClient.Subscribe(subject, c.serve)
+	} else {
+		subscription, err = c.subscribeStream(subject)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to subscribe to %s: %w", subject, err)
+	}
+	defer subscription.Unsubscribe()
+
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Commands) subscribeStream(subject string) (*nats.Subscription, error) {
+	js, err := c.natsClient.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := js.StreamInfo(c.config.Stream); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{Name: c.config.Stream, Subjects: []string{subject}})
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return js.Subscribe(subject, c.serve,
+		nats.BindStream(c.config.Stream),
+		nats.Durable(c.config.Durable),
+		nats.DeliverAll(),
+		nats.ManualAck(),
+	)
+}
+
+// restore rebuilds the place acks journaled and those of the customer orders
+// open in the book.
+func (c *Commands) restore() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.config.Journal != nil {
+		for _, entry := range c.config.Journal.Acks() {
+			ack := Ack{}
+			if err := json.Unmarshal(entry.Ack, &ack); err != nil {
+				log.Printf("skipping unreadable ack of %s: %v\n", entry.OrderID, err)
+				continue
+			}
+			c.remember(entry.OrderID, ack)
+		}
+	}
+
+	for _, order := range c.book.LocalOrders() {
+		if order.Account == "" || !strings.HasPrefix(order.ID, order.Account+"/") {
+			continue // Not placed through commands
+		}
+
+		if _, ok := c.acks[order.ID]; !ok {
+			clientOrderID := strings.TrimPrefix(order.ID, order.Account+"/")
+			c.remember(order.ID, c.accepted(PlaceCommand, clientOrderID, order, nil))
+		}
+	}
+}
+
+// remember keeps the place ack of orderID, forgetting the oldest ones beyond
+// KeepAcks.
+func (c *Commands) remember(orderID string, ack Ack) {
+	c.acks[orderID] = ack
+	c.ackOrder = append(c.ackOrder, orderID)
+
+	for len(c.ackOrder) > c.config.KeepAcks {
+		delete(c.acks, c.ackOrder[0])
+		c.ackOrder = c.ackOrder[1:]
+	}
+}
+
+func (c *Commands) serve(msg *nats.Msg) {
+	ack := c.Execute(commandOf(msg.Subject), msg.Data)
+
+	encoded, err := json.Marshal(ack)
+	if err != nil {
+		log.Printf("failed to marshal %+v: %+v\n", ack, err)
+		return
+	}
+
+	replyTo := msg.Reply
+	if c.config.Stream != "" {
+		replyTo = msg.Header.Get(ReplyToHeader)
+		if err := msg.Ack(); err != nil {
+			log.Printf("unable to acknowledge %s: %+v\n", msg.Subject, err)
+		}
+	}
+	if replyTo == "" {
+		return
+	}
+
+	if err := c.natsClient.Publish(replyTo, encoded); err != nil {
+		log.Printf("unable to reply on %s: %+v\n", replyTo, err)
+	}
+}
+
+func commandOf(subject string) string {
+	for i := len(subject) - 1; i >= 0; i-- {
+		if subject[i] == '.' {
+			return subject[i+1:]
+		}
+	}
+	return subject
+}
+
+// Execute runs one encoded command and returns its ack.
+func (c *Commands) Execute(command string, data []byte) Ack {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch command {
+	case PlaceCommand:
+		place := Place{}
+		if err := decode(data, &place, &place.Version); err != nil {
+			return c.rejected(command, place.ClientOrderID, err)
+		}
+		return c.place(place)
+	case CancelCommand:
+		cancel := Cancel{}
+		if err := decode(data, &cancel, &cancel.Version); err != nil {
+			return c.rejected(command, cancel.ClientOrderID, err)
+		}
+		return c.cancel(cancel)
+	case AmendCommand:
+		amend := Amend{}
+		if err := decode(data, &amend, &amend.Version); err != nil {
+			return c.rejected(command, amend.ClientOrderID, err)
+		}
+		return c.amend(amend)
+	default:
+		return c.rejected(command, "", fmt.Errorf("unknown command %q", command))
+	}
+}
+
+func decode(data []byte, command any, version *int) error {
+	if err := json.Unmarshal(data, command); err != nil {
+		return fmt.Errorf("invalid command: %v", err)
+	}
+	if *version != SchemaVersion {
+		return fmt.Errorf("unsupported schema version %d, expected %d", *version, SchemaVersion)
+	}
+	return nil
+}
+
+func (c *Commands) rejected(command string, clientOrderID string, err error) Ack {
+	ack := Ack{
+		Version:       SchemaVersion,
+		Command:       command,
+		ClientOrderID: clientOrderID,
+		Reason:        string(risk.ReasonInvalidOrder),
+		Message:       err.Error(),
+		Sequence:      c.book.Sequence(),
+	}
+
+	var rejection *risk.Rejection
+	if errors.As(err, &rejection) {
+		ack.Reason = string(rejection.Reason)
+		ack.Message = rejection.Message
+	}
+
+	return ack
+}
+
+func (c *Commands) accepted(command string, clientOrderID string, order *cob.Order, fills []cob.Fill) Ack {
+	return Ack{
+		Version:       SchemaVersion,
+		Command:       command,
+		ClientOrderID: clientOrderID,
+		OrderID:       order.ID,
+		Accepted:      true,
+		Fills:         fills,
+		Remaining:     order.Quantity,
+		Sequence:      c.book.Sequence(),
+	}
+}
+
+func (c *Commands) place(place Place) Ack {
+	if place.ClientOrderID == "" || place.Account == "" {
+		return c.rejected(PlaceCommand, place.ClientOrderID, errors.New("client order ID and account are required"))
+	}
+
+	orderID := OrderID(place.Account, place.ClientOrderID)
+	if ack, ok := c.acks[orderID]; ok {
+		ack.Duplicate = true
+		return ack
+	}
+	if c.config.Journal != nil && c.config.Journal.Placed(orderID) {
+		ack := c.rejected(PlaceCommand, place.ClientOrderID, fmt.Errorf("%w: %s was already placed", cob.ErrDuplicateOrder, orderID))
+		ack.Reason = string(cob.DuplicateOrderReason)
+		ack.OrderID = orderID
+		return ack
+	}
+
+	order := &cob.Order{
+		ID:                  orderID,
+		Side:                place.Side,
+		Price:               place.Price,
+		Quantity:            place.Quantity,
+		Provider:            "local",
+		Account:             place.Account,
+		Type:                place.Type,
+		TimeInForce:         place.TimeInForce,
+		PostOnly:            place.PostOnly,
+		ProtectionPrice:     place.ProtectionPrice,
+		StopPrice:           place.StopPrice,
+		Trigger:             place.Trigger,
+		ExpireAt:            place.ExpireAt,
+		DisplayQuantity:     place.DisplayQuantity,
+		SelfTradePrevention: place.SelfTradePrevention,
+	}
+
+	if err := c.journalPlace(order); err != nil {
+		ack := c.rejected(PlaceCommand, place.ClientOrderID, err)
+		ack.OrderID = orderID
+		return ack
+	}
+	order.Timestamp = c.book.Now().UnixNano() // Time of the journal entry, as replay sees it
+
+	var fills []cob.Fill
+	var err error
+	if c.checker != nil {
+		fills, err = c.checker.PlaceOrder(c.book, order)
+	} else {
+		fills, err = c.book.ProcessOrder(order)
+	}
+
+	ack := c.accepted(PlaceCommand, place.ClientOrderID, order, fills)
+	if err != nil {
+		ack = c.rejected(PlaceCommand, place.ClientOrderID, err)
+		ack.OrderID = orderID
+	}
+	c.remember(orderID, ack)
+	if c.config.Journal != nil {
+		if err := c.config.Journal.AppendAck(orderID, ack); err != nil {
+			log.Printf("unable to journal the ack of %s: %v\n", orderID, err)
+		}
+	}
+
+	return ack
+}
+
+func (c *Commands) cancel(cancel Cancel) Ack {
+	orderID := OrderID(cancel.Account, cancel.ClientOrderID)
+
+	if err := c.journal(journal.Entry{Kind: journal.CancelEntry, OrderID: orderID}); err != nil {
+		return c.rejected(CancelCommand, cancel.ClientOrderID, err)
+	}
+
+	var order *cob.Order
+	if c.checker != nil {
+		order = c.checker.CancelOrder(c.book, orderID)
+	} else {
+		order = c.book.CancelOrder(orderID)
+	}
+	if order == nil {
+		return c.rejected(CancelCommand, cancel.ClientOrderID, fmt.Errorf("%w: %s is not open", cob.ErrUnknownOrder, orderID))
+	}
+
+	ack := c.accepted(CancelCommand, cancel.ClientOrderID, order, nil)
+	ack.Remaining = 0
+	return ack
+}
+
+func (c *Commands) amend(amend Amend) Ack {
+	orderID := OrderID(amend.Account, amend.ClientOrderID)
+	order := c.book.Order(orderID)
+	if order == nil {
+		return c.rejected(AmendCommand, amend.ClientOrderID, fmt.Errorf("%w: %s", cob.ErrUnknownOrder, orderID))
+	}
+
+	if c.checker != nil {
+		if err := c.checker.CheckAmend(c.book, orderID, amend.Price, amend.Quantity); err != nil {
+			return c.rejected(AmendCommand, amend.ClientOrderID, err)
+		}
+	}
+	if err := c.journal(journal.Entry{Kind: journal.AmendEntry, OrderID: orderID, Price: amend.Price, Quantity: amend.Quantity}); err != nil {
+		return c.rejected(AmendCommand, amend.ClientOrderID, err)
+	}
+
+	var fills []cob.Fill
+	var err error
+	if c.checker != nil {
+		fills, err = c.checker.AmendOrder(c.book, orderID, amend.Price, amend.Quantity)
+	} else {
+		_, fills, err = c.book.AmendOrder(orderID, amend.Price, amend.Quantity)
+	}
+	if err != nil {
+		return c.rejected(AmendCommand, amend.ClientOrderID, err)
+	}
+
+	return c.accepted(AmendCommand, amend.ClientOrderID, order, fills)
+}
+
+// journal appends entry to the journal of the book, if any.
+func (c *Commands) journal(entry journal.Entry) error {
+	if c.config.Journal == nil {
+		return nil
+	}
+	return c.config.Journal.Append(entry)
+}
+
+// journalPlace journals a place as the book will see it: a risk rejection is
+// journaled as such, as it never reaches the book.
+func (c *Commands) journalPlace(order *cob.Order) error {
+	if c.config.Journal == nil {
+		return nil
+	}
+
+	journaled := *order
+	if c.checker != nil {
+		var rejection *risk.Rejection
+		if err := c.checker.Check(c.book, order); errors.As(err, &rejection) {
+			return c.config.Journal.Append(journal.Entry{Kind: journal.RejectEntry, Order: &journaled, Reason: string(rejection.Reason)})
+		}
+	}
+	return c.config.Journal.Append(journal.Entry{Kind: journal.PlaceEntry, Order: &journaled})
+}
@@ -0,0 +1,262 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cob"
+	"cob/journal"
+	"cob/risk"
+)
+
+func execute(t *testing.T, c *Commands, command string, payload any) Ack {
+	t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Execute(command, data)
+}
+
+func place(clientOrderID string, price float64) Place {
+	return Place{Version: SchemaVersion, ClientOrderID: clientOrderID, Account: "acct", Side: "buy", Price: price, Quantity: 1}
+}
+
+func TestRepeatedPlaceReturnsTheFirstAck(t *testing.T) {
+	c := NewCommands(nil, cob.NewOrderBook(), &sync.Mutex{}, nil, CommandConfig{})
+
+	first := execute(t, c, PlaceCommand, place("c1", 100))
+	again := execute(t, c, PlaceCommand, place("c1", 101))
+	if !first.Accepted || !again.Duplicate || again.Sequence != first.Sequence {
+		t.Fatalf("first %+v, again %+v", first, again)
+	}
+}
+
+func TestForgottenAckOfAnOpenOrderIsRejectedAsDuplicate(t *testing.T) {
+	book := cob.NewOrderBook()
+	c := NewCommands(nil, book, &sync.Mutex{}, nil, CommandConfig{KeepAcks: 2})
+
+	for _, id := range []string{"c1", "c2", "c3"} {
+		if ack := execute(t, c, PlaceCommand, place(id, 100)); !ack.Accepted {
+			t.Fatalf("%s: %+v", id, ack)
+		}
+	}
+	if len(c.acks) != 2 || len(c.ackOrder) != 2 {
+		t.Fatalf("%d acks kept", len(c.acks))
+	}
+
+	ack := execute(t, c, PlaceCommand, place("c1", 100))
+	if ack.Accepted || ack.Duplicate || !strings.Contains(ack.Message, "duplicate order ID") {
+		t.Fatalf("ack = %+v", ack)
+	}
+	if orders := book.LocalOrders(); len(orders) != 3 {
+		t.Fatalf("%d orders in the book", len(orders))
+	}
+}
+
+func TestRestoreRebuildsAcksOfOpenOrders(t *testing.T) {
+	book := cob.NewOrderBook()
+	for _, order := range []*cob.Order{
+		{ID: OrderID("acct", "c1"), Account: "acct", Side: "buy", Price: 100, Quantity: 2, Provider: "local"},
+		{ID: OrderID("acct", "s1"), Account: "acct", Side: "sell", Type: cob.StopOrder, StopPrice: 90, Quantity: 1, Provider: "local"},
+	} {
+		if _, err := book.ProcessOrder(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A restarted engine, its book replayed.
+	c := NewCommands(nil, book, &sync.Mutex{}, nil, CommandConfig{})
+	c.restore()
+
+	ack := execute(t, c, PlaceCommand, place("c1", 100))
+	if !ack.Duplicate || !ack.Accepted || ack.OrderID != "acct/c1" || ack.Remaining != 2 {
+		t.Fatalf("ack = %+v", ack)
+	}
+	if ack := execute(t, c, PlaceCommand, Place{Version: SchemaVersion, ClientOrderID: "s1", Account: "acct", Side: "sell"}); !ack.Duplicate {
+		t.Fatalf("stop ack = %+v", ack)
+	}
+
+	amended := execute(t, c, AmendCommand, Amend{Version: SchemaVersion, ClientOrderID: "c1", Account: "acct", Quantity: 1})
+	if !amended.Accepted || amended.Remaining != 1 {
+		t.Fatalf("amend = %+v", amended)
+	}
+}
+
+// eventLog encodes the events of a book as they are published.
+type eventLog struct {
+	bytes.Buffer
+}
+
+func (l *eventLog) HandleEvent(event cob.Event) {
+	encoded, _ := json.Marshal(event)
+	l.Write(append(encoded, '\n'))
+}
+
+func TestJournaledCommandsReplayToTheSameEvents(t *testing.T) {
+	dir := t.TempDir()
+	clock := cob.NewManualClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	live := &eventLog{}
+	book := cob.NewOrderBook()
+	book.AddSink(live)
+	j, err := journal.Open(dir, book, journal.Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCommands(nil, book, &sync.Mutex{}, risk.New(risk.Limits{MaxQuantity: 5}), CommandConfig{Journal: j})
+
+	commands := []struct {
+		command  string
+		payload  any
+		accepted bool
+	}{
+		{PlaceCommand, place("c1", 100), true},
+		{PlaceCommand, Place{Version: SchemaVersion, ClientOrderID: "c2", Account: "acct", Side: "buy", Price: 100, Quantity: 10}, false},
+		{AmendCommand, Amend{Version: SchemaVersion, ClientOrderID: "c1", Account: "acct", Quantity: 10}, false},
+		{AmendCommand, Amend{Version: SchemaVersion, ClientOrderID: "c1", Account: "acct", Price: 101, Quantity: 3}, true},
+		{PlaceCommand, Place{Version: SchemaVersion, ClientOrderID: "c3", Account: "other", Side: "sell", Price: 101, Quantity: 1}, true},
+		{CancelCommand, Cancel{Version: SchemaVersion, ClientOrderID: "c1", Account: "acct"}, true},
+	}
+	for _, command := range commands {
+		clock.Advance(time.Second)
+		if ack := execute(t, c, command.command, command.payload); ack.Accepted != command.accepted {
+			t.Fatalf("%s %+v: ack %+v", command.command, command.payload, ack)
+		}
+	}
+	j.Close()
+
+	replayed := &eventLog{}
+	book = cob.NewOrderBook()
+	book.AddSink(replayed)
+	j, err = journal.Open(dir, book, journal.Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if !strings.Contains(live.String(), `"order_rejected"`) || !bytes.Equal(live.Bytes(), replayed.Bytes()) {
+		t.Fatalf("live events:\n%s\nreplayed events:\n%s", live.String(), replayed.String())
+	}
+	if j.Sequence() != 8 {
+		t.Fatalf("%d entries journaled, expected 5 commands without the rejected amend and 3 place acks", j.Sequence())
+	}
+}
+
+func TestJournaledAcksAnswerRepeatsAfterARestart(t *testing.T) {
+	dir := t.TempDir()
+	clock := cob.NewManualClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	open := func() *Commands {
+		book := cob.NewOrderBook()
+		j, err := journal.Open(dir, book, journal.Config{Clock: clock, KeepAcks: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewCommands(nil, book, &sync.Mutex{}, nil, CommandConfig{Journal: j})
+		c.restore()
+		return c
+	}
+
+	c := open()
+	sell := Place{Version: SchemaVersion, ClientOrderID: "c1", Account: "maker", Side: "sell", Price: 100, Quantity: 1}
+	first := execute(t, c, PlaceCommand, sell)
+	filled := execute(t, c, PlaceCommand, place("c2", 100))
+	if !first.Accepted || len(filled.Fills) != 1 {
+		t.Fatalf("first %+v, filled %+v", first, filled)
+	}
+	c.config.Journal.Close()
+
+	// Redelivered after a restart: both orders are filled, neither is in the
+	// book to be found as a duplicate.
+	c = open()
+	defer c.config.Journal.Close()
+	sequence := c.book.Sequence()
+
+	again := execute(t, c, PlaceCommand, place("c2", 100))
+	if !again.Duplicate || !reflect.DeepEqual(again.Fills, filled.Fills) || again.Sequence != filled.Sequence {
+		t.Fatalf("again %+v, first %+v", again, filled)
+	}
+	forgotten := execute(t, c, PlaceCommand, sell) // Ack beyond KeepAcks
+	if forgotten.Accepted || forgotten.Reason != string(cob.DuplicateOrderReason) {
+		t.Fatalf("forgotten = %+v", forgotten)
+	}
+	if c.book.Sequence() != sequence || len(c.book.LocalOrders()) != 0 {
+		t.Fatalf("repeats reached the book: sequence %d, was %d", c.book.Sequence(), sequence)
+	}
+}
+
+func TestRecoveryRebuildsTheRiskChecker(t *testing.T) {
+	dir := t.TempDir()
+	clock := cob.NewManualClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	limits := risk.Limits{MaxPosition: 5, MaxOpenOrders: 2}
+
+	// Snapshots every other entry, the positions come from a snapshot and
+	// from the entries after it.
+	open := func(checker *risk.Checker) *Commands {
+		book := cob.NewOrderBook()
+		j, err := journal.Open(dir, book, journal.Config{Clock: clock, SnapshotEvery: 2, Checker: checker})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewCommands(nil, book, &sync.Mutex{}, checker, CommandConfig{Journal: j})
+	}
+
+	live := risk.New(limits)
+	c := open(live)
+	for _, payload := range []Place{
+		place("c1", 100),
+		{Version: SchemaVersion, ClientOrderID: "c2", Account: "other", Side: "sell", Price: 100, Quantity: 1},
+		place("c3", 99),
+		{Version: SchemaVersion, ClientOrderID: "c4", Account: "other", Side: "sell", Price: 99, Quantity: 0.5},
+		place("c5", 98),
+	} {
+		clock.Advance(time.Second)
+		if ack := execute(t, c, PlaceCommand, payload); !ack.Accepted {
+			t.Fatalf("%s: %+v", payload.ClientOrderID, ack)
+		}
+	}
+	c.config.Journal.Close()
+
+	recovered := risk.New(limits)
+	c = open(recovered)
+	defer c.config.Journal.Close()
+	if !reflect.DeepEqual(recovered.Positions(), live.Positions()) || recovered.Position("acct") != 1.5 {
+		t.Fatalf("positions %v, live %v", recovered.Positions(), live.Positions())
+	}
+
+	// The open orders are known again: amendable, and counted against the
+	// limits.
+	if ack := execute(t, c, AmendCommand, Amend{Version: SchemaVersion, ClientOrderID: "c3", Account: "acct", Quantity: 0.25}); !ack.Accepted {
+		t.Fatalf("amend = %+v", ack)
+	}
+	if ack := execute(t, c, PlaceCommand, place("c6", 97)); ack.Accepted || ack.Reason != string(risk.ReasonOpenOrderLimit) {
+		t.Fatalf("place beyond the open order limit = %+v", ack)
+	}
+}
+
+func TestJournaledPlaceIsStampedWithTheEntryTime(t *testing.T) {
+	clock := cob.NewManualClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	book := cob.NewOrderBook()
+	j, err := journal.Open(t.TempDir(), book, journal.Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	c := NewCommands(nil, book, &sync.Mutex{}, nil, CommandConfig{Journal: j})
+
+	for _, id := range []string{"c1", "c2"} {
+		clock.Advance(time.Second)
+		execute(t, c, PlaceCommand, place(id, 100))
+
+		if order := book.Order(OrderID("acct", id)); order.Timestamp != clock.Now().UnixNano() {
+			t.Fatalf("%s stamped %v, journaled at %v", id, time.Unix(0, order.Timestamp).UTC(), clock.Now())
+		}
+	}
+}
@@ -2,6 +2,7 @@ package cob
 
 import (
 	"container/heap"
+	"time"
 )
 
 // Order represents a buy/sell order.
@@ -13,36 +14,26 @@ type Order struct {
 	Timestamp    int64   // Unix time for FIFO ordering
 	Provider     string  // "local" or external exchange name
 	AvailableBal float64 // Balance available for external exchange orders
+	Account      string  // Customer account owning a local order
+
+	Type                string    // "limit" (default), "market", "stop" or "stop_limit"
+	TimeInForce         string    // "gtc" (default), "ioc", "fok", "gtd" or "day"
+	PostOnly            bool      // Reject limit orders that would take liquidity
+	ProtectionPrice     float64   // Worst price a market order may trade at, none when zero
+	StopPrice           float64   // Trigger price of "stop" and "stop_limit" orders
+	Trigger             string    // Price watched by stop orders: "last" (default), "mark" or "quote"
+	ExpireAt            time.Time // Expiry of "gtd" orders, set at the session close for "day" orders
+	SelfTradePrevention string    // Mode applied against resting orders of the same account, the book's when empty
+	DisplayQuantity     float64   // Visible size of an iceberg order, fully visible when zero
+	Hidden              float64   // Hidden reserve of a resting iceberg order, included in Quantity
 }
 
-// OrderQueue represents a priority queue for orders within a price level.
+// OrderQueue holds the orders of a price level as a heap. It has no ordering
+// of its own: the level keeps it ordered by its Policy.
 type OrderQueue []*Order
 
 func (oq OrderQueue) Len() int      { return len(oq) }
 func (oq OrderQueue) Swap(i, j int) { oq[i], oq[j] = oq[j], oq[i] }
-func (oq OrderQueue) Less(i, j int) bool {
-	// Custom ordering logic:
-	// 1. Local orders are prioritized over external.
-	if oq[i].Provider == "local" && oq[j].Provider != "local" {
-		return true
-	}
-	if oq[i].Provider != "local" && oq[j].Provider == "local" {
-		return false
-	}
-
-	// 2. Higher volume orders are prioritized.
-	if oq[i].Quantity != oq[j].Quantity {
-		return oq[i].Quantity > oq[j].Quantity
-	}
-
-	// 3. Orders with sufficient available balance are prioritized.
-	if oq[i].AvailableBal != oq[j].AvailableBal {
-		return oq[i].AvailableBal > oq[j].AvailableBal
-	}
-
-	// 4. Older orders are prioritized (FIFO).
-	return oq[i].Timestamp < oq[j].Timestamp
-}
 
 // Push pushes an order onto the queue.
 func (oq *OrderQueue) Push(x interface{}) {
@@ -58,15 +49,16 @@ func (oq *OrderQueue) Pop() interface{} {
 	return item
 }
 
-// RemoveByID removes an order by ID and returns the removed order (if any).
-func (oq *OrderQueue) RemoveByID(orderID string) *Order {
+// RemoveByID removes an order by ID from a queue ordered by policy,
+// DefaultPolicy when nil, and returns the removed order (if any).
+func (oq *OrderQueue) RemoveByID(orderID string, policy PriorityPolicy) *Order {
+	if policy == nil {
+		policy = DefaultPolicy
+	}
+
 	for i, order := range *oq {
 		if order.ID == orderID {
-			// Remove the order from the slice and return it.
-			removed := order
-			*oq = append((*oq)[:i], (*oq)[i+1:]...)
-			heap.Init(oq) // Reheapify after removal.
-			return removed
+			return heap.Remove(policyQueue{orders: oq, policy: policy}, i).(*Order)
 		}
 	}
 	return nil
@@ -74,38 +66,65 @@ func (oq *OrderQueue) RemoveByID(orderID string) *Order {
 
 // PriceLevel represents a specific price level in the order book.
 type PriceLevel struct {
-	Price         float64     // Price for this level
-	TotalQuantity float64     // Precomputed total quantity for this level
-	Orders        *OrderQueue // Priority queue for orders
+	Price          float64        // Price for this level
+	TotalQuantity  float64        // Precomputed total quantity for this level, hidden included
+	HiddenQuantity float64        // Part of TotalQuantity held in iceberg reserves
+	Orders         *OrderQueue    // Priority queue for orders
+	Policy         PriorityPolicy // Ordering of Orders, DefaultPolicy when nil
 }
 
 // AddOrder adds an order to the price level and updates TotalQuantity.
 func (pl *PriceLevel) AddOrder(order *Order) {
-	heap.Push(pl.Orders, order)
-	pl.TotalQuantity += order.Quantity
+	pl.PlaceOrder(order)
 }
 
 // RemoveOrder removes an order and updates TotalQuantity.
 func (pl *PriceLevel) RemoveOrder(orderID string) {
-	removed := pl.Orders.RemoveByID(orderID)
-	if removed != nil {
-		pl.TotalQuantity -= removed.Quantity
+	pl.remove(orderID)
+}
+
+// remove takes an order out of the queue and the totals and returns it, nil
+// when the level has no order with that ID.
+func (pl *PriceLevel) remove(orderID string) *Order {
+	for i, order := range *pl.Orders {
+		if order.ID == orderID {
+			heap.Remove(pl.queue(), i)
+			pl.TotalQuantity -= order.Quantity
+			pl.HiddenQuantity -= order.Hidden
+			return order
+		}
+	}
+	return nil
+}
+
+// fix restores the order of the queue after the priority of order changed in
+// place, e.g. when its quantity went down.
+func (pl *PriceLevel) fix(order *Order) {
+	for i, resting := range *pl.Orders {
+		if resting == order {
+			heap.Fix(pl.queue(), i)
+			return
+		}
 	}
 }
 
 // UpdatePriceLevel recalculates the total quantity for the price level.
 func (pl *PriceLevel) UpdatePriceLevel() {
-	total := 0.0
+	total, hidden := 0.0, 0.0
 	for _, order := range *pl.Orders {
 		total += order.Quantity
+		hidden += order.Hidden
 	}
 	pl.TotalQuantity = total
+	pl.HiddenQuantity = hidden
 }
 
 // PlaceOrder places an order in the appropriate price level.
 func (pl *PriceLevel) PlaceOrder(order *Order) {
-	heap.Push(pl.Orders, order)        // Add the order to the priority queue
+	order.hide()                       // Keep what exceeds the display quantity of icebergs hidden
+	heap.Push(pl.queue(), order)       // Add the order to the priority queue
 	pl.TotalQuantity += order.Quantity // Update the total quantity
+	pl.HiddenQuantity += order.Hidden  // and the hidden part of it
 }
 
 // PriceLevelHeap is a heap for managing price levels.
@@ -149,8 +168,27 @@ func (h *PriceLevelHeap) Pop() interface{} {
 // }
 
 type OrderBook struct {
-	Bids map[float64]*PriceLevel // Map of bid price levels
-	Asks map[float64]*PriceLevel // Map of ask price levels
+	Symbol  string                  // Instrument of the book, e.g. "BTC/USD"
+	Bids    map[float64]*PriceLevel // Map of bid price levels
+	Asks    map[float64]*PriceLevel // Map of ask price levels
+	OnFill  func(fill Fill)         // Optional hook called for every fill of ProcessOrder
+	Policy  PriorityPolicy          // Priority within price levels, DefaultPolicy when nil; change it with SetPolicy
+	ProRata *ProRataConfig          // Allocate levels pro-rata instead of in priority order when set
+
+	SelfTradePrevention string                // Default self-trade prevention mode, none when empty
+	OnSelfTrade         func(event SelfTrade) // Optional hook called for every match prevented
+
+	Clock        Clock                             // Time source of expiries, the system clock when nil
+	SessionClose time.Duration                     // Time of day (UTC) day orders expire at, midnight when zero
+	OnCancel     func(order *Order, reason string) // Optional hook called for orders the book removes itself, e.g. "expired"
+
+	stops     stopIndex // Stop orders waiting for their trigger
+	lastPrice float64   // Price of the last trade in the book or on a venue
+	markPrice float64
+	expiries  expiryHeap
+	expirySeq int64
+	sinks     []EventSink
+	sequence  uint64 // Sequence of the last event
 }
 
 // NewOrderBook creates a new, empty order book.
@@ -180,8 +218,9 @@ func (ob *OrderBook) UpdatePriceLevel(side string, price float64) {
 			Price:         price,
 			TotalQuantity: 0,
 			Orders:        &OrderQueue{},
+			Policy:        ob.Policy,
 		}
-		heap.Init(priceLevels[price].Orders)
+		heap.Init(priceLevels[price].queue())
 	}
 }
 
@@ -198,8 +237,9 @@ func (ob *OrderBook) PlaceOrder(order *Order) {
 			Price:         order.Price,
 			TotalQuantity: 0,
 			Orders:        &OrderQueue{},
+			Policy:        ob.Policy,
 		}
-		heap.Init(priceLevels[order.Price].Orders)
+		heap.Init(priceLevels[order.Price].queue())
 	}
 
 	// Place the order in the priority queue
@@ -208,33 +248,76 @@ func (ob *OrderBook) PlaceOrder(order *Order) {
 
 	// Update the price level in the order book
 	ob.UpdatePriceLevel(order.Side, order.Price)
+	ob.levelChanged(order.Side, order.Price)
 }
 
 // MatchOrder matches an incoming order against existing orders in the price level.
 // Returns the remaining unmatched quantity.
 func (pl *PriceLevel) MatchOrder(order *Order) float64 {
 	remaining := order.Quantity
+	for _, fill := range pl.Match(order, order.Quantity) {
+		remaining -= fill.Quantity
+	}
+
+	return remaining
+}
+
+// Match matches up to quantity of an incoming order against existing orders
+// in the price level and returns a fill per matched resting order. Iceberg
+// orders trade their visible part, then refill from their reserve at the back
+// of the queue, where the incoming order may reach them again.
+func (pl *PriceLevel) Match(order *Order, quantity float64) []Fill {
+	return pl.match(order, quantity, nil)
+}
+
+// match is Match with self-trade prevention checked against every resting
+// order before it trades.
+func (pl *PriceLevel) match(order *Order, quantity float64, prevent preventFunc) []Fill {
+	fills := []Fill{}
+	remaining := quantity
 
 	for pl.Orders.Len() > 0 && remaining > 0 {
 		// Peek the highest-priority order
-		bestOrder := heap.Pop(pl.Orders).(*Order)
-
-		if remaining >= bestOrder.Quantity {
-			// Fully match the best order
-			remaining -= bestOrder.Quantity
-			pl.TotalQuantity -= bestOrder.Quantity
-		} else {
-			// Partially match the best order
-			bestOrder.Quantity -= remaining
-			pl.TotalQuantity -= remaining
-			remaining = 0
+		bestOrder := heap.Pop(pl.queue()).(*Order)
+
+		if prevent != nil {
+			reduced, gone := prevent(pl, bestOrder, remaining)
+			remaining -= reduced
+			if gone || reduced > 0 {
+				if !gone {
+					heap.Push(pl.queue(), bestOrder)
+				}
+				continue
+			}
+		}
+
+		matched := min(remaining, bestOrder.Visible())
 
+		remaining -= matched
+		bestOrder.Quantity -= matched
+		pl.TotalQuantity -= matched
+
+		if bestOrder.Visible() <= 0 && bestOrder.Hidden > 0 {
+			// Show the next slice of the iceberg with a new time priority
+			pl.refill(bestOrder, order)
+		}
+		if bestOrder.Quantity > 0 {
 			// Push the partially filled order back into the queue
-			heap.Push(pl.Orders, bestOrder)
+			heap.Push(pl.queue(), bestOrder)
 		}
+
+		fills = append(fills, Fill{
+			TakerOrderID: order.ID,
+			MakerOrderID: bestOrder.ID,
+			Side:         order.Side,
+			Price:        pl.Price,
+			Quantity:     matched,
+			Provider:     bestOrder.Provider,
+			Timestamp:    order.Timestamp,
+		})
 	}
 
-	return remaining
+	return fills
 }
 
 // // CancelOrder removes an order from the order book.
@@ -0,0 +1,98 @@
+package cob
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VenueLevel is the liquidity one provider shows at a price.
+type VenueLevel struct {
+	Provider string
+	Price    float64
+	Quantity float64
+}
+
+// ExternalOrderID is the ID of the order standing for the liquidity an
+// external provider shows at a price level.
+func ExternalOrderID(provider string, side string, price float64) string {
+	return fmt.Sprintf("%s:%s:%v", provider, side, price)
+}
+
+func (ob *OrderBook) priceLevels(side string) map[float64]*PriceLevel {
+	if side == "sell" {
+		return ob.Asks
+	}
+	return ob.Bids
+}
+
+// UpdateExternalLevel sets the quantity an external provider shows at a price,
+// as received from its L2 feed. A zero quantity removes the provider from the
+// price level. The new quote may trigger stops, their fills are returned.
+func (ob *OrderBook) UpdateExternalLevel(provider string, side string, price float64, quantity float64, timestamp int64) []Fill {
+	priceLevels := ob.priceLevels(side)
+	orderID := ExternalOrderID(provider, side, price)
+
+	if pl, exists := priceLevels[price]; exists {
+		pl.RemoveOrder(orderID)
+		ob.UpdatePriceLevel(side, price)
+	}
+
+	if quantity <= 0 {
+		ob.levelChanged(side, price)
+		return ob.TriggerStops()
+	}
+
+	ob.PlaceOrder(&Order{
+		ID:        orderID,
+		Side:      side,
+		Price:     price,
+		Quantity:  quantity,
+		Timestamp: timestamp,
+		Provider:  provider,
+	})
+	return ob.TriggerStops()
+}
+
+// Prices returns the prices of one side, best first.
+func (ob *OrderBook) Prices(side string) []float64 {
+	priceLevels := ob.priceLevels(side)
+	prices := make([]float64, 0, len(priceLevels))
+	for price := range priceLevels {
+		prices = append(prices, price)
+	}
+
+	if side == "sell" {
+		sort.Float64s(prices)
+	} else {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	}
+
+	return prices
+}
+
+// VenueDepth returns the liquidity of external providers on one side, grouped
+// by provider with the best price first. Local orders are left out.
+func (ob *OrderBook) VenueDepth(side string) map[string][]VenueLevel {
+	depth := make(map[string][]VenueLevel)
+	priceLevels := ob.priceLevels(side)
+
+	for _, price := range ob.Prices(side) {
+		quantities := make(map[string]float64)
+		for _, order := range *priceLevels[price].Orders {
+			if order.Provider == "local" {
+				continue
+			}
+			quantities[order.Provider] += order.Visible()
+		}
+
+		for provider, quantity := range quantities {
+			depth[provider] = append(depth[provider], VenueLevel{
+				Provider: provider,
+				Price:    price,
+				Quantity: quantity,
+			})
+		}
+	}
+
+	return depth
+}
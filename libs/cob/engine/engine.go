@@ -0,0 +1,423 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cob"
+	"cob/journal"
+)
+
+var (
+	ErrQueueFull = errors.New("shard input queue full")
+	ErrStopped   = errors.New("shard stopped")
+)
+
+type Config struct {
+	QueueSize      int           // Capacity of the input queue of each shard, defaults to 4096
+	ExpiryInterval time.Duration // Time between two expiry passes, defaults to 100ms
+	ExpiryBatch    int           // Orders expired per pass, defaults to 100
+
+	// JournalDir holds a journal per shard, in a directory named after the
+	// symbol; the books are not journaled when empty.
+	JournalDir string
+	Journal    journal.Config
+}
+
+// Metrics tells how loaded the input queue of a shard is.
+type Metrics struct {
+	Symbol       string
+	Depth        int           // Inputs waiting
+	Capacity     int           // Size of the queue
+	MaxDepth     int           // Most inputs ever waiting
+	Enqueued     uint64        // Inputs accepted
+	Processed    uint64        // Inputs run
+	Blocked      uint64        // Submits that had to wait for room
+	Dropped      uint64        // TrySubmits refused because the queue was full
+	MaxQueueTime time.Duration // Longest time an input waited before running
+}
+
+type input struct {
+	fn       func(book *cob.OrderBook)
+	enqueued time.Time
+}
+
+// Shard owns the book of one instrument. Every change of the book, from
+// market data, customer commands or timers, is an input run by the shard's
+// goroutine in queue order, so the book needs no locking and replaying the
+// same inputs gives the same book.
+type Shard struct {
+	symbol  string
+	book    *cob.OrderBook
+	journal *journal.Journal // Nil when the engine keeps no journals
+	input   chan input
+	config  Config
+	done    chan struct{}
+
+	state   sync.Mutex // Held by lockers while the shard has not started
+	started bool
+
+	expiryQueued atomic.Bool // An expiry pass is waiting in the queue
+	maxDepth     atomic.Int64
+	enqueued     atomic.Uint64
+	processed    atomic.Uint64
+	blocked      atomic.Uint64
+	dropped      atomic.Uint64
+	maxQueueTime atomic.Int64
+}
+
+func (s *Shard) Symbol() string {
+	return s.symbol
+}
+
+// Journal returns the journal of the book, nil when the engine keeps none.
+// Inputs change the book through it, or Append their entries to it first, so
+// the book can be recovered.
+func (s *Shard) Journal() *journal.Journal {
+	return s.journal
+}
+
+// queued counts an input just sent to the queue.
+func (s *Shard) queued() {
+	s.enqueued.Add(1)
+	storeMax(&s.maxDepth, int64(len(s.input)))
+}
+
+// storeMax raises max to value, producers racing each other included.
+func storeMax(max *atomic.Int64, value int64) {
+	for {
+		current := max.Load()
+		if value <= current || max.CompareAndSwap(current, value) {
+			return
+		}
+	}
+}
+
+// Submit queues fn to run on the book, waiting for room while the queue is
+// full. That wait is the backpressure on producers.
+func (s *Shard) Submit(ctx context.Context, fn func(book *cob.OrderBook)) error {
+	in := input{fn: fn, enqueued: time.Now()}
+
+	select {
+	case s.input <- in:
+		s.queued()
+		return nil
+	default:
+	}
+
+	s.blocked.Add(1)
+	select {
+	case s.input <- in:
+		s.queued()
+		return nil
+	case <-s.done:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySubmit queues fn unless the queue is full, for producers that must not
+// block, such as market data handlers that resync on gaps anyway.
+func (s *Shard) TrySubmit(fn func(book *cob.OrderBook)) error {
+	in := input{fn: fn, enqueued: time.Now()}
+
+	select {
+	case s.input <- in:
+		s.queued()
+		return nil
+	default:
+		s.dropped.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Do runs fn on the book and waits until it has run, for commands that
+// answer with the outcome.
+func (s *Shard) Do(ctx context.Context, fn func(book *cob.OrderBook)) error {
+	ran := make(chan struct{})
+	err := s.Submit(ctx, func(book *cob.OrderBook) {
+		defer close(ran)
+		fn(book)
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ran:
+		return nil
+	case <-s.done:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Metrics returns the current queue metrics.
+func (s *Shard) Metrics() Metrics {
+	return Metrics{
+		Symbol:       s.symbol,
+		Depth:        len(s.input),
+		Capacity:     cap(s.input),
+		MaxDepth:     int(s.maxDepth.Load()),
+		Enqueued:     s.enqueued.Load(),
+		Processed:    s.processed.Load(),
+		Blocked:      s.blocked.Load(),
+		Dropped:      s.dropped.Load(),
+		MaxQueueTime: time.Duration(s.maxQueueTime.Load()),
+	}
+}
+
+// Locker returns a sync.Locker whose critical section runs in the input
+// queue: Lock waits until the shard goroutine reaches it and holds the
+// goroutine until Unlock. Before the shard runs, Lock holds its start
+// instead. It lets components written against a lock, such as broker.Broker
+// or risk checks, share the book with the shard. It must not be locked from
+// inputs of the shard itself.
+func (s *Shard) Locker() sync.Locker {
+	return &shardLocker{shard: s}
+}
+
+type shardLocker struct {
+	shard   *Shard
+	mutex   sync.Mutex
+	release chan struct{} // Releases the shard goroutine, nil when not holding it
+	direct  bool          // Holding shard.state of a shard not started
+}
+
+func (l *shardLocker) Lock() {
+	l.mutex.Lock()
+
+	l.shard.state.Lock()
+	if !l.shard.started {
+		l.direct = true
+		return
+	}
+	l.shard.state.Unlock()
+
+	acquired := make(chan struct{})
+	release := make(chan struct{})
+	err := l.shard.Submit(context.Background(), func(book *cob.OrderBook) {
+		close(acquired)
+		<-release
+	})
+	if err != nil {
+		return // Stopped shards no longer touch the book
+	}
+
+	select {
+	case <-acquired:
+	case <-l.shard.done:
+	}
+	l.release = release
+}
+
+func (l *shardLocker) Unlock() {
+	if l.direct {
+		l.direct = false
+		l.shard.state.Unlock()
+	}
+	if l.release != nil {
+		close(l.release)
+		l.release = nil
+	}
+	l.mutex.Unlock()
+}
+
+func (s *Shard) run(ctx context.Context) {
+	defer close(s.done)
+	if s.journal != nil {
+		defer func() {
+			if err := s.journal.Close(); err != nil {
+				log.Printf("unable to close the journal of %s: %v\n", s.symbol, err)
+			}
+		}()
+	}
+
+	// Wait for lockers that took the book before the start.
+	s.state.Lock()
+	s.started = true
+	s.state.Unlock()
+
+	ticking := make(chan struct{})
+	go func() {
+		defer close(ticking)
+		s.tick(ctx)
+	}()
+	defer func() { <-ticking }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case in := <-s.input:
+			storeMax(&s.maxQueueTime, int64(time.Since(in.enqueued)))
+			in.fn(s.book)
+			s.processed.Add(1)
+		}
+	}
+}
+
+// tick queues an expiry pass every ExpiryInterval. Timers are inputs too,
+// run in queue order with the rest and bounded to ExpiryBatch orders so a
+// burst of expiries doesn't hold the queue. A tick is skipped while the last
+// pass is still waiting or the queue is full.
+func (s *Shard) tick(ctx context.Context) {
+	ticker := time.NewTicker(s.config.ExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.expiryQueued.CompareAndSwap(false, true) {
+			continue
+		}
+		in := input{fn: s.expire, enqueued: time.Now()}
+		select {
+		case s.input <- in:
+			s.queued()
+		default:
+			s.expiryQueued.Store(false)
+		}
+	}
+}
+
+func (s *Shard) expire(book *cob.OrderBook) {
+	s.expiryQueued.Store(false)
+	if s.journal == nil {
+		book.ExpireOrders(s.config.ExpiryBatch)
+		return
+	}
+
+	if _, err := s.journal.ExpireOrders(s.config.ExpiryBatch); err != nil {
+		log.Printf("unable to expire orders of %s: %v\n", s.symbol, err)
+	}
+}
+
+// Engine runs one shard per instrument, each on its own goroutine, so
+// instruments match in parallel while every book has a single writer.
+type Engine struct {
+	mutex   sync.Mutex
+	config  Config
+	shards  map[string]*Shard
+	ctx     context.Context // Set while running
+	running sync.WaitGroup
+}
+
+func New(config Config) *Engine {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 4096
+	}
+	if config.ExpiryInterval <= 0 {
+		config.ExpiryInterval = 100 * time.Millisecond
+	}
+	if config.ExpiryBatch <= 0 {
+		config.ExpiryBatch = 100
+	}
+
+	return &Engine{
+		config: config,
+		shards: make(map[string]*Shard),
+	}
+}
+
+// AddInstrument creates the shard of an instrument with a new book, which
+// configure may set up (policy, clock, sinks...) before the shard starts.
+// With a JournalDir, the book is then recovered from its journal, the sinks
+// receiving the replayed events. Shards added while the engine runs start at
+// once.
+func (e *Engine) AddInstrument(symbol string, configure func(book *cob.OrderBook)) (*Shard, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, exists := e.shards[symbol]; exists {
+		return nil, fmt.Errorf("instrument %s already has a shard", symbol)
+	}
+
+	book := cob.NewOrderBook()
+	book.Symbol = symbol
+	if configure != nil {
+		configure(book)
+	}
+
+	var bookJournal *journal.Journal
+	if e.config.JournalDir != "" {
+		dir := filepath.Join(e.config.JournalDir, strings.ReplaceAll(symbol, "/", "-"))
+		var err error
+		if bookJournal, err = journal.Open(dir, book, e.config.Journal); err != nil {
+			return nil, fmt.Errorf("unable to recover %s: %w", symbol, err)
+		}
+	}
+
+	shard := &Shard{
+		symbol:  symbol,
+		book:    book,
+		journal: bookJournal,
+		input:   make(chan input, e.config.QueueSize),
+		config:  e.config,
+		done:    make(chan struct{}),
+	}
+	e.shards[symbol] = shard
+
+	if e.ctx != nil {
+		e.start(shard)
+	}
+
+	return shard, nil
+}
+
+func (e *Engine) start(shard *Shard) {
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		shard.run(e.ctx)
+	}()
+}
+
+func (e *Engine) Shard(symbol string) (*Shard, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	shard, ok := e.shards[symbol]
+	return shard, ok
+}
+
+// Metrics returns the queue metrics of every shard.
+func (e *Engine) Metrics() []Metrics {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	metrics := make([]Metrics, 0, len(e.shards))
+	for _, shard := range e.shards {
+		metrics = append(metrics, shard.Metrics())
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Symbol < metrics[j].Symbol })
+
+	return metrics
+}
+
+// Run runs every shard until ctx is cancelled.
+func (e *Engine) Run(ctx context.Context) {
+	e.mutex.Lock()
+	e.ctx = ctx
+	for _, shard := range e.shards {
+		e.start(shard)
+	}
+	e.mutex.Unlock()
+
+	<-ctx.Done()
+	e.running.Wait()
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cob"
+)
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLockBeforeRunHoldsTheStart(t *testing.T) {
+	e := New(Config{})
+	shard, err := e.AddInstrument("BTC/USD", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locker := shard.Locker()
+	locked := make(chan struct{})
+	go func() {
+		locker.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock blocked on a shard not running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	ran := make(chan struct{})
+	shard.Submit(ctx, func(book *cob.OrderBook) { close(ran) })
+	select {
+	case <-ran:
+		t.Fatal("input ran while the book was locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	locker.Unlock()
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("input did not run after Unlock")
+	}
+
+	// Running shards are locked through the queue.
+	locker.Lock()
+	locker.Unlock()
+}
+
+func TestLockOfAStoppedShardDoesNotPanic(t *testing.T) {
+	e := New(Config{QueueSize: 1})
+	shard, _ := e.AddInstrument("BTC/USD", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		e.Run(ctx)
+	}()
+	waitFor(t, "shard start", func() bool {
+		shard.state.Lock()
+		defer shard.state.Unlock()
+		return shard.started
+	})
+	cancel()
+	<-stopped
+
+	// The queue is full, the submit of Lock fails.
+	if err := shard.TrySubmit(func(book *cob.OrderBook) {}); err != nil {
+		t.Fatal(err)
+	}
+	locker := shard.Locker()
+	locker.Lock()
+	locker.Unlock()
+	locker.Lock()
+	locker.Unlock()
+}
+
+func TestExpiryPassesRunThroughTheQueue(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	clock := cob.NewManualClock(start)
+
+	e := New(Config{ExpiryInterval: time.Millisecond})
+	shard, _ := e.AddInstrument("BTC/USD", func(book *cob.OrderBook) { book.Clock = clock })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	err := shard.Do(ctx, func(book *cob.OrderBook) {
+		book.ProcessOrder(&cob.Order{ID: "o1", Side: "buy", Price: 100, Quantity: 1, Provider: "local", TimeInForce: cob.GoodTillDate, ExpireAt: start.Add(time.Second)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expiry passes are counted as processed inputs.
+	processed := shard.Metrics().Processed
+	waitFor(t, "expiry passes", func() bool { return shard.Metrics().Processed > processed+2 })
+
+	clock.Advance(time.Second)
+	waitFor(t, "expiry", func() bool {
+		left := true
+		shard.Do(ctx, func(book *cob.OrderBook) { left = book.Order("o1") != nil })
+		return !left
+	})
+}
+
+func TestMaxDepthKeepsTheLargestDepthOfConcurrentSubmits(t *testing.T) {
+	const producers = 64
+
+	e := New(Config{QueueSize: producers})
+	shard, _ := e.AddInstrument("BTC/USD", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shard.TrySubmit(func(book *cob.OrderBook) {})
+		}()
+	}
+	wg.Wait()
+
+	if metrics := shard.Metrics(); metrics.MaxDepth != producers || metrics.Enqueued != producers {
+		t.Fatalf("metrics = %+v", metrics)
+	}
+}
+
+func TestJournaledShardRecoversItsBook(t *testing.T) {
+	config := Config{JournalDir: t.TempDir()}
+
+	e := New(config)
+	shard, err := e.AddInstrument("BTC/USD", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		e.Run(ctx)
+	}()
+	err = shard.Do(ctx, func(book *cob.OrderBook) {
+		shard.Journal().ProcessOrder(&cob.Order{ID: "o1", Side: "buy", Price: 100, Quantity: 1, Provider: "local"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-stopped
+
+	shard, err = New(config).AddInstrument("BTC/USD", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shard.book.Order("o1") == nil || shard.Journal().Sequence() != 1 {
+		t.Fatalf("book not recovered, %d entries", shard.Journal().Sequence())
+	}
+	shard.Journal().Close()
+}
@@ -0,0 +1,192 @@
+package cob
+
+import "time"
+
+// EventType tells what happened in the engine.
+type EventType string
+
+const (
+	OrderAccepted    EventType = "order_accepted"
+	OrderRejected    EventType = "order_rejected"
+	OrderFilled      EventType = "fill"
+	OrderAmended     EventType = "order_amended"
+	OrderReduced     EventType = "order_reduced"
+	OrderCancelled   EventType = "order_cancelled"
+	OrderExpired     EventType = "order_expired"
+	BookLevelChanged EventType = "book_level_changed"
+)
+
+// Cancel reasons besides ExpiredReason and SelfTradeReason.
+const (
+	RequestedReason = "requested" // Cancelled through CancelOrder
+	UnfilledReason  = "unfilled"  // Remainder of an order that does not rest, e.g. immediate-or-cancel
+)
+
+// Liquidity of a fill.
+const (
+	LiquidityInternal = "internal" // Between two customer orders
+	LiquidityExternal = "external" // Against venue liquidity, to be hedged
+)
+
+// Event is one change of the book. Exactly one of Order, Fill and Level is set,
+// depending on Type.
+type Event struct {
+	Sequence uint64      `json:"sequence"` // Engine sequence, increasing by one per event of the book
+	Type     EventType   `json:"type"`
+	Symbol   string      `json:"symbol"`
+	Time     time.Time   `json:"time"` // Time of the book clock
+	Order    *OrderEvent `json:"order,omitempty"`
+	Fill     *FillEvent  `json:"fill,omitempty"`
+	Level    *LevelEvent `json:"level,omitempty"`
+}
+
+// OrderEvent describes the order of an accepted, rejected, amended, reduced,
+// cancelled or expired event.
+type OrderEvent struct {
+	OrderID     string  `json:"order_id"`
+	Account     string  `json:"account,omitempty"`
+	Side        string  `json:"side"`
+	Type        string  `json:"type,omitempty"`
+	TimeInForce string  `json:"time_in_force,omitempty"`
+	Price       float64 `json:"price,omitempty"`
+	StopPrice   float64 `json:"stop_price,omitempty"`
+	Quantity    float64 `json:"quantity"` // Order quantity, or the quantity removed for reductions and cancellations
+	Provider    string  `json:"provider,omitempty"`
+	Reason      string  `json:"reason,omitempty"` // Rejection, reduction or cancel reason
+}
+
+// FillEvent is a trade between an incoming and a resting order.
+type FillEvent struct {
+	TakerOrderID string  `json:"taker_order_id"`
+	MakerOrderID string  `json:"maker_order_id"`
+	Side         string  `json:"side"` // Side of the taker order
+	Price        float64 `json:"price"`
+	Quantity     float64 `json:"quantity"`
+	Liquidity    string  `json:"liquidity"` // "internal" or "external"
+	Provider     string  `json:"provider"`  // Provider of the maker order
+}
+
+// LevelEvent is the public state of a price level after a change, hidden
+// iceberg quantity left out. A zero quantity means the level is gone.
+type LevelEvent struct {
+	Side     string  `json:"side"`
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+	Orders   int     `json:"orders"`
+}
+
+// EventSink receives the events of a book, in sequence order, while the book
+// is being changed. Sinks must not call back into the book.
+type EventSink interface {
+	HandleEvent(event Event)
+}
+
+// EventSinkFunc adapts a function to EventSink.
+type EventSinkFunc func(event Event)
+
+func (f EventSinkFunc) HandleEvent(event Event) { f(event) }
+
+// AddSink delivers every following event of the book to sink.
+func (ob *OrderBook) AddSink(sink EventSink) {
+	ob.sinks = append(ob.sinks, sink)
+}
+
+// Sequence returns the sequence of the last event of the book.
+func (ob *OrderBook) Sequence() uint64 {
+	return ob.sequence
+}
+
+func (ob *OrderBook) emit(event Event) {
+	ob.sequence++
+	if len(ob.sinks) == 0 {
+		return
+	}
+
+	event.Sequence = ob.sequence
+	event.Symbol = ob.Symbol
+	event.Time = ob.Now()
+
+	for _, sink := range ob.sinks {
+		sink.HandleEvent(event)
+	}
+}
+
+func newOrderEvent(order *Order, quantity float64, reason string) *OrderEvent {
+	return &OrderEvent{
+		OrderID:     order.ID,
+		Account:     order.Account,
+		Side:        order.Side,
+		Type:        order.Type,
+		TimeInForce: order.TimeInForce,
+		Price:       order.Price,
+		StopPrice:   order.StopPrice,
+		Quantity:    quantity,
+		Provider:    order.Provider,
+		Reason:      reason,
+	}
+}
+
+func (ob *OrderBook) accepted(order *Order) {
+	ob.emit(Event{Type: OrderAccepted, Order: newOrderEvent(order, order.Quantity, "")})
+}
+
+func (ob *OrderBook) amended(order *Order) {
+	ob.emit(Event{Type: OrderAmended, Order: newOrderEvent(order, order.Quantity, "")})
+}
+
+// Reject reports an order refused before it reached the book, e.g. by the
+// pre-trade risk checks. Orders the book refuses itself are reported by
+// ProcessOrder.
+func (ob *OrderBook) Reject(order *Order, reason RejectReason) {
+	ob.emit(Event{Type: OrderRejected, Order: newOrderEvent(order, order.Quantity, string(reason))})
+}
+
+// cancelled reports quantity of order removed from the book without trading,
+// to OnCancel and the sinks. Requested cancels only go to the sinks.
+func (ob *OrderBook) cancelled(order *Order, reason string, quantity float64) {
+	if ob.OnCancel != nil && reason != RequestedReason {
+		ob.OnCancel(order, reason)
+	}
+
+	eventType := OrderCancelled
+	if reason == ExpiredReason {
+		eventType = OrderExpired
+	}
+	ob.emit(Event{Type: eventType, Order: newOrderEvent(order, quantity, reason)})
+}
+
+// reduced reports quantity of order removed without trading while the rest
+// of it stays live, e.g. by self-trade prevention.
+func (ob *OrderBook) reduced(order *Order, reason string, quantity float64) {
+	ob.emit(Event{Type: OrderReduced, Order: newOrderEvent(order, quantity, reason)})
+}
+
+func (ob *OrderBook) filled(fill Fill) {
+	if ob.OnFill != nil {
+		ob.OnFill(fill)
+	}
+
+	liquidity := LiquidityExternal
+	if fill.Provider == "local" {
+		liquidity = LiquidityInternal
+	}
+	ob.emit(Event{Type: OrderFilled, Fill: &FillEvent{
+		TakerOrderID: fill.TakerOrderID,
+		MakerOrderID: fill.MakerOrderID,
+		Side:         fill.Side,
+		Price:        fill.Price,
+		Quantity:     fill.Quantity,
+		Liquidity:    liquidity,
+		Provider:     fill.Provider,
+	}})
+}
+
+// levelChanged reports the state of a price level after a change.
+func (ob *OrderBook) levelChanged(side string, price float64) {
+	level := &LevelEvent{Side: side, Price: price}
+	if pl, exists := ob.priceLevels(side)[price]; exists {
+		level.Quantity = pl.VisibleQuantity()
+		level.Orders = pl.Orders.Len()
+	}
+	ob.emit(Event{Type: BookLevelChanged, Level: level})
+}
@@ -0,0 +1,283 @@
+package cob
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Time in force of orders with a lifetime.
+const (
+	GoodTillDate = "gtd" // Expires at Order.ExpireAt
+	Day          = "day" // Expires at the next session close
+)
+
+// ExpiredReason is the reason passed to OnCancel for expired orders.
+const ExpiredReason = "expired"
+
+// Clock is the time source of the book, injectable so expiries can be tested
+// deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the wall clock.
+var SystemClock Clock = systemClock{}
+
+// ManualClock only moves when told to.
+type ManualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (mc *ManualClock) Now() time.Time {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	return mc.now
+}
+
+func (mc *ManualClock) Set(now time.Time) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.now = now
+}
+
+func (mc *ManualClock) Advance(d time.Duration) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.now = mc.now.Add(d)
+}
+
+// Now returns the time of the book clock.
+func (ob *OrderBook) Now() time.Time {
+	if ob.Clock == nil {
+		return SystemClock.Now()
+	}
+	return ob.Clock.Now()
+}
+
+// expiryEntry schedules the expiry of an order. Entries of orders that were
+// filled or cancelled in the meantime are skipped when they come up.
+type expiryEntry struct {
+	order    *Order
+	expireAt time.Time
+	seq      int64
+}
+
+// expiryHeap is a min-heap of expiries, ties in arrival order.
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int      { return len(h) }
+func (h expiryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h expiryHeap) Less(i, j int) bool {
+	if !h[i].expireAt.Equal(h[j].expireAt) {
+		return h[i].expireAt.Before(h[j].expireAt)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	*h = append(*h, x.(*expiryEntry))
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[0 : n-1]
+	return item
+}
+
+// prepareExpiry sets the expiry of day orders and rejects orders that are
+// already expired on arrival.
+func (ob *OrderBook) prepareExpiry(order *Order) error {
+	now := ob.Now()
+
+	if order.TimeInForce == Day && order.ExpireAt.IsZero() {
+		year, month, day := now.UTC().Date()
+		sessionClose := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Add(ob.SessionClose)
+		if !sessionClose.After(now) {
+			sessionClose = sessionClose.Add(24 * time.Hour)
+		}
+		order.ExpireAt = sessionClose
+	}
+
+	if !order.ExpireAt.IsZero() && !order.ExpireAt.After(now) {
+		return fmt.Errorf("%w: order expired at %s", ErrInvalidOrder, order.ExpireAt.Format(time.RFC3339Nano))
+	}
+
+	return nil
+}
+
+func (ob *OrderBook) scheduleExpiry(order *Order) {
+	if order.ExpireAt.IsZero() {
+		return
+	}
+
+	ob.expirySeq++
+	heap.Push(&ob.expiries, &expiryEntry{order: order, expireAt: order.ExpireAt, seq: ob.expirySeq})
+}
+
+func expired(order *Order, now time.Time) bool {
+	return !order.ExpireAt.IsZero() && !order.ExpireAt.After(now)
+}
+
+// removeResting takes a resting order out of its price level, it reports
+// false when the order is no longer in the book.
+func (ob *OrderBook) removeResting(order *Order) bool {
+	pl, exists := ob.priceLevels(order.Side)[order.Price]
+	if !exists || pl.remove(order.ID) == nil {
+		return false
+	}
+
+	ob.UpdatePriceLevel(order.Side, order.Price)
+	ob.levelChanged(order.Side, order.Price)
+	return true
+}
+
+// dropExpired removes expired orders from the levels an incoming order may
+// trade with, so expired liquidity never trades even when the scheduler is
+// behind.
+func (ob *OrderBook) dropExpired(order *Order) {
+	now := ob.Now()
+	makerSide := oppositeSide(order.Side)
+	priceLevels := ob.priceLevels(makerSide)
+
+	for _, price := range ob.Prices(makerSide) {
+		if !order.crosses(price) {
+			break
+		}
+
+		for _, resting := range append([]*Order{}, *priceLevels[price].Orders...) {
+			if expired(resting, now) && ob.removeResting(resting) {
+				ob.cancelled(resting, ExpiredReason, resting.Quantity)
+			}
+		}
+	}
+}
+
+// ExpireOrders removes up to max orders whose expiry has passed, oldest
+// expiry first, and reports each through OnCancel with ExpiredReason. A
+// max of zero or less removes all of them. Bounding the batch keeps a large
+// expiry burst from stalling matching: call it again while it returns max
+// orders.
+func (ob *OrderBook) ExpireOrders(max int) []*Order {
+	now := ob.Now()
+	removed := []*Order{}
+
+	for ob.expiries.Len() > 0 && (max <= 0 || len(removed) < max) {
+		entry := ob.expiries[0]
+		if entry.expireAt.After(now) {
+			break
+		}
+		heap.Pop(&ob.expiries)
+
+		order := entry.order
+		if !order.ExpireAt.Equal(entry.expireAt) {
+			continue // Expiry changed since it was scheduled
+		}
+
+		if order.IsStop() {
+			if ob.stops.remove(order.ID) == nil {
+				continue
+			}
+		} else if !ob.removeResting(order) {
+			continue
+		}
+
+		ob.cancelled(order, ExpiredReason, order.Quantity)
+		removed = append(removed, order)
+	}
+
+	return removed
+}
+
+// NextExpiry returns the earliest scheduled expiry.
+func (ob *OrderBook) NextExpiry() (time.Time, bool) {
+	if ob.expiries.Len() == 0 {
+		return time.Time{}, false
+	}
+	return ob.expiries[0].expireAt, true
+}
+
+// Expiry scheduler defaults.
+const (
+	defaultExpiryBatch    = 100
+	defaultExpiryInterval = 100 * time.Millisecond
+)
+
+// ExpiryScheduler expires the orders of a book in the background. The book
+// is only touched while holding lock, the same lock the caller holds around
+// matching, and at most Batch orders are removed per lock so matching gets
+// in between during expiry bursts.
+type ExpiryScheduler struct {
+	book      *OrderBook
+	lock      sync.Locker
+	Batch     int                   // Orders expired per lock, defaults to 100 when zero or less
+	Interval  time.Duration         // Polling interval, defaults to 100ms when zero or less
+	OnExpired func(orders []*Order) // Optional hook called with every batch, outside the lock
+}
+
+func NewExpiryScheduler(book *OrderBook, lock sync.Locker) *ExpiryScheduler {
+	return &ExpiryScheduler{
+		book:     book,
+		lock:     lock,
+		Batch:    defaultExpiryBatch,
+		Interval: defaultExpiryInterval,
+	}
+}
+
+// Run expires orders until ctx is cancelled.
+func (es *ExpiryScheduler) Run(ctx context.Context) {
+	interval := es.Interval
+	if interval <= 0 {
+		interval = defaultExpiryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		es.expire(ctx)
+	}
+}
+
+// expire runs one pass at the book clock's time, batch after batch until a
+// batch comes back short.
+func (es *ExpiryScheduler) expire(ctx context.Context) {
+	batch := es.Batch
+	if batch <= 0 {
+		batch = defaultExpiryBatch
+	}
+
+	for {
+		es.lock.Lock()
+		removed := es.book.ExpireOrders(batch)
+		es.lock.Unlock()
+
+		if len(removed) > 0 && es.OnExpired != nil {
+			es.OnExpired(removed)
+		}
+		if len(removed) < batch || ctx.Err() != nil {
+			return
+		}
+	}
+}
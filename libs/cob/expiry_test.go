@@ -0,0 +1,99 @@
+package cob
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingLocker counts the times it was locked.
+type countingLocker struct {
+	sync.Mutex
+	locks int
+}
+
+func (cl *countingLocker) Lock() {
+	cl.Mutex.Lock()
+	cl.locks++
+}
+
+func bookWithExpiries(t *testing.T, clock Clock, expireAt time.Time, n int) *OrderBook {
+	t.Helper()
+
+	ob := NewOrderBook()
+	ob.Clock = clock
+	for i := 0; i < n; i++ {
+		order := &Order{
+			ID: fmt.Sprintf("o%d", i), Side: "buy", Price: 100 - float64(i%10), Quantity: 1,
+			Provider: "local", TimeInForce: GoodTillDate, ExpireAt: expireAt,
+		}
+		if _, err := ob.ProcessOrder(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ob
+}
+
+func TestExpirySchedulerExpiresInBatchesAtTheClockTime(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	ob := bookWithExpiries(t, clock, start.Add(time.Second), 250)
+	ob.ProcessOrder(&Order{ID: "later", Side: "sell", Price: 200, Quantity: 1, Provider: "local", TimeInForce: GoodTillDate, ExpireAt: start.Add(time.Minute)})
+
+	lock := &countingLocker{}
+	es := NewExpiryScheduler(ob, lock)
+	batches := []int{}
+	es.OnExpired = func(orders []*Order) { batches = append(batches, len(orders)) }
+
+	es.expire(context.Background())
+	if len(batches) != 0 || lock.locks != 1 {
+		t.Fatalf("before expiry: batches %v, locks %d", batches, lock.locks)
+	}
+
+	clock.Advance(time.Second)
+	es.expire(context.Background())
+	if fmt.Sprint(batches) != "[100 100 50]" || lock.locks != 4 {
+		t.Fatalf("batches %v, locks %d", batches, lock.locks)
+	}
+	if len(ob.Bids) != 0 || len(ob.Asks) != 1 {
+		t.Fatalf("%d bid and %d ask levels left", len(ob.Bids), len(ob.Asks))
+	}
+	if next, ok := ob.NextExpiry(); !ok || !next.Equal(start.Add(time.Minute)) {
+		t.Fatalf("next expiry = %v, %v", next, ok)
+	}
+}
+
+func TestExpirySchedulerDefaultsBatchAndInterval(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	ob := bookWithExpiries(t, clock, start.Add(time.Second), 150)
+	clock.Advance(time.Second)
+
+	expired := make(chan int, 10)
+	es := &ExpiryScheduler{book: ob, lock: &sync.Mutex{}}
+	es.OnExpired = func(orders []*Order) { expired <- len(orders) }
+
+	// Neither a zero interval panics nor a zero batch spins.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		es.Run(ctx)
+	}()
+
+	for _, expected := range []int{defaultExpiryBatch, 50} {
+		select {
+		case n := <-expired:
+			if n != expected {
+				t.Fatalf("batch of %d, expected %d", n, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no orders expired")
+		}
+	}
+
+	cancel()
+	<-done
+}
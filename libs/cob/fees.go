@@ -0,0 +1,205 @@
+package cob
+
+import (
+	"sort"
+	"sync"
+)
+
+// FeeTier holds the rates applying from a 30 day traded volume upwards.
+type FeeTier struct {
+	Volume float64 // 30 day volume in the quote asset from which the tier applies
+	Maker  float64 // Fraction of the notional, e.g. 0.0016
+	Taker  float64 // Fraction of the notional, e.g. 0.0026
+}
+
+// FeeSchedule is the fee structure of one venue and where the account stands
+// in it.
+type FeeSchedule struct {
+	Venue   string
+	Tiers   []FeeTier // Sorted by volume
+	Volume  float64   // Current 30 day volume of the account
+	Account *FeeTier  // Rates reported by the venue for the account, they take precedence over the tiers
+}
+
+// Rates returns the maker and taker rates currently applying.
+func (fs *FeeSchedule) Rates() (float64, float64) {
+	if fs.Account != nil {
+		return fs.Account.Maker, fs.Account.Taker
+	}
+
+	tier := FeeTier{}
+	for _, t := range fs.Tiers {
+		if fs.Volume < t.Volume {
+			break
+		}
+		tier = t
+	}
+
+	return tier.Maker, tier.Taker
+}
+
+// Fees holds the fee schedules of every venue. It is safe for concurrent use,
+// so private account data can update it while the book is being read.
+// Venues without a schedule, such as "local", trade without fees, and so
+// does every venue with a nil *Fees.
+type Fees struct {
+	mutex     sync.RWMutex
+	schedules map[string]*FeeSchedule
+}
+
+func NewFees() *Fees {
+	return &Fees{
+		schedules: make(map[string]*FeeSchedule),
+	}
+}
+
+func (f *Fees) schedule(venue string) *FeeSchedule {
+	schedule, exists := f.schedules[venue]
+	if !exists {
+		schedule = &FeeSchedule{Venue: venue}
+		f.schedules[venue] = schedule
+	}
+	return schedule
+}
+
+// SetTiers replaces the tiers of a venue.
+func (f *Fees) SetTiers(venue string, tiers []FeeTier) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	sorted := append([]FeeTier{}, tiers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Volume < sorted[j].Volume })
+	f.schedule(venue).Tiers = sorted
+}
+
+// SetVolume updates the 30 day volume of the account, which selects the tier.
+func (f *Fees) SetVolume(venue string, volume float64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.schedule(venue).Volume = volume
+}
+
+// SetAccountRates sets the rates the venue reports for the account, e.g. from
+// a trade volume or fee endpoint.
+func (f *Fees) SetAccountRates(venue string, maker float64, taker float64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.schedule(venue).Account = &FeeTier{Maker: maker, Taker: taker}
+}
+
+// ObserveExecution updates the account rate of a venue from the fee charged on
+// one of our executions. Liquidity is "m" for maker and "t" for taker fills,
+// as on Kraken execution reports.
+func (f *Fees) ObserveExecution(venue string, liquidity string, notional float64, fee float64) {
+	if f == nil || notional <= 0 {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	schedule := f.schedule(venue)
+	if schedule.Account == nil {
+		maker, taker := schedule.Rates()
+		schedule.Account = &FeeTier{Maker: maker, Taker: taker}
+	}
+
+	if liquidity == "m" {
+		schedule.Account.Maker = fee / notional
+	} else {
+		schedule.Account.Taker = fee / notional
+	}
+}
+
+// Rates returns the maker and taker rates of a venue.
+func (f *Fees) Rates(venue string) (float64, float64) {
+	if f == nil {
+		return 0, 0
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	schedule, exists := f.schedules[venue]
+	if !exists {
+		return 0, 0
+	}
+	return schedule.Rates()
+}
+
+func (f *Fees) Has(venue string) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	_, exists := f.schedules[venue]
+	return exists
+}
+
+// EffectivePrice is the all-in price of taking liquidity at price on venue
+// with an order of side: buys pay the taker fee on top, sells receive the
+// price minus the fee.
+func (f *Fees) EffectivePrice(venue string, side string, price float64) float64 {
+	_, taker := f.Rates(venue)
+	if side == "sell" {
+		return price * (1 - taker)
+	}
+	return price * (1 + taker)
+}
+
+// EffectiveLevel is the liquidity of one provider at a price with the price a
+// taker pays or receives after fees.
+type EffectiveLevel struct {
+	VenueLevel
+	EffectivePrice float64
+}
+
+// EffectiveDepth returns the liquidity of one side of the book per provider,
+// local orders included, sorted by fee adjusted price, best first. The asks are
+// adjusted for a taker buying and the bids for a taker selling, so it serves
+// both routing and customer quotes.
+func (ob *OrderBook) EffectiveDepth(side string, fees *Fees) []EffectiveLevel {
+	takerSide := oppositeSide(side)
+	priceLevels := ob.priceLevels(side)
+	levels := []EffectiveLevel{}
+
+	for _, price := range ob.Prices(side) {
+		quantities := make(map[string]float64)
+		for _, order := range *priceLevels[price].Orders {
+			quantities[order.Provider] += order.Visible()
+		}
+
+		for provider, quantity := range quantities {
+			levels = append(levels, EffectiveLevel{
+				VenueLevel:     VenueLevel{Provider: provider, Price: price, Quantity: quantity},
+				EffectivePrice: fees.EffectivePrice(provider, takerSide, price),
+			})
+		}
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		if levels[i].EffectivePrice != levels[j].EffectivePrice {
+			if side == "sell" {
+				return levels[i].EffectivePrice < levels[j].EffectivePrice
+			}
+			return levels[i].EffectivePrice > levels[j].EffectivePrice
+		}
+		return levels[i].Provider < levels[j].Provider
+	})
+
+	return levels
+}
+
+// BestEffective returns the level with the best all-in price of one side.
+func (ob *OrderBook) BestEffective(side string, fees *Fees) (EffectiveLevel, bool) {
+	levels := ob.EffectiveDepth(side, fees)
+	if len(levels) == 0 {
+		return EffectiveLevel{}, false
+	}
+	return levels[0], true
+}
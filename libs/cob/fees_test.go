@@ -0,0 +1,140 @@
+package cob
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// krakenTiers are listed out of order, SetTiers sorts them.
+var krakenTiers = []FeeTier{
+	{Volume: 50000, Maker: 0.0014, Taker: 0.0024},
+	{Volume: 0, Maker: 0.0016, Taker: 0.0026},
+	{Volume: 100000, Maker: 0.0012, Taker: 0.0022},
+}
+
+func TestTiersSelectTheRatesByVolume(t *testing.T) {
+	tests := []struct {
+		volume float64
+		maker  float64
+		taker  float64
+	}{
+		{volume: 0, maker: 0.0016, taker: 0.0026},
+		{volume: 49999.99, maker: 0.0016, taker: 0.0026},
+		{volume: 50000, maker: 0.0014, taker: 0.0024}, // A tier applies from its volume on
+		{volume: 99999, maker: 0.0014, taker: 0.0024},
+		{volume: 1e7, maker: 0.0012, taker: 0.0022},
+	}
+
+	fees := NewFees()
+	fees.SetTiers("kraken", krakenTiers)
+	for _, tt := range tests {
+		fees.SetVolume("kraken", tt.volume)
+		if maker, taker := fees.Rates("kraken"); maker != tt.maker || taker != tt.taker {
+			t.Fatalf("volume %v: rates %v / %v, expected %v / %v", tt.volume, maker, taker, tt.maker, tt.taker)
+		}
+	}
+}
+
+func TestVenuesWithoutScheduleTradeWithoutFees(t *testing.T) {
+	var none *Fees
+	for _, fees := range []*Fees{none, NewFees()} {
+		if maker, taker := fees.Rates("local"); maker != 0 || taker != 0 || fees.Has("local") {
+			t.Fatalf("rates %v / %v", maker, taker)
+		}
+		if price := fees.EffectivePrice("local", "buy", 100); price != 100 {
+			t.Fatalf("effective price %v", price)
+		}
+		fees.ObserveExecution("local", "t", 100, 1) // No-op on a nil *Fees
+	}
+}
+
+func TestAccountRatesTakePrecedenceOverTiers(t *testing.T) {
+	fees := NewFees()
+	fees.SetTiers("kraken", krakenTiers)
+	fees.SetAccountRates("kraken", 0.001, 0.002)
+	fees.SetVolume("kraken", 1e7)
+
+	if maker, taker := fees.Rates("kraken"); maker != 0.001 || taker != 0.002 {
+		t.Fatalf("rates %v / %v", maker, taker)
+	}
+}
+
+func TestObservedExecutionsSetTheRateOfTheirLiquidity(t *testing.T) {
+	fees := NewFees()
+	fees.SetTiers("kraken", krakenTiers)
+
+	// A taker fill sets the taker rate, the maker rate stays at the tier's.
+	fees.ObserveExecution("kraken", "t", 1000, 2)
+	if maker, taker := fees.Rates("kraken"); maker != 0.0016 || taker != 0.002 {
+		t.Fatalf("after a taker fill %v / %v", maker, taker)
+	}
+
+	// Fees are charged rounded to the cent, the rate is taken as charged.
+	fees.ObserveExecution("kraken", "m", 12.3456, 0.02)
+	if maker, taker := fees.Rates("kraken"); math.Abs(maker-0.02/12.3456) > 1e-15 || taker != 0.002 {
+		t.Fatalf("after a maker fill %v / %v", maker, taker)
+	}
+
+	// Executions without a notional say nothing about the rate.
+	fees.ObserveExecution("kraken", "t", 0, 1)
+	if _, taker := fees.Rates("kraken"); taker != 0.002 {
+		t.Fatalf("after an empty fill %v", taker)
+	}
+}
+
+func TestEffectivePriceAddsTheTakerFee(t *testing.T) {
+	fees := NewFees()
+	fees.SetAccountRates("kraken", 0.0016, 0.0026)
+
+	if price := fees.EffectivePrice("kraken", "buy", 100); math.Abs(price-100.26) > 1e-9 {
+		t.Fatalf("buy %v", price)
+	}
+	if price := fees.EffectivePrice("kraken", "sell", 100); math.Abs(price-99.74) > 1e-9 {
+		t.Fatalf("sell %v", price)
+	}
+}
+
+func TestEffectiveDepthSortsByAllInPrice(t *testing.T) {
+	fees := NewFees()
+	fees.SetAccountRates("kraken", 0, 0.0026)
+	fees.SetAccountRates("bybit", 0, 0.0001)
+
+	ob := NewOrderBook()
+	ob.UpdateExternalLevel("kraken", "sell", 100, 1, 1)
+	ob.UpdateExternalLevel("bybit", "sell", 100.2, 2, 1)
+	ob.UpdateExternalLevel("binance", "sell", 100.3, 1, 1) // No schedule, no fee
+	ob.PlaceOrder(&Order{ID: "local", Side: "sell", Price: 100.3, Quantity: 1, Provider: "local"})
+	ob.UpdateExternalLevel("kraken", "buy", 99, 1, 1)
+	ob.UpdateExternalLevel("bybit", "buy", 98.8, 1, 1)
+
+	providers := func(levels []EffectiveLevel) []string {
+		result := []string{}
+		for _, level := range levels {
+			result = append(result, level.Provider)
+		}
+		return result
+	}
+
+	// 100.21002 on bybit beats 100.26 on kraken, equal prices by provider.
+	asks := ob.EffectiveDepth("sell", fees)
+	if expected := []string{"bybit", "kraken", "binance", "local"}; !reflect.DeepEqual(providers(asks), expected) {
+		t.Fatalf("asks %+v", asks)
+	}
+	if math.Abs(asks[0].EffectivePrice-100.21002) > 1e-9 || asks[0].Quantity != 2 {
+		t.Fatalf("best ask %+v", asks[0])
+	}
+
+	// A seller receives 98.79012 on bybit and 98.7426 on kraken.
+	bids := ob.EffectiveDepth("buy", fees)
+	if expected := []string{"bybit", "kraken"}; !reflect.DeepEqual(providers(bids), expected) {
+		t.Fatalf("bids %+v", bids)
+	}
+
+	if best, ok := ob.BestEffective("buy", fees); !ok || best.Provider != "bybit" {
+		t.Fatalf("best bid %+v", best)
+	}
+	if _, ok := NewOrderBook().BestEffective("sell", fees); ok {
+		t.Fatal("best ask of an empty book")
+	}
+}
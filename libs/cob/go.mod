@@ -2,12 +2,32 @@ module cob
 
 go 1.23.1
 
-require github.com/nats-io/nats.go v1.38.0
+replace bitnet/exchange => ../exchange
+
+replace bitnet/kraken_ws_client => ../kraken_ws_client
+
+require (
+	bitnet/exchange v0.0.0-00010101000000-000000000000
+	bitnet/kraken_ws_client v0.0.0-00010101000000-000000000000
+	github.com/jackc/pgx/v5 v5.7.1
+	github.com/nats-io/nats-server/v2 v2.10.23
+	github.com/nats-io/nats.go v1.38.0
+)
 
 require (
-	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/jackc/puddle/v2 v2.2.2 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	github.com/minio/highwayhash v1.0.3 // indirect
+	github.com/nats-io/jwt/v2 v2.5.8 // indirect
 	github.com/nats-io/nkeys v0.4.9 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
+	go.uber.org/automaxprocs v1.6.0 // indirect
 	golang.org/x/crypto v0.31.0 // indirect
+	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/time v0.8.0 // indirect
 )
@@ -0,0 +1,452 @@
+package hedge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitnet/exchange"
+	"cob"
+	"cob/inventory"
+	"cob/positions"
+	"cob/router"
+)
+
+// Config of the hedge executor of one instrument.
+type Config struct {
+	Symbol      string        // Symbol of the hedge orders, e.g. "BTC/USD"
+	Slippage    float64       // Worst hedge price relative to the customer fill price, e.g. 0.002 for 0.2%
+	MaxAttempts int           // Rounds of orders sent per customer fill, defaults to 3
+	Timeout     time.Duration // Time a child order may take to complete, defaults to 10s
+	// Time to wait for the final report of a child order cancelled on
+	// timeout, defaults to Timeout. Without it the order is "unconfirmed".
+	CancelTimeout time.Duration
+	RetryDelay    time.Duration // Pause before routing a remainder again, defaults to 250ms
+	PriceTick     float64       // Price increment limit prices are rounded to, inside the slippage limit
+	MinQuantity   float64       // Remainders below it are not hedged
+	Fees          *cob.Fees     // Optional, updated from the fees charged on hedge fills
+
+	// Optional, child orders reserve their balance before they are sent and
+	// release it on reject, cancel or completion.
+	Inventory *inventory.Manager
+	Base      string // Base asset of Symbol, e.g. "BTC"
+	Quote     string // Quote asset of Symbol, e.g. "USD"
+
+	Positions *positions.Keeper // Optional, hedge fills are booked on it
+
+	OnExecution func(execution exchange.Execution) // Optional hook called for every execution report of the venues
+}
+
+// Child is one order sent to a venue for a customer fill.
+type Child struct {
+	Venue         string
+	ClientOrderId string
+	OrderId       string
+	Attempt       int
+	Side          string
+	Quantity      float64
+	LimitPrice    float64
+	Filled        float64
+	AvgPrice      float64
+	Fee           float64
+	Status        string // Final state: "filled", "canceled", "expired", "rejected", "timeout" or "unconfirmed"
+	Reason        string
+}
+
+// Result is the outcome of hedging one customer fill.
+type Result struct {
+	Fill      cob.Fill
+	Side      string  // Side of the hedge orders
+	Filled    float64 // Quantity hedged
+	Remaining float64 // Quantity left unhedged
+	AvgPrice  float64
+	Fees      float64
+	Status    string // "hedged", "partial", "failed" or "unconfirmed"
+	Children  []Child
+}
+
+// Executor hedges customer fills against external liquidity on the venue the
+// liquidity came from. Child orders are immediate-or-cancel limits within
+// the slippage limit, and any unfilled remainder is routed again across all
+// venues with the router until it is hedged or MaxAttempts is reached.
+//
+// Tracking fills requires connectors implementing exchange.ExecutionReporter,
+// orders on other connectors are reported "unconfirmed" and never retried.
+type Executor struct {
+	config     Config
+	connectors map[string]exchange.ExchangeConnector
+	route      func(request router.Request) router.Plan
+	results    chan Result
+
+	mutex   sync.Mutex
+	pending map[string]chan exchange.Execution // Executions by client order id
+	runId   string                             // Tells the client order ids of restarts apart
+	seq     int64
+}
+
+// New creates an executor. Route plans the remainder of a hedge, usually
+// Router.Plan on the consolidated book; without it remainders are retried on
+// the original venue only.
+func New(config Config, connectors []exchange.ExchangeConnector, route func(request router.Request) router.Plan) *Executor {
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 3
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.CancelTimeout == 0 {
+		config.CancelTimeout = config.Timeout
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = 250 * time.Millisecond
+	}
+
+	e := &Executor{
+		config:     config,
+		connectors: make(map[string]exchange.ExchangeConnector),
+		route:      route,
+		results:    make(chan Result, 100),
+		pending:    make(map[string]chan exchange.Execution),
+		runId:      strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	for _, connector := range connectors {
+		e.connectors[connector.Name()] = connector
+	}
+
+	return e
+}
+
+// Open creates an executor on the connectors registered under the names of
+// venues, so that adding a venue only takes importing its connector package
+// and listing it in the config.
+func Open(config Config, venues []exchange.Config, route func(request router.Request) router.Plan) (*Executor, error) {
+	connectors := make([]exchange.ExchangeConnector, 0, len(venues))
+	for _, venue := range venues {
+		connector, err := exchange.Open(venue)
+		if err != nil {
+			return nil, fmt.Errorf("hedge: can not open venue %s: %w", venue.Name, err)
+		}
+		connectors = append(connectors, connector)
+	}
+
+	return New(config, connectors, route), nil
+}
+
+// Results delivers a Result per hedged customer fill.
+func (e *Executor) Results() <-chan Result {
+	return e.results
+}
+
+// Run subscribes to the executions of the connectors and hedges every fill
+// against external liquidity until fills is closed or ctx is cancelled.
+// Fills against local orders need no hedge and are skipped.
+func (e *Executor) Run(ctx context.Context, fills <-chan cob.Fill) error {
+	for _, connector := range e.connectors {
+		reporter, ok := connector.(exchange.ExecutionReporter)
+		if !ok {
+			continue
+		}
+
+		executions, err := reporter.SubscribeExecutions(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to subscribe to %s executions: %w", connector.Name(), err)
+		}
+		go e.dispatch(executions)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case fill, ok := <-fills:
+			if !ok {
+				return nil
+			}
+			if fill.Provider == "local" {
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := e.Hedge(ctx, fill)
+				select {
+				case e.results <- result:
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}
+}
+
+func (e *Executor) dispatch(executions <-chan exchange.Execution) {
+	for execution := range executions {
+		if e.config.OnExecution != nil {
+			e.config.OnExecution(execution)
+		}
+
+		e.mutex.Lock()
+		pending, ok := e.pending[execution.ClientOrderId]
+		e.mutex.Unlock()
+		if !ok {
+			continue
+		}
+
+		select {
+		case pending <- execution:
+		default:
+			log.Printf("hedge: dropping execution of %s, receiver is not keeping up\n", execution.ClientOrderId)
+		}
+	}
+}
+
+// Hedge sends the child orders of one customer fill and waits until they are
+// complete.
+func (e *Executor) Hedge(ctx context.Context, fill cob.Fill) Result {
+	result := Result{
+		Fill:      fill,
+		Side:      fill.Side,
+		Remaining: fill.Quantity,
+	}
+
+	limitPrice := fill.Price * (1 + e.config.Slippage)
+	if e.config.PriceTick > 0 {
+		limitPrice = math.Floor(limitPrice/e.config.PriceTick+1e-9) * e.config.PriceTick
+	}
+	if fill.Side == "sell" {
+		limitPrice = fill.Price * (1 - e.config.Slippage)
+		if e.config.PriceTick > 0 {
+			limitPrice = math.Ceil(limitPrice/e.config.PriceTick-1e-9) * e.config.PriceTick
+		}
+	}
+
+	for attempt := 1; attempt <= e.config.MaxAttempts; attempt++ {
+		if result.Remaining <= e.config.MinQuantity {
+			break
+		}
+		if attempt > 1 {
+			select {
+			case <-time.After(e.config.RetryDelay):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		children := e.plan(fill, attempt, result.Remaining, limitPrice)
+		if len(children) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		for i := range children {
+			wg.Add(1)
+			go func(child *Child) {
+				defer wg.Done()
+				e.execute(ctx, child)
+			}(&children[i])
+		}
+		wg.Wait()
+
+		unconfirmed := false
+		for _, child := range children {
+			result.Children = append(result.Children, child)
+			result.Remaining -= child.Filled
+			result.Filled += child.Filled
+			result.AvgPrice += child.Filled * child.AvgPrice // Notional until divided below
+			result.Fees += child.Fee
+			if child.Status == "unconfirmed" {
+				unconfirmed = true
+			}
+		}
+
+		if unconfirmed {
+			result.Status = "unconfirmed"
+			break
+		}
+	}
+
+	if result.Filled > 0 {
+		result.AvgPrice = result.AvgPrice / result.Filled
+	}
+	result.Remaining = math.Max(result.Remaining, 0)
+
+	if result.Status == "" {
+		switch {
+		case result.Remaining <= e.config.MinQuantity:
+			result.Status = "hedged"
+		case result.Filled > 0:
+			result.Status = "partial"
+		default:
+			result.Status = "failed"
+		}
+	}
+
+	return result
+}
+
+// plan returns the child orders of one attempt: the venue of the customer fill
+// first, then whatever the router finds within the slippage limit.
+func (e *Executor) plan(fill cob.Fill, attempt int, quantity float64, limitPrice float64) []Child {
+	if attempt == 1 || e.route == nil {
+		return []Child{e.newChild(fill.Provider, attempt, fill.Side, quantity, limitPrice)}
+	}
+
+	plan := e.route(router.Request{
+		Side:       fill.Side,
+		Quantity:   quantity,
+		LimitPrice: limitPrice,
+	})
+
+	children := make([]Child, 0, len(plan.Children))
+	for _, planned := range plan.Children {
+		children = append(children, e.newChild(planned.Venue, attempt, fill.Side, planned.Quantity, limitPrice))
+	}
+
+	return children
+}
+
+func (e *Executor) newChild(venue string, attempt int, side string, quantity float64, limitPrice float64) Child {
+	e.mutex.Lock()
+	e.seq++
+	clientOrderId := fmt.Sprintf("h%s-%s", e.runId, strconv.FormatInt(e.seq, 36))
+	e.mutex.Unlock()
+
+	return Child{
+		Venue:         venue,
+		ClientOrderId: clientOrderId,
+		Attempt:       attempt,
+		Side:          side,
+		Quantity:      quantity,
+		LimitPrice:    limitPrice,
+	}
+}
+
+// execute places a child order and follows its executions until it is done.
+func (e *Executor) execute(ctx context.Context, child *Child) {
+	connector, ok := e.connectors[child.Venue]
+	if !ok {
+		child.Status = "rejected"
+		child.Reason = "no connector for venue"
+		return
+	}
+
+	if err := e.reserve(child); err != nil {
+		child.Status = "rejected"
+		child.Reason = err.Error()
+		return
+	}
+
+	executions := make(chan exchange.Execution, 20)
+	e.mutex.Lock()
+	e.pending[child.ClientOrderId] = executions
+	e.mutex.Unlock()
+	defer func() {
+		e.mutex.Lock()
+		delete(e.pending, child.ClientOrderId)
+		e.mutex.Unlock()
+	}()
+
+	ack, err := connector.PlaceOrder(ctx, exchange.OrderRequest{
+		ClientOrderId: child.ClientOrderId,
+		Symbol:        e.config.Symbol,
+		Side:          child.Side,
+		OrderType:     "limit",
+		Price:         child.LimitPrice,
+		Quantity:      child.Quantity,
+		TimeInForce:   "ioc",
+	})
+	if err != nil {
+		e.release(child)
+		child.Status = "rejected"
+		child.Reason = err.Error()
+		return
+	}
+	child.OrderId = ack.OrderId
+
+	// The reservation of unconfirmed orders is kept, the next balance
+	// snapshot of the venue settles it.
+	if _, ok := connector.(exchange.ExecutionReporter); !ok {
+		child.Status = "unconfirmed"
+		return
+	}
+
+	timeout := time.NewTimer(e.config.Timeout)
+	defer timeout.Stop()
+
+	// A child order cancelled on timeout may still fill until the venue
+	// reports it done, its remainder is only known then.
+	cancelled := false
+	for {
+		select {
+		case execution := <-executions:
+			if execution.LastQty > 0 {
+				e.config.Fees.ObserveExecution(child.Venue, execution.Liquidity, execution.LastQty*execution.LastPrice, execution.Fee)
+				if e.config.Inventory != nil {
+					e.config.Inventory.ApplyFill(child.Venue, child.ClientOrderId, execution.ExecId, e.config.Base, e.config.Quote, child.Side, execution.LastQty, execution.LastPrice, execution.Fee)
+				}
+				if e.config.Positions != nil {
+					e.config.Positions.OnHedgeFill(e.config.Symbol, child.Venue, child.Side, execution.LastQty, execution.LastPrice, execution.Fee)
+				}
+			}
+			child.Filled = execution.CumQty
+			child.AvgPrice = execution.AvgPrice
+			child.Fee += execution.Fee
+
+			if execution.Terminal() {
+				e.release(child)
+				child.Status = execution.Status
+				child.Reason = execution.Reason
+				if cancelled && execution.Status != "filled" {
+					child.Status = "timeout"
+				}
+				return
+			}
+		case <-timeout.C:
+			if cancelled {
+				child.Status = "unconfirmed"
+				child.Reason = "no final report after cancel"
+				return
+			}
+
+			if err := connector.CancelOrder(ctx, child.OrderId); err != nil {
+				log.Printf("hedge: unable to cancel %s on %s: %v\n", child.OrderId, child.Venue, err)
+			}
+			cancelled = true
+			timeout.Reset(e.config.CancelTimeout)
+		case <-ctx.Done():
+			child.Status = "unconfirmed"
+			child.Reason = ctx.Err().Error()
+			return
+		}
+	}
+}
+
+// reserve sets the balance of a child order aside: the quote asset including
+// the taker fee for buys, the base asset for sells.
+func (e *Executor) reserve(child *Child) error {
+	if e.config.Inventory == nil {
+		return nil
+	}
+
+	if child.Side == "sell" {
+		return e.config.Inventory.Reserve(child.ClientOrderId, child.Venue, e.config.Base, child.Quantity)
+	}
+
+	_, taker := e.config.Fees.Rates(child.Venue)
+	return e.config.Inventory.Reserve(child.ClientOrderId, child.Venue, e.config.Quote, child.Quantity*child.LimitPrice*(1+taker))
+}
+
+func (e *Executor) release(child *Child) {
+	if e.config.Inventory != nil {
+		e.config.Inventory.Release(child.ClientOrderId)
+	}
+}
@@ -0,0 +1,231 @@
+package hedge
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"bitnet/exchange"
+	"cob"
+)
+
+// fakeVenue is a connector registered under the name of its config.
+type fakeVenue struct {
+	name string
+}
+
+func (f *fakeVenue) Name() string { return f.name }
+
+func (f *fakeVenue) SubscribeMarketData(ctx context.Context, symbols []string) (<-chan exchange.MarketData, error) {
+	return nil, exchange.ErrNotSupported
+}
+
+func (f *fakeVenue) PlaceOrder(ctx context.Context, request exchange.OrderRequest) (exchange.OrderAck, error) {
+	return exchange.OrderAck{}, exchange.ErrNotSupported
+}
+
+func (f *fakeVenue) CancelOrder(ctx context.Context, orderId string) error {
+	return exchange.ErrNotSupported
+}
+
+func (f *fakeVenue) Balances(ctx context.Context) ([]exchange.Balance, error) {
+	return nil, exchange.ErrNotSupported
+}
+
+func (f *fakeVenue) Instruments(ctx context.Context) ([]exchange.Instrument, error) {
+	return nil, exchange.ErrNotSupported
+}
+
+func (f *fakeVenue) Health() exchange.Health { return exchange.Health{} }
+
+func init() {
+	exchange.Register("hedge_test_venue", func(config exchange.Config) (exchange.ExchangeConnector, error) {
+		return &fakeVenue{name: config.Option("name", "hedge_test_venue")}, nil
+	})
+}
+
+func TestOpenBuildsConnectorsByConfigName(t *testing.T) {
+	executor, err := Open(Config{Symbol: "BTC/USD"}, []exchange.Config{
+		{Name: "hedge_test_venue", Options: map[string]string{"name": "a"}},
+		{Name: "hedge_test_venue", Options: map[string]string{"name": "b"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executor.connectors) != 2 || executor.connectors["a"] == nil || executor.connectors["b"] == nil {
+		t.Fatalf("connectors = %+v", executor.connectors)
+	}
+
+	if _, err := Open(Config{Symbol: "BTC/USD"}, []exchange.Config{{Name: "unknown"}}, nil); err == nil {
+		t.Fatal("opening an unregistered venue succeeded")
+	}
+}
+
+// reportingVenue acks every order and leaves its executions to the test:
+// onPlace and onCancel return the reports to send after each call.
+type reportingVenue struct {
+	fakeVenue
+	executions chan exchange.Execution
+	onPlace    func(request exchange.OrderRequest) []exchange.Execution
+	onCancel   func(orderId string) []exchange.Execution
+
+	mutex    sync.Mutex
+	requests []exchange.OrderRequest
+	cancels  []string
+}
+
+func newReportingVenue() *reportingVenue {
+	return &reportingVenue{
+		fakeVenue:  fakeVenue{name: "venue"},
+		executions: make(chan exchange.Execution, 100),
+	}
+}
+
+func (r *reportingVenue) SubscribeExecutions(ctx context.Context) (<-chan exchange.Execution, error) {
+	return r.executions, nil
+}
+
+func (r *reportingVenue) PlaceOrder(ctx context.Context, request exchange.OrderRequest) (exchange.OrderAck, error) {
+	r.mutex.Lock()
+	r.requests = append(r.requests, request)
+	r.mutex.Unlock()
+
+	for _, execution := range r.onPlace(request) {
+		r.executions <- execution
+	}
+	return exchange.OrderAck{OrderId: "o-" + request.ClientOrderId, ClientOrderId: request.ClientOrderId}, nil
+}
+
+func (r *reportingVenue) CancelOrder(ctx context.Context, orderId string) error {
+	r.mutex.Lock()
+	r.cancels = append(r.cancels, orderId)
+	r.mutex.Unlock()
+
+	if r.onCancel != nil {
+		for _, execution := range r.onCancel(orderId) {
+			r.executions <- execution
+		}
+	}
+	return nil
+}
+
+func report(request exchange.OrderRequest, status string, lastQty float64, cumQty float64) exchange.Execution {
+	return exchange.Execution{
+		Venue: "venue", ClientOrderId: request.ClientOrderId, Status: status,
+		Quantity: request.Quantity, LastQty: lastQty, LastPrice: 100, CumQty: cumQty, AvgPrice: 100,
+	}
+}
+
+// hedgeFill runs one fill through Run and returns its result.
+func hedgeFill(t *testing.T, executor *Executor, fill cob.Fill) Result {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fills := make(chan cob.Fill, 1)
+	fills <- fill
+	close(fills)
+
+	done := make(chan error, 1)
+	go func() { done <- executor.Run(ctx, fills) }()
+
+	select {
+	case result := <-executor.Results():
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("no hedge result")
+		return Result{}
+	}
+}
+
+func TestTimedOutChildIsRetriedWithTheRemainderAfterItsFinalReport(t *testing.T) {
+	venue := newReportingVenue()
+	var first exchange.OrderRequest
+	venue.onPlace = func(request exchange.OrderRequest) []exchange.Execution {
+		if first.ClientOrderId == "" {
+			// The first child fills in part and then hangs.
+			first = request
+			return []exchange.Execution{report(request, "partially_filled", 0.4, 0.4)}
+		}
+		return []exchange.Execution{report(request, "filled", request.Quantity, request.Quantity)}
+	}
+	venue.onCancel = func(orderId string) []exchange.Execution {
+		// More fills arrive before the venue confirms the cancel.
+		return []exchange.Execution{
+			report(first, "partially_filled", 0.3, 0.7),
+			report(first, "canceled", 0, 0.7),
+		}
+	}
+
+	executor := New(Config{Symbol: "BTC/USD", Timeout: 50 * time.Millisecond, RetryDelay: time.Millisecond},
+		[]exchange.ExchangeConnector{venue}, nil)
+	result := hedgeFill(t, executor, cob.Fill{Side: "buy", Price: 100, Quantity: 1, Provider: "venue"})
+
+	if len(venue.requests) != 2 || venue.requests[1].Quantity < 0.3-1e-9 || venue.requests[1].Quantity > 0.3+1e-9 {
+		t.Fatalf("requests = %+v", venue.requests)
+	}
+	if len(venue.cancels) != 1 || result.Children[0].Status != "timeout" || result.Children[0].Filled != 0.7 {
+		t.Fatalf("cancels = %v, first child = %+v", venue.cancels, result.Children[0])
+	}
+	if result.Status != "hedged" || result.Filled < 1-1e-9 || result.Filled > 1+1e-9 {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestChildWithoutFinalReportAfterCancelIsUnconfirmed(t *testing.T) {
+	venue := newReportingVenue()
+	venue.onPlace = func(request exchange.OrderRequest) []exchange.Execution {
+		return []exchange.Execution{report(request, "partially_filled", 0.4, 0.4)}
+	}
+
+	executor := New(Config{Symbol: "BTC/USD", Timeout: 20 * time.Millisecond, RetryDelay: time.Millisecond},
+		[]exchange.ExchangeConnector{venue}, nil)
+	result := hedgeFill(t, executor, cob.Fill{Side: "buy", Price: 100, Quantity: 1, Provider: "venue"})
+
+	// The remainder is unknown, routing it again could over-hedge.
+	if len(venue.requests) != 1 || result.Status != "unconfirmed" || result.Children[0].Status != "unconfirmed" {
+		t.Fatalf("requests = %d, result = %+v", len(venue.requests), result)
+	}
+}
+
+func TestRunReturnsWhenResultsAreNotRead(t *testing.T) {
+	venue := newReportingVenue()
+	venue.onPlace = func(request exchange.OrderRequest) []exchange.Execution {
+		return []exchange.Execution{report(request, "filled", request.Quantity, request.Quantity)}
+	}
+
+	executor := New(Config{Symbol: "BTC/USD"}, []exchange.ExchangeConnector{venue}, nil)
+	executor.results = make(chan Result) // Nobody reads them
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fills := make(chan cob.Fill, 1)
+	fills <- cob.Fill{Side: "buy", Price: 100, Quantity: 1, Provider: "venue"}
+
+	done := make(chan error, 1)
+	go func() { done <- executor.Run(ctx, fills) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run blocked on an unread result")
+	}
+}
+
+func TestClientOrderIdsOfRestartsDiffer(t *testing.T) {
+	first := New(Config{}, nil, nil)
+	second := New(Config{}, nil, nil)
+
+	a := first.newChild("venue", 1, "buy", 1, 100).ClientOrderId
+	b := second.newChild("venue", 1, "buy", 1, 100).ClientOrderId
+	if a == b || len(a) > 18 {
+		t.Fatalf("client order ids %q and %q", a, b)
+	}
+}
@@ -0,0 +1,44 @@
+package cob
+
+// Visible returns the quantity of the order shown in depth: the display
+// quantity of a resting iceberg order, the whole quantity otherwise.
+func (o *Order) Visible() float64 {
+	return o.Quantity - o.Hidden
+}
+
+// IsIceberg reports whether the order shows only part of its quantity.
+func (o *Order) IsIceberg() bool {
+	return o.DisplayQuantity > 0
+}
+
+// hide moves what exceeds the display quantity of an iceberg order into its
+// hidden reserve, when the order starts resting or after a refill.
+func (o *Order) hide() {
+	o.Hidden = 0
+	if o.IsIceberg() && o.Quantity > o.DisplayQuantity {
+		o.Hidden = o.Quantity - o.DisplayQuantity
+	}
+}
+
+// VisibleQuantity returns the quantity of the level shown in depth snapshots,
+// the hidden reserve of iceberg orders left out.
+func (pl *PriceLevel) VisibleQuantity() float64 {
+	return pl.TotalQuantity - pl.HiddenQuantity
+}
+
+// refill shows the next display quantity of an iceberg order whose visible
+// part was consumed. The refill loses its time priority: it gets a timestamp
+// after every order of the level, and at least the one of the taker.
+func (pl *PriceLevel) refill(order *Order, taker *Order) {
+	pl.HiddenQuantity -= order.Hidden
+	order.hide()
+	pl.HiddenQuantity += order.Hidden
+
+	timestamp := taker.Timestamp
+	for _, resting := range *pl.Orders {
+		if resting.Timestamp >= timestamp {
+			timestamp = resting.Timestamp + 1
+		}
+	}
+	order.Timestamp = timestamp
+}
@@ -0,0 +1,106 @@
+package cob
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// icebergBook rests an iceberg of 6 showing 2 ahead of a plain order of 2,
+// both selling at 100 in price/time priority.
+func icebergBook() *OrderBook {
+	ob := NewOrderBook()
+	ob.SetPolicy(PriceTime{})
+	ob.PlaceOrder(&Order{ID: "iceberg", Side: "sell", Price: 100, Quantity: 6, DisplayQuantity: 2, Timestamp: 1, Provider: "local"})
+	ob.PlaceOrder(&Order{ID: "plain", Side: "sell", Price: 100, Quantity: 2, Timestamp: 2, Provider: "local"})
+	return ob
+}
+
+// fillsOf returns maker and quantity of every fill.
+func fillsOf(fills []Fill) [][2]any {
+	result := [][2]any{}
+	for _, fill := range fills {
+		result = append(result, [2]any{fill.MakerOrderID, fill.Quantity})
+	}
+	return result
+}
+
+func TestIcebergShowsOnlyItsDisplayQuantity(t *testing.T) {
+	ob := icebergBook()
+
+	levels := []*LevelEvent{}
+	ob.AddSink(EventSinkFunc(func(event Event) {
+		if event.Type == BookLevelChanged {
+			levels = append(levels, event.Level)
+		}
+	}))
+
+	pl := ob.priceLevels("sell")[100]
+	if pl.TotalQuantity != 8 || pl.HiddenQuantity != 4 || pl.VisibleQuantity() != 4 {
+		t.Fatalf("level total %v, hidden %v", pl.TotalQuantity, pl.HiddenQuantity)
+	}
+
+	// Taking the visible slice refills it, the level keeps showing 2 of the
+	// iceberg.
+	if _, err := ob.ProcessOrder(&Order{ID: "t1", Side: "buy", Price: 100, Quantity: 2, Timestamp: 3, Provider: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	if pl.TotalQuantity != 6 || pl.HiddenQuantity != 2 || pl.VisibleQuantity() != 4 {
+		t.Fatalf("level total %v, hidden %v", pl.TotalQuantity, pl.HiddenQuantity)
+	}
+	if len(levels) == 0 || levels[len(levels)-1].Quantity != 4 {
+		t.Fatalf("level events %+v", levels)
+	}
+}
+
+func TestIcebergRefillLosesTimePriority(t *testing.T) {
+	ob := icebergBook()
+
+	// The iceberg goes first, its refill queues behind the plain order.
+	fills, err := ob.ProcessOrder(&Order{ID: "t1", Side: "buy", Price: 100, Quantity: 3, Timestamp: 3, Provider: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := [][2]any{{"iceberg", 2.0}, {"plain", 1.0}}; !reflect.DeepEqual(fillsOf(fills), expected) {
+		t.Fatalf("first fills %v", fillsOf(fills))
+	}
+	if iceberg := ob.Order("iceberg"); iceberg.Timestamp != 3 || iceberg.Visible() != 2 || iceberg.Hidden != 2 {
+		t.Fatalf("refilled iceberg %+v", iceberg)
+	}
+
+	// Every refill again queues behind the orders resting.
+	fills, err = ob.ProcessOrder(&Order{ID: "t2", Side: "buy", Price: 100, Quantity: 10, Timestamp: 4, Provider: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := [][2]any{{"plain", 1.0}, {"iceberg", 2.0}, {"iceberg", 2.0}}; !reflect.DeepEqual(fillsOf(fills), expected) {
+		t.Fatalf("second fills %v", fillsOf(fills))
+	}
+	if _, exists := ob.priceLevels("sell")[100]; exists {
+		t.Fatal("empty level left in the book")
+	}
+}
+
+func TestRefillQueuesBehindLaterOrders(t *testing.T) {
+	ob := icebergBook()
+	ob.PlaceOrder(&Order{ID: "late", Side: "sell", Price: 100, Quantity: 1, Timestamp: 9, Provider: "local"})
+
+	if _, err := ob.ProcessOrder(&Order{ID: "t1", Side: "buy", Price: 100, Quantity: 2, Timestamp: 3, Provider: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	if iceberg := ob.Order("iceberg"); iceberg.Timestamp != 10 {
+		t.Fatalf("refill timestamp %d, expected after the last order", iceberg.Timestamp)
+	}
+}
+
+func TestIcebergsMustRest(t *testing.T) {
+	for _, order := range []*Order{
+		{ID: "ioc", Side: "buy", Price: 100, Quantity: 5, DisplayQuantity: 1, TimeInForce: ImmediateOrCancel},
+		{ID: "market", Side: "buy", Type: MarketOrder, Quantity: 5, DisplayQuantity: 1},
+		{ID: "negative", Side: "buy", Price: 100, Quantity: 5, DisplayQuantity: -1},
+	} {
+		if _, err := NewOrderBook().ProcessOrder(order); !errors.Is(err, ErrInvalidOrder) {
+			t.Fatalf("%s: %v", order.ID, err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"bitnet/exchange"
+)
+
+var ErrInsufficientBalance = errors.New("insufficient free balance")
+
+// Balance of one asset on one venue. Free is what can still be committed:
+// Total minus what open venue orders hold and what we reserved ourselves.
+type Balance struct {
+	Venue    string
+	Asset    string
+	Total    float64
+	Held     float64 // Held by orders on the venue we did not reserve for, e.g. from the snapshot
+	Reserved float64 // Reserved by us for orders being sent or open
+	Free     float64
+}
+
+type Reservation struct {
+	ID     string
+	Venue  string
+	Asset  string
+	Amount float64 // Amount still reserved
+}
+
+type balance struct {
+	total      float64
+	held       float64
+	reserved   float64
+	lastLedger time.Time
+	settled    map[string]bool // Trades the venue already reported in total, by ledger ref id
+	refs       []string        // Keys of settled, oldest first
+}
+
+// maxSettled bounds the trades remembered per balance, fills are reported
+// long before that many more trades settle.
+const maxSettled = 1000
+
+func (b *balance) settle(refId string) {
+	if refId == "" || b.settled[refId] {
+		return
+	}
+	if b.settled == nil {
+		b.settled = make(map[string]bool)
+	}
+	if len(b.refs) >= maxSettled {
+		delete(b.settled, b.refs[0])
+		b.refs = b.refs[1:]
+	}
+	b.settled[refId] = true
+	b.refs = append(b.refs, refId)
+}
+
+// add moves total by amount unless the venue already reported trade refId in
+// total. Either way the trade is settled, a later report sets total anyway.
+func (b *balance) add(refId string, amount float64) {
+	if refId == "" || !b.settled[refId] {
+		b.total += amount
+	}
+	b.settle(refId)
+}
+
+// Manager keeps the balances of every venue and the reservations made for our
+// orders. Every method is atomic, so the free balance it reports can be
+// reserved without anybody else committing it in between.
+type Manager struct {
+	mutex        sync.Mutex
+	balances     map[string]map[string]*balance // Venue, asset
+	reservations map[string]*Reservation
+}
+
+func New() *Manager {
+	return &Manager{
+		balances:     make(map[string]map[string]*balance),
+		reservations: make(map[string]*Reservation),
+	}
+}
+
+func (m *Manager) balance(venue string, asset string) *balance {
+	assets, ok := m.balances[venue]
+	if !ok {
+		assets = make(map[string]*balance)
+		m.balances[venue] = assets
+	}
+
+	b, ok := assets[asset]
+	if !ok {
+		b = &balance{}
+		assets[asset] = b
+	}
+	return b
+}
+
+func (m *Manager) view(venue string, asset string, b *balance) Balance {
+	return Balance{
+		Venue:    venue,
+		Asset:    asset,
+		Total:    b.total,
+		Held:     b.held,
+		Reserved: b.reserved,
+		Free:     b.total - b.held - b.reserved,
+	}
+}
+
+// Snapshot replaces the balances of a venue, e.g. with the result of
+// ExchangeConnector.Balances. Reservations are kept.
+func (m *Manager) Snapshot(venue string, balances []exchange.Balance) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, b := range m.balances[venue] {
+		b.total = 0
+		b.held = 0
+	}
+
+	for _, snapshot := range balances {
+		b := m.balance(venue, snapshot.Asset)
+		b.total = snapshot.Total
+		// What the venue holds beyond our reservations is held by orders we
+		// do not know about.
+		b.held = max(snapshot.Total-snapshot.Available-b.reserved, 0)
+	}
+}
+
+// SetTotal sets the total balance of an asset, as reported by the venue at
+// timestamp. Reports older than the last one applied are ignored.
+func (m *Manager) SetTotal(venue string, asset string, total float64, timestamp time.Time) {
+	m.SetLedgerTotal(venue, asset, "", total, timestamp)
+}
+
+// SetLedgerTotal sets the total balance of an asset after the ledger entry of
+// trade refId, so that ApplyFill does not count that trade again when its
+// report comes later. An older report is ignored, the newer total it lost to
+// includes the trade too.
+func (m *Manager) SetLedgerTotal(venue string, asset string, refId string, total float64, timestamp time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	b := m.balance(venue, asset)
+	b.settle(refId)
+	if timestamp.Before(b.lastLedger) {
+		return
+	}
+	b.total = total
+	b.lastLedger = timestamp
+}
+
+// Free returns how much of asset can be committed on venue right now.
+func (m *Manager) Free(venue string, asset string) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.view(venue, asset, m.balance(venue, asset)).Free
+}
+
+func (m *Manager) Balance(venue string, asset string) Balance {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.view(venue, asset, m.balance(venue, asset))
+}
+
+// Balances returns the balances of a venue sorted by asset.
+func (m *Manager) Balances(venue string) []Balance {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	balances := []Balance{}
+	for asset, b := range m.balances[venue] {
+		balances = append(balances, m.view(venue, asset, b))
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Asset < balances[j].Asset })
+
+	return balances
+}
+
+// Reserve sets amount of asset aside on venue for an order about to be sent.
+// It fails with ErrInsufficientBalance when the free balance is short.
+func (m *Manager) Reserve(id string, venue string, asset string, amount float64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.reservations[id]; exists {
+		return fmt.Errorf("reservation %s already exists", id)
+	}
+
+	b := m.balance(venue, asset)
+	if free := m.view(venue, asset, b).Free; free < amount {
+		return fmt.Errorf("%w: %v %s free on %s, %v needed", ErrInsufficientBalance, free, asset, venue, amount)
+	}
+
+	b.reserved += amount
+	m.reservations[id] = &Reservation{ID: id, Venue: venue, Asset: asset, Amount: amount}
+
+	return nil
+}
+
+// ReserveUpTo reserves as much as possible of amount and returns what was
+// reserved.
+func (m *Manager) ReserveUpTo(id string, venue string, asset string, amount float64) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.reservations[id]; exists {
+		return 0
+	}
+
+	b := m.balance(venue, asset)
+	reserved := min(max(m.view(venue, asset, b).Free, 0), amount)
+	if reserved <= 0 {
+		return 0
+	}
+
+	b.reserved += reserved
+	m.reservations[id] = &Reservation{ID: id, Venue: venue, Asset: asset, Amount: reserved}
+
+	return reserved
+}
+
+// Release frees what is left of a reservation, on reject, cancel or once the
+// order is done.
+func (m *Manager) Release(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	reservation, ok := m.reservations[id]
+	if !ok {
+		return
+	}
+
+	b := m.balance(reservation.Venue, reservation.Asset)
+	b.reserved = max(b.reserved-reservation.Amount, 0)
+	delete(m.reservations, id)
+}
+
+func (m *Manager) Reservation(id string) (Reservation, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	reservation, ok := m.reservations[id]
+	if !ok {
+		return Reservation{}, false
+	}
+	return *reservation, true
+}
+
+// ApplyFill books one of our fills on venue: base and quote totals move at
+// once, and the spent part of the reservation reservationId, if any, is
+// consumed. TradeId is the venue trade id ledger entries refer to: totals the
+// venue already reported after that trade are left alone, and later reports
+// set absolute totals, so nothing is counted twice.
+func (m *Manager) ApplyFill(venue string, reservationId string, tradeId string, base string, quote string, side string, quantity float64, price float64, fee float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	spentAsset, spent := quote, quantity*price+fee
+	if side == "buy" {
+		m.balance(venue, base).add(tradeId, quantity)
+		m.balance(venue, quote).add(tradeId, -spent)
+	} else {
+		spentAsset, spent = base, quantity
+		m.balance(venue, base).add(tradeId, -quantity)
+		m.balance(venue, quote).add(tradeId, quantity*price-fee)
+	}
+
+	reservation, ok := m.reservations[reservationId]
+	if !ok || reservation.Asset != spentAsset {
+		return
+	}
+
+	consumed := min(spent, reservation.Amount)
+	reservation.Amount -= consumed
+	b := m.balance(venue, spentAsset)
+	b.reserved = max(b.reserved-consumed, 0)
+}
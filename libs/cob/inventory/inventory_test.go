@@ -0,0 +1,75 @@
+package inventory
+
+import (
+	"testing"
+	"time"
+
+	"bitnet/exchange"
+	krakenwsclient "bitnet/kraken_ws_client"
+)
+
+// ledger is the Kraken balances update for one side of trade refId.
+func ledger(asset string, refId string, amount float64, balance float64, timestamp time.Time) krakenwsclient.BalanceUpdate {
+	return krakenwsclient.BalanceUpdate{Transactions: []krakenwsclient.LedgerTransaction{{
+		Asset: asset, Amount: amount, Balance: balance, RefID: refId, LedgerID: "L" + refId, Timestamp: timestamp, Type: "trade",
+	}}}
+}
+
+func TestLedgerBeforeFillIsNotCountedTwice(t *testing.T) {
+	m := New()
+	m.Snapshot("kraken", []exchange.Balance{{Asset: "BTC", Total: 1, Available: 1}, {Asset: "USD", Total: 100000, Available: 100000}})
+	if err := m.Reserve("c1", "kraken", "USD", 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	m.ApplyBalanceUpdate("kraken", ledger("BTC", "T1", 0.1, 1.1, now))
+	m.ApplyBalanceUpdate("kraken", ledger("USD", "T1", -5010, 94990, now))
+	m.ApplyFill("kraken", "c1", "T1", "BTC", "USD", "buy", 0.1, 50000, 10)
+
+	if total := m.Balance("kraken", "BTC").Total; total != 1.1 {
+		t.Fatalf("BTC total = %v", total)
+	}
+	usd := m.Balance("kraken", "USD")
+	if usd.Total != 94990 || usd.Reserved != 0 {
+		t.Fatalf("USD = %+v", usd)
+	}
+}
+
+func TestFillBeforeLedgerIsSetByTheLedger(t *testing.T) {
+	m := New()
+	m.Snapshot("kraken", []exchange.Balance{{Asset: "BTC", Total: 1, Available: 1}, {Asset: "USD", Total: 100000, Available: 100000}})
+
+	m.ApplyFill("kraken", "", "T1", "BTC", "USD", "sell", 0.1, 50000, 10)
+	if total := m.Balance("kraken", "USD").Total; total != 104990 {
+		t.Fatalf("USD total after fill = %v", total)
+	}
+
+	now := time.Now()
+	m.ApplyBalanceUpdate("kraken", ledger("BTC", "T1", -0.1, 0.9, now))
+	m.ApplyBalanceUpdate("kraken", ledger("USD", "T1", 4990, 104990, now))
+	if btc, usd := m.Balance("kraken", "BTC").Total, m.Balance("kraken", "USD").Total; btc != 0.9 || usd != 104990 {
+		t.Fatalf("totals = %v BTC, %v USD", btc, usd)
+	}
+
+	// A ledger entry that arrives late still settles its trade.
+	m.ApplyBalanceUpdate("kraken", ledger("USD", "T2", 100, 105090, now.Add(time.Second)))
+	m.ApplyBalanceUpdate("kraken", ledger("BTC", "T2", 0.002, 0.902, now.Add(-time.Second)))
+	m.ApplyFill("kraken", "", "T2", "BTC", "USD", "buy", 0.002, 50000, 0)
+	if btc := m.Balance("kraken", "BTC").Total; btc != 0.9 {
+		t.Fatalf("BTC total after stale ledger = %v", btc)
+	}
+}
+
+func TestBalanceSnapshotClearsHolds(t *testing.T) {
+	m := New()
+	m.Snapshot("kraken", []exchange.Balance{{Asset: "USD", Total: 100000, Available: 90000}})
+	if held := m.Balance("kraken", "USD").Held; held != 10000 {
+		t.Fatalf("held = %v", held)
+	}
+
+	m.ApplyBalanceSnapshot("kraken", krakenwsclient.BalanceSnapshot{Balances: []krakenwsclient.BalanceAsset{{Asset: "USD", Balance: 100000}}})
+	if usd := m.Balance("kraken", "USD"); usd.Held != 0 || usd.Free != 100000 {
+		t.Fatalf("USD = %+v", usd)
+	}
+}
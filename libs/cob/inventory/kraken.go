@@ -0,0 +1,33 @@
+package inventory
+
+import (
+	krakenwsclient "bitnet/kraken_ws_client"
+)
+
+// ApplyBalanceSnapshot replaces the balances of venue with a snapshot of the
+// Kraken balances channel. It carries no holds, like Snapshot it clears those
+// of orders we did not reserve for; reservations are kept.
+func (m *Manager) ApplyBalanceSnapshot(venue string, snapshot krakenwsclient.BalanceSnapshot) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, b := range m.balances[venue] {
+		b.total = 0
+		b.held = 0
+	}
+	for _, asset := range snapshot.Balances {
+		m.balance(venue, asset.Asset).total = asset.Balance
+	}
+}
+
+// ApplyBalanceUpdate applies the ledger transactions of a Kraken balances
+// channel update. Each carries the resulting balance of its asset.
+func (m *Manager) ApplyBalanceUpdate(venue string, update krakenwsclient.BalanceUpdate) {
+	for _, transaction := range update.Transactions {
+		m.ApplyLedgerTransaction(venue, transaction)
+	}
+}
+
+func (m *Manager) ApplyLedgerTransaction(venue string, transaction krakenwsclient.LedgerTransaction) {
+	m.SetLedgerTotal(venue, transaction.Asset, transaction.RefID, transaction.Balance, transaction.Timestamp)
+}
@@ -0,0 +1,74 @@
+package journal
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// ackLog keeps what the journal knows of the places it recorded: the order
+// IDs of every place and reject entry, and the last acks journaled as
+// AckEntry, so a repeated place is answered the same after a restart.
+type ackLog struct {
+	keep   int
+	acks   []Entry // Ack entries, oldest first
+	placed map[string]bool
+}
+
+func newAckLog(keep int) *ackLog {
+	return &ackLog{keep: keep, placed: make(map[string]bool)}
+}
+
+// note records entry, journaled or replayed.
+func (l *ackLog) note(entry Entry) {
+	switch entry.Kind {
+	case PlaceEntry, RejectEntry:
+		l.placed[entry.Order.ID] = true
+	case AckEntry:
+		l.placed[entry.OrderID] = true
+		l.acks = append(l.acks, entry)
+		if len(l.acks) > l.keep {
+			l.acks = l.acks[1:]
+		}
+	}
+}
+
+// restore replaces the log with the one saved in snapshot.
+func (l *ackLog) restore(snapshot *Snapshot) {
+	l.acks = append([]Entry{}, snapshot.Acks...)
+	l.placed = make(map[string]bool)
+	for _, orderID := range snapshot.Placed {
+		l.placed[orderID] = true
+	}
+}
+
+// save copies the log into snapshot.
+func (l *ackLog) save(snapshot *Snapshot) {
+	snapshot.Acks = append([]Entry{}, l.acks...)
+	snapshot.Placed = make([]string, 0, len(l.placed))
+	for orderID := range l.placed {
+		snapshot.Placed = append(snapshot.Placed, orderID)
+	}
+	sort.Strings(snapshot.Placed)
+}
+
+// AppendAck journals ack as the reply to the place of orderID. It does not
+// change the book.
+func (j *Journal) AppendAck(orderID string, ack any) error {
+	encoded, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	return j.Append(Entry{Kind: AckEntry, OrderID: orderID, Ack: encoded})
+}
+
+// Acks returns the last KeepAcks ack entries, oldest first, recovered or
+// journaled since.
+func (j *Journal) Acks() []Entry {
+	return append([]Entry{}, j.acks.acks...)
+}
+
+// Placed reports whether an order of this ID was ever journaled, placed or
+// rejected, whether or not its ack is still kept.
+func (j *Journal) Placed(orderID string) bool {
+	return j.acks.placed[orderID]
+}
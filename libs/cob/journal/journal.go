@@ -0,0 +1,770 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cob"
+	"cob/risk"
+)
+
+// SyncPolicy tells when appended entries are forced to disk.
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // fsync after every entry, nothing acknowledged is lost
+	SyncInterval SyncPolicy = "interval" // fsync every Config.SyncInterval, a crash loses at most that much
+	SyncNever    SyncPolicy = "never"    // leave it to the OS
+)
+
+// Entry kinds, one per way of changing the book.
+const (
+	PlaceEntry       = "place"
+	RejectEntry      = "reject" // Order rejected before the book, e.g. by the risk checks
+	CancelEntry      = "cancel"
+	AmendEntry       = "amend"
+	LevelEntry       = "level"
+	LastPriceEntry   = "last_price"
+	MarkPriceEntry   = "mark_price"
+	TriggerEntry     = "trigger"
+	ExpireEntry      = "expire"
+	AckEntry         = "ack" // Reply to a place, kept for repeats; the book is left as it is
+	journalFile      = "journal.log"
+	runFile          = "run"
+	snapshotPrefix   = "snapshot-"
+	snapshotSuffix   = ".json"
+	recordHeaderSize = 8       // Length and CRC-32 of the payload
+	maxRecordSize    = 1 << 20 // Longest payload, a longer length is a damaged header
+)
+
+// Entry is one command applied to the book. Time is the book clock when it
+// was applied, replay sets the clock back to it so expiries and event times
+// come out the same.
+type Entry struct {
+	Sequence  uint64          `json:"sequence"`
+	Time      time.Time       `json:"time"`
+	Kind      string          `json:"kind"`
+	Order     *cob.Order      `json:"order,omitempty"`
+	OrderID   string          `json:"order_id,omitempty"`
+	Provider  string          `json:"provider,omitempty"`
+	Side      string          `json:"side,omitempty"`
+	Price     float64         `json:"price,omitempty"`
+	Quantity  float64         `json:"quantity,omitempty"`
+	Timestamp int64           `json:"timestamp,omitempty"`
+	Max       int             `json:"max,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Ack       json.RawMessage `json:"ack,omitempty"`
+}
+
+// Snapshot is a book state with the last journal entry it includes.
+type Snapshot struct {
+	JournalSequence uint64             `json:"journal_sequence"`
+	Time            time.Time          `json:"time"`
+	Book            cob.BookSnapshot   `json:"book"`
+	Positions       map[string]float64 `json:"positions,omitempty"` // Of Config.Checker
+	Acks            []Entry            `json:"acks,omitempty"`      // Last KeepAcks ack entries
+	Placed          []string           `json:"placed,omitempty"`    // IDs of every order placed or rejected
+}
+
+type Config struct {
+	Sync          SyncPolicy    // Defaults to SyncAlways
+	SyncInterval  time.Duration // Defaults to 100ms
+	SnapshotEvery int           // Entries between automatic snapshots, none when zero
+	KeepSnapshots int           // Snapshots kept on disk, defaults to 2
+	KeepAcks      int           // Ack entries kept for Acks, defaults to 10000
+	Clock         cob.Clock     // Time source of new entries, the system clock when nil
+	Checker       *risk.Checker // Risk checker the customer orders went through, rebuilt on recovery
+}
+
+// Journal is a write-ahead journal in front of one book: every change goes
+// through it, is appended to an append-only file of checksummed records and
+// only then applied. Components changing the book themselves, such as
+// broker.Commands, Append their entries before applying them. Open recovers
+// the book from the latest snapshot and the entries after it. It is not safe
+// for concurrent use, like the book it serves; run it on the book's single
+// writer.
+type Journal struct {
+	dir      string
+	config   Config
+	book     *cob.OrderBook
+	clock    *cob.ManualClock // Clock of the book, set to the time of each entry
+	file     *os.File
+	writer   *bufio.Writer
+	run      string
+	sequence uint64
+	since    int // Entries since the last snapshot
+	acks     *ackLog
+
+	mutex   sync.Mutex // Guards file and writer against the interval sync
+	dirty   bool
+	closing chan struct{}
+	closed  sync.WaitGroup
+}
+
+// Open recovers book from the journal in dir, creating it if needed, and
+// returns the journal to apply further changes through. The book gets a
+// manual clock driven by the journal; its sinks receive the events of the
+// replayed entries. With a Checker, replayed orders, cancels and amends go
+// through it and the fills of the other entries are booked to it, as
+// broker.Commands does live, so its open orders and positions are those the
+// journaled commands left.
+func Open(dir string, book *cob.OrderBook, config Config) (*Journal, error) {
+	if config.Sync == "" {
+		config.Sync = SyncAlways
+	}
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = 100 * time.Millisecond
+	}
+	if config.KeepSnapshots <= 0 {
+		config.KeepSnapshots = 2
+	}
+	if config.KeepAcks <= 0 {
+		config.KeepAcks = 10000
+	}
+	if config.Clock == nil {
+		config.Clock = cob.SystemClock
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	run, err := loadRun(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		run:     run,
+		dir:     dir,
+		config:  config,
+		book:    book,
+		clock:   cob.NewManualClock(config.Clock.Now()),
+		acks:    newAckLog(config.KeepAcks),
+		closing: make(chan struct{}),
+	}
+	book.Clock = j.clock
+
+	if err := j.recover(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+	j.writer = bufio.NewWriter(file)
+
+	if config.Sync == SyncInterval {
+		j.closed.Add(1)
+		go j.syncLoop()
+	}
+
+	return j, nil
+}
+
+// recover restores the latest readable snapshot and replays the entries
+// after it. A record cut short by the end of the file, from a crash while
+// appending, is cut off; any other damage fails recovery, as replaying past
+// it or cutting it off would silently lose changes.
+func (j *Journal) recover() error {
+	snapshot, err := j.latestSnapshot()
+	if err != nil {
+		return err
+	}
+	if snapshot != nil {
+		j.book.Restore(snapshot.Book)
+		j.clock.Set(snapshot.Time)
+		j.sequence = snapshot.JournalSequence
+		if j.config.Checker != nil {
+			j.config.Checker.Restore(j.book, snapshot.Positions)
+		}
+		j.acks.restore(snapshot)
+	}
+
+	path := filepath.Join(j.dir, journalFile)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+	for {
+		entry, record, err := readEntry(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !errors.Is(err, errTruncated) {
+			return fmt.Errorf("journal %s: corrupt record at offset %d: %w", path, offset, err)
+		}
+		if err != nil {
+			log.Printf("journal %s: dropping torn tail at offset %d: %v\n", path, offset, err)
+			if err := os.Truncate(path, offset); err != nil {
+				return err
+			}
+			break
+		}
+		offset += int64(len(record))
+
+		if entry.Sequence <= j.sequence {
+			continue // Included in the snapshot
+		}
+		if entry.Sequence != j.sequence+1 {
+			// Compacted away, recovery needs a snapshot that is no longer readable
+			return fmt.Errorf("journal %s: entries %d to %d missing at offset %d", path, j.sequence+1, entry.Sequence-1, offset-int64(len(record)))
+		}
+		j.sequence = entry.Sequence
+		j.acks.note(entry)
+		j.apply(entry)
+	}
+
+	return nil
+}
+
+// errTruncated is returned for a record cut short by the end of the file.
+var errTruncated = errors.New("truncated")
+
+// readEntry reads the next record and returns it, header included, with the
+// entry it holds.
+func readEntry(reader *bufio.Reader) (Entry, []byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF {
+			return Entry{}, nil, io.EOF
+		}
+		return Entry{}, nil, fmt.Errorf("%w header: %v", errTruncated, err)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxRecordSize {
+		return Entry{}, nil, fmt.Errorf("record length %d above %d", length, maxRecordSize)
+	}
+	record := make([]byte, recordHeaderSize+int(length))
+	copy(record, header)
+	payload := record[recordHeaderSize:]
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return Entry{}, nil, fmt.Errorf("%w record: %v", errTruncated, err)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return Entry{}, nil, errors.New("checksum mismatch")
+	}
+
+	entry := Entry{}
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return Entry{}, nil, fmt.Errorf("invalid record: %w", err)
+	}
+
+	return entry, record, nil
+}
+
+// apply runs a replayed entry against the book at the entry's time. Errors
+// are those the book returned when the entry was first applied.
+func (j *Journal) apply(entry Entry) {
+	j.clock.Set(entry.Time)
+	checker := j.config.Checker
+
+	switch entry.Kind {
+	case PlaceEntry:
+		order := *entry.Order
+		if checker != nil {
+			checker.PlaceOrder(j.book, &order)
+		} else {
+			j.book.ProcessOrder(&order)
+		}
+	case RejectEntry:
+		order := *entry.Order
+		j.book.Reject(&order, cob.RejectReason(entry.Reason))
+	case CancelEntry:
+		if checker != nil {
+			checker.CancelOrder(j.book, entry.OrderID)
+		} else {
+			j.book.CancelOrder(entry.OrderID)
+		}
+	case AmendEntry:
+		if checker != nil {
+			checker.AmendOrder(j.book, entry.OrderID, entry.Price, entry.Quantity)
+		} else {
+			j.book.AmendOrder(entry.OrderID, entry.Price, entry.Quantity)
+		}
+	case LevelEntry:
+		j.applyFills(j.book.UpdateExternalLevel(entry.Provider, entry.Side, entry.Price, entry.Quantity, entry.Timestamp))
+	case LastPriceEntry:
+		j.applyFills(j.book.SetLastPrice(entry.Price))
+	case MarkPriceEntry:
+		j.applyFills(j.book.SetMarkPrice(entry.Price))
+	case TriggerEntry:
+		j.applyFills(j.book.TriggerStops())
+	case ExpireEntry:
+		expired := j.book.ExpireOrders(entry.Max)
+		if checker != nil {
+			checker.Expired(expired)
+		}
+	case AckEntry:
+	default:
+		log.Printf("journal: skipping unknown entry kind %q\n", entry.Kind)
+	}
+}
+
+// applyFills books replayed fills made outside the checker to it.
+func (j *Journal) applyFills(fills []cob.Fill) {
+	if j.config.Checker != nil {
+		j.config.Checker.ApplyFills(fills)
+	}
+}
+
+// Append journals an entry ahead of applying it and sets the book clock to
+// the entry's time; the caller applies it to the book right after, before
+// appending the next one. Entries are replayed as the Journal methods of
+// their kind apply them. An order without a timestamp is stamped with the
+// entry's time. A snapshot that came due with the previous entry is taken
+// first.
+func (j *Journal) Append(entry Entry) error {
+	if j.config.SnapshotEvery > 0 && j.since >= j.config.SnapshotEvery {
+		if err := j.Snapshot(); err != nil {
+			log.Printf("journal: unable to snapshot: %v\n", err)
+		}
+	}
+
+	entry.Sequence = j.sequence + 1
+	entry.Time = j.config.Clock.Now()
+	if entry.Order != nil && entry.Order.Timestamp == 0 {
+		entry.Order.Timestamp = entry.Time.UnixNano()
+	}
+
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to journal %s: %w", entry.Kind, err)
+	}
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("unable to journal %s: %d bytes above %d", entry.Kind, len(payload), maxRecordSize)
+	}
+
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+
+	if err := j.writeRecord(append(header, payload...)); err != nil {
+		return fmt.Errorf("unable to journal %s: %w", entry.Kind, err)
+	}
+
+	j.sequence = entry.Sequence
+	j.since++
+	j.acks.note(entry)
+	j.clock.Set(entry.Time)
+	return nil
+}
+
+func (j *Journal) writeRecord(record []byte) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, err := j.writer.Write(record); err != nil {
+		return err
+	}
+
+	switch j.config.Sync {
+	case SyncAlways:
+		if err := j.writer.Flush(); err != nil {
+			return err
+		}
+		return j.file.Sync()
+	case SyncInterval:
+		j.dirty = true
+		return nil
+	default:
+		return j.writer.Flush()
+	}
+}
+
+// ProcessOrder journals and processes a new order, see
+// cob.OrderBook.ProcessOrder. Orders the book rejects are journaled too, and
+// rejected again on replay.
+func (j *Journal) ProcessOrder(order *cob.Order) ([]cob.Fill, error) {
+	submitted := *order
+	if err := j.Append(Entry{Kind: PlaceEntry, Order: &submitted}); err != nil {
+		return nil, err
+	}
+	order.Timestamp = submitted.Timestamp
+
+	return j.book.ProcessOrder(order)
+}
+
+// Reject journals and reports an order refused before it reached the book,
+// see cob.OrderBook.Reject.
+func (j *Journal) Reject(order *cob.Order, reason cob.RejectReason) error {
+	rejected := *order
+	if err := j.Append(Entry{Kind: RejectEntry, Order: &rejected, Reason: string(reason)}); err != nil {
+		return err
+	}
+	order.Timestamp = rejected.Timestamp
+
+	j.book.Reject(order, reason)
+	return nil
+}
+
+// CancelOrder journals and applies a cancel, see cob.OrderBook.CancelOrder.
+func (j *Journal) CancelOrder(orderID string) (*cob.Order, error) {
+	if err := j.Append(Entry{Kind: CancelEntry, OrderID: orderID}); err != nil {
+		return nil, err
+	}
+
+	return j.book.CancelOrder(orderID), nil
+}
+
+// AmendOrder journals and applies an amendment, see cob.OrderBook.AmendOrder.
+func (j *Journal) AmendOrder(orderID string, price float64, quantity float64) (*cob.Order, []cob.Fill, error) {
+	if err := j.Append(Entry{Kind: AmendEntry, OrderID: orderID, Price: price, Quantity: quantity}); err != nil {
+		return nil, nil, err
+	}
+
+	return j.book.AmendOrder(orderID, price, quantity)
+}
+
+// UpdateExternalLevel journals and applies a venue level update, see
+// cob.OrderBook.UpdateExternalLevel.
+func (j *Journal) UpdateExternalLevel(provider string, side string, price float64, quantity float64, timestamp int64) ([]cob.Fill, error) {
+	entry := Entry{Kind: LevelEntry, Provider: provider, Side: side, Price: price, Quantity: quantity, Timestamp: timestamp}
+	if err := j.Append(entry); err != nil {
+		return nil, err
+	}
+
+	return j.book.UpdateExternalLevel(provider, side, price, quantity, timestamp), nil
+}
+
+func (j *Journal) SetLastPrice(price float64) ([]cob.Fill, error) {
+	if err := j.Append(Entry{Kind: LastPriceEntry, Price: price}); err != nil {
+		return nil, err
+	}
+
+	return j.book.SetLastPrice(price), nil
+}
+
+func (j *Journal) SetMarkPrice(price float64) ([]cob.Fill, error) {
+	if err := j.Append(Entry{Kind: MarkPriceEntry, Price: price}); err != nil {
+		return nil, err
+	}
+
+	return j.book.SetMarkPrice(price), nil
+}
+
+func (j *Journal) TriggerStops() ([]cob.Fill, error) {
+	if err := j.Append(Entry{Kind: TriggerEntry}); err != nil {
+		return nil, err
+	}
+
+	return j.book.TriggerStops(), nil
+}
+
+// ExpireOrders journals and runs an expiry pass. Passes are journaled since
+// what they remove depends on when they run.
+func (j *Journal) ExpireOrders(max int) ([]*cob.Order, error) {
+	if next, ok := j.book.NextExpiry(); !ok || next.After(j.config.Clock.Now()) {
+		return nil, nil // Nothing due, not worth an entry
+	}
+
+	if err := j.Append(Entry{Kind: ExpireEntry, Max: max}); err != nil {
+		return nil, err
+	}
+
+	return j.book.ExpireOrders(max), nil
+}
+
+// loadRun reads the run of the journal in dir, created with the journal. A
+// journal from before runs has the empty run, that of the history stored
+// before them.
+func loadRun(dir string) (string, error) {
+	path := filepath.Join(dir, runFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := os.Stat(filepath.Join(dir, journalFile)); err == nil {
+		run = ""
+	}
+	if err := writeFileSync(path, []byte(run+"\n")); err != nil {
+		return "", err
+	}
+	return run, syncDir(dir)
+}
+
+// Run identifies the journal. The book events it sequences continue across
+// restarts as long as the journal does, so a run and a sequence name one
+// event, e.g. for store.Store.SetRun.
+func (j *Journal) Run() string {
+	return j.run
+}
+
+// Sequence returns the sequence of the last entry.
+func (j *Journal) Sequence() uint64 {
+	return j.sequence
+}
+
+func snapshotName(sequence uint64) string {
+	return fmt.Sprintf("%s%020d%s", snapshotPrefix, sequence, snapshotSuffix)
+}
+
+// Snapshot writes the state of the book, so recovery only replays the entries
+// after it, removes the snapshots beyond KeepSnapshots and compacts the
+// journal to the entries after the oldest snapshot kept.
+func (j *Journal) Snapshot() error {
+	snapshot := Snapshot{
+		JournalSequence: j.sequence,
+		Time:            j.clock.Now(),
+		Book:            j.book.Snapshot(),
+	}
+	if j.config.Checker != nil {
+		snapshot.Positions = j.config.Checker.Positions()
+	}
+	j.acks.save(&snapshot)
+
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(j.dir, snapshotName(j.sequence))
+	if err := writeFileSync(path+".tmp", encoded); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if err := syncDir(j.dir); err != nil {
+		return err
+	}
+	j.since = 0
+
+	names, err := j.snapshots()
+	if err != nil {
+		return err
+	}
+	for len(names) > j.config.KeepSnapshots {
+		if err := os.Remove(filepath.Join(j.dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+
+	oldest, err := snapshotSequence(names[0])
+	if err != nil {
+		return err
+	}
+	return j.compact(oldest)
+}
+
+func snapshotSequence(name string) (uint64, error) {
+	sequence := strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix)
+	return strconv.ParseUint(sequence, 10, 64)
+}
+
+// compact rewrites the journal without the entries up to sequence, which
+// every snapshot kept includes. The new file replaces the old one in a
+// single rename, a crash leaves one or the other.
+func (j *Journal) compact(sequence uint64) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(j.dir, journalFile)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	kept := bytes.Buffer{}
+	dropped := false
+	reader := bufio.NewReader(file)
+	for {
+		entry, record, err := readEntry(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("journal %s: unable to compact: %w", path, err)
+		}
+		if entry.Sequence <= sequence {
+			dropped = true
+			continue
+		}
+		kept.Write(record)
+	}
+	if !dropped {
+		return nil
+	}
+
+	// Appends continue on the file written, which keeps its descriptor
+	// through the rename.
+	compacted, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := replaceSync(compacted, kept.Bytes(), path); err != nil {
+		compacted.Close()
+		return err
+	}
+	if err := syncDir(j.dir); err != nil {
+		compacted.Close()
+		return err
+	}
+
+	j.file.Close()
+	j.file = compacted
+	j.writer.Reset(compacted)
+	j.dirty = false
+
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// replaceSync writes data to file, forces it to disk and renames it to path.
+func replaceSync(file *os.File, data []byte, path string) error {
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// syncDir forces the entries of dir to disk, so a renamed file survives a
+// crash under its new name.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// snapshots returns the snapshot files, oldest first.
+func (j *Journal) snapshots() ([]string, error) {
+	dirEntries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// latestSnapshot reads the newest snapshot that can be decoded.
+func (j *Journal) latestSnapshot() (*Snapshot, error) {
+	names, err := j.snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(filepath.Join(j.dir, names[i]))
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot := &Snapshot{}
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			log.Printf("journal: skipping unreadable snapshot %s: %v\n", names[i], err)
+			continue
+		}
+		return snapshot, nil
+	}
+
+	return nil, nil
+}
+
+func (j *Journal) syncLoop() {
+	defer j.closed.Done()
+
+	ticker := time.NewTicker(j.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.closing:
+			return
+		case <-ticker.C:
+		}
+
+		if err := j.Sync(); err != nil {
+			log.Printf("journal: unable to sync: %v\n", err)
+		}
+	}
+}
+
+// Sync forces the appended entries to disk.
+func (j *Journal) Sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	if j.config.Sync == SyncInterval && !j.dirty {
+		return nil
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	close(j.closing)
+	j.closed.Wait()
+
+	if err := j.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
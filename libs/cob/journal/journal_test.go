@@ -0,0 +1,309 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cob"
+)
+
+var start = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+// eventLog encodes the events of a book as they are published.
+type eventLog struct {
+	bytes.Buffer
+}
+
+func (l *eventLog) HandleEvent(event cob.Event) {
+	encoded, _ := json.Marshal(event)
+	l.Write(append(encoded, '\n'))
+}
+
+// steps changes the book through the journal in every way it can be changed.
+var steps = []func(j *Journal){
+	func(j *Journal) { j.UpdateExternalLevel("kraken", "sell", 101, 2, 1) },
+	func(j *Journal) { j.UpdateExternalLevel("kraken", "buy", 99, 2, 2) },
+	func(j *Journal) {
+		j.ProcessOrder(&cob.Order{ID: "o1", Account: "a", Side: "buy", Price: 100, Quantity: 1, Provider: "local"})
+	},
+	func(j *Journal) {
+		j.Reject(&cob.Order{ID: "r1", Account: "a", Side: "buy", Price: 100, Quantity: 50, Provider: "local"}, "max_quantity")
+	},
+	func(j *Journal) {
+		j.ProcessOrder(&cob.Order{ID: "s1", Account: "b", Side: "sell", Type: cob.StopOrder, StopPrice: 98, Quantity: 1, Provider: "local"})
+	},
+	func(j *Journal) {
+		j.ProcessOrder(&cob.Order{ID: "g1", Account: "b", Side: "sell", Price: 103, Quantity: 1, Provider: "local", TimeInForce: cob.GoodTillDate, ExpireAt: start.Add(8 * time.Second)})
+	},
+	func(j *Journal) { j.AmendOrder("o1", 100.5, 2) },
+	func(j *Journal) {
+		j.ProcessOrder(&cob.Order{ID: "t1", Account: "c", Side: "sell", Price: 100, Quantity: 1, Provider: "local"})
+	},
+	func(j *Journal) { j.SetLastPrice(97) },
+	func(j *Journal) { j.SetMarkPrice(97) },
+	func(j *Journal) { j.TriggerStops() },
+	func(j *Journal) { j.ExpireOrders(10) },
+	func(j *Journal) { j.CancelOrder("o1") },
+	func(j *Journal) {
+		j.ProcessOrder(&cob.Order{ID: "t2", Account: "c", Side: "buy", Price: 101, Quantity: 1, Provider: "local"})
+	},
+}
+
+// open opens the journal in dir for a new book publishing to events, on a
+// clock advancing a second per step.
+func open(t *testing.T, dir string, events *eventLog, clock *cob.ManualClock, snapshotEvery int) *Journal {
+	t.Helper()
+
+	book := cob.NewOrderBook()
+	book.Symbol = "BTC/USD"
+	book.AddSink(events)
+	j, err := Open(dir, book, Config{Clock: clock, SnapshotEvery: snapshotEvery})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func run(j *Journal, clock *cob.ManualClock, steps []func(j *Journal)) {
+	for _, step := range steps {
+		clock.Advance(time.Second)
+		step(j)
+	}
+}
+
+func TestReplayPublishesTheEventsOfTheLiveRun(t *testing.T) {
+	dir := t.TempDir()
+	clock := cob.NewManualClock(start)
+
+	live := &eventLog{}
+	j := open(t, dir, live, clock, 0)
+	run(j, clock, steps)
+	j.Close()
+
+	replayed := &eventLog{}
+	j = open(t, dir, replayed, clock, 0)
+	defer j.Close()
+
+	if live.Len() == 0 || !bytes.Equal(live.Bytes(), replayed.Bytes()) {
+		t.Fatalf("live events:\n%s\nreplayed events:\n%s", live.String(), replayed.String())
+	}
+}
+
+func TestSnapshotRecoveryContinuesLikeTheLiveRun(t *testing.T) {
+	const restart = 7
+
+	for _, snapshotEvery := range []int{1, 3, 5} {
+		// Uninterrupted run.
+		clock := cob.NewManualClock(start)
+		events := &eventLog{}
+		j := open(t, t.TempDir(), events, clock, 0)
+		run(j, clock, steps[:restart])
+		events.Reset()
+		run(j, clock, steps[restart:])
+		expected := events.String()
+		state, _ := json.Marshal(j.book.Snapshot())
+		j.Close()
+
+		// Restarted from a snapshot and the entries after it.
+		dir := t.TempDir()
+		clock = cob.NewManualClock(start)
+		events = &eventLog{}
+		j = open(t, dir, events, clock, snapshotEvery)
+		run(j, clock, steps[:restart])
+		j.Close()
+		if snapshots, _ := j.snapshots(); len(snapshots) == 0 {
+			t.Fatalf("every %d: no snapshot taken", snapshotEvery)
+		}
+
+		events = &eventLog{}
+		j = open(t, dir, events, clock, snapshotEvery)
+		events.Reset()
+		run(j, clock, steps[restart:])
+		recovered, _ := json.Marshal(j.book.Snapshot())
+		j.Close()
+
+		if events.String() != expected {
+			t.Fatalf("every %d: expected events:\n%s\nrecovered events:\n%s", snapshotEvery, expected, events.String())
+		}
+		if !bytes.Equal(state, recovered) {
+			t.Fatalf("every %d: expected book %s, recovered %s", snapshotEvery, state, recovered)
+		}
+	}
+}
+
+// journaled writes steps to a new journal and returns its directory and the
+// size of its file.
+func journaled(t *testing.T) (string, int64) {
+	t.Helper()
+
+	dir := t.TempDir()
+	clock := cob.NewManualClock(start)
+	j := open(t, dir, &eventLog{}, clock, 0)
+	run(j, clock, steps)
+	j.Close()
+
+	info, err := os.Stat(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, info.Size()
+}
+
+func TestCorruptRecordFailsRecovery(t *testing.T) {
+	damages := map[string]func(data []byte){
+		"payload":       func(data []byte) { data[recordHeaderSize+2] ^= 0xff },
+		"length":        func(data []byte) { data[0] ^= 0x7f }, // Far beyond the file
+		"last checksum": func(data []byte) { data[len(data)-2] ^= 0xff },
+	}
+
+	for name, damage := range damages {
+		dir, _ := journaled(t)
+		path := filepath.Join(dir, journalFile)
+
+		data, _ := os.ReadFile(path)
+		damage(data)
+		os.WriteFile(path, data, 0o644)
+
+		book := cob.NewOrderBook()
+		if _, err := Open(dir, book, Config{Clock: cob.NewManualClock(start)}); err == nil {
+			t.Fatalf("%s: recovered past a corrupt record", name)
+		}
+		if after, _ := os.ReadFile(path); !bytes.Equal(after, data) {
+			t.Fatalf("%s: journal changed by a failed recovery", name)
+		}
+	}
+}
+
+func TestTornTailIsCut(t *testing.T) {
+	for _, tear := range []int{3, 20} {
+		dir, size := journaled(t)
+		path := filepath.Join(dir, journalFile)
+
+		data, _ := os.ReadFile(path)
+		data = data[:size-int64(tear)]
+		os.WriteFile(path, data, 0o644)
+
+		j, err := Open(dir, cob.NewOrderBook(), Config{Clock: cob.NewManualClock(start)})
+		if err != nil {
+			t.Fatalf("torn by %d: %v", tear, err)
+		}
+		j.Close()
+
+		if j.Sequence() != uint64(len(steps)-1) {
+			t.Fatalf("torn by %d: sequence %d after recovery", tear, j.Sequence())
+		}
+		if info, _ := os.Stat(path); info.Size() >= size {
+			t.Fatalf("torn by %d: tail not cut, %d bytes", tear, info.Size())
+		}
+	}
+}
+
+func TestSnapshotsCompactTheJournal(t *testing.T) {
+	_, full := journaled(t)
+
+	dir := t.TempDir()
+	clock := cob.NewManualClock(start)
+	j := open(t, dir, &eventLog{}, clock, 3)
+	run(j, clock, steps)
+	state, _ := json.Marshal(j.book.Snapshot())
+	j.Close()
+
+	path := filepath.Join(dir, journalFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= full {
+		t.Fatalf("journal of %d bytes not compacted, %d uncompacted", info.Size(), full)
+	}
+
+	// The oldest snapshot kept still recovers the book when the newest is
+	// unreadable.
+	for _, damaged := range []bool{false, true} {
+		if damaged {
+			names, _ := j.snapshots()
+			os.WriteFile(filepath.Join(dir, names[len(names)-1]), []byte("{"), 0o644)
+		}
+
+		reopened := open(t, dir, &eventLog{}, clock, 3)
+		recovered, _ := json.Marshal(reopened.book.Snapshot())
+		reopened.Close()
+		if !bytes.Equal(state, recovered) {
+			t.Fatalf("damaged %v: expected book %s, recovered %s", damaged, state, recovered)
+		}
+	}
+
+	// Without a snapshot the entries compacted away are missing.
+	names, _ := j.snapshots()
+	for _, name := range names {
+		os.Remove(filepath.Join(dir, name))
+	}
+	if _, err := Open(dir, cob.NewOrderBook(), Config{Clock: clock}); err == nil {
+		t.Fatal("recovered without the compacted entries")
+	}
+}
+
+func TestAcksSurviveSnapshotsAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+	clock := cob.NewManualClock(start)
+	config := Config{Clock: clock, SnapshotEvery: 2, KeepAcks: 2}
+
+	j, err := Open(dir, cob.NewOrderBook(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"o1", "o2", "o3"} {
+		clock.Advance(time.Second)
+		j.ProcessOrder(&cob.Order{ID: id, Account: "a", Side: "buy", Price: 100, Quantity: 1, Provider: "local"})
+		if err := j.AppendAck(id, map[string]string{"order_id": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Second)
+	j.Reject(&cob.Order{ID: "r1", Account: "a", Side: "buy", Price: 100, Quantity: 50, Provider: "local"}, "max_quantity")
+	j.Close()
+
+	j, err = Open(dir, cob.NewOrderBook(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	acks := j.Acks()
+	if len(acks) != 2 || acks[0].OrderID != "o2" || string(acks[1].Ack) != `{"order_id":"o3"}` {
+		t.Fatalf("acks %+v", acks)
+	}
+	for _, id := range []string{"o1", "o2", "o3", "r1"} {
+		if !j.Placed(id) {
+			t.Fatalf("%s not placed", id)
+		}
+	}
+	if j.Placed("o4") {
+		t.Fatal("o4 placed")
+	}
+}
+
+func TestRunSurvivesRestarts(t *testing.T) {
+	dir, _ := journaled(t)
+	os.Remove(filepath.Join(dir, runFile)) // Journaled before runs
+
+	runs := []string{}
+	for _, dir := range []string{t.TempDir(), dir} {
+		for i := 0; i < 2; i++ {
+			j, err := Open(dir, cob.NewOrderBook(), Config{Clock: cob.NewManualClock(start)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			runs = append(runs, j.Run())
+			j.Close()
+		}
+	}
+
+	if runs[0] == "" || runs[1] != runs[0] || runs[2] != "" || runs[3] != "" {
+		t.Fatalf("runs %q", runs)
+	}
+}
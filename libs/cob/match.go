@@ -0,0 +1,258 @@
+package cob
+
+import "fmt"
+
+// Fill is one match of an incoming (taker) order against a resting (maker)
+// order. Fills against external liquidity, Provider other than "local", have
+// to be hedged on that venue.
+type Fill struct {
+	Symbol       string  `json:"symbol"`
+	TakerOrderID string  `json:"taker_order_id"`
+	MakerOrderID string  `json:"maker_order_id"`
+	Side         string  `json:"side"`  // Side of the taker order
+	Price        float64 `json:"price"` // Price of the maker level
+	Quantity     float64 `json:"quantity"`
+	Provider     string  `json:"provider"`  // Provider of the maker order
+	Timestamp    int64   `json:"timestamp"` // Timestamp of the taker order
+}
+
+// crosses reports whether an order of side at limit may trade at price.
+func crosses(side string, limit float64, price float64) bool {
+	if side == "buy" {
+		return price <= limit
+	}
+	return price >= limit
+}
+
+// ProcessOrder matches an incoming order against the opposite side of the
+// book, best price first, for as long as prices cross the order's limit. What
+// remains of a good-till-cancel limit order stays in the book, the remainder
+// of market and immediate-or-cancel orders is dropped and left in
+// order.Quantity. Fills are returned and passed to OnFill.
+//
+// Fill-or-kill orders that can not be filled completely and post-only orders
+// that would cross are rejected before anything is matched.
+//
+// Stop orders are held until their trigger price is reached. The returned
+// fills include those of stop orders triggered by this order's trades.
+//
+// Orders with the ID of an order still resting or waiting for its trigger
+// are rejected with ErrDuplicateOrder.
+//
+// Every step is reported to the event sinks: the order accepted or rejected,
+// its fills, the price levels it changed and the cancellation of what it
+// leaves unfilled.
+func (ob *OrderBook) ProcessOrder(order *Order) ([]Fill, error) {
+	if ob.Order(order.ID) != nil {
+		err := fmt.Errorf("%w: %s", ErrDuplicateOrder, order.ID)
+		ob.Reject(order, rejectReason(err))
+		return nil, err
+	}
+
+	if order.IsStop() {
+		if err := ob.addStop(order); err != nil {
+			ob.Reject(order, rejectReason(err))
+			return nil, err
+		}
+		ob.accepted(order)
+		// A stop already reached when it arrives triggers at once.
+		return ob.TriggerStops(), nil
+	}
+
+	if err := ob.check(order); err != nil {
+		ob.Reject(order, rejectReason(err))
+		return nil, err
+	}
+	ob.accepted(order)
+
+	return append(ob.match(order), ob.TriggerStops()...), nil
+}
+
+// check rejects orders the book can't take as they are, after dropping the
+// expired orders they would trade with.
+func (ob *OrderBook) check(order *Order) error {
+	if err := validateOrder(order); err != nil {
+		return err
+	}
+	if err := ob.prepareExpiry(order); err != nil {
+		return err
+	}
+	ob.dropExpired(order)
+
+	makerSide := oppositeSide(order.Side)
+	if order.PostOnly {
+		if prices := ob.Prices(makerSide); len(prices) > 0 && order.crosses(prices[0]) {
+			return ErrWouldCross
+		}
+	}
+	if order.TimeInForce == FillOrKill && ob.crossingQuantity(order) < order.Quantity {
+		return ErrNotFilled
+	}
+
+	return nil
+}
+
+// match trades a checked order and rests or cancels what is left of it.
+func (ob *OrderBook) match(order *Order) []Fill {
+	fills := []Fill{}
+	makerSide := oppositeSide(order.Side)
+	priceLevels := ob.priceLevels(makerSide)
+
+	prevent := ob.selfTradePrevention(order)
+	for _, price := range ob.Prices(makerSide) {
+		if order.Quantity <= 0 || !order.crosses(price) {
+			break
+		}
+
+		// Self-trade prevention takes quantity off order while matching.
+		for _, fill := range ob.matchLevel(priceLevels[price], order, prevent) {
+			fill.Symbol = ob.Symbol
+			order.Quantity -= fill.Quantity
+			ob.lastPrice = fill.Price
+			fills = append(fills, fill)
+			ob.filled(fill)
+		}
+		ob.UpdatePriceLevel(makerSide, price)
+		ob.levelChanged(makerSide, price)
+	}
+
+	if order.Quantity < 1e-12 {
+		order.Quantity = 0
+	}
+	if order.Quantity > 0 && order.Rests() {
+		ob.PlaceOrder(order)
+		ob.scheduleExpiry(order)
+	} else if order.Quantity > 0 {
+		ob.cancelled(order, UnfilledReason, order.Quantity)
+	}
+
+	return fills
+}
+
+// matchLevel matches order against one level in the matching mode of the
+// book: pro-rata when configured, priority order otherwise.
+func (ob *OrderBook) matchLevel(pl *PriceLevel, order *Order, prevent preventFunc) []Fill {
+	if ob.ProRata != nil {
+		return pl.matchProRata(order, order.Quantity, *ob.ProRata, prevent)
+	}
+	return pl.match(order, order.Quantity, prevent)
+}
+
+// crossingQuantity returns the opposite liquidity an order may trade with,
+// as far as self-trade prevention lets it: resting orders of its own account
+// are left out under cancel_oldest, under the other modes meeting one cancels
+// or decrements the order, so counting stops there. Pro-rata levels apply
+// prevention to the whole level before allocating it.
+func (ob *OrderBook) crossingQuantity(order *Order) float64 {
+	makerSide := oppositeSide(order.Side)
+	priceLevels := ob.priceLevels(makerSide)
+	mode := ob.selfTradeMode(order)
+
+	total := 0.0
+	for _, price := range ob.Prices(makerSide) {
+		if !order.crosses(price) || total >= order.Quantity {
+			break
+		}
+
+		pl := priceLevels[price]
+		if mode == "" {
+			total += pl.TotalQuantity
+			continue
+		}
+
+		level := 0.0
+		for _, resting := range pl.SortedOrders() {
+			if ob.ProRata == nil && total+level >= order.Quantity {
+				break
+			}
+			if !selfMatch(order, resting) {
+				level += resting.Quantity
+				continue
+			}
+			if mode == CancelOldest {
+				continue
+			}
+			if ob.ProRata != nil {
+				return total
+			}
+			return total + level
+		}
+		total += level
+	}
+
+	return total
+}
+
+// CancelOrder removes a resting order from the book and returns it, or nil
+// when no order has that ID.
+func (ob *OrderBook) CancelOrder(orderID string) *Order {
+	if order := ob.stops.remove(orderID); order != nil {
+		ob.cancelled(order, RequestedReason, order.Quantity)
+		return order
+	}
+
+	for _, side := range []string{"buy", "sell"} {
+		for price, pl := range ob.priceLevels(side) {
+			for _, order := range *pl.Orders {
+				if order.ID != orderID {
+					continue
+				}
+
+				pl.RemoveOrder(orderID)
+				ob.UpdatePriceLevel(side, price)
+				ob.cancelled(order, RequestedReason, order.Quantity)
+				ob.levelChanged(side, price)
+				return order
+			}
+		}
+	}
+
+	return nil
+}
+
+// Order returns the resting or stop order with orderID, nil when the book
+// holds none.
+func (ob *OrderBook) Order(orderID string) *Order {
+	if order, _ := ob.findResting(orderID); order != nil {
+		return order
+	}
+	for _, stop := range ob.StopOrders() {
+		if stop.ID == orderID {
+			return stop
+		}
+	}
+	return nil
+}
+
+// LocalOrders returns the resting customer orders, bids then asks, best price
+// first and each level in priority, followed by the stop orders.
+func (ob *OrderBook) LocalOrders() []*Order {
+	orders := []*Order{}
+	for _, side := range []string{"buy", "sell"} {
+		priceLevels := ob.priceLevels(side)
+		for _, price := range ob.Prices(side) {
+			for _, order := range priceLevels[price].SortedOrders() {
+				if order.Provider == "local" {
+					orders = append(orders, order)
+				}
+			}
+		}
+	}
+	return append(orders, ob.StopOrders()...)
+}
+
+// Mid returns the mid price of the best bid and ask, or the best price of the
+// only side with liquidity. It is false when the book is empty.
+func (ob *OrderBook) Mid() (float64, bool) {
+	bids, asks := ob.Prices("buy"), ob.Prices("sell")
+	switch {
+	case len(bids) > 0 && len(asks) > 0:
+		return (bids[0] + asks[0]) / 2, true
+	case len(bids) > 0:
+		return bids[0], true
+	case len(asks) > 0:
+		return asks[0], true
+	default:
+		return 0, false
+	}
+}
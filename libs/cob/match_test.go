@@ -0,0 +1,135 @@
+package cob
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFillOrKillLeavesOutLiquidityPreventedBySelfTrade(t *testing.T) {
+	asks := []*Order{
+		{ID: "b1", Account: "b", Price: 100, Quantity: 1, Timestamp: 1},
+		{ID: "a1", Account: "a", Price: 101, Quantity: 1, Timestamp: 2},
+		{ID: "b2", Account: "b", Price: 102, Quantity: 5, Timestamp: 3},
+	}
+
+	tests := []struct {
+		mode     string
+		quantity float64
+		rejected bool
+		filled   float64
+	}{
+		{mode: "", quantity: 3, filled: 3},
+		{mode: CancelOldest, quantity: 3, filled: 3},
+		{mode: CancelOldest, quantity: 7, rejected: true},
+		{mode: CancelNewest, quantity: 3, rejected: true},
+		{mode: CancelBoth, quantity: 3, rejected: true},
+		{mode: DecrementAndCancel, quantity: 3, rejected: true},
+		{mode: CancelNewest, quantity: 1, filled: 1},
+	}
+
+	for _, test := range tests {
+		ob := NewOrderBook()
+		for _, ask := range asks {
+			resting := *ask
+			resting.Side, resting.Provider = "sell", "local"
+			ob.PlaceOrder(&resting)
+		}
+
+		account := "a"
+		if test.mode == "" {
+			account = "c"
+		}
+		fills, err := ob.ProcessOrder(&Order{
+			ID: "taker", Account: account, Side: "buy", Price: 102, Quantity: test.quantity, Timestamp: 10,
+			Provider: "local", TimeInForce: FillOrKill, SelfTradePrevention: test.mode,
+		})
+
+		if test.rejected {
+			if !errors.Is(err, ErrNotFilled) || len(fills) != 0 {
+				t.Fatalf("%q %v: fills %+v, err %v", test.mode, test.quantity, fills, err)
+			}
+			if len(ob.Asks) != 3 {
+				t.Fatalf("%q %v: rejected order changed the book", test.mode, test.quantity)
+			}
+			continue
+		}
+
+		filled := 0.0
+		for _, fill := range fills {
+			filled += fill.Quantity
+		}
+		if err != nil || filled != test.filled {
+			t.Fatalf("%q %v: filled %v, err %v", test.mode, test.quantity, filled, err)
+		}
+	}
+}
+
+func TestFillOrKillUnderProRataStopsAtLevelsWithOwnOrders(t *testing.T) {
+	ob := NewOrderBook()
+	ob.ProRata = &ProRataConfig{}
+	ob.PlaceOrder(&Order{ID: "b1", Account: "b", Side: "sell", Price: 100, Quantity: 5, Timestamp: 1, Provider: "local"})
+	ob.PlaceOrder(&Order{ID: "a1", Account: "a", Side: "sell", Price: 100, Quantity: 1, Timestamp: 2, Provider: "local"})
+
+	// Prevention runs over the whole level before it is allocated, the own
+	// order cancels the taker although b1 alone could fill it.
+	order := &Order{ID: "taker", Account: "a", Side: "buy", Price: 100, Quantity: 2, Timestamp: 10, Provider: "local", TimeInForce: FillOrKill, SelfTradePrevention: CancelNewest}
+	if _, err := ob.ProcessOrder(order); !errors.Is(err, ErrNotFilled) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestProcessOrderRejectsDuplicateIDs(t *testing.T) {
+	ob := NewOrderBook()
+	if _, err := ob.ProcessOrder(&Order{ID: "o1", Side: "buy", Price: 100, Quantity: 1, Provider: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.ProcessOrder(&Order{ID: "s1", Side: "sell", Type: StopOrder, StopPrice: 90, Quantity: 1, Provider: "local"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"o1", "s1"} {
+		fills, err := ob.ProcessOrder(&Order{ID: id, Side: "sell", Price: 100, Quantity: 1, Provider: "local"})
+		if !errors.Is(err, ErrDuplicateOrder) || len(fills) != 0 {
+			t.Fatalf("%s: fills %+v, err %v", id, fills, err)
+		}
+	}
+	if order := ob.Order("o1"); order == nil || order.Quantity != 1 {
+		t.Fatalf("o1 = %+v", order)
+	}
+
+	// Once filled, the ID is free again.
+	ob.ProcessOrder(&Order{ID: "t1", Side: "sell", Price: 100, Quantity: 1, Provider: "local"})
+	if _, err := ob.ProcessOrder(&Order{ID: "o1", Side: "buy", Price: 99, Quantity: 1, Provider: "local"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRejectionsCarryTypedReasons(t *testing.T) {
+	ob := NewOrderBook()
+	ob.PlaceOrder(&Order{ID: "ask", Side: "sell", Price: 100, Quantity: 1, Provider: "local"})
+	ob.ProcessOrder(&Order{ID: "bid", Side: "buy", Price: 99, Quantity: 1, Provider: "local"})
+
+	reasons := []string{}
+	ob.AddSink(EventSinkFunc(func(event Event) {
+		if event.Type == OrderRejected {
+			reasons = append(reasons, event.Order.Reason)
+		}
+	}))
+
+	orders := []*Order{
+		{ID: "o1", Side: "up", Price: 100, Quantity: 1, Provider: "local"},
+		{ID: "o2", Side: "buy", Price: 100, Quantity: 1, Provider: "local", PostOnly: true},
+		{ID: "o3", Side: "buy", Price: 100, Quantity: 2, Provider: "local", TimeInForce: FillOrKill},
+		{ID: "bid", Side: "buy", Price: 98, Quantity: 1, Provider: "local"},
+	}
+	for _, order := range orders {
+		ob.ProcessOrder(order)
+	}
+	ob.Reject(&Order{ID: "o4", Side: "buy", Quantity: 1}, RejectReason("max_quantity"))
+
+	expected := []string{"invalid_order", "would_cross", "not_filled", "duplicate_order", "max_quantity"}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Fatalf("reasons %q", reasons)
+	}
+}
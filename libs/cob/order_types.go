@@ -0,0 +1,120 @@
+package cob
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Order types.
+const (
+	LimitOrder  = "limit"
+	MarketOrder = "market"
+)
+
+// Time in force.
+const (
+	GoodTillCancel    = "gtc"
+	ImmediateOrCancel = "ioc"
+	FillOrKill        = "fok"
+)
+
+var (
+	ErrInvalidOrder   = errors.New("invalid order")
+	ErrWouldCross     = errors.New("post-only order would take liquidity")
+	ErrNotFilled      = errors.New("fill-or-kill order can not be filled completely")
+	ErrUnknownOrder   = errors.New("unknown order")
+	ErrDuplicateOrder = errors.New("duplicate order ID")
+)
+
+// RejectReason is the reason of an order_rejected event: one of the book's
+// below, or a risk.Reason for orders refused before the book.
+type RejectReason string
+
+// Reject reasons of the book, by the error ProcessOrder returns.
+const (
+	InvalidOrderReason   RejectReason = "invalid_order"
+	WouldCrossReason     RejectReason = "would_cross"
+	NotFilledReason      RejectReason = "not_filled"
+	DuplicateOrderReason RejectReason = "duplicate_order"
+)
+
+// rejectReason returns the reject reason of an error of the book.
+func rejectReason(err error) RejectReason {
+	switch {
+	case errors.Is(err, ErrWouldCross):
+		return WouldCrossReason
+	case errors.Is(err, ErrNotFilled):
+		return NotFilledReason
+	case errors.Is(err, ErrDuplicateOrder):
+		return DuplicateOrderReason
+	default:
+		return InvalidOrderReason
+	}
+}
+
+func validateOrder(order *Order) error {
+	if order.Side != "buy" && order.Side != "sell" {
+		return fmt.Errorf("%w: side %q", ErrInvalidOrder, order.Side)
+	}
+	if order.Quantity <= 0 {
+		return fmt.Errorf("%w: quantity must be positive", ErrInvalidOrder)
+	}
+
+	switch order.Type {
+	case "", LimitOrder:
+		if order.Price <= 0 {
+			return fmt.Errorf("%w: limit orders need a price", ErrInvalidOrder)
+		}
+	case MarketOrder:
+		if order.PostOnly {
+			return fmt.Errorf("%w: market orders can not be post-only", ErrInvalidOrder)
+		}
+	default:
+		return fmt.Errorf("%w: order type %q", ErrInvalidOrder, order.Type)
+	}
+
+	switch order.TimeInForce {
+	case "", GoodTillCancel, ImmediateOrCancel, Day:
+	case GoodTillDate:
+		if order.ExpireAt.IsZero() {
+			return fmt.Errorf("%w: good-till-date orders need an expiry", ErrInvalidOrder)
+		}
+	case FillOrKill:
+		if order.PostOnly {
+			return fmt.Errorf("%w: fill-or-kill orders can not be post-only", ErrInvalidOrder)
+		}
+	default:
+		return fmt.Errorf("%w: time in force %q", ErrInvalidOrder, order.TimeInForce)
+	}
+
+	if !validSelfTradePrevention(order.SelfTradePrevention) {
+		return fmt.Errorf("%w: self-trade prevention %q", ErrInvalidOrder, order.SelfTradePrevention)
+	}
+	if order.DisplayQuantity < 0 {
+		return fmt.Errorf("%w: display quantity can not be negative", ErrInvalidOrder)
+	}
+	if order.IsIceberg() && !order.Rests() {
+		return fmt.Errorf("%w: only resting orders can be icebergs", ErrInvalidOrder)
+	}
+
+	return nil
+}
+
+// crosses reports whether the order may trade at price: limit orders up to
+// their price, market orders up to their protection price, if any.
+func (o *Order) crosses(price float64) bool {
+	if o.Type == MarketOrder {
+		return o.ProtectionPrice == 0 || crosses(o.Side, o.ProtectionPrice, price)
+	}
+	return crosses(o.Side, o.Price, price)
+}
+
+// Rests reports whether the unmatched quantity of the order stays in the book.
+func (o *Order) Rests() bool {
+	switch o.TimeInForce {
+	case "", GoodTillCancel, GoodTillDate, Day:
+		return o.Type != MarketOrder
+	default:
+		return false
+	}
+}
@@ -0,0 +1,80 @@
+package cob
+
+import (
+	"errors"
+	"testing"
+)
+
+// orderTypesBook quotes 99 x 1 / 100 x 1, with 2 more offered at 101.
+func orderTypesBook() *OrderBook {
+	ob := NewOrderBook()
+	ob.PlaceOrder(&Order{ID: "ask", Side: "sell", Price: 100, Quantity: 1, Provider: "local"})
+	ob.UpdateExternalLevel("kraken", "sell", 101, 2, 1)
+	ob.PlaceOrder(&Order{ID: "bid", Side: "buy", Price: 99, Quantity: 1, Provider: "local"})
+	return ob
+}
+
+func TestOrderTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		order  Order
+		err    error
+		filled float64
+		rests  bool
+	}{
+		{name: "post-only crossing the ask", order: Order{Side: "buy", Price: 100, Quantity: 1, PostOnly: true}, err: ErrWouldCross},
+		{name: "post-only crossing the bid", order: Order{Side: "sell", Price: 98, Quantity: 1, PostOnly: true}, err: ErrWouldCross},
+		{name: "post-only inside the spread", order: Order{Side: "buy", Price: 99.5, Quantity: 1, PostOnly: true}, rests: true},
+		{name: "post-only market", order: Order{Side: "buy", Type: MarketOrder, Quantity: 1, PostOnly: true}, err: ErrInvalidOrder},
+		{name: "post-only fill-or-kill", order: Order{Side: "buy", Price: 98, Quantity: 1, PostOnly: true, TimeInForce: FillOrKill}, err: ErrInvalidOrder},
+
+		{name: "fill-or-kill short of its price", order: Order{Side: "buy", Price: 100, Quantity: 2, TimeInForce: FillOrKill}, err: ErrNotFilled},
+		{name: "fill-or-kill across levels", order: Order{Side: "buy", Price: 101, Quantity: 3, TimeInForce: FillOrKill}, filled: 3},
+		{name: "fill-or-kill market beyond the depth", order: Order{Side: "buy", Type: MarketOrder, Quantity: 4, TimeInForce: FillOrKill}, err: ErrNotFilled},
+		{name: "fill-or-kill market short of its protection", order: Order{Side: "buy", Type: MarketOrder, ProtectionPrice: 100, Quantity: 2, TimeInForce: FillOrKill}, err: ErrNotFilled},
+
+		{name: "market stops at its protection price", order: Order{Side: "buy", Type: MarketOrder, ProtectionPrice: 100, Quantity: 3}, filled: 1},
+		{name: "market protected below the best price", order: Order{Side: "sell", Type: MarketOrder, ProtectionPrice: 99.5, Quantity: 1}},
+		{name: "market without protection sweeps the book", order: Order{Side: "buy", Type: MarketOrder, Quantity: 5}, filled: 3},
+		{name: "immediate-or-cancel", order: Order{Side: "buy", Price: 100, Quantity: 2, TimeInForce: ImmediateOrCancel}, filled: 1},
+		{name: "limit rests its remainder", order: Order{Side: "buy", Price: 100, Quantity: 2}, filled: 1, rests: true},
+
+		{name: "unknown side", order: Order{Side: "up", Price: 100, Quantity: 1}, err: ErrInvalidOrder},
+		{name: "no quantity", order: Order{Side: "buy", Price: 100}, err: ErrInvalidOrder},
+		{name: "limit without a price", order: Order{Side: "buy", Quantity: 1}, err: ErrInvalidOrder},
+		{name: "unknown type", order: Order{Side: "buy", Type: "twap", Price: 100, Quantity: 1}, err: ErrInvalidOrder},
+		{name: "unknown time in force", order: Order{Side: "buy", Price: 100, Quantity: 1, TimeInForce: "gtx"}, err: ErrInvalidOrder},
+		{name: "good-till-date without an expiry", order: Order{Side: "buy", Price: 100, Quantity: 1, TimeInForce: GoodTillDate}, err: ErrInvalidOrder},
+		{name: "unknown self-trade prevention", order: Order{Side: "buy", Price: 100, Quantity: 1, SelfTradePrevention: "skip"}, err: ErrInvalidOrder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := orderTypesBook()
+			order := tt.order
+			order.ID = "o"
+			order.Provider = "local"
+
+			fills, err := ob.ProcessOrder(&order)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("error %v, expected %v", err, tt.err)
+			}
+
+			filled := 0.0
+			for _, fill := range fills {
+				filled += fill.Quantity
+			}
+			if filled != tt.filled {
+				t.Fatalf("filled %v, expected %v", filled, tt.filled)
+			}
+			if rests := ob.Order("o") != nil; rests != tt.rests {
+				t.Fatalf("rests %v", rests)
+			}
+
+			// Rejected orders leave the book as it was.
+			if tt.err != nil && (ob.Asks[100].TotalQuantity != 1 || ob.Asks[101].TotalQuantity != 2 || ob.Bids[99].TotalQuantity != 1) {
+				t.Fatal("book changed by a rejected order")
+			}
+		})
+	}
+}
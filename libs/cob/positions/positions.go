@@ -0,0 +1,228 @@
+package positions
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"cob"
+
+	"github.com/nats-io/nats.go"
+)
+
+// CustomersVenue is the venue of the position taken against customers.
+const CustomersVenue = "customers"
+
+func Subject(symbol string) string {
+	return fmt.Sprintf("cob.positions.%s", symbol)
+}
+
+// VenuePosition is the net quantity the platform holds on one venue.
+type VenuePosition struct {
+	Venue    string  `json:"venue"`
+	Quantity float64 `json:"quantity"` // Positive when long
+}
+
+// Exposure is the position of the platform in one instrument. Customer fills
+// against external liquidity open it and hedge fills close it, both at
+// average cost, so RealizedPnL is what hedging earned or lost against the
+// customer prices.
+type Exposure struct {
+	Symbol        string          `json:"symbol"`
+	Net           float64         `json:"net"` // Unhedged quantity, positive when long
+	AvgPrice      float64         `json:"avg_price"`
+	Mark          float64         `json:"mark"` // Consolidated mid
+	RealizedPnL   float64         `json:"realized_pnl"`
+	UnrealizedPnL float64         `json:"unrealized_pnl"`
+	Fees          float64         `json:"fees"` // Hedge fees, already deducted from RealizedPnL
+	Venues        []VenuePosition `json:"venues"`
+	Timestamp     time.Time       `json:"timestamp"`
+}
+
+type position struct {
+	net      float64
+	avgPrice float64
+	realized float64
+	fees     float64
+	mark     float64
+	venues   map[string]float64
+}
+
+// Keeper tracks the net exposure of the platform per instrument and venue
+// and publishes every change on cob.positions.<symbol>.
+type Keeper struct {
+	mutex      sync.Mutex
+	positions  map[string]*position
+	natsClient *nats.Conn
+}
+
+// New creates a keeper, updates are only published with a natsClient.
+func New(natsClient *nats.Conn) *Keeper {
+	return &Keeper{
+		positions:  make(map[string]*position),
+		natsClient: natsClient,
+	}
+}
+
+func (k *Keeper) position(symbol string) *position {
+	p, ok := k.positions[symbol]
+	if !ok {
+		p = &position{venues: make(map[string]float64)}
+		k.positions[symbol] = p
+	}
+	return p
+}
+
+// trade applies a platform trade at average cost.
+func (p *position) trade(venue string, side string, quantity float64, price float64) {
+	signed := quantity
+	if side == "sell" {
+		signed = -quantity
+	}
+	p.venues[venue] += signed
+
+	if p.net == 0 || (p.net > 0) == (signed > 0) {
+		p.avgPrice = (p.avgPrice*math.Abs(p.net) + price*quantity) / (math.Abs(p.net) + quantity)
+		p.net += signed
+		return
+	}
+
+	closed := math.Min(quantity, math.Abs(p.net))
+	if p.net > 0 {
+		p.realized += closed * (price - p.avgPrice)
+	} else {
+		p.realized += closed * (p.avgPrice - price)
+	}
+
+	p.net += signed
+	switch {
+	case math.Abs(p.net) < 1e-12:
+		p.net = 0
+		p.avgPrice = 0
+	case quantity > closed:
+		// The trade flipped the position, the rest is opened at price.
+		p.avgPrice = price
+	}
+}
+
+// OnCustomerFill books a fill of the matching engine. The platform takes the
+// other side of fills against external liquidity; fills between two customer
+// orders leave it flat.
+func (k *Keeper) OnCustomerFill(fill cob.Fill) {
+	if fill.Provider == "local" {
+		return
+	}
+
+	k.mutex.Lock()
+	side := "sell"
+	if fill.Side == "sell" {
+		side = "buy"
+	}
+	k.position(fill.Symbol).trade(CustomersVenue, side, fill.Quantity, fill.Price)
+	exposure := k.exposure(fill.Symbol)
+	k.mutex.Unlock()
+
+	k.publish(exposure)
+}
+
+// OnHedgeFill books a fill of one of our orders on an external venue.
+func (k *Keeper) OnHedgeFill(symbol string, venue string, side string, quantity float64, price float64, fee float64) {
+	k.mutex.Lock()
+	p := k.position(symbol)
+	p.trade(venue, side, quantity, price)
+	p.realized -= fee
+	p.fees += fee
+	exposure := k.exposure(symbol)
+	k.mutex.Unlock()
+
+	k.publish(exposure)
+}
+
+// Mark sets the price open positions are valued at. Updates are published
+// only while there is an open position.
+func (k *Keeper) Mark(symbol string, price float64) {
+	k.mutex.Lock()
+	p := k.position(symbol)
+	changed := p.mark != price
+	p.mark = price
+	exposure := k.exposure(symbol)
+	k.mutex.Unlock()
+
+	if changed && exposure.Net != 0 {
+		k.publish(exposure)
+	}
+}
+
+// MarkFromBook marks the instrument of book at its consolidated mid.
+func (k *Keeper) MarkFromBook(book *cob.OrderBook) {
+	if mid, ok := book.Mid(); ok {
+		k.Mark(book.Symbol, mid)
+	}
+}
+
+// Exposure returns the current position of an instrument.
+func (k *Keeper) Exposure(symbol string) Exposure {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.exposure(symbol)
+}
+
+// Exposures returns the positions of every instrument sorted by symbol.
+func (k *Keeper) Exposures() []Exposure {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	exposures := make([]Exposure, 0, len(k.positions))
+	for symbol := range k.positions {
+		exposures = append(exposures, k.exposure(symbol))
+	}
+	sort.Slice(exposures, func(i, j int) bool { return exposures[i].Symbol < exposures[j].Symbol })
+
+	return exposures
+}
+
+func (k *Keeper) exposure(symbol string) Exposure {
+	p := k.position(symbol)
+
+	exposure := Exposure{
+		Symbol:      symbol,
+		Net:         p.net,
+		AvgPrice:    p.avgPrice,
+		Mark:        p.mark,
+		RealizedPnL: p.realized,
+		Fees:        p.fees,
+		Venues:      []VenuePosition{},
+		Timestamp:   time.Now().UTC(),
+	}
+	if p.mark > 0 {
+		exposure.UnrealizedPnL = (p.mark - p.avgPrice) * p.net
+	}
+
+	for venue, quantity := range p.venues {
+		exposure.Venues = append(exposure.Venues, VenuePosition{Venue: venue, Quantity: quantity})
+	}
+	sort.Slice(exposure.Venues, func(i, j int) bool { return exposure.Venues[i].Venue < exposure.Venues[j].Venue })
+
+	return exposure
+}
+
+func (k *Keeper) publish(exposure Exposure) {
+	if k.natsClient == nil {
+		return
+	}
+
+	encoded, err := json.Marshal(exposure)
+	if err != nil {
+		log.Printf("failed to marshal %+v: %+v\n", exposure, err)
+		return
+	}
+
+	if err := k.natsClient.Publish(Subject(exposure.Symbol), encoded); err != nil {
+		log.Printf("unable to publish to %s: %+v\n", Subject(exposure.Symbol), err)
+	}
+}
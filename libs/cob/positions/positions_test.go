@@ -0,0 +1,143 @@
+package positions
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"cob"
+)
+
+type trade struct {
+	side     string
+	quantity float64
+	price    float64
+	fee      float64
+}
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestAverageCost(t *testing.T) {
+	tests := []struct {
+		name     string
+		trades   []trade
+		net      float64
+		avgPrice float64
+		realized float64
+	}{
+		{
+			name:     "adds average the price",
+			trades:   []trade{{"buy", 1, 100, 0}, {"buy", 3, 104, 0}},
+			net:      4,
+			avgPrice: 103,
+		},
+		{
+			name:     "partial close realizes at the average price",
+			trades:   []trade{{"buy", 1, 100, 0}, {"buy", 1, 102, 0}, {"sell", 1, 111, 0}},
+			net:      1,
+			avgPrice: 101,
+			realized: 10,
+		},
+		{
+			name:     "close resets the average price",
+			trades:   []trade{{"buy", 2, 100, 0}, {"sell", 2, 90, 0}},
+			realized: -20,
+		},
+		{
+			name:     "short covered with a profit",
+			trades:   []trade{{"sell", 2, 100, 0}, {"buy", 1, 90, 0}},
+			net:      -1,
+			avgPrice: 100,
+			realized: 10,
+		},
+		{
+			name:     "flip realizes the closed part and opens the rest at the trade price",
+			trades:   []trade{{"buy", 1, 100, 0}, {"sell", 3, 105, 0}},
+			net:      -2,
+			avgPrice: 105,
+			realized: 5,
+		},
+		{
+			name:     "flip back from short",
+			trades:   []trade{{"sell", 2, 100, 0}, {"buy", 3, 98, 0}},
+			net:      1,
+			avgPrice: 98,
+			realized: 4,
+		},
+		{
+			name:     "fees are deducted from the realized PnL",
+			trades:   []trade{{"buy", 1, 100, 0.1}, {"sell", 1, 101, 0.2}},
+			realized: 0.7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keeper := New(nil)
+			fees := 0.0
+			for _, trade := range tt.trades {
+				keeper.OnHedgeFill("BTC/USD", "kraken", trade.side, trade.quantity, trade.price, trade.fee)
+				fees += trade.fee
+			}
+
+			exposure := keeper.Exposure("BTC/USD")
+			if !near(exposure.Net, tt.net) || !near(exposure.AvgPrice, tt.avgPrice) || !near(exposure.RealizedPnL, tt.realized) || !near(exposure.Fees, fees) {
+				t.Fatalf("exposure %+v", exposure)
+			}
+			if !near(exposure.Venues[0].Quantity, tt.net) {
+				t.Fatalf("venues %+v", exposure.Venues)
+			}
+		})
+	}
+}
+
+func TestCustomerFillsOpenAndHedgesClose(t *testing.T) {
+	keeper := New(nil)
+
+	// A customer buys 2 from external liquidity, the platform is short.
+	keeper.OnCustomerFill(cob.Fill{Symbol: "BTC/USD", Side: "buy", Price: 100, Quantity: 2, Provider: "kraken"})
+	// Fills between customers leave the platform flat.
+	keeper.OnCustomerFill(cob.Fill{Symbol: "BTC/USD", Side: "sell", Price: 100, Quantity: 5, Provider: "local"})
+
+	if exposure := keeper.Exposure("BTC/USD"); exposure.Net != -2 || exposure.AvgPrice != 100 {
+		t.Fatalf("after the customer fill %+v", exposure)
+	}
+
+	keeper.OnHedgeFill("BTC/USD", "kraken", "buy", 2, 99, 0.05)
+	exposure := keeper.Exposure("BTC/USD")
+	if exposure.Net != 0 || !near(exposure.RealizedPnL, 1.95) {
+		t.Fatalf("after the hedge %+v", exposure)
+	}
+	expected := []VenuePosition{{Venue: CustomersVenue, Quantity: -2}, {Venue: "kraken", Quantity: 2}}
+	if !reflect.DeepEqual(exposure.Venues, expected) {
+		t.Fatalf("venues %+v", exposure.Venues)
+	}
+}
+
+func TestSymbolsAreKeptApart(t *testing.T) {
+	keeper := New(nil)
+
+	keeper.OnHedgeFill("ETH/USD", "kraken", "buy", 10, 2000, 0)
+	keeper.OnHedgeFill("BTC/USD", "bybit", "sell", 1, 60000, 0)
+	keeper.OnHedgeFill("BTC/USD", "kraken", "buy", 0.5, 59000, 0)
+	keeper.Mark("ETH/USD", 2100)
+	keeper.Mark("BTC/USD", 58000)
+
+	exposures := keeper.Exposures()
+	if len(exposures) != 2 || exposures[0].Symbol != "BTC/USD" || exposures[1].Symbol != "ETH/USD" {
+		t.Fatalf("exposures %+v", exposures)
+	}
+
+	btc, eth := exposures[0], exposures[1]
+	if btc.Net != -0.5 || btc.AvgPrice != 60000 || btc.RealizedPnL != 500 || btc.UnrealizedPnL != 1000 {
+		t.Fatalf("BTC/USD %+v", btc)
+	}
+	if eth.Net != 10 || eth.AvgPrice != 2000 || eth.RealizedPnL != 0 || eth.UnrealizedPnL != 1000 {
+		t.Fatalf("ETH/USD %+v", eth)
+	}
+	if len(eth.Venues) != 1 || len(btc.Venues) != 2 {
+		t.Fatalf("venues BTC/USD %+v, ETH/USD %+v", btc.Venues, eth.Venues)
+	}
+}
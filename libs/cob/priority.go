@@ -0,0 +1,198 @@
+package cob
+
+import (
+	"container/heap"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PriorityPolicy orders the resting orders of a price level: Less reports
+// whether a is matched before b. Policies have to be a strict total order on
+// distinct orders, so they break remaining ties by order ID and the outcome
+// never depends on arrival in the heap.
+type PriorityPolicy interface {
+	Name() string
+	Less(a *Order, b *Order) bool
+}
+
+// Priority policies.
+const (
+	SizePriorityName    = "size"
+	PriceTimeName       = "price_time"
+	LocalFirstName      = "local_first"
+	ProRataName         = "pro_rata"
+	VenuePreferenceName = "venue_preference"
+)
+
+// earlier is the FIFO tiebreak shared by the policies.
+func earlier(a *Order, b *Order) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return a.ID < b.ID
+}
+
+// SizePriority is the original ordering of the book and the default: local
+// orders first, then larger quantity, hidden reserve included, then larger
+// available balance, then FIFO. Priority changes with every partial fill.
+type SizePriority struct{}
+
+func (SizePriority) Name() string { return SizePriorityName }
+
+func (SizePriority) Less(a *Order, b *Order) bool {
+	if (a.Provider == "local") != (b.Provider == "local") {
+		return a.Provider == "local"
+	}
+	if a.Quantity != b.Quantity {
+		return a.Quantity > b.Quantity
+	}
+	if a.AvailableBal != b.AvailableBal {
+		return a.AvailableBal > b.AvailableBal
+	}
+	return earlier(a, b)
+}
+
+// PriceTime is strict FIFO within the level.
+type PriceTime struct{}
+
+func (PriceTime) Name() string { return PriceTimeName }
+
+func (PriceTime) Less(a *Order, b *Order) bool {
+	return earlier(a, b)
+}
+
+// LocalFirst matches customer orders before external liquidity, each FIFO.
+type LocalFirst struct{}
+
+func (LocalFirst) Name() string { return LocalFirstName }
+
+func (LocalFirst) Less(a *Order, b *Order) bool {
+	if (a.Provider == "local") != (b.Provider == "local") {
+		return a.Provider == "local"
+	}
+	return earlier(a, b)
+}
+
+// ProRata orders a level for pro-rata allocation: larger visible quantity
+// first, then FIFO. Consuming the queue in this order fills the largest
+// orders first; the pro-rata matching mode uses it to hand out remainders.
+type ProRata struct{}
+
+func (ProRata) Name() string { return ProRataName }
+
+func (ProRata) Less(a *Order, b *Order) bool {
+	if a.Visible() != b.Visible() {
+		return a.Visible() > b.Visible()
+	}
+	return earlier(a, b)
+}
+
+// VenuePreference matches providers with a higher weight first, then FIFO.
+// Providers missing from Weights weigh zero, "local" included.
+type VenuePreference struct {
+	Weights map[string]float64
+}
+
+func (VenuePreference) Name() string { return VenuePreferenceName }
+
+func (vp VenuePreference) Less(a *Order, b *Order) bool {
+	if wa, wb := vp.Weights[a.Provider], vp.Weights[b.Provider]; wa != wb {
+		return wa > wb
+	}
+	return earlier(a, b)
+}
+
+// DefaultPolicy is used by books without a Policy.
+var DefaultPolicy PriorityPolicy = SizePriority{}
+
+// PolicyByName returns the policy configured for an instrument by name.
+// Venue preference takes its weights after a colon, e.g.
+// "venue_preference:kraken=2,binance=1".
+func PolicyByName(name string) (PriorityPolicy, error) {
+	name, weights, _ := strings.Cut(name, ":")
+
+	switch name {
+	case "", SizePriorityName:
+		return SizePriority{}, nil
+	case PriceTimeName:
+		return PriceTime{}, nil
+	case LocalFirstName:
+		return LocalFirst{}, nil
+	case ProRataName:
+		return ProRata{}, nil
+	case VenuePreferenceName:
+		return parseVenuePreference(weights)
+	default:
+		return nil, fmt.Errorf("unknown priority policy %q", name)
+	}
+}
+
+// parseVenuePreference reads weights given as "provider=weight,...".
+func parseVenuePreference(weights string) (PriorityPolicy, error) {
+	vp := VenuePreference{Weights: make(map[string]float64)}
+	if weights == "" {
+		return vp, nil
+	}
+
+	for _, pair := range strings.Split(weights, ",") {
+		provider, weight, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || provider == "" {
+			return nil, fmt.Errorf("invalid venue weight %q", pair)
+		}
+		value, err := strconv.ParseFloat(weight, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid venue weight %q: %w", pair, err)
+		}
+		vp.Weights[provider] = value
+	}
+
+	return vp, nil
+}
+
+// policyQueue runs the heap of a level under its policy.
+type policyQueue struct {
+	orders *OrderQueue
+	policy PriorityPolicy
+}
+
+func (pq policyQueue) Len() int           { return pq.orders.Len() }
+func (pq policyQueue) Swap(i, j int)      { pq.orders.Swap(i, j) }
+func (pq policyQueue) Less(i, j int) bool { return pq.policy.Less((*pq.orders)[i], (*pq.orders)[j]) }
+func (pq policyQueue) Push(x interface{}) { pq.orders.Push(x) }
+func (pq policyQueue) Pop() interface{}   { return pq.orders.Pop() }
+
+func (pl *PriceLevel) policy() PriorityPolicy {
+	if pl.Policy == nil {
+		return DefaultPolicy
+	}
+	return pl.Policy
+}
+
+func (pl *PriceLevel) queue() heap.Interface {
+	return policyQueue{orders: pl.Orders, policy: pl.policy()}
+}
+
+// SortedOrders returns the orders of the level in match priority.
+func (pl *PriceLevel) SortedOrders() []*Order {
+	orders := append(OrderQueue{}, *pl.Orders...)
+	q := policyQueue{orders: &orders, policy: pl.policy()}
+
+	sorted := make([]*Order, 0, len(orders))
+	for q.Len() > 0 {
+		sorted = append(sorted, heap.Pop(q).(*Order))
+	}
+	return sorted
+}
+
+// SetPolicy changes the priority policy of the book, the instrument it
+// trades, and reorders every resting level under it.
+func (ob *OrderBook) SetPolicy(policy PriorityPolicy) {
+	ob.Policy = policy
+	for _, priceLevels := range []map[float64]*PriceLevel{ob.Bids, ob.Asks} {
+		for _, pl := range priceLevels {
+			pl.Policy = policy
+			heap.Init(pl.queue())
+		}
+	}
+}
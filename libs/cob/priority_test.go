@@ -0,0 +1,179 @@
+package cob
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var testPolicies = []PriorityPolicy{
+	SizePriority{},
+	PriceTime{},
+	LocalFirst{},
+	ProRata{},
+	VenuePreference{Weights: map[string]float64{"kraken": 2, "binance": 1}},
+}
+
+// randomOrders returns buy orders at one price with few distinct values, so
+// policies have ties to break.
+func randomOrders(r *rand.Rand, n int) []Order {
+	providers := []string{"local", "kraken", "binance"}
+	orders := make([]Order, n)
+	for i := range orders {
+		orders[i] = Order{
+			ID:           fmt.Sprintf("o%02d", i),
+			Side:         "buy",
+			Price:        100,
+			Quantity:     float64(1 + r.Intn(4)),
+			Timestamp:    int64(r.Intn(5)),
+			Provider:     providers[r.Intn(len(providers))],
+			AvailableBal: float64(r.Intn(2)),
+		}
+		if r.Intn(3) == 0 {
+			orders[i].DisplayQuantity = 1
+		}
+	}
+	return orders
+}
+
+// levelOf places copies of orders in a new level of policy, in the order of
+// permutation.
+func levelOf(orders []Order, permutation []int, policy PriorityPolicy) *PriceLevel {
+	pl := &PriceLevel{Price: 100, Orders: &OrderQueue{}, Policy: policy}
+	for _, i := range permutation {
+		order := orders[i]
+		pl.PlaceOrder(&order)
+	}
+	return pl
+}
+
+func ids(orders []*Order) []string {
+	result := make([]string, len(orders))
+	for i, order := range orders {
+		result[i] = order.ID
+	}
+	return result
+}
+
+func TestPoliciesOrderLevelsIndependentlyOfArrival(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for round := 0; round < 200; round++ {
+		orders := randomOrders(r, 2+r.Intn(20))
+
+		for _, policy := range testPolicies {
+			expected := levelOf(orders, r.Perm(len(orders)), policy).SortedOrders()
+			byLess := append([]*Order{}, expected...)
+			sort.SliceStable(byLess, func(i, j int) bool { return policy.Less(byLess[i], byLess[j]) })
+			if !reflect.DeepEqual(ids(byLess), ids(expected)) {
+				t.Fatalf("%s: level order %v, Less order %v", policy.Name(), ids(expected), ids(byLess))
+			}
+
+			sorted := levelOf(orders, r.Perm(len(orders)), policy).SortedOrders()
+			if !reflect.DeepEqual(ids(sorted), ids(expected)) {
+				t.Fatalf("%s: %v, then %v in another arrival order", policy.Name(), ids(expected), ids(sorted))
+			}
+		}
+	}
+}
+
+func TestRemovalKeepsTheLevelPolicy(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	for round := 0; round < 200; round++ {
+		orders := randomOrders(r, 3+r.Intn(20))
+
+		for _, policy := range testPolicies {
+			pl := levelOf(orders, r.Perm(len(orders)), policy)
+			removed := map[string]bool{}
+			for _, i := range r.Perm(len(orders))[:len(orders)/2] {
+				id := orders[i].ID
+				removed[id] = true
+				if i%2 == 0 {
+					pl.RemoveOrder(id)
+				} else if pl.Orders.RemoveByID(id, pl.Policy) == nil {
+					t.Fatalf("%s: %s not removed", policy.Name(), id)
+				}
+			}
+
+			remaining := []int{}
+			for i, order := range orders {
+				if !removed[order.ID] {
+					remaining = append(remaining, i)
+				}
+			}
+			expected := levelOf(orders, remaining, policy).SortedOrders()
+
+			// Popping the heap in place shows whether it is still ordered by
+			// the level's policy.
+			popped := []*Order{}
+			for pl.Orders.Len() > 0 {
+				popped = append(popped, heap.Pop(pl.queue()).(*Order))
+			}
+			if !reflect.DeepEqual(ids(popped), ids(expected)) {
+				t.Fatalf("%s: popped %v, expected %v", policy.Name(), ids(popped), ids(expected))
+			}
+		}
+	}
+}
+
+func TestMatchingIsDeterministicUnderEveryPolicy(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+
+	for round := 0; round < 100; round++ {
+		orders := randomOrders(r, 2+r.Intn(15))
+		quantity := float64(1 + r.Intn(30))
+
+		for _, policy := range testPolicies {
+			var first []Fill
+			for run := 0; run < 2; run++ {
+				ob := NewOrderBook()
+				ob.SetPolicy(policy)
+				for _, i := range r.Perm(len(orders)) {
+					order := orders[i]
+					ob.PlaceOrder(&order)
+				}
+
+				fills, err := ob.ProcessOrder(&Order{ID: "taker", Side: "sell", Type: MarketOrder, Quantity: quantity, Timestamp: 10, Provider: "local"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if run == 0 {
+					first = fills
+				} else if !reflect.DeepEqual(fills, first) {
+					t.Fatalf("%s: fills %+v, then %+v", policy.Name(), first, fills)
+				}
+			}
+		}
+	}
+}
+
+func TestSizePriorityRanksByTotalQuantity(t *testing.T) {
+	iceberg := &Order{ID: "iceberg", Quantity: 10, DisplayQuantity: 1, Provider: "local"}
+	plain := &Order{ID: "plain", Quantity: 5, Provider: "local"}
+	iceberg.hide()
+
+	if !(SizePriority{}).Less(iceberg, plain) {
+		t.Fatal("iceberg of 10 ranked after a plain order of 5")
+	}
+}
+
+func TestPolicyByNameBuildsVenuePreference(t *testing.T) {
+	policy, err := PolicyByName("venue_preference:kraken=2, binance=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := VenuePreference{Weights: map[string]float64{"kraken": 2, "binance": 0.5}}
+	if !reflect.DeepEqual(policy, expected) {
+		t.Fatalf("policy = %+v", policy)
+	}
+
+	for _, name := range []string{"venue_preference:kraken", "venue_preference:kraken=high", "fastest"} {
+		if _, err := PolicyByName(name); err == nil {
+			t.Fatalf("%q accepted", name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package cob
+
+import (
+	"container/heap"
+	"math"
+)
+
+// ProRataConfig sets how a level is allocated in pro-rata matching.
+type ProRataConfig struct {
+	MinAllocation float64 // Proportional shares below it are dropped and go to the remainder
+	LotSize       float64 // Shares are rounded down to it, the remainder goes to the queue in priority order
+	TopOrderShare float64 // Fraction of the incoming quantity given to the head of the queue first, e.g. 0.4
+}
+
+// MatchProRata matches up to quantity of an incoming order against the level
+// by allocating it across the resting orders in proportion to their visible
+// quantity, instead of to the head of the queue as Match does:
+//
+//  1. The head of the queue, by the level's policy, gets TopOrderShare of the
+//     quantity, if set.
+//  2. The rest is split in proportion to the visible quantities, each share
+//     rounded down to LotSize and dropped below MinAllocation.
+//  3. What rounding and minimums leave over is filled in priority order, so
+//     the distribution is deterministic.
+//
+// Iceberg orders refill once their visible part is taken and join the next
+// allocation round. It returns one fill per resting order and round, in
+// priority order.
+func (pl *PriceLevel) MatchProRata(order *Order, quantity float64, config ProRataConfig) []Fill {
+	return pl.matchProRata(order, quantity, config, nil)
+}
+
+// matchProRata is MatchProRata with self-trade prevention applied to the
+// resting orders of the same account before each allocation round.
+func (pl *PriceLevel) matchProRata(order *Order, quantity float64, config ProRataConfig, prevent preventFunc) []Fill {
+	fills := []Fill{}
+	remaining := quantity
+
+	for pl.Orders.Len() > 0 && remaining > 1e-12 {
+		remaining = pl.preventSelfTrades(prevent, remaining)
+		if pl.Orders.Len() == 0 || remaining <= 1e-12 {
+			break
+		}
+
+		orders := pl.SortedOrders()
+		allocations := allocateProRata(orders, remaining, config)
+
+		for i, resting := range orders {
+			matched := allocations[i]
+			if matched <= 0 {
+				continue
+			}
+
+			remaining -= matched
+			resting.Quantity -= matched
+			pl.TotalQuantity -= matched
+
+			fills = append(fills, Fill{
+				TakerOrderID: order.ID,
+				MakerOrderID: resting.ID,
+				Side:         order.Side,
+				Price:        pl.Price,
+				Quantity:     matched,
+				Provider:     resting.Provider,
+				Timestamp:    order.Timestamp,
+			})
+		}
+
+		left := (*pl.Orders)[:0]
+		for _, resting := range orders {
+			if resting.Visible() <= 0 && resting.Hidden > 0 {
+				pl.refill(resting, order)
+			}
+			if resting.Quantity > 0 {
+				left = append(left, resting)
+			}
+		}
+		*pl.Orders = left
+		heap.Init(pl.queue())
+	}
+
+	return fills
+}
+
+// allocateProRata splits quantity across orders, sorted in priority, and
+// returns the share of each. Shares never exceed the visible quantity and add
+// up to quantity, or to all the visible quantity when it is less.
+func allocateProRata(orders []*Order, quantity float64, config ProRataConfig) []float64 {
+	allocations := make([]float64, len(orders))
+	capacity := func(i int) float64 { return orders[i].Visible() - allocations[i] }
+
+	visible := 0.0
+	for _, order := range orders {
+		visible += order.Visible()
+	}
+	quantity = math.Min(quantity, visible)
+	remaining := quantity
+
+	if config.TopOrderShare > 0 && len(orders) > 0 {
+		top := roundToLot(quantity*config.TopOrderShare, config.LotSize)
+		allocations[0] = math.Min(top, capacity(0))
+		remaining -= allocations[0]
+	}
+
+	base := remaining
+	total := 0.0
+	for i := range orders {
+		total += capacity(i)
+	}
+	if total > 0 {
+		for i := range orders {
+			share := roundToLot(base*capacity(i)/total, config.LotSize)
+			share = math.Min(share, capacity(i))
+			if share <= 0 || share < config.MinAllocation {
+				continue
+			}
+			allocations[i] += share
+			remaining -= share
+		}
+	}
+
+	// Remainder of rounding and minimums, in priority order.
+	for i := range orders {
+		if remaining <= 1e-12 {
+			break
+		}
+		share := math.Min(remaining, capacity(i))
+		allocations[i] += share
+		remaining -= share
+	}
+
+	return allocations
+}
+
+// roundToLot rounds quantity down to a multiple of lot, the epsilon keeps
+// exact multiples from losing a lot to float rounding.
+func roundToLot(quantity float64, lot float64) float64 {
+	if lot <= 0 {
+		return quantity
+	}
+	return math.Floor(quantity/lot+1e-9) * lot
+}
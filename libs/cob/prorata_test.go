@@ -0,0 +1,133 @@
+package cob
+
+import (
+	"math"
+	"testing"
+)
+
+// visibleOrders returns resting orders showing quantities, in that priority.
+func visibleOrders(quantities ...float64) []*Order {
+	orders := make([]*Order, len(quantities))
+	for i, quantity := range quantities {
+		orders[i] = &Order{ID: string(rune('a' + i)), Side: "buy", Price: 100, Quantity: quantity}
+	}
+	return orders
+}
+
+func TestAllocateProRata(t *testing.T) {
+	iceberg := &Order{ID: "i", Side: "buy", Price: 100, Quantity: 10, DisplayQuantity: 2}
+	iceberg.hide()
+
+	tests := []struct {
+		name     string
+		orders   []*Order
+		quantity float64
+		config   ProRataConfig
+		expected []float64
+	}{
+		{
+			name:     "in proportion to the visible quantity",
+			orders:   visibleOrders(6, 3, 1),
+			quantity: 5,
+			expected: []float64{3, 1.5, 0.5},
+		},
+		{
+			name:     "shares rounded down to the lot, remainder in priority order",
+			orders:   visibleOrders(6, 3, 1),
+			quantity: 5,
+			config:   ProRataConfig{LotSize: 1},
+			expected: []float64{4, 1, 0},
+		},
+		{
+			name:     "remainder skips orders already full",
+			orders:   visibleOrders(3, 3, 3),
+			quantity: 8,
+			config:   ProRataConfig{LotSize: 2},
+			expected: []float64{3, 3, 2},
+		},
+		{
+			name:     "shares below the minimum go to the remainder",
+			orders:   visibleOrders(6, 3, 1),
+			quantity: 5,
+			config:   ProRataConfig{MinAllocation: 1},
+			expected: []float64{3.5, 1.5, 0},
+		},
+		{
+			name:     "top order share first, the rest in proportion",
+			orders:   visibleOrders(6, 3, 1),
+			quantity: 5,
+			config:   ProRataConfig{TopOrderShare: 0.4},
+			expected: []float64{3.5, 1.125, 0.375},
+		},
+		{
+			name:     "top order share rounded to the lot",
+			orders:   visibleOrders(6, 3, 1),
+			quantity: 5,
+			config:   ProRataConfig{TopOrderShare: 0.5, LotSize: 1},
+			expected: []float64{4, 1, 0},
+		},
+		{
+			name:     "top order share capped at the visible quantity",
+			orders:   visibleOrders(2, 8),
+			quantity: 8,
+			config:   ProRataConfig{TopOrderShare: 0.5},
+			expected: []float64{2, 6},
+		},
+		{
+			name:     "no more than the visible quantity",
+			orders:   []*Order{visibleOrders(3)[0], iceberg},
+			quantity: 20,
+			expected: []float64{3, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations := allocateProRata(tt.orders, tt.quantity, tt.config)
+
+			total, expectedTotal := 0.0, 0.0
+			for i := range allocations {
+				if math.Abs(allocations[i]-tt.expected[i]) > 1e-9 {
+					t.Fatalf("allocations %v, expected %v", allocations, tt.expected)
+				}
+				total += allocations[i]
+				expectedTotal += tt.expected[i]
+			}
+			if math.Abs(total-expectedTotal) > 1e-9 {
+				t.Fatalf("allocated %v of %v", total, expectedTotal)
+			}
+		})
+	}
+}
+
+func TestMatchProRataRefillsIcebergsForTheNextRound(t *testing.T) {
+	pl := &PriceLevel{Price: 100, Orders: &OrderQueue{}, Policy: ProRata{}}
+	pl.PlaceOrder(&Order{ID: "a", Side: "buy", Price: 100, Quantity: 4, Timestamp: 1})
+	pl.PlaceOrder(&Order{ID: "b", Side: "buy", Price: 100, Quantity: 6, DisplayQuantity: 2, Timestamp: 2})
+
+	taker := &Order{ID: "t", Side: "sell", Price: 100, Quantity: 7, Timestamp: 3}
+	fills := pl.MatchProRata(taker, 7, ProRataConfig{LotSize: 1})
+
+	// All of the visible 6 in the first round, b refills and takes the last
+	// one in the second.
+	expected := []struct {
+		maker    string
+		quantity float64
+	}{{"a", 4}, {"b", 2}, {"b", 1}}
+	if len(fills) != len(expected) {
+		t.Fatalf("fills %+v", fills)
+	}
+	for i, fill := range fills {
+		if fill.MakerOrderID != expected[i].maker || fill.Quantity != expected[i].quantity || fill.Price != 100 {
+			t.Fatalf("fill %d = %+v", i, fill)
+		}
+	}
+
+	b := pl.SortedOrders()[0]
+	if pl.Orders.Len() != 1 || b.ID != "b" || b.Quantity != 3 || b.Visible() != 1 || b.Timestamp != 3 {
+		t.Fatalf("left %+v", pl.SortedOrders())
+	}
+	if pl.TotalQuantity != 3 || pl.HiddenQuantity != 2 || pl.VisibleQuantity() != 1 {
+		t.Fatalf("level total %v, hidden %v", pl.TotalQuantity, pl.HiddenQuantity)
+	}
+}
@@ -0,0 +1,507 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"cob"
+)
+
+// Reason tells which rule rejected an order.
+type Reason string
+
+const (
+	ReasonKillSwitch       Reason = "kill_switch"
+	ReasonInvalidOrder     Reason = "invalid_order"
+	ReasonMaxQuantity      Reason = "max_quantity"
+	ReasonMaxNotional      Reason = "max_notional"
+	ReasonMinQuantity      Reason = "min_quantity"
+	ReasonTickSize         Reason = "tick_size"
+	ReasonLotSize          Reason = "lot_size"
+	ReasonNoReferencePrice Reason = "no_reference_price"
+	ReasonPriceCollar      Reason = "price_collar"
+	ReasonPositionLimit    Reason = "position_limit"
+	ReasonOpenOrderLimit   Reason = "open_order_limit"
+	ReasonWouldCross       Reason = "would_cross" // Post-only order would take liquidity
+	ReasonNotFilled        Reason = "not_filled"  // Fill-or-kill order can not be filled completely
+)
+
+// Rejection is the error returned for orders failing a rule.
+type Rejection struct {
+	Reason  Reason
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("order rejected (%s): %s", r.Reason, r.Message)
+}
+
+func reject(reason Reason, format string, args ...any) *Rejection {
+	return &Rejection{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// Limits configures the rules, a zero value disables a rule.
+type Limits struct {
+	MaxQuantity   float64 // Maximum quantity of one order
+	MaxNotional   float64 // Maximum price times quantity of one order
+	MinQuantity   float64 // Instrument minimum order quantity
+	TickSize      float64 // Instrument price increment
+	LotSize       float64 // Instrument quantity increment
+	PriceCollar   float64 // Maximum distance from the consolidated mid, e.g. 0.05 for 5%
+	MaxPosition   float64 // Maximum absolute net position per customer, open orders included
+	MaxOpenOrders int     // Maximum resting orders per customer
+}
+
+// trackedOrder is a resting or stop customer order.
+type trackedOrder struct {
+	order     *cob.Order
+	account   string
+	side      string
+	remaining float64
+}
+
+// Checker is the pre-trade risk layer in front of an OrderBook. Orders only
+// enter the book through PlaceOrder, which runs every rule first and keeps
+// the customer positions and open orders the limits are checked against.
+type Checker struct {
+	mutex        sync.Mutex
+	limits       Limits
+	accounts     map[string]Limits // Per customer overrides of the limits
+	killed       bool
+	killReason   string
+	positions    map[string]float64 // Net position per customer
+	orders       map[string]*trackedOrder
+	stops        map[string]*trackedOrder      // Tracked stop orders, until they rest or complete
+	openOrders   map[string]int                // Resting orders per customer
+	openQuantity map[string]map[string]float64 // Resting quantity per customer and side
+	expiring     map[string]*trackedOrder      // Tracked orders with an expiry
+}
+
+func New(limits Limits) *Checker {
+	return &Checker{
+		limits:       limits,
+		accounts:     make(map[string]Limits),
+		positions:    make(map[string]float64),
+		orders:       make(map[string]*trackedOrder),
+		stops:        make(map[string]*trackedOrder),
+		openOrders:   make(map[string]int),
+		openQuantity: make(map[string]map[string]float64),
+		expiring:     make(map[string]*trackedOrder),
+	}
+}
+
+// SetAccountLimits replaces the limits of one customer.
+func (c *Checker) SetAccountLimits(account string, limits Limits) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.accounts[account] = limits
+}
+
+// Kill rejects every new order until Resume is called.
+func (c *Checker) Kill(reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.killed = true
+	c.killReason = reason
+}
+
+func (c *Checker) Resume() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.killed = false
+	c.killReason = ""
+}
+
+// Position returns the net position of a customer, positive when long.
+func (c *Checker) Position(account string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.positions[account]
+}
+
+// Positions returns a copy of the net positions of every customer.
+func (c *Checker) Positions() map[string]float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	positions := make(map[string]float64, len(c.positions))
+	for account, position := range c.positions {
+		positions[account] = position
+	}
+	return positions
+}
+
+// Restore replaces the open orders with the customer orders in book and the
+// positions with positions, e.g. those saved with a journal snapshot.
+func (c *Checker) Restore(book *cob.OrderBook, positions map[string]float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.positions = make(map[string]float64)
+	for account, position := range positions {
+		c.positions[account] = position
+	}
+	c.orders = make(map[string]*trackedOrder)
+	c.stops = make(map[string]*trackedOrder)
+	c.openOrders = make(map[string]int)
+	c.openQuantity = make(map[string]map[string]float64)
+	c.expiring = make(map[string]*trackedOrder)
+
+	for _, order := range book.LocalOrders() {
+		c.track(order)
+	}
+}
+
+func (c *Checker) limitsOf(account string) Limits {
+	if limits, ok := c.accounts[account]; ok {
+		return limits
+	}
+	return c.limits
+}
+
+// Check runs every rule against order without placing it. The error is a
+// *Rejection.
+func (c *Checker) Check(book *cob.OrderBook, order *cob.Order) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if rejection := c.check(book, order); rejection != nil {
+		return rejection
+	}
+	return nil
+}
+
+func (c *Checker) check(book *cob.OrderBook, order *cob.Order) *Rejection {
+	if c.killed {
+		return reject(ReasonKillSwitch, "trading halted: %s", c.killReason)
+	}
+
+	if order.Side != "buy" && order.Side != "sell" {
+		return reject(ReasonInvalidOrder, "unknown side %q", order.Side)
+	}
+	if order.Quantity <= 0 {
+		return reject(ReasonInvalidOrder, "quantity must be positive")
+	}
+	if order.Type != cob.MarketOrder && order.Type != cob.StopOrder && order.Price <= 0 {
+		return reject(ReasonInvalidOrder, "price must be positive")
+	}
+	if _, exists := c.orders[order.ID]; exists {
+		return reject(ReasonInvalidOrder, "duplicate order id %s", order.ID)
+	}
+
+	limits := c.limitsOf(order.Account)
+	mid, hasMid := book.Mid()
+
+	// Market orders are checked at their protection price, and without one
+	// at the consolidated mid.
+	price := order.Price
+	switch order.Type {
+	case cob.MarketOrder:
+		price = order.ProtectionPrice
+	case cob.StopOrder:
+		price = order.StopPrice
+	}
+
+	if limits.MaxQuantity > 0 && order.Quantity > limits.MaxQuantity {
+		return reject(ReasonMaxQuantity, "quantity %v above %v", order.Quantity, limits.MaxQuantity)
+	}
+	if limits.MaxNotional > 0 {
+		reference := price
+		if reference == 0 {
+			if !hasMid {
+				return reject(ReasonNoReferencePrice, "no consolidated price to value the order at")
+			}
+			reference = mid
+		}
+		if notional := reference * order.Quantity; notional > limits.MaxNotional {
+			return reject(ReasonMaxNotional, "notional %v above %v", notional, limits.MaxNotional)
+		}
+	}
+	if limits.MinQuantity > 0 && order.Quantity < limits.MinQuantity {
+		return reject(ReasonMinQuantity, "quantity %v below %v", order.Quantity, limits.MinQuantity)
+	}
+	if !multipleOf(price, limits.TickSize) {
+		return reject(ReasonTickSize, "price %v is not a multiple of %v", price, limits.TickSize)
+	}
+	if !multipleOf(order.Quantity, limits.LotSize) {
+		return reject(ReasonLotSize, "quantity %v is not a multiple of %v", order.Quantity, limits.LotSize)
+	}
+
+	if limits.PriceCollar > 0 && price > 0 {
+		if !hasMid {
+			return reject(ReasonNoReferencePrice, "no consolidated price to check the collar against")
+		}
+		if math.Abs(price-mid)/mid > limits.PriceCollar {
+			return reject(ReasonPriceCollar, "price %v more than %v%% away from mid %v", price, limits.PriceCollar*100, mid)
+		}
+	}
+
+	if limits.MaxPosition > 0 {
+		exposure := c.positions[order.Account] + c.openQuantity[order.Account]["buy"] + order.Quantity
+		if order.Side == "sell" {
+			exposure = c.positions[order.Account] - c.openQuantity[order.Account]["sell"] - order.Quantity
+		}
+		if math.Abs(exposure) > limits.MaxPosition {
+			return reject(ReasonPositionLimit, "position would reach %v, limit %v", exposure, limits.MaxPosition)
+		}
+	}
+
+	if limits.MaxOpenOrders > 0 && c.openOrders[order.Account] >= limits.MaxOpenOrders {
+		return reject(ReasonOpenOrderLimit, "%d open orders, limit %d", c.openOrders[order.Account], limits.MaxOpenOrders)
+	}
+
+	return nil
+}
+
+// multipleOf reports whether value is a multiple of increment, within float
+// rounding. A zero increment accepts any value.
+func multipleOf(value float64, increment float64) bool {
+	if increment <= 0 {
+		return true
+	}
+
+	steps := value / increment
+	return math.Abs(steps-math.Round(steps)) < 1e-6
+}
+
+// PlaceOrder checks a customer order and, when it passes, matches it in book.
+// The error is a *Rejection.
+func (c *Checker) PlaceOrder(book *cob.OrderBook, order *cob.Order) ([]cob.Fill, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if rejection := c.check(book, order); rejection != nil {
+		book.Reject(order, cob.RejectReason(rejection.Reason))
+		return nil, rejection
+	}
+
+	order.Provider = "local"
+	fills, err := book.ProcessOrder(order)
+	switch {
+	case errors.Is(err, cob.ErrWouldCross):
+		return nil, reject(ReasonWouldCross, "%v", err)
+	case errors.Is(err, cob.ErrNotFilled):
+		return nil, reject(ReasonNotFilled, "%v", err)
+	case err != nil:
+		return nil, reject(ReasonInvalidOrder, "%v", err)
+	}
+
+	for _, fill := range fills {
+		c.applyFill(order.Account, fill)
+	}
+	if order.Quantity > 0 && order.Rests() {
+		c.track(order)
+	}
+	c.reconcileStops()
+	c.reconcileExpired(book.Now())
+	c.reconcileSelfTrades(order.Account)
+
+	return fills, nil
+}
+
+// AmendOrder checks the new price and quantity of a customer order as if it
+// were placed again, without its current open quantity, and amends it in
+// book. The error is a *Rejection.
+func (c *Checker) AmendOrder(book *cob.OrderBook, orderID string, price float64, quantity float64) ([]cob.Fill, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if rejection := c.checkAmend(book, orderID, price, quantity); rejection != nil {
+		return nil, rejection
+	}
+
+	tracked := c.orders[orderID]
+	c.untrack(orderID)
+	order, fills, err := book.AmendOrder(orderID, price, quantity)
+	if err != nil {
+		c.track(tracked.order)
+		if errors.Is(err, cob.ErrWouldCross) {
+			return nil, reject(ReasonWouldCross, "%v", err)
+		}
+		return nil, reject(ReasonInvalidOrder, "%v", err)
+	}
+
+	for _, fill := range fills {
+		c.applyFill(tracked.account, fill)
+	}
+	if order.Quantity > 0 && (order.IsStop() || order.Rests()) {
+		c.track(order)
+	}
+	c.reconcileStops()
+	c.reconcileSelfTrades(tracked.account)
+
+	return fills, nil
+}
+
+// CheckAmend runs the rules AmendOrder applies to an amend without making it.
+// The error is a *Rejection.
+func (c *Checker) CheckAmend(book *cob.OrderBook, orderID string, price float64, quantity float64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if rejection := c.checkAmend(book, orderID, price, quantity); rejection != nil {
+		return rejection
+	}
+	return nil
+}
+
+func (c *Checker) checkAmend(book *cob.OrderBook, orderID string, price float64, quantity float64) *Rejection {
+	tracked, ok := c.orders[orderID]
+	if !ok {
+		return reject(ReasonInvalidOrder, "unknown order %s", orderID)
+	}
+
+	probe := *tracked.order
+	probe.Quantity = quantity
+	if price > 0 {
+		probe.Price = price
+	}
+
+	c.untrack(orderID)
+	defer c.track(tracked.order)
+	return c.check(book, &probe)
+}
+
+// ApplyFills books fills made outside PlaceOrder, such as those of stop
+// orders triggered by market data.
+func (c *Checker) ApplyFills(fills []cob.Fill) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, fill := range fills {
+		c.applyFill("", fill)
+	}
+	c.reconcileStops()
+}
+
+// reconcileStops stops tracking triggered stop orders that did not rest,
+// and keeps the others as regular resting orders.
+func (c *Checker) reconcileStops() {
+	for orderID, tracked := range c.stops {
+		if tracked.order.IsStop() {
+			continue
+		}
+
+		delete(c.stops, orderID)
+		if tracked.order.Quantity <= 0 || tracked.order.Type == cob.MarketOrder || !tracked.order.Rests() {
+			c.untrack(orderID)
+		}
+	}
+}
+
+// reconcileExpired stops tracking orders past their expiry. The book never
+// matches them anymore, whether or not they were removed from it yet.
+func (c *Checker) reconcileExpired(now time.Time) {
+	for orderID, tracked := range c.expiring {
+		if !tracked.order.ExpireAt.After(now) {
+			c.untrack(orderID)
+		}
+	}
+}
+
+// reconcileSelfTrades releases the open quantity self-trade prevention took
+// off the resting orders of account without trading.
+func (c *Checker) reconcileSelfTrades(account string) {
+	for orderID, tracked := range c.orders {
+		if tracked.account != account || tracked.order.IsStop() {
+			continue
+		}
+		if cancelled := tracked.remaining - tracked.order.Quantity; cancelled > 1e-12 {
+			c.consume(orderID, tracked, cancelled)
+		}
+	}
+}
+
+// Expired stops tracking orders removed by the book on expiry, it is meant as
+// ExpiryScheduler.OnExpired.
+func (c *Checker) Expired(orders []*cob.Order) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, order := range orders {
+		c.untrack(order.ID)
+	}
+}
+
+// CancelOrder removes a customer order from book and from the open orders.
+func (c *Checker) CancelOrder(book *cob.OrderBook, orderID string) *cob.Order {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	order := book.CancelOrder(orderID)
+	if order != nil {
+		c.untrack(orderID)
+	}
+	return order
+}
+
+func (c *Checker) track(order *cob.Order) {
+	tracked := &trackedOrder{
+		order:     order,
+		account:   order.Account,
+		side:      order.Side,
+		remaining: order.Quantity,
+	}
+	c.orders[order.ID] = tracked
+	if order.IsStop() {
+		c.stops[order.ID] = tracked
+	}
+	if !order.ExpireAt.IsZero() {
+		c.expiring[order.ID] = tracked
+	}
+	c.openOrders[order.Account]++
+	if c.openQuantity[order.Account] == nil {
+		c.openQuantity[order.Account] = make(map[string]float64)
+	}
+	c.openQuantity[order.Account][order.Side] += order.Quantity
+}
+
+func (c *Checker) untrack(orderID string) {
+	tracked, ok := c.orders[orderID]
+	if !ok {
+		return
+	}
+
+	delete(c.orders, orderID)
+	delete(c.stops, orderID)
+	delete(c.expiring, orderID)
+	c.openOrders[tracked.account]--
+	c.openQuantity[tracked.account][tracked.side] -= tracked.remaining
+}
+
+// applyFill moves the positions of the taker and, for customer orders in the
+// book, of the maker. Takers are either tracked, like triggered stops, or the
+// order being placed by takerAccount.
+func (c *Checker) applyFill(takerAccount string, fill cob.Fill) {
+	signed := fill.Quantity
+	if fill.Side == "sell" {
+		signed = -fill.Quantity
+	}
+
+	if taker, ok := c.orders[fill.TakerOrderID]; ok {
+		takerAccount = taker.account
+		c.consume(fill.TakerOrderID, taker, fill.Quantity)
+	}
+	c.positions[takerAccount] += signed
+
+	if maker, ok := c.orders[fill.MakerOrderID]; ok {
+		c.positions[maker.account] -= signed
+		c.consume(fill.MakerOrderID, maker, fill.Quantity)
+	}
+}
+
+func (c *Checker) consume(orderID string, tracked *trackedOrder, quantity float64) {
+	tracked.remaining -= quantity
+	c.openQuantity[tracked.account][tracked.side] -= quantity
+	if tracked.remaining <= 1e-12 {
+		tracked.remaining = 0
+		c.untrack(orderID)
+	}
+}
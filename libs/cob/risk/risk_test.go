@@ -0,0 +1,262 @@
+package risk
+
+import (
+	"errors"
+	"testing"
+
+	"cob"
+)
+
+// testBook quotes 99 / 101 on an external venue, a mid of 100.
+func testBook() *cob.OrderBook {
+	book := cob.NewOrderBook()
+	book.UpdateExternalLevel("kraken", "buy", 99, 10, 1)
+	book.UpdateExternalLevel("kraken", "sell", 101, 10, 1)
+	return book
+}
+
+func limit(id string, account string, side string, price float64, quantity float64) *cob.Order {
+	return &cob.Order{ID: id, Account: account, Side: side, Price: price, Quantity: quantity}
+}
+
+// reason returns the rejection reason of err, empty when the order passed.
+func reason(t *testing.T, err error) Reason {
+	t.Helper()
+
+	if err == nil {
+		return ""
+	}
+	rejection := &Rejection{}
+	if !errors.As(err, &rejection) {
+		t.Fatalf("error %v is not a rejection", err)
+	}
+	return rejection.Reason
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		empty  bool         // No external quotes, so no mid
+		placed []*cob.Order // Resting before order is checked
+		order  *cob.Order
+		reason Reason
+	}{
+		{name: "within every limit", limits: Limits{MaxQuantity: 5, MaxNotional: 1000, PriceCollar: 0.05, MaxPosition: 5, MaxOpenOrders: 1}, order: limit("o", "a", "buy", 100, 1)},
+		{name: "max quantity", limits: Limits{MaxQuantity: 5}, order: limit("o", "a", "buy", 100, 6), reason: ReasonMaxQuantity},
+		{name: "min quantity", limits: Limits{MinQuantity: 0.01}, order: limit("o", "a", "buy", 100, 0.001), reason: ReasonMinQuantity},
+		{name: "tick size", limits: Limits{TickSize: 0.5}, order: limit("o", "a", "buy", 100.2, 1), reason: ReasonTickSize},
+		{name: "lot size", limits: Limits{LotSize: 0.1}, order: limit("o", "a", "buy", 100, 0.15), reason: ReasonLotSize},
+
+		{name: "notional at the limit", limits: Limits{MaxNotional: 1000}, order: limit("o", "a", "buy", 100, 10)},
+		{name: "notional above the limit", limits: Limits{MaxNotional: 1000}, order: limit("o", "a", "buy", 100, 10.5), reason: ReasonMaxNotional},
+		{
+			name:   "market notional at the protection price",
+			limits: Limits{MaxNotional: 1000},
+			order:  &cob.Order{ID: "o", Account: "a", Side: "buy", Type: cob.MarketOrder, ProtectionPrice: 120, Quantity: 9},
+			reason: ReasonMaxNotional,
+		},
+		{
+			name:   "market notional at the mid",
+			limits: Limits{MaxNotional: 1000},
+			order:  &cob.Order{ID: "o", Account: "a", Side: "buy", Type: cob.MarketOrder, Quantity: 10.5},
+			reason: ReasonMaxNotional,
+		},
+		{
+			name:   "market notional without a mid",
+			limits: Limits{MaxNotional: 1000},
+			empty:  true,
+			order:  &cob.Order{ID: "o", Account: "a", Side: "buy", Type: cob.MarketOrder, Quantity: 1},
+			reason: ReasonNoReferencePrice,
+		},
+
+		{name: "price within the band", limits: Limits{PriceCollar: 0.05}, order: limit("o", "a", "sell", 95, 1)},
+		{name: "price above the band", limits: Limits{PriceCollar: 0.05}, order: limit("o", "a", "buy", 105.5, 1), reason: ReasonPriceCollar},
+		{name: "price below the band", limits: Limits{PriceCollar: 0.05}, order: limit("o", "a", "sell", 94.5, 1), reason: ReasonPriceCollar},
+		{name: "band without a mid", limits: Limits{PriceCollar: 0.05}, empty: true, order: limit("o", "a", "buy", 100, 1), reason: ReasonNoReferencePrice},
+
+		{
+			name:   "position with the open buys",
+			limits: Limits{MaxPosition: 2},
+			placed: []*cob.Order{limit("r", "a", "buy", 90, 1.5)},
+			order:  limit("o", "a", "buy", 90, 1),
+			reason: ReasonPositionLimit,
+		},
+		{
+			name:   "open buys do not count against sells",
+			limits: Limits{MaxPosition: 2},
+			placed: []*cob.Order{limit("r", "a", "buy", 90, 1.5)},
+			order:  limit("o", "a", "sell", 110, 2),
+		},
+		{
+			name:   "short position",
+			limits: Limits{MaxPosition: 2},
+			placed: []*cob.Order{limit("r", "a", "sell", 110, 1.5)},
+			order:  limit("o", "a", "sell", 110, 1),
+			reason: ReasonPositionLimit,
+		},
+		{
+			name:   "position of another customer",
+			limits: Limits{MaxPosition: 2},
+			placed: []*cob.Order{limit("r", "b", "buy", 90, 1.5)},
+			order:  limit("o", "a", "buy", 90, 1),
+		},
+
+		{
+			name:   "open orders at the limit",
+			limits: Limits{MaxOpenOrders: 2},
+			placed: []*cob.Order{limit("r1", "a", "buy", 90, 1), limit("r2", "a", "sell", 110, 1)},
+			order:  limit("o", "a", "buy", 90, 1),
+			reason: ReasonOpenOrderLimit,
+		},
+		{
+			name:   "open orders of another customer",
+			limits: Limits{MaxOpenOrders: 2},
+			placed: []*cob.Order{limit("r1", "b", "buy", 90, 1), limit("r2", "b", "sell", 110, 1)},
+			order:  limit("o", "a", "buy", 90, 1),
+		},
+
+		{name: "duplicate id", placed: []*cob.Order{limit("o", "a", "buy", 90, 1)}, order: limit("o", "a", "buy", 90, 1), reason: ReasonInvalidOrder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := testBook()
+			if tt.empty {
+				book = cob.NewOrderBook()
+			}
+			checker := New(Limits{})
+			for _, order := range tt.placed {
+				if _, err := checker.PlaceOrder(book, order); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checker.limits = tt.limits
+
+			if got := reason(t, checker.Check(book, tt.order)); got != tt.reason {
+				t.Fatalf("reason %q, expected %q", got, tt.reason)
+			}
+			_, err := checker.PlaceOrder(book, tt.order)
+			if got := reason(t, err); got != tt.reason {
+				t.Fatalf("placed with reason %q, expected %q", got, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAccountLimitsOverrideTheDefaults(t *testing.T) {
+	book := testBook()
+	checker := New(Limits{MaxQuantity: 1})
+	checker.SetAccountLimits("whale", Limits{MaxQuantity: 10})
+
+	if got := reason(t, checker.Check(book, limit("o1", "a", "buy", 90, 5))); got != ReasonMaxQuantity {
+		t.Fatalf("default limits: %q", got)
+	}
+	if err := checker.Check(book, limit("o2", "whale", "buy", 90, 5)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFillsMovePositionsAndOpenQuantity(t *testing.T) {
+	book := testBook()
+	checker := New(Limits{MaxPosition: 2})
+
+	if _, err := checker.PlaceOrder(book, limit("m", "a", "buy", 100, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checker.PlaceOrder(book, limit("t", "b", "sell", 100, 0.5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if checker.Position("a") != 0.5 || checker.Position("b") != -0.5 {
+		t.Fatalf("positions %v", checker.Positions())
+	}
+	// 0.5 held and 1.5 still resting.
+	if got := reason(t, checker.Check(book, limit("o", "a", "buy", 90, 0.1))); got != ReasonPositionLimit {
+		t.Fatalf("reason %q", got)
+	}
+	if checker.openQuantity["a"]["buy"] != 1.5 || checker.openOrders["a"] != 1 {
+		t.Fatalf("open quantity %v, orders %v", checker.openQuantity["a"], checker.openOrders["a"])
+	}
+
+	// The rest fills and the order stops counting as open.
+	if _, err := checker.PlaceOrder(book, limit("t2", "b", "sell", 100, 1.5)); err != nil {
+		t.Fatal(err)
+	}
+	if checker.Position("a") != 2 || checker.openOrders["a"] != 0 || len(checker.orders) != 0 {
+		t.Fatalf("position %v, open orders %v, tracked %v", checker.Position("a"), checker.openOrders["a"], checker.orders)
+	}
+}
+
+func TestAmendIsCheckedWithoutItsOwnOpenQuantity(t *testing.T) {
+	book := testBook()
+	checker := New(Limits{MaxPosition: 2, MaxOpenOrders: 1})
+
+	if _, err := checker.PlaceOrder(book, limit("o", "a", "buy", 90, 1.5)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the open order nor its quantity count against the amend.
+	if _, err := checker.AmendOrder(book, "o", 91, 2); err != nil {
+		t.Fatal(err)
+	}
+	if checker.openQuantity["a"]["buy"] != 2 || checker.openOrders["a"] != 1 {
+		t.Fatalf("open quantity %v, orders %v", checker.openQuantity["a"], checker.openOrders["a"])
+	}
+
+	_, err := checker.AmendOrder(book, "o", 91, 2.5)
+	if got := reason(t, err); got != ReasonPositionLimit {
+		t.Fatalf("amend above the limit: %q", got)
+	}
+	if checker.openQuantity["a"]["buy"] != 2 || checker.openOrders["a"] != 1 {
+		t.Fatalf("rejected amend changed open quantity %v, orders %v", checker.openQuantity["a"], checker.openOrders["a"])
+	}
+
+	_, err = checker.AmendOrder(book, "unknown", 91, 1)
+	if got := reason(t, err); got != ReasonInvalidOrder {
+		t.Fatalf("amend of an unknown order: %q", got)
+	}
+}
+
+func TestCancelReleasesTheOpenOrder(t *testing.T) {
+	book := testBook()
+	checker := New(Limits{MaxPosition: 1, MaxOpenOrders: 1})
+
+	if _, err := checker.PlaceOrder(book, limit("o1", "a", "buy", 90, 1)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := checker.PlaceOrder(book, limit("o2", "a", "buy", 90, 1))
+	if got := reason(t, err); got != ReasonPositionLimit {
+		t.Fatalf("second order: %q", got)
+	}
+
+	if order := checker.CancelOrder(book, "o1"); order == nil {
+		t.Fatal("o1 not cancelled")
+	}
+	if checker.CancelOrder(book, "o1") != nil {
+		t.Fatal("o1 cancelled twice")
+	}
+	if checker.openQuantity["a"]["buy"] != 0 || checker.openOrders["a"] != 0 {
+		t.Fatalf("open quantity %v, orders %v", checker.openQuantity["a"], checker.openOrders["a"])
+	}
+
+	if _, err := checker.PlaceOrder(book, limit("o2", "a", "buy", 90, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKillSwitchRejectsUntilResumed(t *testing.T) {
+	book := testBook()
+	checker := New(Limits{})
+
+	checker.Kill("maintenance")
+	_, err := checker.PlaceOrder(book, limit("o1", "a", "buy", 90, 1))
+	if got := reason(t, err); got != ReasonKillSwitch {
+		t.Fatalf("killed: %q", got)
+	}
+
+	checker.Resume()
+	if _, err := checker.PlaceOrder(book, limit("o1", "a", "buy", 90, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
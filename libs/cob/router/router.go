@@ -0,0 +1,325 @@
+package router
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"cob"
+	"cob/inventory"
+)
+
+// Venue is what the router needs to know about an external exchange.
+type Venue struct {
+	Name         string
+	Balance      float64 // Available balance, in the quote asset for buys and the base asset for sells, unless the router has an inventory
+	TakerFee     float64 // Fraction of the notional, e.g. 0.0026, unless the router has a fee schedule for the venue
+	QtyMin       float64 // Minimum order quantity
+	CostMin      float64 // Minimum order notional
+	QtyIncrement float64 // Lot size, child quantities are rounded down to it
+}
+
+// Request asks for Quantity to be executed on the external venues.
+type Request struct {
+	Side       string  // Side of the hedge orders, "buy" takes the asks of the venues
+	Quantity   float64 // Quantity to route
+	LimitPrice float64 // Worst acceptable venue price, no limit when zero
+}
+
+// ChildOrder is the part of a request sent to one venue.
+type ChildOrder struct {
+	Venue      string
+	Side       string
+	Quantity   float64
+	LimitPrice float64 // Worst price level the order is expected to reach
+	AvgPrice   float64 // Expected average price before fees
+	Fee        float64 // Expected taker fee in the quote asset
+	Cost       float64 // Expected notional, fees added for buys and deducted for sells
+}
+
+// Plan is the outcome of routing a request, nothing is sent by the router.
+type Plan struct {
+	Request  Request
+	Children []ChildOrder      // Sorted by venue name
+	Quantity float64           // Routed quantity
+	Unrouted float64           // Quantity no venue can take within the constraints
+	Cost     float64           // Total expected cost of the children
+	AvgPrice float64           // Fee adjusted average price of the routed quantity
+	Excluded map[string]string // Venues left out, with the reason
+}
+
+// Router splits hedge orders across venues by fee adjusted price, taking the
+// visible depth, the balances and the order minimums of every venue into
+// account.
+type Router struct {
+	mutex     sync.RWMutex
+	venues    map[string]Venue
+	fees      *cob.Fees
+	inventory *inventory.Manager
+	base      string
+	quote     string
+}
+
+func New(venues ...Venue) *Router {
+	r := &Router{venues: make(map[string]Venue)}
+	for _, venue := range venues {
+		r.venues[venue.Name] = venue
+	}
+	return r
+}
+
+// SetVenue adds or replaces a venue, e.g. after a balance change.
+func (r *Router) SetVenue(venue Venue) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.venues[venue.Name] = venue
+}
+
+// SetFees makes the router take taker fees from the fee schedules, falling
+// back to Venue.TakerFee for venues without one.
+func (r *Router) SetFees(fees *cob.Fees) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.fees = fees
+}
+
+// SetInventory makes the router take venue balances from the free balances of
+// the inventory: quote for buys and base for sells.
+func (r *Router) SetInventory(inventory *inventory.Manager, base string, quote string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.inventory = inventory
+	r.base = base
+	r.quote = quote
+}
+
+// currentVenues returns the venues with their balance for side.
+func (r *Router) currentVenues(side string) map[string]Venue {
+	venues := make(map[string]Venue, len(r.venues))
+	for name, venue := range r.venues {
+		if r.inventory != nil {
+			asset := r.quote
+			if side == "sell" {
+				asset = r.base
+			}
+			venue.Balance = r.inventory.Free(name, asset)
+		}
+		venues[name] = venue
+	}
+	return venues
+}
+
+func (r *Router) takerFee(venue Venue) float64 {
+	if r.fees.Has(venue.Name) {
+		_, taker := r.fees.Rates(venue.Name)
+		return taker
+	}
+	return venue.TakerFee
+}
+
+func (r *Router) Venue(name string) (Venue, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	venue, ok := r.venues[name]
+	return venue, ok
+}
+
+// level is a venue price level with its fee adjusted price.
+type level struct {
+	venue     Venue
+	price     float64
+	quantity  float64
+	effective float64
+}
+
+// Plan routes request against the external liquidity of book. Buys take the
+// asks and sells take the bids.
+//
+// Levels of all venues are taken greedily in order of fee adjusted price,
+// which minimizes the cost as long as no minimum applies. Venues whose share
+// ends up below their QtyMin or CostMin are then excluded and the request is
+// routed again, until every child order is valid.
+func (r *Router) Plan(book *cob.OrderBook, request Request) Plan {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	excluded := make(map[string]string)
+	venues := r.currentVenues(request.Side)
+	depth := book.VenueDepth(oppositeSide(request.Side))
+	for name := range depth {
+		venue, ok := venues[name]
+		if !ok {
+			excluded[name] = "venue not configured"
+		} else if venue.Balance <= 0 {
+			excluded[name] = "no available balance"
+		}
+	}
+
+	for {
+		children := r.allocate(venues, depth, request, excluded)
+
+		valid := true
+		for _, child := range children {
+			venue := venues[child.Venue]
+			if child.Quantity <= 0 || child.Quantity < venue.QtyMin {
+				excluded[child.Venue] = "quantity below venue minimum"
+				valid = false
+			} else if child.Quantity*child.AvgPrice < venue.CostMin {
+				excluded[child.Venue] = "cost below venue minimum"
+				valid = false
+			}
+		}
+
+		if valid {
+			return newPlan(request, children, excluded)
+		}
+	}
+}
+
+// allocate assigns the requested quantity to the cheapest levels, bounded by
+// the venue balances, and aggregates it to one child order per venue.
+func (r *Router) allocate(venues map[string]Venue, depth map[string][]cob.VenueLevel, request Request, excluded map[string]string) []ChildOrder {
+	levels := []level{}
+	for name, venueLevels := range depth {
+		if _, ok := excluded[name]; ok {
+			continue
+		}
+
+		venue := venues[name]
+		takerFee := r.takerFee(venue)
+		for _, venueLevel := range venueLevels {
+			if !acceptable(request, venueLevel.Price) {
+				break
+			}
+
+			effective := venueLevel.Price * (1 + takerFee)
+			if request.Side == "sell" {
+				effective = venueLevel.Price * (1 - takerFee)
+			}
+
+			levels = append(levels, level{
+				venue:     venue,
+				price:     venueLevel.Price,
+				quantity:  venueLevel.Quantity,
+				effective: effective,
+			})
+		}
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		if levels[i].effective != levels[j].effective {
+			if request.Side == "sell" {
+				return levels[i].effective > levels[j].effective
+			}
+			return levels[i].effective < levels[j].effective
+		}
+		if levels[i].venue.Name != levels[j].venue.Name {
+			return levels[i].venue.Name < levels[j].venue.Name
+		}
+		return levels[i].price < levels[j].price
+	})
+
+	remaining := request.Quantity
+	spent := make(map[string]float64)
+	children := make(map[string]*ChildOrder)
+
+	for _, lvl := range levels {
+		if remaining <= 0 {
+			break
+		}
+
+		capacity := lvl.venue.Balance - spent[lvl.venue.Name]
+		if request.Side == "buy" {
+			capacity = capacity / lvl.effective
+		}
+
+		quantity := math.Min(remaining, math.Min(lvl.quantity, capacity))
+		if quantity <= 0 {
+			continue
+		}
+
+		child, ok := children[lvl.venue.Name]
+		if !ok {
+			child = &ChildOrder{Venue: lvl.venue.Name, Side: request.Side}
+			children[lvl.venue.Name] = child
+		}
+		child.Quantity += quantity
+		child.AvgPrice += quantity * lvl.price // Notional until divided below
+		child.LimitPrice = lvl.price
+
+		remaining -= quantity
+		if request.Side == "buy" {
+			spent[lvl.venue.Name] += quantity * lvl.effective
+		} else {
+			spent[lvl.venue.Name] += quantity
+		}
+	}
+
+	result := make([]ChildOrder, 0, len(children))
+	for _, child := range children {
+		venue := venues[child.Venue]
+		avgPrice := child.AvgPrice / child.Quantity
+
+		child.Quantity = roundDown(child.Quantity, venue.QtyIncrement)
+		child.AvgPrice = avgPrice
+		child.Fee = child.Quantity * avgPrice * r.takerFee(venue)
+		child.Cost = child.Quantity*avgPrice + child.Fee
+		if request.Side == "sell" {
+			child.Cost = child.Quantity*avgPrice - child.Fee
+		}
+
+		result = append(result, *child)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Venue < result[j].Venue })
+
+	return result
+}
+
+func newPlan(request Request, children []ChildOrder, excluded map[string]string) Plan {
+	plan := Plan{
+		Request:  request,
+		Children: children,
+		Excluded: excluded,
+	}
+
+	for _, child := range children {
+		plan.Quantity += child.Quantity
+		plan.Cost += child.Cost
+	}
+	plan.Unrouted = math.Max(request.Quantity-plan.Quantity, 0)
+	if plan.Quantity > 0 {
+		plan.AvgPrice = plan.Cost / plan.Quantity
+	}
+
+	return plan
+}
+
+func acceptable(request Request, price float64) bool {
+	if request.LimitPrice == 0 {
+		return true
+	}
+	if request.Side == "buy" {
+		return price <= request.LimitPrice
+	}
+	return price >= request.LimitPrice
+}
+
+func roundDown(quantity float64, increment float64) float64 {
+	if increment <= 0 {
+		return quantity
+	}
+	// The epsilon keeps exact multiples, e.g. 0.3/0.1, from losing a lot.
+	return math.Floor(quantity/increment+1e-9) * increment
+}
+
+func oppositeSide(side string) string {
+	if side == "buy" {
+		return "sell"
+	}
+	return "buy"
+}
@@ -0,0 +1,175 @@
+package router
+
+import (
+	"math"
+	"testing"
+
+	"cob"
+)
+
+// testBook has asks on two venues, kraken cheaper at the top and bybit
+// cheaper once fees are added below it, and bids on both.
+func testBook() *cob.OrderBook {
+	book := cob.NewOrderBook()
+	book.UpdateExternalLevel("kraken", "sell", 100, 1, 1)
+	book.UpdateExternalLevel("kraken", "sell", 101, 2, 1)
+	book.UpdateExternalLevel("bybit", "sell", 100.5, 1, 1)
+	book.UpdateExternalLevel("bybit", "sell", 102, 5, 1)
+	book.UpdateExternalLevel("kraken", "buy", 99, 1, 1)
+	book.UpdateExternalLevel("bybit", "buy", 99.2, 2, 1)
+	return book
+}
+
+func venues() []Venue {
+	return []Venue{
+		{Name: "kraken", Balance: 1e6, TakerFee: 0.0026},
+		{Name: "bybit", Balance: 1e6, TakerFee: 0.001},
+	}
+}
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPlan(t *testing.T) {
+	krakenExpensive := cob.NewFees()
+	krakenExpensive.SetAccountRates("kraken", 0, 0.01)
+
+	bybitMinimum := venues()
+	bybitMinimum[1].QtyMin = 2
+
+	lots := venues()
+	lots[0].QtyIncrement = 0.5
+
+	tests := []struct {
+		name     string
+		venues   []Venue
+		fees     *cob.Fees
+		request  Request
+		children []ChildOrder
+		unrouted float64
+		excluded map[string]string
+	}{
+		{
+			name:    "cheapest venue takes a small order",
+			request: Request{Side: "buy", Quantity: 0.5},
+			children: []ChildOrder{
+				{Venue: "kraken", Quantity: 0.5, LimitPrice: 100, AvgPrice: 100, Fee: 0.13, Cost: 50.13},
+			},
+		},
+		{
+			name:    "split across venues by fee adjusted price",
+			request: Request{Side: "buy", Quantity: 3},
+			children: []ChildOrder{
+				{Venue: "bybit", Quantity: 1, LimitPrice: 100.5, AvgPrice: 100.5, Fee: 0.1005, Cost: 100.6005},
+				{Venue: "kraken", Quantity: 2, LimitPrice: 101, AvgPrice: 100.5, Fee: 0.5226, Cost: 201.5226},
+			},
+		},
+		{
+			name:    "fee schedule overrides the venue fee",
+			fees:    krakenExpensive,
+			request: Request{Side: "buy", Quantity: 1},
+			children: []ChildOrder{
+				{Venue: "bybit", Quantity: 1, LimitPrice: 100.5, AvgPrice: 100.5, Fee: 0.1005, Cost: 100.6005},
+			},
+		},
+		{
+			name:    "sells take the best fee adjusted bids",
+			request: Request{Side: "sell", Quantity: 2},
+			children: []ChildOrder{
+				{Venue: "bybit", Quantity: 2, LimitPrice: 99.2, AvgPrice: 99.2, Fee: 0.1984, Cost: 198.2016},
+			},
+		},
+		{
+			name:    "depth exhaustion leaves the rest unrouted",
+			request: Request{Side: "buy", Quantity: 20},
+			children: []ChildOrder{
+				{Venue: "bybit", Quantity: 6, LimitPrice: 102, AvgPrice: 101.75, Fee: 0.6105, Cost: 611.1105},
+				{Venue: "kraken", Quantity: 3, LimitPrice: 101, AvgPrice: 302.0 / 3, Fee: 0.7852, Cost: 302.7852},
+			},
+			unrouted: 11,
+		},
+		{
+			name:    "limit price stops at the worst acceptable level",
+			request: Request{Side: "buy", Quantity: 3, LimitPrice: 100.5},
+			children: []ChildOrder{
+				{Venue: "bybit", Quantity: 1, LimitPrice: 100.5, AvgPrice: 100.5, Fee: 0.1005, Cost: 100.6005},
+				{Venue: "kraken", Quantity: 1, LimitPrice: 100, AvgPrice: 100, Fee: 0.26, Cost: 100.26},
+			},
+			unrouted: 1,
+		},
+		{
+			name:    "venue below its minimum is excluded and the rest rerouted",
+			venues:  bybitMinimum,
+			request: Request{Side: "buy", Quantity: 3},
+			children: []ChildOrder{
+				{Venue: "kraken", Quantity: 3, LimitPrice: 101, AvgPrice: 302.0 / 3, Fee: 0.7852, Cost: 302.7852},
+			},
+			excluded: map[string]string{"bybit": "quantity below venue minimum"},
+		},
+		{
+			name:    "child quantities are rounded down to the lot",
+			venues:  lots,
+			request: Request{Side: "buy", Quantity: 2.6},
+			children: []ChildOrder{
+				{Venue: "bybit", Quantity: 1, LimitPrice: 100.5, AvgPrice: 100.5, Fee: 0.1005, Cost: 100.6005},
+				{Venue: "kraken", Quantity: 1.5, LimitPrice: 101, AvgPrice: 100.375, Fee: 0.3914625, Cost: 150.9539625},
+			},
+			unrouted: 0.1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.venues == nil {
+				tt.venues = venues()
+			}
+			router := New(tt.venues...)
+			router.SetFees(tt.fees)
+
+			plan := router.Plan(testBook(), tt.request)
+
+			if len(plan.Children) != len(tt.children) {
+				t.Fatalf("children %+v", plan.Children)
+			}
+			quantity, cost := 0.0, 0.0
+			for i, child := range plan.Children {
+				expected := tt.children[i]
+				if child.Venue != expected.Venue || child.Side != tt.request.Side || !near(child.Quantity, expected.Quantity) ||
+					child.LimitPrice != expected.LimitPrice || !near(child.AvgPrice, expected.AvgPrice) ||
+					!near(child.Fee, expected.Fee) || !near(child.Cost, expected.Cost) {
+					t.Fatalf("child %d = %+v, expected %+v", i, child, expected)
+				}
+				quantity += expected.Quantity
+				cost += expected.Cost
+			}
+
+			if !near(plan.Quantity, quantity) || !near(plan.Unrouted, tt.unrouted) || !near(plan.Cost, cost) || !near(plan.AvgPrice, cost/quantity) {
+				t.Fatalf("plan = %+v", plan)
+			}
+			for venue, reason := range tt.excluded {
+				if plan.Excluded[venue] != reason {
+					t.Fatalf("excluded = %v", plan.Excluded)
+				}
+			}
+			if len(plan.Excluded) != len(tt.excluded) {
+				t.Fatalf("excluded = %v", plan.Excluded)
+			}
+		})
+	}
+}
+
+func TestPlanSkipsVenuesWithoutBalanceOrConfig(t *testing.T) {
+	book := testBook()
+	book.UpdateExternalLevel("coinbase", "sell", 99, 10, 1)
+
+	router := New(Venue{Name: "kraken", Balance: 0, TakerFee: 0.0026}, Venue{Name: "bybit", Balance: 1e6, TakerFee: 0.001})
+	plan := router.Plan(book, Request{Side: "buy", Quantity: 1})
+
+	if len(plan.Children) != 1 || plan.Children[0].Venue != "bybit" || plan.Children[0].Quantity != 1 {
+		t.Fatalf("children %+v", plan.Children)
+	}
+	if plan.Excluded["kraken"] != "no available balance" || plan.Excluded["coinbase"] != "venue not configured" {
+		t.Fatalf("excluded = %v", plan.Excluded)
+	}
+}
@@ -0,0 +1,131 @@
+package cob
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// BookSnapshot is the state of a book, enough to restore it and continue
+// with the same events. Configuration such as Policy, ProRata, hooks and sinks
+// is not part of it.
+type BookSnapshot struct {
+	Symbol    string   `json:"symbol"`
+	Sequence  uint64   `json:"sequence"` // Sequence of the last event
+	LastPrice float64  `json:"last_price"`
+	MarkPrice float64  `json:"mark_price"`
+	Bids      []*Order `json:"bids"`      // Resting orders, best price first, each level in priority
+	Asks      []*Order `json:"asks"`      // Resting orders, best price first, each level in priority
+	Stops     []*Order `json:"stops"`     // Stop orders in trigger order
+	StopSeqs  []int64  `json:"stop_seqs"` // Arrival order of each stop, breaks ties across queues
+	Expiries  []string `json:"expiries"`  // Orders with a pending expiry, in expiry order
+	StopSeq   int64    `json:"stop_seq"`
+	ExpirySeq int64    `json:"expiry_seq"`
+}
+
+// Snapshot copies the state of the book.
+func (ob *OrderBook) Snapshot() BookSnapshot {
+	snapshot := BookSnapshot{
+		Symbol:    ob.Symbol,
+		Sequence:  ob.sequence,
+		LastPrice: ob.lastPrice,
+		MarkPrice: ob.markPrice,
+		Bids:      ob.snapshotSide("buy"),
+		Asks:      ob.snapshotSide("sell"),
+		Stops:     []*Order{},
+		StopSeqs:  []int64{},
+		Expiries:  []string{},
+		StopSeq:   ob.stops.seq,
+		ExpirySeq: ob.expirySeq,
+	}
+
+	for _, entry := range ob.stops.entries() {
+		copied := *entry.order
+		snapshot.Stops = append(snapshot.Stops, &copied)
+		snapshot.StopSeqs = append(snapshot.StopSeqs, entry.seq)
+	}
+
+	// Expiries of orders filled or cancelled since they were scheduled are
+	// left out, ExpireOrders would skip them anyway.
+	inBook := make(map[string]bool)
+	for _, orders := range [][]*Order{snapshot.Bids, snapshot.Asks, snapshot.Stops} {
+		for _, order := range orders {
+			inBook[order.ID] = true
+		}
+	}
+
+	entries := append(expiryHeap{}, ob.expiries...)
+	sort.Sort(entries)
+	for _, entry := range entries {
+		if entry.order.ExpireAt.Equal(entry.expireAt) && inBook[entry.order.ID] {
+			snapshot.Expiries = append(snapshot.Expiries, entry.order.ID)
+		}
+	}
+
+	return snapshot
+}
+
+func (ob *OrderBook) snapshotSide(side string) []*Order {
+	orders := []*Order{}
+	priceLevels := ob.priceLevels(side)
+	for _, price := range ob.Prices(side) {
+		for _, order := range priceLevels[price].SortedOrders() {
+			copied := *order
+			orders = append(orders, &copied)
+		}
+	}
+	return orders
+}
+
+// Restore replaces the state of the book with snapshot, without emitting
+// events. The next event continues the sequence of the snapshot.
+func (ob *OrderBook) Restore(snapshot BookSnapshot) {
+	ob.Symbol = snapshot.Symbol
+	ob.Bids = make(map[float64]*PriceLevel)
+	ob.Asks = make(map[float64]*PriceLevel)
+	ob.stops = stopIndex{}
+	ob.expiries = expiryHeap{}
+	ob.sequence = snapshot.Sequence
+	ob.lastPrice = snapshot.LastPrice
+	ob.markPrice = snapshot.MarkPrice
+
+	orders := make(map[string]*Order)
+	for _, side := range [][]*Order{snapshot.Bids, snapshot.Asks} {
+		for _, saved := range side {
+			order := *saved
+			ob.restoreResting(&order)
+			orders[order.ID] = &order
+		}
+	}
+	for i, saved := range snapshot.Stops {
+		order := *saved
+		if i < len(snapshot.StopSeqs) {
+			ob.stops.insert(&stopEntry{order: &order, seq: snapshot.StopSeqs[i]})
+		} else {
+			ob.stops.add(&order) // Snapshot from before StopSeqs
+		}
+		orders[order.ID] = &order
+	}
+	ob.stops.seq = snapshot.StopSeq
+
+	for _, orderID := range snapshot.Expiries {
+		if order, ok := orders[orderID]; ok {
+			ob.scheduleExpiry(order)
+		}
+	}
+	ob.expirySeq = snapshot.ExpirySeq
+}
+
+// restoreResting puts an order back in its level as it was, hidden reserve
+// included, where PlaceOrder would show a fresh display quantity.
+func (ob *OrderBook) restoreResting(order *Order) {
+	priceLevels := ob.priceLevels(order.Side)
+	pl, exists := priceLevels[order.Price]
+	if !exists {
+		pl = &PriceLevel{Price: order.Price, Orders: &OrderQueue{}, Policy: ob.Policy}
+		priceLevels[order.Price] = pl
+	}
+
+	heap.Push(pl.queue(), order)
+	pl.TotalQuantity += order.Quantity
+	pl.HiddenQuantity += order.Hidden
+}
@@ -0,0 +1,266 @@
+package cob
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Stop order types, they become market and limit orders once triggered.
+const (
+	StopOrder      = "stop"
+	StopLimitOrder = "stop_limit"
+)
+
+// Stop triggers.
+const (
+	LastPriceTrigger = "last"  // Last trade price, in the book or on an external venue
+	MarkPriceTrigger = "mark"  // Mark price set with SetMarkPrice
+	QuoteTrigger     = "quote" // Best ask for buy stops, best bid for sell stops
+)
+
+var stopTriggers = []string{LastPriceTrigger, MarkPriceTrigger, QuoteTrigger}
+
+func (o *Order) IsStop() bool {
+	return o.Type == StopOrder || o.Type == StopLimitOrder
+}
+
+type stopEntry struct {
+	order *Order
+	seq   int64 // Arrival order, breaks ties of equal timestamps
+}
+
+// stopIndex holds stop orders off-book, one queue per trigger and side. Buy
+// queues are sorted by ascending and sell queues by descending stop price,
+// then by time, so the stops a price move crosses always form a prefix.
+type stopIndex struct {
+	queues map[string][]*stopEntry
+	seq    int64
+}
+
+func stopQueueKey(trigger string, side string) string {
+	return trigger + ":" + side
+}
+
+func (si *stopIndex) add(order *Order) {
+	si.seq++
+	si.insert(&stopEntry{order: order, seq: si.seq})
+}
+
+// insert queues entry in trigger order under the seq it already has.
+func (si *stopIndex) insert(entry *stopEntry) {
+	if si.queues == nil {
+		si.queues = make(map[string][]*stopEntry)
+	}
+
+	key := stopQueueKey(entry.order.Trigger, entry.order.Side)
+	queue := si.queues[key]
+
+	i := sort.Search(len(queue), func(i int) bool { return stopBefore(entry, queue[i]) })
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = entry
+	si.queues[key] = queue
+}
+
+// stopBefore reports whether a triggers before b: better stop price first,
+// then older.
+func stopBefore(a *stopEntry, b *stopEntry) bool {
+	if a.order.StopPrice != b.order.StopPrice {
+		if a.order.Side == "buy" {
+			return a.order.StopPrice < b.order.StopPrice
+		}
+		return a.order.StopPrice > b.order.StopPrice
+	}
+	return olderStop(a, b)
+}
+
+func (si *stopIndex) remove(orderID string) *Order {
+	for key, queue := range si.queues {
+		for i, entry := range queue {
+			if entry.order.ID == orderID {
+				si.queues[key] = append(queue[:i], queue[i+1:]...)
+				return entry.order
+			}
+		}
+	}
+	return nil
+}
+
+// take removes and returns the stops reached by the reference prices. Stops
+// of one side trigger in price/time priority across all triggers, better stop
+// price first; buy and sell stops are merged by time.
+func (si *stopIndex) take(reference func(trigger string, side string) float64) []*Order {
+	triggered := map[string][]*stopEntry{}
+
+	for _, trigger := range stopTriggers {
+		for _, side := range []string{"buy", "sell"} {
+			key := stopQueueKey(trigger, side)
+			queue := si.queues[key]
+			price := reference(trigger, side)
+			if len(queue) == 0 || price <= 0 {
+				continue
+			}
+
+			n := 0
+			for n < len(queue) && stopReached(queue[n].order, price) {
+				n++
+			}
+			triggered[side] = append(triggered[side], queue[:n]...)
+			si.queues[key] = queue[n:]
+		}
+	}
+
+	buys, sells := triggered["buy"], triggered["sell"]
+	sort.Slice(buys, func(i, j int) bool { return stopBefore(buys[i], buys[j]) })
+	sort.Slice(sells, func(i, j int) bool { return stopBefore(sells[i], sells[j]) })
+
+	orders := make([]*Order, 0, len(buys)+len(sells))
+	for len(buys) > 0 || len(sells) > 0 {
+		if len(sells) == 0 || (len(buys) > 0 && olderStop(buys[0], sells[0])) {
+			orders = append(orders, buys[0].order)
+			buys = buys[1:]
+		} else {
+			orders = append(orders, sells[0].order)
+			sells = sells[1:]
+		}
+	}
+
+	return orders
+}
+
+// olderStop reports whether a was placed before b.
+func olderStop(a *stopEntry, b *stopEntry) bool {
+	if a.order.Timestamp != b.order.Timestamp {
+		return a.order.Timestamp < b.order.Timestamp
+	}
+	return a.seq < b.seq
+}
+
+// stopReached reports whether price reached the stop: at or above it for buy
+// stops, at or below it for sell stops.
+func stopReached(order *Order, price float64) bool {
+	if order.Side == "buy" {
+		return price >= order.StopPrice
+	}
+	return price <= order.StopPrice
+}
+
+// StopOrders returns the orders waiting for their trigger.
+func (ob *OrderBook) StopOrders() []*Order {
+	orders := []*Order{}
+	for _, entry := range ob.stops.entries() {
+		orders = append(orders, entry.order)
+	}
+	return orders
+}
+
+// entries returns the queued stops, queue by queue.
+func (si *stopIndex) entries() []*stopEntry {
+	entries := []*stopEntry{}
+	for _, trigger := range stopTriggers {
+		for _, side := range []string{"buy", "sell"} {
+			entries = append(entries, si.queues[stopQueueKey(trigger, side)]...)
+		}
+	}
+	return entries
+}
+
+// addStop validates a stop as the order it becomes once triggered and holds
+// it until then.
+func (ob *OrderBook) addStop(order *Order) error {
+	if order.StopPrice <= 0 {
+		return fmt.Errorf("%w: stop orders need a stop price", ErrInvalidOrder)
+	}
+	activated := *order
+	activated.Type = activatedType(order)
+	if err := validateOrder(&activated); err != nil {
+		return err
+	}
+	if order.PostOnly {
+		return fmt.Errorf("%w: stop orders can not be post-only", ErrInvalidOrder)
+	}
+	if order.IsIceberg() && order.Type != StopLimitOrder {
+		return fmt.Errorf("%w: only stop-limit orders can be icebergs", ErrInvalidOrder)
+	}
+
+	switch order.Trigger {
+	case "":
+		order.Trigger = LastPriceTrigger
+	case LastPriceTrigger, MarkPriceTrigger, QuoteTrigger:
+	default:
+		return fmt.Errorf("%w: stop trigger %q", ErrInvalidOrder, order.Trigger)
+	}
+
+	if err := ob.prepareExpiry(order); err != nil {
+		return err
+	}
+
+	ob.stops.add(order)
+	ob.scheduleExpiry(order)
+	return nil
+}
+
+// activatedType is the type a stop order takes once triggered.
+func activatedType(order *Order) string {
+	if order.Type == StopOrder {
+		return MarketOrder
+	}
+	return LimitOrder
+}
+
+func (ob *OrderBook) stopReference(trigger string, side string) float64 {
+	switch trigger {
+	case LastPriceTrigger:
+		return ob.lastPrice
+	case MarkPriceTrigger:
+		return ob.markPrice
+	default:
+		prices := ob.Prices(oppositeSide(side))
+		if len(prices) == 0 {
+			return 0
+		}
+		return prices[0]
+	}
+}
+
+// SetLastPrice records a trade price printed on an external venue and
+// triggers the stops it reaches.
+func (ob *OrderBook) SetLastPrice(price float64) []Fill {
+	ob.lastPrice = price
+	return ob.TriggerStops()
+}
+
+// SetMarkPrice updates the mark price and triggers the stops it reaches.
+func (ob *OrderBook) SetMarkPrice(price float64) []Fill {
+	ob.markPrice = price
+	return ob.TriggerStops()
+}
+
+// TriggerStops activates every stop reached by the current prices and sends
+// it through the normal matching path, stop orders as market orders and
+// stop-limit orders as limit orders. Trades of activated orders may reach
+// further stops, which are processed in turn until none is left. External
+// book updates call it for the quote trigger.
+//
+// Activated orders that the matching engine rejects, e.g. fill-or-kill
+// orders without enough liquidity, are dropped.
+func (ob *OrderBook) TriggerStops() []Fill {
+	fills := []Fill{}
+
+	for {
+		triggered := ob.stops.take(ob.stopReference)
+		if len(triggered) == 0 {
+			return fills
+		}
+
+		for _, order := range triggered {
+			order.Type = activatedType(order)
+
+			if err := ob.check(order); err != nil {
+				ob.cancelled(order, err.Error(), order.Quantity)
+				continue
+			}
+			fills = append(fills, ob.match(order)...)
+		}
+	}
+}
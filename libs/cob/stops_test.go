@@ -0,0 +1,110 @@
+package cob
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTakeSortsTriggeredStopsAcrossTriggers(t *testing.T) {
+	si := &stopIndex{}
+	for _, order := range []*Order{
+		{ID: "last-buy-100", Side: "buy", StopPrice: 100, Timestamp: 1, Trigger: LastPriceTrigger},
+		{ID: "mark-buy-99", Side: "buy", StopPrice: 99, Timestamp: 2, Trigger: MarkPriceTrigger},
+		{ID: "mark-sell-105", Side: "sell", StopPrice: 105, Timestamp: 3, Trigger: MarkPriceTrigger},
+		{ID: "quote-buy-99", Side: "buy", StopPrice: 99, Timestamp: 4, Trigger: QuoteTrigger},
+		{ID: "last-buy-101", Side: "buy", StopPrice: 101, Timestamp: 0, Trigger: LastPriceTrigger},
+	} {
+		si.add(order)
+	}
+
+	reference := func(trigger string, side string) float64 {
+		if side == "sell" {
+			return 104
+		}
+		return 100
+	}
+
+	ids := []string{}
+	for _, order := range si.take(reference) {
+		ids = append(ids, order.ID)
+	}
+
+	// Buys in price/time priority whatever their trigger, the sell merged in
+	// by time.
+	expected := []string{"mark-buy-99", "mark-sell-105", "quote-buy-99", "last-buy-100"}
+	if len(ids) != len(expected) {
+		t.Fatalf("triggered = %v", ids)
+	}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Fatalf("triggered = %v, expected %v", ids, expected)
+		}
+	}
+	if stops := len(si.queues[stopQueueKey(LastPriceTrigger, "buy")]); stops != 1 {
+		t.Fatalf("%d last price buy stops left", stops)
+	}
+}
+
+func TestRestoredStopsKeepTheirArrivalOrder(t *testing.T) {
+	ob := NewOrderBook()
+	for _, order := range []*Order{
+		{ID: "mark-sell", Side: "sell", Type: StopOrder, Quantity: 1, StopPrice: 99, Timestamp: 5, Trigger: MarkPriceTrigger, Provider: "local"},
+		{ID: "last-sell", Side: "sell", Type: StopOrder, Quantity: 1, StopPrice: 99, Timestamp: 5, Trigger: LastPriceTrigger, Provider: "local"},
+	} {
+		if _, err := ob.ProcessOrder(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored := NewOrderBook()
+	restored.Restore(ob.Snapshot())
+
+	reference := func(trigger string, side string) float64 { return 99 }
+	for _, book := range []*OrderBook{ob, restored} {
+		ids := []string{}
+		for _, order := range book.stops.take(reference) {
+			ids = append(ids, order.ID)
+		}
+		if len(ids) != 2 || ids[0] != "mark-sell" || ids[1] != "last-sell" {
+			t.Fatalf("triggered = %v", ids)
+		}
+	}
+}
+
+func TestStopIsValidatedWhenPlaced(t *testing.T) {
+	ob := NewOrderBook()
+
+	for _, order := range []*Order{
+		{ID: "s1", Side: "buy", Type: StopLimitOrder, Price: 100, Quantity: 1, StopPrice: 101, TimeInForce: "never"},
+		{ID: "s2", Side: "buy", Type: StopOrder, Quantity: 1, StopPrice: 101, SelfTradePrevention: "unknown"},
+		{ID: "s3", Side: "sell", Type: StopLimitOrder, Quantity: 1, StopPrice: 99},
+	} {
+		if _, err := ob.ProcessOrder(order); !errors.Is(err, ErrInvalidOrder) {
+			t.Fatalf("%s: err = %v", order.ID, err)
+		}
+	}
+	if stops := ob.StopOrders(); len(stops) != 0 {
+		t.Fatalf("stops = %+v", stops)
+	}
+}
+
+func TestExternalQuoteTriggersStops(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.UpdateExternalLevel("kraken", "sell", 100, 2, 1)
+	ob.UpdateExternalLevel("kraken", "sell", 102, 2, 1)
+
+	stop := &Order{ID: "s1", Side: "buy", Type: StopOrder, Quantity: 1, StopPrice: 101, Trigger: QuoteTrigger, Provider: "local"}
+	if fills, err := ob.ProcessOrder(stop); err != nil || len(fills) != 0 {
+		t.Fatalf("fills = %+v, err = %v", fills, err)
+	}
+
+	// The best ask moves up through the stop price.
+	fills := ob.UpdateExternalLevel("kraken", "sell", 100, 0, 2)
+	if len(fills) != 1 || fills[0].TakerOrderID != "s1" || fills[0].Price != 102 || fills[0].Quantity != 1 {
+		t.Fatalf("fills = %+v", fills)
+	}
+	if stops := ob.StopOrders(); len(stops) != 0 {
+		t.Fatalf("stops = %+v", stops)
+	}
+}
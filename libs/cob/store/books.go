@@ -0,0 +1,261 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type BookLevel struct {
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+}
+
+// Book is the consolidated book of a symbol at a point in time, best prices
+// first. Sequence is that of the last level change included.
+type Book struct {
+	Symbol   string      `json:"symbol"`
+	Time     time.Time   `json:"time"`
+	Sequence int64       `json:"sequence"`
+	Bids     []BookLevel `json:"bids"`
+	Asks     []BookLevel `json:"asks"`
+}
+
+// VenueTop is the best bid and ask of an external venue, zero prices for an
+// empty side.
+type VenueTop struct {
+	Venue       string    `db:"venue" json:"venue"`
+	Time        time.Time `db:"time" json:"time"`
+	BidPrice    float64   `db:"bid_price" json:"bid_price"`
+	BidQuantity float64   `db:"bid_quantity" json:"bid_quantity"`
+	AskPrice    float64   `db:"ask_price" json:"ask_price"`
+	AskQuantity float64   `db:"ask_quantity" json:"ask_quantity"`
+}
+
+// bookPoint orders snapshots and level changes. Sequences restart with an
+// engine that is not recovered from its journal, times break the tie.
+type bookPoint struct {
+	time     time.Time
+	sequence int64
+}
+
+func (bp bookPoint) after(other bookPoint) bool {
+	if !bp.time.Equal(other.time) {
+		return bp.time.After(other.time)
+	}
+	return bp.sequence > other.sequence
+}
+
+type levelChange struct {
+	bookPoint
+	side     string
+	price    float64
+	quantity float64
+}
+
+// bookHistory reads the recorded snapshots and level changes BookSeries
+// rebuilds books from.
+type bookHistory interface {
+	snapshotPoints(ctx context.Context, symbol string, from time.Time, to time.Time) ([]bookPoint, error)
+	snapshotLevels(ctx context.Context, symbol string, snapshot bookPoint) (map[string]map[float64]float64, error)
+	levelChanges(ctx context.Context, symbol string, since bookPoint, to time.Time) ([]levelChange, error)
+}
+
+// pgBookHistory is the book history of the database.
+type pgBookHistory struct {
+	pool *pgxpool.Pool
+}
+
+// BookAt rebuilds the consolidated book of symbol at a point in time from the
+// latest snapshot before it and the level changes since, with up to levels
+// per side, all of them when zero. It returns ErrNotFound when no snapshot
+// was recorded before at.
+func (s *Store) BookAt(ctx context.Context, symbol string, at time.Time, levels int) (Book, error) {
+	books, err := s.BookSeries(ctx, symbol, at, at, 0, levels)
+	if err != nil {
+		return Book{}, err
+	}
+	return books[0], nil
+}
+
+// BookSeries rebuilds the consolidated book of symbol at from and then every
+// step until to, e.g. to study how liquidity evolved around a large fill. A
+// step of zero or less only rebuilds the book at from.
+func (s *Store) BookSeries(ctx context.Context, symbol string, from time.Time, to time.Time, step time.Duration, levels int) ([]Book, error) {
+	if to.Before(from) {
+		to = from
+	}
+
+	snapshots, err := s.history.snapshotPoints(ctx, symbol, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, ErrNotFound
+	}
+
+	changes, err := s.history.levelChanges(ctx, symbol, snapshots[0], to)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []Book{}
+	var base bookPoint
+	var current Book
+	var sides map[string]map[float64]float64
+	next := 0 // First change not applied yet
+
+	for at := from; !at.After(to); at = at.Add(step) {
+		// Start over from the latest snapshot up to at, if it is newer.
+		latest := -1
+		for i, snapshot := range snapshots {
+			if !snapshot.time.After(at) {
+				latest = i
+			}
+		}
+		if sides == nil || snapshots[latest].after(base) {
+			base = snapshots[latest]
+			if sides, err = s.history.snapshotLevels(ctx, symbol, base); err != nil {
+				return nil, err
+			}
+			current = Book{Symbol: symbol, Sequence: base.sequence}
+			for next < len(changes) && !changes[next].after(base) {
+				next++
+			}
+		}
+
+		for next < len(changes) && !changes[next].time.After(at) {
+			change := changes[next]
+			if change.quantity > 0 {
+				sides[change.side][change.price] = change.quantity
+			} else {
+				delete(sides[change.side], change.price)
+			}
+			current.Sequence = change.sequence
+			next++
+		}
+
+		current.Time = at
+		current.Bids = sortedLevels(sides["buy"], "buy", levels)
+		current.Asks = sortedLevels(sides["sell"], "sell", levels)
+		books = append(books, current)
+
+		if step <= 0 {
+			break
+		}
+	}
+
+	return books, nil
+}
+
+// snapshotPoints returns the latest snapshot up to from and those after it up
+// to to, in order.
+func (h pgBookHistory) snapshotPoints(ctx context.Context, symbol string, from time.Time, to time.Time) ([]bookPoint, error) {
+	rows, err := h.pool.Query(ctx, `(SELECT time, sequence FROM book_snapshots
+			WHERE symbol = $1 AND time <= $2 ORDER BY time DESC, sequence DESC LIMIT 1)
+		UNION ALL
+		(SELECT time, sequence FROM book_snapshots WHERE symbol = $1 AND time > $2 AND time <= $3)
+		ORDER BY time, sequence`, symbol, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	points := []bookPoint{}
+	var point bookPoint
+	_, err = pgx.ForEachRow(rows, []any{&point.time, &point.sequence}, func() error {
+		points = append(points, point)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(points) > 0 && points[0].time.After(from) {
+		return nil, nil // Nothing recorded before from
+	}
+
+	return points, nil
+}
+
+func (h pgBookHistory) snapshotLevels(ctx context.Context, symbol string, snapshot bookPoint) (map[string]map[float64]float64, error) {
+	rows, err := h.pool.Query(ctx, `SELECT side, price, quantity FROM book_snapshot_levels
+		WHERE symbol = $1 AND time = $2 AND sequence = $3`, symbol, snapshot.time, snapshot.sequence)
+	if err != nil {
+		return nil, err
+	}
+
+	sides := map[string]map[float64]float64{"buy": {}, "sell": {}}
+	var side string
+	var price, quantity float64
+	_, err = pgx.ForEachRow(rows, []any{&side, &price, &quantity}, func() error {
+		sides[side][price] = quantity
+		return nil
+	})
+
+	return sides, err
+}
+
+// levelChanges returns the level changes after since up to to, in order.
+func (h pgBookHistory) levelChanges(ctx context.Context, symbol string, since bookPoint, to time.Time) ([]levelChange, error) {
+	rows, err := h.pool.Query(ctx, `SELECT time, sequence, side, price, quantity FROM book_level_changes
+		WHERE symbol = $1 AND time >= $2 AND time <= $4 AND (time > $2 OR sequence > $3)
+		ORDER BY time, sequence`, symbol, since.time, since.sequence, to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []levelChange{}
+	var change levelChange
+	_, err = pgx.ForEachRow(rows, []any{&change.time, &change.sequence, &change.side, &change.price, &change.quantity}, func() error {
+		changes = append(changes, change)
+		return nil
+	})
+
+	return changes, err
+}
+
+// VenueTops returns the top of book of every venue at from and its changes
+// up to to, in time order.
+func (s *Store) VenueTops(ctx context.Context, symbol string, from time.Time, to time.Time) ([]VenueTop, error) {
+	rows, _ := s.pool.Query(ctx, `SELECT venue, time, bid_price, bid_quantity, ask_price, ask_quantity FROM (
+			SELECT DISTINCT ON (venue) * FROM venue_tops
+			WHERE symbol = $1 AND time <= $2 ORDER BY venue, time DESC
+		) initial
+		UNION ALL
+		SELECT venue, time, bid_price, bid_quantity, ask_price, ask_quantity FROM venue_tops
+		WHERE symbol = $1 AND time > $2 AND time <= $3
+		ORDER BY time, venue`, symbol, from, to)
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[VenueTop])
+}
+
+// LargeFills returns the fills of symbol of at least quantity in [from, to),
+// the points in time worth rebuilding the book around.
+func (s *Store) LargeFills(ctx context.Context, symbol string, quantity float64, from time.Time, to time.Time) ([]Fill, error) {
+	rows, _ := s.pool.Query(ctx, `SELECT `+fillColumns+` FROM fills f
+		WHERE f.symbol = $1 AND f.quantity >= $2 AND f.time >= $3 AND f.time < $4
+		ORDER BY f.time, f.sequence`, symbol, quantity, from, to)
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Fill])
+}
+
+func sortedLevels(quantities map[float64]float64, side string, limit int) []BookLevel {
+	levels := make([]BookLevel, 0, len(quantities))
+	for price, quantity := range quantities {
+		levels = append(levels, BookLevel{Price: price, Quantity: quantity})
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		if side == "buy" {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+
+	if limit > 0 && len(levels) > limit {
+		levels = levels[:limit]
+	}
+	return levels
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cob"
+)
+
+// fakeHistory answers the book history queries as the database would from
+// what it holds.
+type fakeHistory struct {
+	snapshots map[bookPoint]map[string]map[float64]float64
+	changes   []levelChange
+	loaded    []bookPoint // Snapshots whose levels were read
+}
+
+func (h *fakeHistory) snapshotPoints(ctx context.Context, symbol string, from time.Time, to time.Time) ([]bookPoint, error) {
+	points := []bookPoint{}
+	var initial *bookPoint
+	for point := range h.snapshots {
+		switch {
+		case !point.time.After(from):
+			if initial == nil || point.after(*initial) {
+				latest := point
+				initial = &latest
+			}
+		case !point.time.After(to):
+			points = append(points, point)
+		}
+	}
+	if initial == nil {
+		return nil, nil
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[j].after(points[i]) })
+	return append([]bookPoint{*initial}, points...), nil
+}
+
+func (h *fakeHistory) snapshotLevels(ctx context.Context, symbol string, snapshot bookPoint) (map[string]map[float64]float64, error) {
+	h.loaded = append(h.loaded, snapshot)
+
+	sides := map[string]map[float64]float64{"buy": {}, "sell": {}}
+	for side, levels := range h.snapshots[snapshot] {
+		for price, quantity := range levels {
+			sides[side][price] = quantity
+		}
+	}
+	return sides, nil
+}
+
+func (h *fakeHistory) levelChanges(ctx context.Context, symbol string, since bookPoint, to time.Time) ([]levelChange, error) {
+	changes := []levelChange{}
+	for _, change := range h.changes {
+		if change.after(since) && !change.time.After(to) {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[j].after(changes[i].bookPoint) })
+	return changes, nil
+}
+
+func second(s int) time.Time {
+	return eventTime.Add(time.Duration(s) * time.Second)
+}
+
+func point(s int, sequence int64) bookPoint {
+	return bookPoint{time: second(s), sequence: sequence}
+}
+
+func change(s int, sequence int64, side string, price float64, quantity float64) levelChange {
+	return levelChange{bookPoint: point(s, sequence), side: side, price: price, quantity: quantity}
+}
+
+// bookLevels pairs up prices and quantities.
+func bookLevels(levels ...float64) []BookLevel {
+	book := []BookLevel{}
+	for i := 0; i < len(levels); i += 2 {
+		book = append(book, BookLevel{Price: levels[i], Quantity: levels[i+1]})
+	}
+	return book
+}
+
+func TestBookAtStartsFromTheLatestSnapshot(t *testing.T) {
+	history := &fakeHistory{
+		snapshots: map[bookPoint]map[string]map[float64]float64{
+			point(0, 1):  {"buy": {100: 1}},
+			point(10, 5): {"buy": {100: 2}},
+			point(20, 9): {"buy": {100: 3}},
+		},
+		changes: []levelChange{
+			change(5, 3, "buy", 99, 1),
+			change(12, 6, "buy", 98, 4),
+			change(16, 7, "buy", 97, 5),
+		},
+	}
+	s := &Store{history: history}
+
+	book, err := s.BookAt(context.Background(), "BTC/USD", second(15), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history.loaded, []bookPoint{point(10, 5)}) {
+		t.Fatalf("snapshots loaded %+v", history.loaded)
+	}
+	if !reflect.DeepEqual(book.Bids, bookLevels(100, 2, 98, 4)) || book.Sequence != 6 || !book.Time.Equal(second(15)) {
+		t.Fatalf("book %+v", book)
+	}
+
+	if _, err := s.BookAt(context.Background(), "BTC/USD", second(-1), 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestBookSeriesSkipsChangesInTheSnapshot(t *testing.T) {
+	history := &fakeHistory{
+		snapshots: map[bookPoint]map[string]map[float64]float64{
+			point(0, 1):  {"buy": {100: 1}},
+			point(10, 5): {"buy": {100: 1, 98: 2}},
+		},
+		changes: []levelChange{
+			change(3, 2, "buy", 99, 1),
+			change(8, 3, "buy", 99, 0),
+			change(10, 4, "buy", 98, 9), // In the snapshot, superseded since
+			change(10, 6, "buy", 97, 3),
+		},
+	}
+	s := &Store{history: history}
+
+	books, err := s.BookSeries(context.Background(), "BTC/USD", second(5), second(15), 5*time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]BookLevel{bookLevels(100, 1, 99, 1), bookLevels(100, 1, 98, 2, 97, 3), bookLevels(100, 1, 98, 2, 97, 3)}
+	if len(books) != len(expected) {
+		t.Fatalf("%d books", len(books))
+	}
+	for i, book := range books {
+		if !reflect.DeepEqual(book.Bids, expected[i]) {
+			t.Fatalf("book %d: bids %+v", i, book.Bids)
+		}
+	}
+	if !reflect.DeepEqual(history.loaded, []bookPoint{point(0, 1), point(10, 5)}) {
+		t.Fatalf("snapshots loaded %+v", history.loaded)
+	}
+}
+
+func TestBookPointsOrderByTimeThenSequence(t *testing.T) {
+	tests := []struct {
+		point, other bookPoint
+		after        bool
+	}{
+		{point(10, 6), point(10, 5), true},
+		{point(10, 5), point(10, 5), false},
+		{point(10, 4), point(10, 5), false},
+		{point(11, 1), point(10, 100), true}, // Sequences restarted with the engine
+		{point(9, 100), point(10, 1), false},
+	}
+	for _, test := range tests {
+		if test.point.after(test.other) != test.after {
+			t.Fatalf("%+v after %+v: %v", test.point, test.other, !test.after)
+		}
+	}
+
+	// An engine restarted without its journal numbers its changes from 1
+	// again, after the snapshot of the previous one.
+	history := &fakeHistory{
+		snapshots: map[bookPoint]map[string]map[float64]float64{
+			point(10, 100): {"buy": {100: 1}},
+		},
+		changes: []levelChange{
+			change(10, 99, "buy", 99, 1),
+			change(10, 101, "buy", 98, 1),
+			change(11, 1, "buy", 97, 1),
+		},
+	}
+	book, err := (&Store{history: history}).BookAt(context.Background(), "BTC/USD", second(12), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(book.Bids, bookLevels(100, 1, 98, 1, 97, 1)) || book.Sequence != 1 {
+		t.Fatalf("book %+v", book)
+	}
+}
+
+func TestDepthLevelsTruncateTheBook(t *testing.T) {
+	history := &fakeHistory{
+		snapshots: map[bookPoint]map[string]map[float64]float64{
+			point(0, 1): {"buy": {100: 1, 99: 2, 98: 3}, "sell": {103: 3, 101: 1, 102: 2}},
+		},
+	}
+	book, err := (&Store{history: history}).BookAt(context.Background(), "BTC/USD", second(1), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(book.Bids, bookLevels(100, 1, 99, 2)) || !reflect.DeepEqual(book.Asks, bookLevels(101, 1, 102, 2)) {
+		t.Fatalf("book %+v", book)
+	}
+
+	// Recorded snapshots keep DepthLevels per side.
+	ob := cob.NewOrderBook()
+	for id, price := range map[string]float64{"o1": 100, "o2": 99, "o3": 98} {
+		ob.PlaceOrder(&cob.Order{ID: id, Side: "buy", Price: price, Quantity: 1, Provider: "local"})
+	}
+	recorder := NewRecorder(New(nil, Config{}), ob, &sync.Mutex{}, RecorderConfig{DepthLevels: 2})
+	snapshot, _ := recorder.capture()
+	if !reflect.DeepEqual(snapshot.Bids, bookLevels(100, 1, 99, 1)) || snapshot.levelLimit != 2 {
+		t.Fatalf("snapshot %+v, limit %d", snapshot.Book, snapshot.levelLimit)
+	}
+}
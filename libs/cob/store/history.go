@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Order is the latest state of a customer order.
+type Order struct {
+	Symbol       string    `db:"symbol" json:"symbol"`
+	Run          string    `db:"run" json:"run"`
+	OrderID      string    `db:"order_id" json:"order_id"`
+	Account      string    `db:"account" json:"account"`
+	Side         string    `db:"side" json:"side"`
+	Type         string    `db:"type" json:"type"`
+	TimeInForce  string    `db:"time_in_force" json:"time_in_force"`
+	Price        float64   `db:"price" json:"price"`
+	StopPrice    float64   `db:"stop_price" json:"stop_price"`
+	Quantity     float64   `db:"quantity" json:"quantity"`
+	Filled       float64   `db:"filled" json:"filled"`
+	Remaining    float64   `db:"remaining" json:"remaining"`
+	AvgPrice     float64   `db:"avg_price" json:"avg_price"`
+	Status       string    `db:"status" json:"status"`
+	Reason       string    `db:"reason" json:"reason"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+	LastSequence int64     `db:"last_sequence" json:"last_sequence"`
+}
+
+// Transition is an engine event that changed an order, with the status it
+// left the order in.
+type Transition struct {
+	Sequence int64     `db:"sequence" json:"sequence"`
+	Type     string    `db:"type" json:"type"`
+	Status   string    `db:"status" json:"status"`
+	Price    float64   `db:"price" json:"price"`
+	Quantity float64   `db:"quantity" json:"quantity"`
+	Reason   string    `db:"reason" json:"reason"`
+	Time     time.Time `db:"time" json:"time"`
+}
+
+type Fill struct {
+	Symbol       string    `db:"symbol" json:"symbol"`
+	Run          string    `db:"run" json:"run"`
+	Sequence     int64     `db:"sequence" json:"sequence"`
+	TakerOrderID string    `db:"taker_order_id" json:"taker_order_id"`
+	MakerOrderID string    `db:"maker_order_id" json:"maker_order_id"`
+	Side         string    `db:"side" json:"side"`
+	Price        float64   `db:"price" json:"price"`
+	Quantity     float64   `db:"quantity" json:"quantity"`
+	Liquidity    string    `db:"liquidity" json:"liquidity"`
+	Provider     string    `db:"provider" json:"provider"`
+	Time         time.Time `db:"time" json:"time"`
+}
+
+// HedgeOrder is a child order sent to a venue to hedge a fill.
+type HedgeOrder struct {
+	ClientOrderID string    `db:"client_order_id" json:"client_order_id"`
+	Venue         string    `db:"venue" json:"venue"`
+	OrderID       string    `db:"order_id" json:"order_id"`
+	Symbol        string    `db:"symbol" json:"symbol"`
+	TakerOrderID  string    `db:"taker_order_id" json:"taker_order_id"`
+	MakerOrderID  string    `db:"maker_order_id" json:"maker_order_id"`
+	Attempt       int       `db:"attempt" json:"attempt"`
+	Side          string    `db:"side" json:"side"`
+	Quantity      float64   `db:"quantity" json:"quantity"`
+	LimitPrice    float64   `db:"limit_price" json:"limit_price"`
+	Filled        float64   `db:"filled" json:"filled"`
+	AvgPrice      float64   `db:"avg_price" json:"avg_price"`
+	Fee           float64   `db:"fee" json:"fee"`
+	Status        string    `db:"status" json:"status"`
+	Reason        string    `db:"reason" json:"reason"`
+	RecordedAt    time.Time `db:"recorded_at" json:"recorded_at"`
+}
+
+const fillColumns = `f.symbol, f.run, f.sequence, f.taker_order_id, f.maker_order_id, f.side, f.price,
+	f.quantity, f.liquidity, f.provider, f.time`
+
+// Order returns the latest state of the last order of an ID, ErrNotFound
+// when it was never stored.
+func (s *Store) Order(ctx context.Context, symbol string, orderID string) (Order, error) {
+	rows, _ := s.pool.Query(ctx, `SELECT symbol, run, order_id, account, side, type, time_in_force,
+		price, stop_price, quantity, filled, remaining, avg_price, status, reason, created_at,
+		updated_at, last_sequence FROM orders WHERE symbol = $1 AND order_id = $2
+		ORDER BY created_at DESC, accept_sequence DESC LIMIT 1`, symbol, orderID)
+
+	order, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[Order])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Order{}, ErrNotFound
+	}
+	return order, err
+}
+
+// AccountOrders returns the orders an account created in [from, to), oldest
+// first.
+func (s *Store) AccountOrders(ctx context.Context, account string, from time.Time, to time.Time) ([]Order, error) {
+	rows, _ := s.pool.Query(ctx, `SELECT symbol, run, order_id, account, side, type, time_in_force,
+		price, stop_price, quantity, filled, remaining, avg_price, status, reason, created_at,
+		updated_at, last_sequence FROM orders WHERE account = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at, symbol, order_id, accept_sequence`, account, from, to)
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Order])
+}
+
+// Transitions returns the status transitions of the orders of an ID in
+// time and sequence order.
+func (s *Store) Transitions(ctx context.Context, symbol string, orderID string) ([]Transition, error) {
+	rows, _ := s.pool.Query(ctx, `SELECT sequence, type, status, price, quantity, reason, time
+		FROM order_events WHERE symbol = $1 AND order_id = $2 ORDER BY time, sequence`, symbol, orderID)
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Transition])
+}
+
+// OrderFills returns the fills of the orders of an ID as taker or maker.
+func (s *Store) OrderFills(ctx context.Context, symbol string, orderID string) ([]Fill, error) {
+	rows, _ := s.pool.Query(ctx, `SELECT `+fillColumns+` FROM fills f
+		WHERE f.symbol = $1 AND (f.taker_order_id = $2 OR f.maker_order_id = $2)
+		ORDER BY f.time, f.sequence`, symbol, orderID)
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Fill])
+}
+
+// AccountFills returns the fills of the orders of an account in [from, to),
+// oldest first. An internal fill between two orders of the account is
+// returned once.
+func (s *Store) AccountFills(ctx context.Context, account string, from time.Time, to time.Time) ([]Fill, error) {
+	rows, _ := s.pool.Query(ctx, `SELECT `+fillColumns+` FROM fills f
+		WHERE f.time >= $2 AND f.time < $3 AND EXISTS (
+			SELECT 1 FROM orders o WHERE o.symbol = f.symbol AND o.run = f.run AND o.account = $1
+				AND o.order_id IN (f.taker_order_id, f.maker_order_id))
+		ORDER BY f.time, f.symbol, f.sequence`, account, from, to)
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Fill])
+}
+
+// HedgeOrders returns the child orders sent to hedge the fills between a
+// taker and a maker order.
+func (s *Store) HedgeOrders(ctx context.Context, symbol string, takerOrderID string, makerOrderID string) ([]HedgeOrder, error) {
+	rows, _ := s.pool.Query(ctx, `SELECT client_order_id, venue, order_id, symbol, taker_order_id,
+		maker_order_id, attempt, side, quantity, limit_price, filled, avg_price, fee, status, reason,
+		recorded_at FROM hedge_orders
+		WHERE symbol = $1 AND taker_order_id = $2 AND maker_order_id = $3
+		ORDER BY attempt, client_order_id`, symbol, takerOrderID, makerOrderID)
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[HedgeOrder])
+}
@@ -0,0 +1,104 @@
+-- Customer orders in their latest state, one row per order of a book.
+CREATE TABLE orders (
+    symbol          text             NOT NULL,
+    order_id        text             NOT NULL,
+    account         text             NOT NULL DEFAULT '',
+    side            text             NOT NULL,
+    type            text             NOT NULL DEFAULT '',
+    time_in_force   text             NOT NULL DEFAULT '',
+    price           double precision NOT NULL DEFAULT 0,
+    stop_price      double precision NOT NULL DEFAULT 0,
+    quantity        double precision NOT NULL,
+    filled          double precision NOT NULL DEFAULT 0,
+    remaining       double precision NOT NULL,
+    avg_price       double precision NOT NULL DEFAULT 0,
+    status          text             NOT NULL,
+    reason          text             NOT NULL DEFAULT '',
+    created_at      timestamptz      NOT NULL,
+    updated_at      timestamptz      NOT NULL,
+    last_sequence   bigint           NOT NULL, -- Engine sequence of the last event applied to the row
+    PRIMARY KEY (symbol, order_id)
+);
+
+CREATE INDEX orders_account_idx ON orders (account, created_at);
+
+-- Status transitions of orders, one row per engine event changing an order.
+CREATE TABLE order_events (
+    symbol      text             NOT NULL,
+    sequence    bigint           NOT NULL,
+    order_id    text             NOT NULL,
+    type        text             NOT NULL,
+    status      text             NOT NULL,
+    price       double precision NOT NULL DEFAULT 0,
+    quantity    double precision NOT NULL,
+    reason      text             NOT NULL DEFAULT '',
+    time        timestamptz      NOT NULL,
+    PRIMARY KEY (symbol, sequence, order_id) -- A fill moves both of its orders
+);
+
+CREATE INDEX order_events_order_idx ON order_events (symbol, order_id, sequence);
+
+-- Fills of the engine, internal and against venue liquidity.
+CREATE TABLE fills (
+    symbol          text             NOT NULL,
+    sequence        bigint           NOT NULL,
+    taker_order_id  text             NOT NULL,
+    maker_order_id  text             NOT NULL,
+    side            text             NOT NULL,
+    price           double precision NOT NULL,
+    quantity        double precision NOT NULL,
+    liquidity       text             NOT NULL,
+    provider        text             NOT NULL,
+    time            timestamptz      NOT NULL,
+    PRIMARY KEY (symbol, sequence)
+);
+
+CREATE INDEX fills_taker_idx ON fills (symbol, taker_order_id);
+CREATE INDEX fills_maker_idx ON fills (symbol, maker_order_id);
+CREATE INDEX fills_time_idx ON fills (time);
+
+-- Child orders sent to venues to hedge fills against external liquidity.
+CREATE TABLE hedge_orders (
+    client_order_id text             NOT NULL PRIMARY KEY,
+    venue           text             NOT NULL,
+    order_id        text             NOT NULL DEFAULT '',
+    symbol          text             NOT NULL,
+    taker_order_id  text             NOT NULL,
+    maker_order_id  text             NOT NULL,
+    attempt         integer          NOT NULL,
+    side            text             NOT NULL,
+    quantity        double precision NOT NULL,
+    limit_price     double precision NOT NULL,
+    filled          double precision NOT NULL DEFAULT 0,
+    avg_price       double precision NOT NULL DEFAULT 0,
+    fee             double precision NOT NULL DEFAULT 0,
+    status          text             NOT NULL,
+    reason          text             NOT NULL DEFAULT '',
+    recorded_at     timestamptz      NOT NULL
+);
+
+CREATE INDEX hedge_orders_fill_idx ON hedge_orders (symbol, taker_order_id, maker_order_id);
+
+-- Execution reports of the venues.
+CREATE TABLE executions (
+    venue           text             NOT NULL,
+    execution_key   text             NOT NULL, -- Exec id of the venue, or order id, status and cumulative quantity without one
+    order_id        text             NOT NULL,
+    client_order_id text             NOT NULL DEFAULT '',
+    symbol          text             NOT NULL,
+    side            text             NOT NULL,
+    status          text             NOT NULL,
+    quantity        double precision NOT NULL,
+    last_qty        double precision NOT NULL DEFAULT 0,
+    last_price      double precision NOT NULL DEFAULT 0,
+    cum_qty         double precision NOT NULL DEFAULT 0,
+    avg_price       double precision NOT NULL DEFAULT 0,
+    fee             double precision NOT NULL DEFAULT 0,
+    fee_asset       text             NOT NULL DEFAULT '',
+    liquidity       text             NOT NULL DEFAULT '',
+    reason          text             NOT NULL DEFAULT '',
+    time            timestamptz      NOT NULL,
+    PRIMARY KEY (venue, execution_key)
+);
+
+CREATE INDEX executions_client_order_idx ON executions (client_order_id);
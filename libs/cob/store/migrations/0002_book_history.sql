@@ -0,0 +1,47 @@
+-- Consolidated book history, partitioned by day on time. Partitions are
+-- created by the store before it writes into a day, see ensurePartitions.
+
+-- Consolidated depth snapshots, the levels are in book_snapshot_levels.
+CREATE TABLE book_snapshots (
+    symbol      text        NOT NULL,
+    time        timestamptz NOT NULL,
+    sequence    bigint      NOT NULL, -- Engine sequence of the last event included
+    levels      integer     NOT NULL, -- Levels per side kept, all when zero
+    PRIMARY KEY (symbol, time, sequence)
+) PARTITION BY RANGE (time);
+
+CREATE TABLE book_snapshot_levels (
+    symbol      text             NOT NULL,
+    time        timestamptz      NOT NULL,
+    sequence    bigint           NOT NULL,
+    side        text             NOT NULL,
+    price       double precision NOT NULL,
+    quantity    double precision NOT NULL, -- Visible quantity of every provider
+    PRIMARY KEY (symbol, time, sequence, side, price)
+) PARTITION BY RANGE (time);
+
+-- Changes of consolidated levels between snapshots, a zero quantity removes
+-- the level.
+CREATE TABLE book_level_changes (
+    symbol      text             NOT NULL,
+    time        timestamptz      NOT NULL,
+    sequence    bigint           NOT NULL,
+    side        text             NOT NULL,
+    price       double precision NOT NULL,
+    quantity    double precision NOT NULL,
+    orders      integer          NOT NULL,
+    PRIMARY KEY (symbol, time, sequence)
+) PARTITION BY RANGE (time);
+
+-- Top of book of each external venue, recorded when it changes. Zero prices
+-- stand for an empty side.
+CREATE TABLE venue_tops (
+    symbol          text             NOT NULL,
+    time            timestamptz      NOT NULL,
+    venue           text             NOT NULL,
+    bid_price       double precision NOT NULL,
+    bid_quantity    double precision NOT NULL,
+    ask_price       double precision NOT NULL,
+    ask_quantity    double precision NOT NULL,
+    PRIMARY KEY (symbol, time, venue)
+) PARTITION BY RANGE (time);
@@ -0,0 +1,23 @@
+-- Engine runs: event sequences are unique within a run, an engine without a
+-- journal starts them over with every start. History stored before runs
+-- keeps the empty run, that of the journals created before them.
+ALTER TABLE order_events ADD COLUMN run text NOT NULL DEFAULT '';
+ALTER TABLE order_events DROP CONSTRAINT order_events_pkey;
+ALTER TABLE order_events ADD PRIMARY KEY (symbol, run, sequence, order_id);
+
+ALTER TABLE fills ADD COLUMN run text NOT NULL DEFAULT '';
+ALTER TABLE fills DROP CONSTRAINT fills_pkey;
+ALTER TABLE fills ADD PRIMARY KEY (symbol, run, sequence);
+
+-- One row per accepted or rejected order, keyed by the sequence of its
+-- acceptance, so an order ID used again no longer overwrites the earlier
+-- order.
+ALTER TABLE orders ADD COLUMN run text NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN accept_sequence bigint;
+UPDATE orders o SET accept_sequence = COALESCE(
+    (SELECT max(e.sequence) FROM order_events e
+        WHERE e.symbol = o.symbol AND e.order_id = o.order_id AND e.type IN ('order_accepted', 'order_rejected')),
+    o.last_sequence);
+ALTER TABLE orders ALTER COLUMN accept_sequence SET NOT NULL;
+ALTER TABLE orders DROP CONSTRAINT orders_pkey;
+ALTER TABLE orders ADD PRIMARY KEY (symbol, run, order_id, accept_sequence);
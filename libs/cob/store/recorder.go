@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cob"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type RecorderConfig struct {
+	SnapshotInterval time.Duration // Time between snapshots while the book changes, defaults to 1s
+	DepthLevels      int           // Levels per side in snapshots, all when zero
+}
+
+// Recorder records the history of a book for analysis: consolidated depth
+// snapshots, every consolidated level change in between and the top of book
+// of each external venue when it changes. The book is only read while holding
+// lock, the same lock the caller holds around matching. Records are written
+// by the Run loop of the store.
+//
+// Snapshots with DepthLevels set keep the book exact only down to that depth,
+// rebuilding deeper levels needs all of them.
+type Recorder struct {
+	store  *Store
+	book   *cob.OrderBook
+	lock   sync.Locker
+	config RecorderConfig
+
+	mutex sync.Mutex
+	dirty bool                // Levels changed since the last snapshot
+	tops  map[string]VenueTop // Last top recorded per venue
+}
+
+func NewRecorder(store *Store, book *cob.OrderBook, lock sync.Locker, config RecorderConfig) *Recorder {
+	if config.SnapshotInterval <= 0 {
+		config.SnapshotInterval = time.Second
+	}
+
+	return &Recorder{
+		store:  store,
+		book:   book,
+		lock:   lock,
+		config: config,
+		dirty:  true, // Start with a snapshot to rebuild from
+		tops:   make(map[string]VenueTop),
+	}
+}
+
+// HandleEvent records the level changes of the book, it makes the recorder an
+// event sink. Like Store.HandleEvent, it never waits on the database.
+func (r *Recorder) HandleEvent(event cob.Event) {
+	if event.Type != cob.BookLevelChanged {
+		return
+	}
+
+	r.mutex.Lock()
+	r.dirty = true
+	r.mutex.Unlock()
+
+	r.store.enqueue(levelChangeRecord(event))
+}
+
+// Run records snapshots and venue tops every SnapshotInterval while the book
+// changes, until ctx is cancelled.
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		r.mutex.Lock()
+		dirty := r.dirty
+		r.dirty = false
+		r.mutex.Unlock()
+
+		if dirty {
+			snapshot, tops := r.capture()
+			r.store.enqueue(snapshot)
+			for _, top := range r.changedTops(snapshot.Time, tops) {
+				r.store.enqueue(venueTopRecord{symbol: snapshot.Symbol, top: top})
+			}
+		}
+	}
+}
+
+// capture reads the consolidated depth and the venue tops of the book.
+func (r *Recorder) capture() (snapshotRecord, map[string]VenueTop) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.book.Now().UTC()
+	snapshot := snapshotRecord{
+		Book: Book{
+			Symbol:   r.book.Symbol,
+			Time:     now,
+			Sequence: int64(r.book.Sequence()),
+			Bids:     r.levels("buy"),
+			Asks:     r.levels("sell"),
+		},
+		levelLimit: r.config.DepthLevels,
+	}
+
+	tops := make(map[string]VenueTop)
+	for provider, levels := range r.book.VenueDepth("buy") {
+		tops[provider] = VenueTop{Venue: provider, Time: now, BidPrice: levels[0].Price, BidQuantity: levels[0].Quantity}
+	}
+	for provider, levels := range r.book.VenueDepth("sell") {
+		top, ok := tops[provider]
+		if !ok {
+			top = VenueTop{Venue: provider, Time: now}
+		}
+		top.AskPrice, top.AskQuantity = levels[0].Price, levels[0].Quantity
+		tops[provider] = top
+	}
+
+	return snapshot, tops
+}
+
+func (r *Recorder) levels(side string) []BookLevel {
+	priceLevels := r.book.Bids
+	if side == "sell" {
+		priceLevels = r.book.Asks
+	}
+
+	levels := []BookLevel{}
+	for _, price := range r.book.Prices(side) {
+		if r.config.DepthLevels > 0 && len(levels) >= r.config.DepthLevels {
+			break
+		}
+		if quantity := priceLevels[price].VisibleQuantity(); quantity > 0 {
+			levels = append(levels, BookLevel{Price: price, Quantity: quantity})
+		}
+	}
+	return levels
+}
+
+// changedTops returns the venue tops that differ from the ones last recorded,
+// venues gone from the book with empty sides at now.
+func (r *Recorder) changedTops(now time.Time, tops map[string]VenueTop) []VenueTop {
+	changed := []VenueTop{}
+	for venue, top := range tops {
+		if last, ok := r.tops[venue]; !ok || !last.sameQuotes(top) {
+			changed = append(changed, top)
+			r.tops[venue] = top
+		}
+	}
+
+	for venue := range r.tops {
+		if _, ok := tops[venue]; !ok {
+			changed = append(changed, VenueTop{Venue: venue, Time: now})
+			delete(r.tops, venue)
+		}
+	}
+
+	return changed
+}
+
+func (vt VenueTop) sameQuotes(other VenueTop) bool {
+	return vt.BidPrice == other.BidPrice && vt.BidQuantity == other.BidQuantity &&
+		vt.AskPrice == other.AskPrice && vt.AskQuantity == other.AskQuantity
+}
+
+const (
+	insertSnapshotSQL = `INSERT INTO book_snapshots (symbol, time, sequence, levels)
+	VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`
+
+	insertSnapshotLevelSQL = `INSERT INTO book_snapshot_levels (symbol, time, sequence, side, price, quantity)
+	VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`
+
+	insertLevelChangeSQL = `INSERT INTO book_level_changes (symbol, time, sequence, side, price, quantity, orders)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`
+
+	insertVenueTopSQL = `INSERT INTO venue_tops (symbol, time, venue, bid_price, bid_quantity, ask_price, ask_quantity)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`
+)
+
+type snapshotRecord struct {
+	Book
+	levelLimit int
+}
+
+func (r snapshotRecord) day() time.Time { return startOfDay(r.Time) }
+
+func (r snapshotRecord) queue(batch *pgx.Batch) {
+	batch.Queue(insertSnapshotSQL, r.Symbol, r.Time, r.Sequence, r.levelLimit)
+	for side, levels := range map[string][]BookLevel{"buy": r.Bids, "sell": r.Asks} {
+		for _, level := range levels {
+			batch.Queue(insertSnapshotLevelSQL, r.Symbol, r.Time, r.Sequence, side, level.Price, level.Quantity)
+		}
+	}
+}
+
+type levelChangeRecord cob.Event
+
+func (r levelChangeRecord) day() time.Time { return startOfDay(r.Time) }
+
+func (r levelChangeRecord) queue(batch *pgx.Batch) {
+	batch.Queue(insertLevelChangeSQL, r.Symbol, r.Time.UTC(), int64(r.Sequence), r.Level.Side,
+		r.Level.Price, r.Level.Quantity, r.Level.Orders)
+}
+
+type venueTopRecord struct {
+	symbol string
+	top    VenueTop
+}
+
+func (r venueTopRecord) day() time.Time { return startOfDay(r.top.Time) }
+
+func (r venueTopRecord) queue(batch *pgx.Batch) {
+	batch.Queue(insertVenueTopSQL, r.symbol, r.top.Time, r.top.Venue, r.top.BidPrice, r.top.BidQuantity,
+		r.top.AskPrice, r.top.AskQuantity)
+}
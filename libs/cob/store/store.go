@@ -0,0 +1,502 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bitnet/exchange"
+	"cob"
+	"cob/hedge"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Order statuses, the same as those of broker.OrderStatus.
+const (
+	StatusOpen      = "open"
+	StatusFilled    = "filled"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+	StatusRejected  = "rejected"
+)
+
+type Config struct {
+	BatchSize     int           // Records written per transaction, defaults to 500
+	FlushInterval time.Duration // Longest a record waits for its batch, defaults to 200ms
+	QueueSize     int           // Records buffered ahead of the database before spilling, defaults to 10000
+	MaxSpill      int           // Records spilled at most, further ones are dropped; defaults to 1000000
+	RetryDelay    time.Duration // Pause before a failed batch is written again, defaults to 1s
+}
+
+// record is one change to write, queued on a batch as one or more
+// statements.
+type record interface {
+	queue(batch *pgx.Batch)
+}
+
+// partitionedRecord is a record written into the day partitions of the book
+// history tables.
+type partitionedRecord interface {
+	record
+	day() time.Time
+}
+
+// Store writes the history of the engine to Postgres: customer orders and
+// their status transitions, fills, hedge child orders and venue executions.
+// Writes are asynchronous and batched, Run does them. Every statement is an
+// idempotent upsert, engine events keyed by symbol, run and sequence, so
+// replaying events, e.g. after recovering a book from its journal, writes
+// nothing twice. The run of a journaled book is that of its journal, see
+// SetRun; other books get a run of the store, new with every start, as their
+// sequences start over.
+//
+// Records beyond QueueSize spill into memory rather than block, so a slow or
+// unreachable database never holds the book's single writer. Beyond MaxSpill
+// they are dropped and counted, see Dropped, rather than exhaust memory.
+type Store struct {
+	pool       *pgxpool.Pool
+	config     Config
+	records    chan record
+	partitions map[time.Time]bool // Days known to have their partitions, only used by Run
+	history    bookHistory
+	run        string   // Of the books without one of their own
+	runs       sync.Map // Runs by symbol
+
+	spillMutex sync.Mutex
+	spill      []record // Records queued after a full queue, oldest first
+	dropped    atomic.Int64
+	dropping   bool // Since the spill is full, until it has room again
+}
+
+// New creates a store on a pool whose database has been migrated with
+// Migrate.
+func New(pool *pgxpool.Pool, config Config) *Store {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 200 * time.Millisecond
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10000
+	}
+	if config.MaxSpill <= 0 {
+		config.MaxSpill = 1000000
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = time.Second
+	}
+
+	return &Store{
+		pool:       pool,
+		config:     config,
+		records:    make(chan record, config.QueueSize),
+		partitions: make(map[time.Time]bool),
+		history:    pgBookHistory{pool: pool},
+		run:        strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+// SetRun sets the run the event sequences of symbol belong to, Journal.Run
+// for a journaled book.
+func (s *Store) SetRun(symbol string, run string) {
+	s.runs.Store(symbol, run)
+}
+
+func (s *Store) runOf(symbol string) string {
+	if run, ok := s.runs.Load(symbol); ok {
+		return run.(string)
+	}
+	return s.run
+}
+
+// HandleEvent queues the order and fill events of a book, it makes the store
+// an event sink. Level events are not stored. It never waits: when the queue
+// is full, e.g. while the database is down, the events spill into memory, up
+// to MaxSpill.
+func (s *Store) HandleEvent(event cob.Event) {
+	if event.Type == cob.BookLevelChanged {
+		return
+	}
+	s.enqueue(eventRecord{Event: event, run: s.runOf(event.Symbol)})
+}
+
+// RecordHedge queues the child orders of a hedge result.
+func (s *Store) RecordHedge(result hedge.Result) {
+	s.enqueue(hedgeRecord{result: result, recordedAt: time.Now()})
+}
+
+// RecordExecution queues an execution report of a venue, it fits
+// hedge.Config.OnExecution.
+func (s *Store) RecordExecution(execution exchange.Execution) {
+	s.enqueue(executionRecord(execution))
+}
+
+// enqueue queues r without waiting. Once the queue is full, records go to the
+// spill until Run has moved all of it back, so they keep their order. Once
+// the spill is full too, they are dropped.
+func (s *Store) enqueue(r record) {
+	s.spillMutex.Lock()
+	defer s.spillMutex.Unlock()
+
+	if len(s.spill) == 0 {
+		select {
+		case s.records <- r:
+			return
+		default:
+			log.Printf("store: queue of %d records full, spilling into memory\n", cap(s.records))
+		}
+	}
+
+	if len(s.spill) >= s.config.MaxSpill {
+		if !s.dropping {
+			log.Printf("store: spill of %d records full, dropping records\n", len(s.spill))
+			s.dropping = true
+		}
+		s.dropped.Add(1)
+		return
+	}
+	if s.dropping {
+		log.Printf("store: spill has room again, %d records dropped so far\n", s.dropped.Load())
+		s.dropping = false
+	}
+	s.spill = append(s.spill, r)
+}
+
+// Dropped returns the number of records dropped on a full spill since the
+// store was created. The history misses them.
+func (s *Store) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// unspill moves spilled records back into the queue as far as it has room.
+func (s *Store) unspill() {
+	s.spillMutex.Lock()
+	defer s.spillMutex.Unlock()
+
+	if len(s.spill) == 0 {
+		return
+	}
+
+	moved := 0
+	for _, r := range s.spill {
+		select {
+		case s.records <- r:
+			moved++
+			continue
+		default:
+		}
+		break
+	}
+	clear(s.spill[:moved])
+	s.spill = s.spill[moved:]
+}
+
+// Run writes the queued records until ctx is cancelled, then writes what is
+// left. A batch that fails is retried until it is written, so records are
+// stored in the order they were queued.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	records := make([]record, 0, s.config.BatchSize)
+	for {
+		s.unspill()
+
+		select {
+		case <-ctx.Done():
+			s.drain(records)
+			return
+		case r := <-s.records:
+			records = append(records, r)
+			if len(records) < s.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(records) == 0 {
+				continue
+			}
+		}
+
+		for {
+			err := s.write(ctx, records)
+			if err == nil {
+				break
+			}
+			log.Printf("store: unable to write %d records: %+v\n", len(records), err)
+
+			select {
+			case <-ctx.Done():
+				s.drain(records)
+				return
+			case <-time.After(s.config.RetryDelay):
+			}
+		}
+		records = records[:0]
+	}
+}
+
+// drain makes a last attempt at writing the pending, queued and spilled
+// records.
+func (s *Store) drain(records []record) {
+	for {
+		select {
+		case r := <-s.records:
+			records = append(records, r)
+			continue
+		default:
+		}
+		break
+	}
+
+	s.spillMutex.Lock()
+	records = append(records, s.spill...)
+	s.spill = nil
+	s.spillMutex.Unlock()
+
+	if len(records) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.write(ctx, records); err != nil {
+		log.Printf("store: dropping %d records on shutdown: %+v\n", len(records), err)
+	}
+}
+
+// write stores records in one transaction.
+func (s *Store) write(ctx context.Context, records []record) error {
+	batch := &pgx.Batch{}
+	for _, r := range records {
+		if partitioned, ok := r.(partitionedRecord); ok {
+			if err := s.ensurePartitions(ctx, partitioned.day()); err != nil {
+				return err
+			}
+		}
+		r.queue(batch)
+	}
+
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		results := tx.SendBatch(ctx, batch)
+		for i := 0; i < batch.Len(); i++ {
+			if _, err := results.Exec(); err != nil {
+				results.Close()
+				return fmt.Errorf("statement %d of batch: %w", i, err)
+			}
+		}
+		return results.Close()
+	})
+}
+
+// historyTables are partitioned by day.
+var historyTables = []string{"book_snapshots", "book_snapshot_levels", "book_level_changes", "venue_tops"}
+
+// ensurePartitions creates the partitions of the history tables for a day.
+func (s *Store) ensurePartitions(ctx context.Context, day time.Time) error {
+	if s.partitions[day] {
+		return nil
+	}
+
+	for _, table := range historyTables {
+		_, err := s.pool.Exec(ctx, fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s_%s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+			table, day.Format("20060102"), table,
+			day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339),
+		))
+		if err != nil {
+			return fmt.Errorf("unable to create partition of %s for %s: %w", table, day.Format(time.DateOnly), err)
+		}
+	}
+
+	s.partitions[day] = true
+	return nil
+}
+
+// startOfDay returns the UTC day of t, the range of its history partitions.
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Every accepted or rejected order gets a row of its own, keyed by the
+// sequence of its acceptance, so an order ID used again once its order is
+// closed leaves the history of the earlier order as it was. Statements moving
+// an order apply to the open order of the ID and record the transition with
+// the status the order is left in. The order row keeps the sequence of the
+// last event applied, so an event already applied changes nothing.
+// Parameters only selected are cast, Postgres can't infer their type.
+const (
+	acceptOrderSQL = `WITH inserted AS (
+		INSERT INTO orders (symbol, run, order_id, accept_sequence, account, side, type, time_in_force,
+			price, stop_price, quantity, remaining, status, created_at, updated_at, last_sequence)
+		VALUES ($1, $2, $3, $12, $4, $5, $6, $7, $8, $9, $10, $10, 'open', $11, $11, $12)
+		ON CONFLICT DO NOTHING
+		RETURNING symbol, run, order_id, status
+	)
+	INSERT INTO order_events (symbol, run, sequence, order_id, type, status, price, quantity, reason, time)
+	SELECT symbol, run, $12::bigint, order_id, 'order_accepted', status, $8::double precision, $10::double precision, '', $11::timestamptz FROM inserted
+	ON CONFLICT DO NOTHING`
+
+	// A rejection may be that of a duplicate of an open order, which it
+	// leaves open.
+	rejectOrderSQL = `WITH inserted AS (
+		INSERT INTO orders (symbol, run, order_id, accept_sequence, account, side, type, time_in_force,
+			price, stop_price, quantity, remaining, status, reason, created_at, updated_at, last_sequence)
+		VALUES ($1, $2, $3, $13, $4, $5, $6, $7, $8, $9, $10, 0, 'rejected', $11, $12, $12, $13)
+		ON CONFLICT DO NOTHING
+	)
+	INSERT INTO order_events (symbol, run, sequence, order_id, type, status, price, quantity, reason, time)
+	VALUES ($1, $2, $13, $3, 'order_rejected', 'rejected', $8, $10, $11, $12)
+	ON CONFLICT DO NOTHING`
+
+	amendOrderSQL = `WITH changed AS (
+		UPDATE orders SET price = $4, remaining = $5, quantity = filled + $5,
+			updated_at = $6, last_sequence = $7
+		WHERE symbol = $1 AND run = $2 AND order_id = $3 AND status = 'open' AND last_sequence < $7
+		RETURNING symbol, run, order_id, status
+	)
+	INSERT INTO order_events (symbol, run, sequence, order_id, type, status, price, quantity, reason, time)
+	SELECT symbol, run, $7::bigint, order_id, 'order_amended', status, $4::double precision, $5::double precision, '', $6::timestamptz FROM changed
+	ON CONFLICT DO NOTHING`
+
+	// $4 is the quantity removed, the order stays open.
+	reduceOrderSQL = `WITH changed AS (
+		UPDATE orders SET remaining = remaining - $4, quantity = quantity - $4, reason = $5,
+			updated_at = $6, last_sequence = $7
+		WHERE symbol = $1 AND run = $2 AND order_id = $3 AND status = 'open' AND last_sequence < $7
+		RETURNING symbol, run, order_id, status, price
+	)
+	INSERT INTO order_events (symbol, run, sequence, order_id, type, status, price, quantity, reason, time)
+	SELECT symbol, run, $7::bigint, order_id, 'order_reduced', status, price, $4::double precision, $5::text, $6::timestamptz FROM changed
+	ON CONFLICT DO NOTHING`
+
+	// $4 is the event type, $5 the final status.
+	closeOrderSQL = `WITH changed AS (
+		UPDATE orders SET remaining = 0, status = $5, reason = $7, updated_at = $8, last_sequence = $9
+		WHERE symbol = $1 AND run = $2 AND order_id = $3 AND status = 'open' AND last_sequence < $9
+		RETURNING symbol, run, order_id, status, price
+	)
+	INSERT INTO order_events (symbol, run, sequence, order_id, type, status, price, quantity, reason, time)
+	SELECT symbol, run, $9::bigint, order_id, $4::text, status, price, $6::double precision, $7::text, $8::timestamptz FROM changed
+	ON CONFLICT DO NOTHING`
+
+	// Fills of venue liquidity leave the maker side unchanged, venue levels
+	// have no order row.
+	fillOrderSQL = `WITH changed AS (
+		UPDATE orders SET
+			avg_price = (avg_price * filled + $4::double precision * $5::double precision) / (filled + $5),
+			filled = filled + $5,
+			remaining = CASE WHEN remaining - $5 <= 1e-12 THEN 0 ELSE remaining - $5 END,
+			status = CASE WHEN remaining - $5 <= 1e-12 THEN 'filled' ELSE status END,
+			updated_at = $6, last_sequence = $7
+		WHERE symbol = $1 AND run = $2 AND order_id = $3 AND status = 'open' AND last_sequence < $7
+		RETURNING symbol, run, order_id, status
+	)
+	INSERT INTO order_events (symbol, run, sequence, order_id, type, status, price, quantity, reason, time)
+	SELECT symbol, run, $7::bigint, order_id, 'fill', status, $4::double precision, $5::double precision, '', $6::timestamptz FROM changed
+	ON CONFLICT DO NOTHING`
+
+	insertFillSQL = `INSERT INTO fills (symbol, run, sequence, taker_order_id, maker_order_id, side, price,
+		quantity, liquidity, provider, time)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	ON CONFLICT DO NOTHING`
+
+	upsertHedgeOrderSQL = `INSERT INTO hedge_orders (client_order_id, venue, order_id, symbol, taker_order_id,
+		maker_order_id, attempt, side, quantity, limit_price, filled, avg_price, fee, status, reason, recorded_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	ON CONFLICT (client_order_id) DO UPDATE SET
+		order_id = excluded.order_id, filled = excluded.filled, avg_price = excluded.avg_price,
+		fee = excluded.fee, status = excluded.status, reason = excluded.reason,
+		recorded_at = excluded.recorded_at`
+
+	insertExecutionSQL = `INSERT INTO executions (venue, execution_key, order_id, client_order_id, symbol, side,
+		status, quantity, last_qty, last_price, cum_qty, avg_price, fee, fee_asset, liquidity, reason, time)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+	ON CONFLICT DO NOTHING`
+)
+
+// eventRecord is an engine event with the run its sequence belongs to.
+type eventRecord struct {
+	cob.Event
+	run string
+}
+
+func (r eventRecord) queue(batch *pgx.Batch) {
+	order, seq := r.Order, int64(r.Sequence)
+
+	switch r.Type {
+	case cob.OrderAccepted:
+		batch.Queue(acceptOrderSQL, r.Symbol, r.run, order.OrderID, order.Account, order.Side, order.Type,
+			order.TimeInForce, order.Price, order.StopPrice, order.Quantity, r.Time, seq)
+
+	case cob.OrderRejected:
+		batch.Queue(rejectOrderSQL, r.Symbol, r.run, order.OrderID, order.Account, order.Side, order.Type,
+			order.TimeInForce, order.Price, order.StopPrice, order.Quantity, order.Reason, r.Time, seq)
+
+	case cob.OrderAmended:
+		batch.Queue(amendOrderSQL, r.Symbol, r.run, order.OrderID, order.Price, order.Quantity, r.Time, seq)
+
+	case cob.OrderReduced:
+		batch.Queue(reduceOrderSQL, r.Symbol, r.run, order.OrderID, order.Quantity, order.Reason, r.Time, seq)
+
+	case cob.OrderCancelled, cob.OrderExpired:
+		status := StatusCancelled
+		if r.Type == cob.OrderExpired {
+			status = StatusExpired
+		}
+		batch.Queue(closeOrderSQL, r.Symbol, r.run, order.OrderID, string(r.Type), status, order.Quantity,
+			order.Reason, r.Time, seq)
+
+	case cob.OrderFilled:
+		fill := r.Fill
+		batch.Queue(insertFillSQL, r.Symbol, r.run, seq, fill.TakerOrderID, fill.MakerOrderID, fill.Side,
+			fill.Price, fill.Quantity, fill.Liquidity, fill.Provider, r.Time)
+		for _, orderID := range []string{fill.TakerOrderID, fill.MakerOrderID} {
+			batch.Queue(fillOrderSQL, r.Symbol, r.run, orderID, fill.Price, fill.Quantity, r.Time, seq)
+		}
+	}
+}
+
+type hedgeRecord struct {
+	result     hedge.Result
+	recordedAt time.Time
+}
+
+func (r hedgeRecord) queue(batch *pgx.Batch) {
+	fill := r.result.Fill
+	for _, child := range r.result.Children {
+		batch.Queue(upsertHedgeOrderSQL, child.ClientOrderId, child.Venue, child.OrderId, fill.Symbol,
+			fill.TakerOrderID, fill.MakerOrderID, child.Attempt, child.Side, child.Quantity, child.LimitPrice,
+			child.Filled, child.AvgPrice, child.Fee, child.Status, child.Reason, r.recordedAt)
+	}
+}
+
+type executionRecord exchange.Execution
+
+// key identifies an execution report, venues without exec ids report at most
+// one execution per order, status and cumulative quantity.
+func (r executionRecord) key() string {
+	if r.ExecId != "" {
+		return r.ExecId
+	}
+	return fmt.Sprintf("%s:%s:%g", r.OrderId, r.Status, r.CumQty)
+}
+
+func (r executionRecord) queue(batch *pgx.Batch) {
+	timestamp := r.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	batch.Queue(insertExecutionSQL, r.Venue, r.key(), r.OrderId, r.ClientOrderId, r.Symbol, r.Side,
+		r.Status, r.Quantity, r.LastQty, r.LastPrice, r.CumQty, r.AvgPrice, r.Fee, r.FeeAsset,
+		r.Liquidity, r.Reason, timestamp)
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cob"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testPool connects to the migrated database of COB_TEST_DATABASE_URL, the
+// test is skipped without one.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	dsn := os.Getenv("COB_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("COB_TEST_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	if err := Migrate(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+// testSymbol is a symbol no other test run has written.
+func testSymbol() string {
+	return fmt.Sprintf("TEST-%d", time.Now().UnixNano())
+}
+
+var eventTime = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+const testRun = "run1"
+
+func event(event cob.Event) record {
+	return eventRecord{Event: event, run: testRun}
+}
+
+func accepted(symbol string, sequence uint64, orderID string, side string, quantity float64) record {
+	return event(cob.Event{Sequence: sequence, Type: cob.OrderAccepted, Symbol: symbol, Time: eventTime,
+		Order: &cob.OrderEvent{OrderID: orderID, Account: "acct", Side: side, Price: 100, Quantity: quantity}})
+}
+
+func cancelled(symbol string, sequence uint64, orderID string, quantity float64) record {
+	return event(cob.Event{Sequence: sequence, Type: cob.OrderCancelled, Symbol: symbol, Time: eventTime,
+		Order: &cob.OrderEvent{OrderID: orderID, Quantity: quantity, Reason: cob.RequestedReason}})
+}
+
+func filled(symbol string, sequence uint64, takerOrderID string, makerOrderID string, quantity float64) record {
+	return event(cob.Event{Sequence: sequence, Type: cob.OrderFilled, Symbol: symbol, Time: eventTime,
+		Fill: &cob.FillEvent{TakerOrderID: takerOrderID, MakerOrderID: makerOrderID, Side: "sell", Price: 100, Quantity: quantity, Liquidity: "internal", Provider: "local"}})
+}
+
+func TestFullQueueSpillsInOrder(t *testing.T) {
+	s := New(nil, Config{QueueSize: 2})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for sequence := uint64(1); sequence <= 10; sequence++ {
+			s.HandleEvent(cob.Event{Sequence: sequence, Type: cob.OrderAccepted, Order: &cob.OrderEvent{}})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleEvent waited for the database")
+	}
+
+	sequences := []uint64{}
+	for {
+		s.unspill()
+		select {
+		case r := <-s.records:
+			sequences = append(sequences, r.(eventRecord).Sequence)
+			continue
+		default:
+		}
+		break
+	}
+
+	if len(sequences) != 10 {
+		t.Fatalf("sequences %v", sequences)
+	}
+	for i, sequence := range sequences {
+		if sequence != uint64(i+1) {
+			t.Fatalf("sequences %v", sequences)
+		}
+	}
+}
+
+func TestFullSpillDropsAndCountsRecords(t *testing.T) {
+	s := New(nil, Config{QueueSize: 2, MaxSpill: 3})
+
+	for sequence := uint64(1); sequence <= 10; sequence++ {
+		s.HandleEvent(cob.Event{Sequence: sequence, Type: cob.OrderAccepted, Order: &cob.OrderEvent{}})
+	}
+	if len(s.records) != 2 || len(s.spill) != 3 || s.Dropped() != 5 {
+		t.Fatalf("%d records queued, %d spilled, %d dropped", len(s.records), len(s.spill), s.Dropped())
+	}
+
+	// Spilling again once Run made room.
+	<-s.records
+	<-s.records
+	s.unspill()
+	s.HandleEvent(cob.Event{Sequence: 11, Type: cob.OrderAccepted, Order: &cob.OrderEvent{}})
+	if len(s.spill) != 2 || s.spill[1].(eventRecord).Sequence != 11 || s.Dropped() != 5 {
+		t.Fatalf("%d spilled, %d dropped", len(s.spill), s.Dropped())
+	}
+}
+
+func TestRecorderDoesNotWaitForTheDatabase(t *testing.T) {
+	s := New(nil, Config{QueueSize: 1})
+	r := NewRecorder(s, cob.NewOrderBook(), &sync.Mutex{}, RecorderConfig{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for sequence := uint64(1); sequence <= 3; sequence++ {
+			r.HandleEvent(cob.Event{Sequence: sequence, Type: cob.BookLevelChanged, Level: &cob.LevelEvent{Side: "buy"}})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleEvent waited for the database")
+	}
+
+	if len(s.records) != 1 || len(s.spill) != 2 {
+		t.Fatalf("%d records queued, %d spilled", len(s.records), len(s.spill))
+	}
+}
+
+func TestMigrateIsRepeatable(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	if err := Migrate(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := migrations.ReadDir("migrations")
+	applied := 0
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(names) {
+		t.Fatalf("%d migrations applied, %d released", applied, len(names))
+	}
+}
+
+func TestReplayedEventsAreStoredOnce(t *testing.T) {
+	s := New(testPool(t), Config{})
+	ctx := context.Background()
+	symbol := testSymbol()
+
+	records := []record{
+		accepted(symbol, 1, "o1", "buy", 2),
+		accepted(symbol, 2, "o2", "sell", 1),
+		filled(symbol, 3, "o2", "o1", 1),
+		cancelled(symbol, 4, "o1", 1),
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.write(ctx, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var status string
+	var filled, remaining float64
+	err := s.pool.QueryRow(ctx, "SELECT status, filled, remaining FROM orders WHERE symbol = $1 AND order_id = 'o1'", symbol).
+		Scan(&status, &filled, &remaining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusCancelled || filled != 1 || remaining != 0 {
+		t.Fatalf("o1 %s, filled %v, remaining %v", status, filled, remaining)
+	}
+
+	var events, fills int
+	s.pool.QueryRow(ctx, "SELECT count(*) FROM order_events WHERE symbol = $1", symbol).Scan(&events)
+	s.pool.QueryRow(ctx, "SELECT count(*) FROM fills WHERE symbol = $1", symbol).Scan(&fills)
+	if events != 5 || fills != 1 {
+		t.Fatalf("%d order events, %d fills", events, fills)
+	}
+}
+
+func TestReusedOrderIDKeepsTheEarlierOrder(t *testing.T) {
+	s := New(testPool(t), Config{})
+	ctx := context.Background()
+	symbol := testSymbol()
+
+	err := s.write(ctx, []record{
+		accepted(symbol, 1, "o1", "buy", 2),
+		accepted(symbol, 2, "t1", "sell", 1),
+		filled(symbol, 3, "t1", "o1", 1),
+		cancelled(symbol, 4, "o1", 1),
+		accepted(symbol, 5, "o1", "sell", 3),
+		accepted(symbol, 1, "o1", "buy", 2), // Replayed
+		event(cob.Event{Sequence: 6, Type: cob.OrderRejected, Symbol: symbol, Time: eventTime,
+			Order: &cob.OrderEvent{OrderID: "o1", Side: "buy", Quantity: 5, Reason: string(cob.DuplicateOrderReason)}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT side, status, filled, remaining FROM orders
+		WHERE symbol = $1 AND order_id = 'o1' ORDER BY accept_sequence`, symbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := []string{}
+	for rows.Next() {
+		var side, status string
+		var filled, remaining float64
+		if err := rows.Scan(&side, &status, &filled, &remaining); err != nil {
+			t.Fatal(err)
+		}
+		orders = append(orders, fmt.Sprintf("%s %s %v/%v", side, status, filled, remaining))
+	}
+	expected := []string{"buy cancelled 1/0", "sell open 0/3", "buy rejected 0/0"}
+	if fmt.Sprint(orders) != fmt.Sprint(expected) {
+		t.Fatalf("o1 orders %v, expected %v", orders, expected)
+	}
+}
+
+func TestSequencesOfANewRunAreStored(t *testing.T) {
+	s := New(testPool(t), Config{})
+	ctx := context.Background()
+	symbol := testSymbol()
+
+	// The second run, an engine restarted without its journal, numbers its
+	// events from 1 again.
+	for _, run := range []string{"run1", "run2"} {
+		records := []record{}
+		for _, r := range []record{accepted(symbol, 1, "o1", "buy", 1), accepted(symbol, 2, "o2", "sell", 1), filled(symbol, 3, "o2", "o1", 1)} {
+			stamped := r.(eventRecord)
+			stamped.run = run
+			records = append(records, stamped)
+		}
+		if err := s.write(ctx, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var orders, fills int
+	s.pool.QueryRow(ctx, "SELECT count(*) FROM orders WHERE symbol = $1 AND status = 'filled'", symbol).Scan(&orders)
+	s.pool.QueryRow(ctx, "SELECT count(*) FROM fills WHERE symbol = $1", symbol).Scan(&fills)
+	if orders != 4 || fills != 2 {
+		t.Fatalf("%d filled orders, %d fills", orders, fills)
+	}
+}
+
+func TestBooksWithoutARunGetTheRunOfTheStore(t *testing.T) {
+	s := New(nil, Config{})
+	s.SetRun("BTC/USD", "journal")
+
+	s.HandleEvent(cob.Event{Sequence: 1, Type: cob.OrderAccepted, Symbol: "BTC/USD", Order: &cob.OrderEvent{}})
+	s.HandleEvent(cob.Event{Sequence: 1, Type: cob.OrderAccepted, Symbol: "ETH/USD", Order: &cob.OrderEvent{}})
+
+	if r := (<-s.records).(eventRecord); r.run != "journal" {
+		t.Fatalf("journaled book stored under run %q", r.run)
+	}
+	if r := (<-s.records).(eventRecord); r.run != s.run || r.run == "" {
+		t.Fatalf("book stored under run %q, store run %q", r.run, s.run)
+	}
+}
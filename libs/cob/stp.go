@@ -0,0 +1,178 @@
+package cob
+
+import (
+	"container/heap"
+	"math"
+)
+
+// Self-trade prevention modes, applied when an incoming order would match a
+// resting order of the same account.
+const (
+	CancelNewest       = "cancel_newest"        // Cancel the rest of the incoming order
+	CancelOldest       = "cancel_oldest"        // Cancel the resting order and keep matching
+	CancelBoth         = "cancel_both"          // Cancel both orders
+	DecrementAndCancel = "decrement_and_cancel" // Reduce both by the smaller quantity, cancelling the smaller
+)
+
+// SelfTradeReason is the reason passed to OnCancel for orders cancelled by
+// self-trade prevention.
+const SelfTradeReason = "self_trade"
+
+// SelfTrade reports a match that self-trade prevention stopped. Cancelled
+// orders are left with a zero quantity, what they had is in the event.
+type SelfTrade struct {
+	Symbol         string
+	Mode           string
+	Account        string
+	TakerOrderID   string
+	MakerOrderID   string
+	Price          float64
+	TakerCancelled float64 // Quantity of the incoming order removed without trading
+	MakerCancelled float64 // Quantity of the resting order removed without trading
+	Timestamp      int64   // Timestamp of the taker order
+}
+
+// preventFunc decides on a resting order met while matching. It returns how
+// much of the incoming order's remaining quantity is removed without trading
+// and whether the resting order is gone from the level.
+type preventFunc func(pl *PriceLevel, maker *Order, remaining float64) (float64, bool)
+
+func validSelfTradePrevention(mode string) bool {
+	switch mode {
+	case "", CancelNewest, CancelOldest, CancelBoth, DecrementAndCancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// selfTradeMode returns the self-trade prevention mode applying to order,
+// its own or else the book's, empty when none does.
+func (ob *OrderBook) selfTradeMode(order *Order) string {
+	if order.Account == "" {
+		return ""
+	}
+	if order.SelfTradePrevention != "" {
+		return order.SelfTradePrevention
+	}
+	return ob.SelfTradePrevention
+}
+
+// selfMatch reports whether maker belongs to the same account as taker.
+func selfMatch(taker *Order, maker *Order) bool {
+	return maker.Account == taker.Account && maker.Provider == taker.Provider
+}
+
+// selfTradePrevention returns the check of order against resting orders, nil
+// when no mode applies.
+func (ob *OrderBook) selfTradePrevention(order *Order) preventFunc {
+	mode := ob.selfTradeMode(order)
+	if mode == "" {
+		return nil
+	}
+
+	return func(pl *PriceLevel, maker *Order, remaining float64) (float64, bool) {
+		if !selfMatch(order, maker) {
+			return 0, false
+		}
+
+		event := SelfTrade{
+			Symbol:       ob.Symbol,
+			Mode:         mode,
+			Account:      order.Account,
+			TakerOrderID: order.ID,
+			MakerOrderID: maker.ID,
+			Price:        pl.Price,
+			Timestamp:    order.Timestamp,
+		}
+
+		switch mode {
+		case CancelNewest:
+			event.TakerCancelled = remaining
+		case CancelOldest:
+			event.MakerCancelled = maker.Quantity
+		case CancelBoth:
+			event.TakerCancelled = remaining
+			event.MakerCancelled = maker.Quantity
+		case DecrementAndCancel:
+			decrement := math.Min(remaining, maker.Quantity)
+			event.TakerCancelled = decrement
+			event.MakerCancelled = decrement
+		}
+
+		makerGone := pl.decrement(maker, event.MakerCancelled)
+		order.Quantity -= event.TakerCancelled
+
+		if ob.OnSelfTrade != nil {
+			ob.OnSelfTrade(event)
+		}
+		if makerGone {
+			ob.cancelled(maker, SelfTradeReason, event.MakerCancelled)
+		} else if event.MakerCancelled > 0 {
+			ob.reduced(maker, SelfTradeReason, event.MakerCancelled)
+		}
+		if event.TakerCancelled >= remaining {
+			ob.cancelled(order, SelfTradeReason, event.TakerCancelled)
+		} else if event.TakerCancelled > 0 {
+			ob.reduced(order, SelfTradeReason, event.TakerCancelled)
+		}
+
+		return event.TakerCancelled, makerGone
+	}
+}
+
+// decrement removes quantity from a resting order without trading, from its
+// hidden reserve first so the visible part keeps its priority. It reports
+// whether nothing is left of the order.
+func (pl *PriceLevel) decrement(order *Order, quantity float64) bool {
+	if quantity <= 0 {
+		return false
+	}
+
+	hidden := math.Min(quantity, order.Hidden)
+	order.Hidden -= hidden
+	pl.HiddenQuantity -= hidden
+
+	order.Quantity -= quantity
+	pl.TotalQuantity -= quantity
+	if order.Quantity <= 1e-12 {
+		order.Quantity = 0
+		return true
+	}
+	return false
+}
+
+// preventSelfTrades applies prevent to the resting orders of a level in
+// priority order before it is allocated as a whole, as pro-rata matching
+// does. It returns the quantity of the incoming order left to match.
+func (pl *PriceLevel) preventSelfTrades(prevent preventFunc, remaining float64) float64 {
+	if prevent == nil {
+		return remaining
+	}
+
+	removed := false
+	for _, resting := range pl.SortedOrders() {
+		if remaining <= 0 {
+			break
+		}
+		reduced, gone := prevent(pl, resting, remaining)
+		remaining -= reduced
+		removed = removed || gone
+		if !gone {
+			pl.fix(resting) // A decremented maker ranks lower by size
+		}
+	}
+
+	if removed {
+		left := (*pl.Orders)[:0]
+		for _, resting := range *pl.Orders {
+			if resting.Quantity > 0 {
+				left = append(left, resting)
+			}
+		}
+		*pl.Orders = left
+		heap.Init(pl.queue())
+	}
+
+	return remaining
+}
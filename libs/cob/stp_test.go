@@ -0,0 +1,107 @@
+package cob
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// orderEvents records the order events of a book as "type order quantity reason".
+func orderEvents(ob *OrderBook) *[]string {
+	events := []string{}
+	ob.AddSink(EventSinkFunc(func(event Event) {
+		if event.Order != nil {
+			events = append(events, fmt.Sprintf("%s %s %v %s", event.Type, event.Order.OrderID, event.Order.Quantity, event.Order.Reason))
+		}
+	}))
+	return &events
+}
+
+func TestSelfTradePreventionReportsEveryReduction(t *testing.T) {
+	tests := []struct {
+		mode     string
+		quantity float64
+		expected []string
+	}{
+		{
+			mode: DecrementAndCancel, quantity: 3,
+			expected: []string{
+				"order_accepted taker 3 ",
+				"order_cancelled own 1 self_trade",
+				"order_reduced taker 1 self_trade",
+			},
+		},
+		{
+			mode: DecrementAndCancel, quantity: 0.5,
+			expected: []string{
+				"order_accepted taker 0.5 ",
+				"order_reduced own 0.5 self_trade",
+				"order_cancelled taker 0.5 self_trade",
+			},
+		},
+		{
+			mode: CancelOldest, quantity: 3,
+			expected: []string{
+				"order_accepted taker 3 ",
+				"order_cancelled own 1 self_trade",
+			},
+		},
+		{
+			mode: CancelBoth, quantity: 3,
+			expected: []string{
+				"order_accepted taker 3 ",
+				"order_cancelled own 1 self_trade",
+				"order_cancelled taker 3 self_trade",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		ob := NewOrderBook()
+		ob.SetPolicy(PriceTime{})
+		ob.PlaceOrder(&Order{ID: "own", Account: "a", Side: "sell", Price: 100, Quantity: 1, Timestamp: 1, Provider: "local"})
+		ob.PlaceOrder(&Order{ID: "other", Account: "b", Side: "sell", Price: 100, Quantity: 5, Timestamp: 2, Provider: "local"})
+
+		selfTrades := 0
+		ob.OnSelfTrade = func(SelfTrade) { selfTrades++ }
+		events := orderEvents(ob)
+
+		_, err := ob.ProcessOrder(&Order{
+			ID: "taker", Account: "a", Side: "buy", Price: 100, Quantity: test.quantity, Timestamp: 10,
+			Provider: "local", TimeInForce: ImmediateOrCancel, SelfTradePrevention: test.mode,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(*events, test.expected) {
+			t.Fatalf("%s %v: events %q", test.mode, test.quantity, *events)
+		}
+		if selfTrades != 1 {
+			t.Fatalf("%s %v: %d self-trades reported", test.mode, test.quantity, selfTrades)
+		}
+	}
+}
+
+func TestDecrementedMakerKeepsTheLevelInPriority(t *testing.T) {
+	ob := NewOrderBook()
+	ob.ProRata = &ProRataConfig{}
+	ob.PlaceOrder(&Order{ID: "own", Account: "a", Side: "sell", Price: 100, Quantity: 5, Timestamp: 1, Provider: "local"})
+	ob.PlaceOrder(&Order{ID: "other", Account: "b", Side: "sell", Price: 100, Quantity: 4, Timestamp: 2, Provider: "local"})
+
+	_, err := ob.ProcessOrder(&Order{
+		ID: "taker", Account: "a", Side: "buy", Price: 100, Quantity: 3, Timestamp: 10,
+		Provider: "local", TimeInForce: ImmediateOrCancel, SelfTradePrevention: DecrementAndCancel,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, order := range ob.Asks[100].SortedOrders() {
+		ids = append(ids, fmt.Sprintf("%s %v", order.ID, order.Quantity))
+	}
+	if !reflect.DeepEqual(ids, []string{"other 4", "own 2"}) {
+		t.Fatalf("level %v", ids)
+	}
+}
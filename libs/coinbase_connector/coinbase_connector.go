@@ -0,0 +1,445 @@
+package coinbase_connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitnet/exchange"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	Name = "coinbase"
+
+	defaultWsUrl   = "wss://advanced-trade-ws.coinbase.com"
+	defaultRestUrl = "https://api.coinbase.com"
+)
+
+// Channels subscribed for market data, heartbeats keep the connection open
+// while the book is quiet.
+var channels = []string{"level2", "ticker", "market_trades", "heartbeats"}
+
+func init() {
+	exchange.Register(Name, New)
+}
+
+// CoinbaseConnector streams Coinbase Advanced Trade market data. Trading is
+// not implemented, the connector is a market data adapter only.
+//
+// Options: ws_url and rest_url, falling back to COINBASE_WS_URL and
+// COINBASE_REST_API_URL and then to the public Coinbase endpoints.
+type CoinbaseConnector struct {
+	wsUrl      string
+	restUrl    string
+	httpClient *http.Client
+	mutex      sync.RWMutex
+	health     exchange.Health
+}
+
+func New(config exchange.Config) (exchange.ExchangeConnector, error) {
+	return &CoinbaseConnector{
+		wsUrl:      config.Option("ws_url", envOr("COINBASE_WS_URL", defaultWsUrl)),
+		restUrl:    config.Option("rest_url", envOr("COINBASE_REST_API_URL", defaultRestUrl)),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+type subscribeRequest struct {
+	Type       string   `json:"type"`
+	ProductIds []string `json:"product_ids"`
+	Channel    string   `json:"channel"`
+}
+
+// message is the envelope of every Coinbase push. sequence_num is counted per
+// connection over all channels, a gap means a message was lost.
+type message struct {
+	Channel     string          `json:"channel"`
+	Timestamp   time.Time       `json:"timestamp"`
+	SequenceNum int64           `json:"sequence_num"`
+	Events      json.RawMessage `json:"events"`
+}
+
+type level2Event struct {
+	Type      string `json:"type"` // "snapshot" or "update"
+	ProductId string `json:"product_id"`
+	Updates   []struct {
+		Side        string `json:"side"` // "bid" or "offer"
+		PriceLevel  string `json:"price_level"`
+		NewQuantity string `json:"new_quantity"`
+	} `json:"updates"`
+}
+
+type tickerEvent struct {
+	Type    string `json:"type"`
+	Tickers []struct {
+		ProductId          string `json:"product_id"`
+		Price              string `json:"price"`
+		Volume24h          string `json:"volume_24_h"`
+		Low24h             string `json:"low_24_h"`
+		High24h            string `json:"high_24_h"`
+		PricePercentChg24h string `json:"price_percent_chg_24_h"`
+		BestBid            string `json:"best_bid"`
+		BestBidQuantity    string `json:"best_bid_quantity"`
+		BestAsk            string `json:"best_ask"`
+		BestAskQuantity    string `json:"best_ask_quantity"`
+	} `json:"tickers"`
+}
+
+type marketTradesEvent struct {
+	Type   string `json:"type"`
+	Trades []struct {
+		TradeId   string    `json:"trade_id"`
+		ProductId string    `json:"product_id"`
+		Price     string    `json:"price"`
+		Size      string    `json:"size"`
+		Side      string    `json:"side"`
+		Time      time.Time `json:"time"`
+	} `json:"trades"`
+}
+
+// productId converts "BTC/USD" to Coinbase's "BTC-USD".
+func productId(symbol string) string {
+	return strings.ReplaceAll(symbol, "/", "-")
+}
+
+func symbolFromProductId(productId string) string {
+	return strings.ReplaceAll(productId, "-", "/")
+}
+
+func (c *CoinbaseConnector) Name() string {
+	return Name
+}
+
+func (c *CoinbaseConnector) Health() exchange.Health {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.health
+}
+
+func (c *CoinbaseConnector) setHealth(connected bool, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.health.Connected = connected
+	if connected {
+		c.health.LastMessage = time.Now()
+	}
+	if err != nil {
+		c.health.Error = err.Error()
+	}
+}
+
+// connect dials the websocket and subscribes every channel, retrying until it
+// succeeds or ctx is cancelled.
+func (c *CoinbaseConnector) connect(ctx context.Context, productIds []string) (*websocket.Conn, error) {
+	for {
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.wsUrl, nil)
+		if err == nil {
+			for _, channel := range channels {
+				err = conn.WriteJSON(subscribeRequest{
+					Type:       "subscribe",
+					ProductIds: productIds,
+					Channel:    channel,
+				})
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				go func() {
+					<-ctx.Done()
+					conn.Close()
+				}()
+
+				return conn, nil
+			}
+			conn.Close()
+		}
+
+		fmt.Printf("websocket connection failed: %v, retrying...\n", err)
+		c.setHealth(false, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second): // TODO: implement exponential backoff (?)
+		}
+	}
+}
+
+func (c *CoinbaseConnector) SubscribeMarketData(ctx context.Context, symbols []string) (<-chan exchange.MarketData, error) {
+	productIds := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		productIds = append(productIds, productId(symbol))
+	}
+
+	conn, err := c.connect(ctx, productIds)
+	if err != nil {
+		return nil, err
+	}
+
+	marketData := make(chan exchange.MarketData, 20)
+
+	go func() {
+		defer close(marketData)
+
+		books := make(map[string]*exchange.LocalBook)
+		lastSequence := int64(-1)
+
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err == nil {
+				c.setHealth(true, nil)
+
+				var msg message
+				if err = json.Unmarshal(raw, &msg); err != nil {
+					log.Printf("error unmarshalling coinbase message: %v\n", err)
+					continue
+				}
+
+				// The first message of a connection may start anywhere, after
+				// that every message has to follow the previous one.
+				if lastSequence >= 0 && msg.SequenceNum != lastSequence+1 {
+					err = fmt.Errorf("sequence gap: expected %d, got %d", lastSequence+1, msg.SequenceNum)
+				} else {
+					lastSequence = msg.SequenceNum
+
+					for _, data := range c.normalize(msg, books) {
+						select {
+						case marketData <- data:
+						case <-ctx.Done():
+							return
+						}
+					}
+					continue
+				}
+			}
+
+			if ctx.Err() != nil {
+				c.setHealth(false, nil)
+				return
+			}
+
+			// Resync by starting over on a new connection, Coinbase sends
+			// fresh level2 snapshots after subscribing.
+			fmt.Printf("coinbase stream broken: %v, reconnecting..\n", err)
+			c.setHealth(false, err)
+			conn.Close()
+			if conn, err = c.connect(ctx, productIds); err != nil {
+				return
+			}
+			books = make(map[string]*exchange.LocalBook)
+			lastSequence = -1
+		}
+	}()
+
+	return marketData, nil
+}
+
+func (c *CoinbaseConnector) normalize(msg message, books map[string]*exchange.LocalBook) []exchange.MarketData {
+	normalized := []exchange.MarketData{}
+
+	switch msg.Channel {
+	case "l2_data":
+		var events []level2Event
+		if err := json.Unmarshal(msg.Events, &events); err != nil {
+			log.Printf("error unmarshalling level2 message: %v\n", err)
+			return nil
+		}
+
+		for _, event := range events {
+			book, ok := books[event.ProductId]
+			if event.Type == "snapshot" {
+				book = exchange.NewLocalBook()
+				books[event.ProductId] = book
+			} else if !ok {
+				// Updates before the snapshot can not be applied.
+				continue
+			}
+
+			bookUpdate := &exchange.BookUpdate{
+				Venue:     Name,
+				Symbol:    symbolFromProductId(event.ProductId),
+				Type:      event.Type,
+				Bids:      []exchange.BookLevel{},
+				Asks:      []exchange.BookLevel{},
+				Sequence:  msg.SequenceNum,
+				Timestamp: msg.Timestamp,
+			}
+			for _, update := range event.Updates {
+				price, err := strconv.ParseFloat(update.PriceLevel, 64)
+				if err != nil {
+					continue
+				}
+				qty, err := strconv.ParseFloat(update.NewQuantity, 64)
+				if err != nil {
+					continue
+				}
+
+				level := exchange.BookLevel{Price: price, Qty: qty}
+				if update.Side == "bid" {
+					bookUpdate.Bids = append(bookUpdate.Bids, level)
+				} else {
+					bookUpdate.Asks = append(bookUpdate.Asks, level)
+				}
+			}
+			book.Apply(bookUpdate.Bids, bookUpdate.Asks)
+
+			normalized = append(normalized, exchange.MarketData{Venue: Name, Type: exchange.BookData, Book: bookUpdate})
+		}
+	case "ticker":
+		var events []tickerEvent
+		if err := json.Unmarshal(msg.Events, &events); err != nil {
+			log.Printf("error unmarshalling ticker message: %v\n", err)
+			return nil
+		}
+
+		for _, event := range events {
+			for _, ticker := range event.Tickers {
+				normalizedTicker := &exchange.Ticker{
+					Symbol:    symbolFromProductId(ticker.ProductId),
+					Bid:       parseFloat(ticker.BestBid),
+					BidQty:    parseFloat(ticker.BestBidQuantity),
+					Ask:       parseFloat(ticker.BestAsk),
+					AskQty:    parseFloat(ticker.BestAskQuantity),
+					Last:      parseFloat(ticker.Price),
+					Volume:    parseFloat(ticker.Volume24h),
+					Low:       parseFloat(ticker.Low24h),
+					High:      parseFloat(ticker.High24h),
+					ChangePct: parseFloat(ticker.PricePercentChg24h),
+				}
+				normalized = append(normalized, exchange.MarketData{Venue: Name, Type: exchange.TickerData, Ticker: normalizedTicker})
+			}
+		}
+	case "market_trades":
+		var events []marketTradesEvent
+		if err := json.Unmarshal(msg.Events, &events); err != nil {
+			log.Printf("error unmarshalling market trades message: %v\n", err)
+			return nil
+		}
+
+		for _, event := range events {
+			// The snapshot replays recent trades, only new ones are published.
+			if event.Type != "update" {
+				continue
+			}
+			for _, trade := range event.Trades {
+				normalized = append(normalized, exchange.MarketData{
+					Venue: Name,
+					Type:  exchange.TradeData,
+					Trade: &exchange.Trade{
+						Symbol:    symbolFromProductId(trade.ProductId),
+						Side:      strings.ToLower(trade.Side),
+						Price:     parseFloat(trade.Price),
+						Qty:       parseFloat(trade.Size),
+						TradeId:   trade.TradeId,
+						Timestamp: trade.Time,
+					},
+				})
+			}
+		}
+	default:
+		//
+	}
+
+	return normalized
+}
+
+func parseFloat(value string) float64 {
+	parsed, _ := strconv.ParseFloat(value, 64)
+
+	return parsed
+}
+
+type productsResponse struct {
+	Products []struct {
+		ProductId       string `json:"product_id"`
+		BaseCurrencyId  string `json:"base_currency_id"`
+		QuoteCurrencyId string `json:"quote_currency_id"`
+		BaseIncrement   string `json:"base_increment"`
+		QuoteIncrement  string `json:"quote_increment"`
+		BaseMinSize     string `json:"base_min_size"`
+		QuoteMinSize    string `json:"quote_min_size"`
+		Status          string `json:"status"`
+		TradingDisabled bool   `json:"trading_disabled"`
+	} `json:"products"`
+}
+
+func (c *CoinbaseConnector) Instruments(ctx context.Context) ([]exchange.Instrument, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.restUrl+"/api/v3/brokerage/market/products?product_type=SPOT", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("coinbase API error: %s: %s", resp.Status, string(body))
+	}
+
+	var products productsResponse
+	if err := json.Unmarshal(body, &products); err != nil {
+		return nil, err
+	}
+
+	instruments := make([]exchange.Instrument, 0, len(products.Products))
+	for _, product := range products.Products {
+		status := strings.ToLower(product.Status)
+		if product.TradingDisabled {
+			status = "disabled"
+		}
+
+		instruments = append(instruments, exchange.Instrument{
+			Symbol:         symbolFromProductId(product.ProductId),
+			Base:           product.BaseCurrencyId,
+			Quote:          product.QuoteCurrencyId,
+			PriceIncrement: parseFloat(product.QuoteIncrement),
+			PricePrecision: exchange.Decimals(product.QuoteIncrement),
+			QtyIncrement:   parseFloat(product.BaseIncrement),
+			QtyPrecision:   exchange.Decimals(product.BaseIncrement),
+			QtyMin:         parseFloat(product.BaseMinSize),
+			CostMin:        parseFloat(product.QuoteMinSize),
+			Status:         status,
+		})
+	}
+
+	return instruments, nil
+}
+
+func (c *CoinbaseConnector) PlaceOrder(ctx context.Context, request exchange.OrderRequest) (exchange.OrderAck, error) {
+	return exchange.OrderAck{}, exchange.ErrNotSupported
+}
+
+func (c *CoinbaseConnector) CancelOrder(ctx context.Context, orderId string) error {
+	return exchange.ErrNotSupported
+}
+
+func (c *CoinbaseConnector) Balances(ctx context.Context) ([]exchange.Balance, error) {
+	return nil, exchange.ErrNotSupported
+}
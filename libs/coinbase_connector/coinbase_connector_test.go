@@ -0,0 +1,183 @@
+package coinbase_connector
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitnet/exchange"
+
+	"github.com/gorilla/websocket"
+)
+
+// gapFrame skips sequence_num 7, the connector has to reconnect.
+const gapFrame = `{"channel":"l2_data","client_id":"","timestamp":"2024-10-19T09:50:44.200118Z","sequence_num":8,"events":[{"type":"update","product_id":"BTC-USD","updates":[{"side":"bid","event_time":"2024-10-19T09:50:44.198002Z","price_level":"68210.00","new_quantity":"0"}]}]}`
+
+// fakeCoinbase replays the recorded frames to every connection once it has
+// subscribed every channel, and the extra frames to the first one only.
+type fakeCoinbase struct {
+	connections atomic.Int32
+	requests    chan subscribeRequest
+}
+
+func newFakeCoinbase(t *testing.T, extra ...string) (*fakeCoinbase, *httptest.Server) {
+	t.Helper()
+
+	file, err := os.Open("testdata/btcusd_stream.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	frames := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			frames = append(frames, line)
+		}
+	}
+
+	fake := &fakeCoinbase{requests: make(chan subscribeRequest, 100)}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		connection := fake.connections.Add(1)
+		for range channels {
+			request := subscribeRequest{}
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+			fake.requests <- request
+		}
+
+		replay := frames
+		if connection == 1 {
+			replay = append(append([]string{}, frames...), extra...)
+		}
+		for _, frame := range replay {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				return
+			}
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	return fake, server
+}
+
+func open(t *testing.T, server *httptest.Server) exchange.ExchangeConnector {
+	t.Helper()
+
+	connector, err := exchange.Open(exchange.Config{
+		Name:    Name,
+		Options: map[string]string{"ws_url": "ws" + strings.TrimPrefix(server.URL, "http")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return connector
+}
+
+func next(t *testing.T, updates <-chan exchange.MarketData) exchange.MarketData {
+	t.Helper()
+
+	select {
+	case update := <-updates:
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("no market data received")
+		return exchange.MarketData{}
+	}
+}
+
+func TestSubscribeMarketDataNormalizesRecordedFrames(t *testing.T) {
+	fake, server := newFakeCoinbase(t)
+	connector := open(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := connector.SubscribeMarketData(ctx, []string{"BTC/USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, channel := range channels {
+		request := <-fake.requests
+		if request.Type != "subscribe" || request.Channel != channel || len(request.ProductIds) != 1 || request.ProductIds[0] != "BTC-USD" {
+			t.Fatalf("request = %+v", request)
+		}
+	}
+
+	snapshot := next(t, updates)
+	if snapshot.Type != exchange.BookData || snapshot.Book.Type != "snapshot" || snapshot.Book.Symbol != "BTC/USD" || snapshot.Book.Sequence != 1 ||
+		len(snapshot.Book.Bids) != 2 || len(snapshot.Book.Asks) != 2 || snapshot.Book.Bids[0] != (exchange.BookLevel{Price: 68210.11, Qty: 0.420143}) {
+		t.Fatalf("snapshot = %+v", snapshot.Book)
+	}
+
+	delta := next(t, updates)
+	if delta.Type != exchange.BookData || delta.Book.Type != "update" || delta.Book.Sequence != 2 || len(delta.Book.Bids) != 1 || delta.Book.Bids[0].Qty != 0 {
+		t.Fatalf("delta = %+v", delta.Book)
+	}
+
+	// The trades of the market_trades snapshot are not published.
+	trade := next(t, updates)
+	if trade.Type != exchange.TradeData || trade.Trade.TradeId != "702456789" || trade.Trade.Side != "buy" || trade.Trade.Price != 68210.12 || trade.Trade.Qty != 0.061411 {
+		t.Fatalf("trade = %+v", trade.Trade)
+	}
+
+	ticker := next(t, updates).Ticker
+	if ticker == nil || ticker.Symbol != "BTC/USD" || ticker.Last != 68210.12 || ticker.Bid != 68210 || ticker.AskQty != 0.8 || ticker.High != 68920 || ticker.ChangePct != 1.25 {
+		t.Fatalf("ticker = %+v", ticker)
+	}
+
+	if health := connector.Health(); !health.Connected {
+		t.Fatalf("health = %+v", health)
+	}
+}
+
+func TestSubscribeMarketDataReconnectsOnSequenceGap(t *testing.T) {
+	fake, server := newFakeCoinbase(t, gapFrame)
+	connector := open(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := connector.SubscribeMarketData(ctx, []string{"BTC/USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The recorded session, then a fresh snapshot on a new connection instead
+	// of the update after the gap.
+	types := []string{}
+	for len(types) < 4 {
+		if update := next(t, updates); update.Type == exchange.BookData {
+			types = append(types, update.Book.Type)
+			if update.Book.Type == "update" && update.Book.Sequence == 8 {
+				t.Fatal("update after the gap published")
+			}
+		}
+	}
+	if strings.Join(types, ",") != "snapshot,update,snapshot,update" {
+		t.Fatalf("book updates %v", types)
+	}
+	if connections := fake.connections.Load(); connections != 2 {
+		t.Fatalf("%d connections", connections)
+	}
+	if requests := len(fake.requests); requests != 2*len(channels) {
+		t.Fatalf("%d subscribe requests", requests)
+	}
+}
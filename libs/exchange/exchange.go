@@ -0,0 +1,212 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNotSupported = errors.New("operation not supported by connector")
+
+// MarketDataType tells which field of a MarketData message is set.
+type MarketDataType string
+
+const (
+	TickerData     MarketDataType = "ticker"
+	BookData       MarketDataType = "book"
+	TradeData      MarketDataType = "trade"
+	InstrumentData MarketDataType = "instrument"
+)
+
+// BookLevel is a single normalized price level.
+type BookLevel struct {
+	Price float64 `json:"price"`
+	Qty   float64 `json:"qty"`
+}
+
+// BookUpdate is a normalized order book message. Snapshots replace the whole
+// book, updates carry changed levels only and a zero Qty removes the level.
+type BookUpdate struct {
+	Venue     string      `json:"venue"`
+	Symbol    string      `json:"symbol"`
+	Type      string      `json:"type"` // "snapshot" or "update"
+	Bids      []BookLevel `json:"bids"`
+	Asks      []BookLevel `json:"asks"`
+	Sequence  int64       `json:"sequence"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Ticker keeps the JSON layout kraken_market_data has always published on
+// market.<venue>.<symbol>.
+type Ticker struct {
+	Symbol    string  `json:"symbol"`
+	Bid       float64 `json:"bid"`
+	BidQty    float64 `json:"bid_qty"`
+	Ask       float64 `json:"ask"`
+	AskQty    float64 `json:"ask_qty"`
+	Last      float64 `json:"last"`
+	Volume    float64 `json:"volume"`
+	VWAP      float64 `json:"vwap"`
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+	Change    float64 `json:"change"`
+	ChangePct float64 `json:"change_pct"`
+}
+
+type Trade struct {
+	Symbol    string    `json:"symbol"`
+	Side      string    `json:"side"` // "buy" or "sell", taker side
+	Price     float64   `json:"price"`
+	Qty       float64   `json:"qty"`
+	TradeId   string    `json:"trade_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type Instrument struct {
+	Symbol         string  `json:"symbol"` // Normalized as "BASE/QUOTE"
+	Base           string  `json:"base"`
+	Quote          string  `json:"quote"`
+	PriceIncrement float64 `json:"price_increment"`
+	PricePrecision int     `json:"price_precision"`
+	QtyIncrement   float64 `json:"qty_increment"`
+	QtyPrecision   int     `json:"qty_precision"`
+	QtyMin         float64 `json:"qty_min"`
+	CostMin        float64 `json:"cost_min"`
+	Status         string  `json:"status"`
+}
+
+// MarketData is one message of a market data subscription, exactly one of
+// the pointers is set according to Type.
+type MarketData struct {
+	Venue      string
+	Type       MarketDataType
+	Ticker     *Ticker
+	Book       *BookUpdate
+	Trade      *Trade
+	Instrument *Instrument
+}
+
+type Balance struct {
+	Asset     string  `json:"asset"`
+	Total     float64 `json:"total"`
+	Available float64 `json:"available"` // Total minus funds held by open orders
+}
+
+type OrderRequest struct {
+	ClientOrderId string  `json:"client_order_id"`
+	Symbol        string  `json:"symbol"`
+	Side          string  `json:"side"`       // "buy" or "sell"
+	OrderType     string  `json:"order_type"` // "limit" or "market"
+	Price         float64 `json:"price"`      // Limit price, ignored for market orders
+	Quantity      float64 `json:"quantity"`
+	TimeInForce   string  `json:"time_in_force"` // "gtc", "ioc" or "fok", empty means venue default
+}
+
+type OrderAck struct {
+	OrderId       string `json:"order_id"`
+	ClientOrderId string `json:"client_order_id"`
+}
+
+// Execution reports a change of one of our orders on a venue. Status is the
+// resulting order state: "new", "partially_filled", "filled", "canceled",
+// "expired" or "rejected". Last* describe the fill of this report, if any.
+type Execution struct {
+	Venue         string    `json:"venue"`
+	OrderId       string    `json:"order_id"`
+	ClientOrderId string    `json:"client_order_id"`
+	ExecId        string    `json:"exec_id,omitempty"`
+	Symbol        string    `json:"symbol"`
+	Side          string    `json:"side"`
+	Status        string    `json:"status"`
+	Quantity      float64   `json:"quantity"`
+	LastQty       float64   `json:"last_qty,omitempty"`
+	LastPrice     float64   `json:"last_price,omitempty"`
+	CumQty        float64   `json:"cum_qty"`
+	AvgPrice      float64   `json:"avg_price"`
+	Fee           float64   `json:"fee,omitempty"`
+	FeeAsset      string    `json:"fee_asset,omitempty"`
+	Liquidity     string    `json:"liquidity,omitempty"` // "t" taker or "m" maker
+	Reason        string    `json:"reason,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// Terminal reports whether no further execution follows for the order.
+func (e Execution) Terminal() bool {
+	switch e.Status {
+	case "filled", "canceled", "expired", "rejected":
+		return true
+	default:
+		return false
+	}
+}
+
+type Health struct {
+	Connected   bool      `json:"connected"`
+	LastMessage time.Time `json:"last_message"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// ExchangeConnector is implemented by every venue adapter. The engine and the
+// routing code only talk to venues through it.
+type ExchangeConnector interface {
+	// Name returns the venue name used in NATS subjects and order providers.
+	Name() string
+	// SubscribeMarketData streams normalized tickers, books, trades and
+	// instruments for the given "BASE/QUOTE" symbols until ctx is cancelled.
+	SubscribeMarketData(ctx context.Context, symbols []string) (<-chan MarketData, error)
+	PlaceOrder(ctx context.Context, request OrderRequest) (OrderAck, error)
+	CancelOrder(ctx context.Context, orderId string) error
+	Balances(ctx context.Context) ([]Balance, error)
+	Instruments(ctx context.Context) ([]Instrument, error)
+	Health() Health
+}
+
+// ExecutionReporter is implemented by connectors able to stream the
+// executions of our orders, which is required to track hedge fills.
+type ExecutionReporter interface {
+	SubscribeExecutions(ctx context.Context) (<-chan Execution, error)
+}
+
+// Config selects a connector by Name, Options are connector specific
+// (urls, credentials, ...).
+type Config struct {
+	Name    string            `json:"name"`
+	Options map[string]string `json:"options"`
+}
+
+func (c Config) Option(key string, fallback string) string {
+	if value, ok := c.Options[key]; ok && value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+func TickerSubject(venue string, symbol string) string {
+	return fmt.Sprintf("market.%s.%s", venue, symbol)
+}
+
+func InstrumentSubject(venue string, symbol string) string {
+	return fmt.Sprintf("market_info.%s.%s", venue, symbol)
+}
+
+func BookSubject(venue string, symbol string) string {
+	return fmt.Sprintf("market_book.%s.%s", venue, symbol)
+}
+
+func TradeSubject(venue string, symbol string) string {
+	return fmt.Sprintf("market_trade.%s.%s", venue, symbol)
+}
+
+// Decimals returns the number of significant decimals of an increment such as
+// "0.00010000" (4).
+func Decimals(increment string) int {
+	dot := strings.IndexByte(increment, '.')
+	if dot < 0 {
+		return 0
+	}
+
+	return len(strings.TrimRight(increment[dot+1:], "0"))
+}
@@ -0,0 +1,3 @@
+module bitnet/exchange
+
+go 1.23.1
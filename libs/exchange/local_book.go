@@ -0,0 +1,77 @@
+package exchange
+
+import "sort"
+
+// LocalBook is an L2 book for one symbol. Connectors keep one per symbol to
+// validate venue streams, resync them and derive the best bid/ask.
+type LocalBook struct {
+	Bids map[float64]float64 // Price to quantity
+	Asks map[float64]float64 // Price to quantity
+}
+
+func NewLocalBook() *LocalBook {
+	return &LocalBook{
+		Bids: make(map[float64]float64),
+		Asks: make(map[float64]float64),
+	}
+}
+
+func (lb *LocalBook) Reset() {
+	lb.Bids = make(map[float64]float64)
+	lb.Asks = make(map[float64]float64)
+}
+
+// Apply applies changed levels, a zero Qty removes the level.
+func (lb *LocalBook) Apply(bids []BookLevel, asks []BookLevel) {
+	applyLevels(lb.Bids, bids)
+	applyLevels(lb.Asks, asks)
+}
+
+func applyLevels(side map[float64]float64, levels []BookLevel) {
+	for _, level := range levels {
+		if level.Qty == 0 {
+			delete(side, level.Price)
+		} else {
+			side[level.Price] = level.Qty
+		}
+	}
+}
+
+// Best returns the best bid and ask, zero values mean the side is empty.
+func (lb *LocalBook) Best() (bid, bidQty, ask, askQty float64) {
+	for price, qty := range lb.Bids {
+		if price > bid {
+			bid, bidQty = price, qty
+		}
+	}
+	for price, qty := range lb.Asks {
+		if ask == 0 || price < ask {
+			ask, askQty = price, qty
+		}
+	}
+
+	return bid, bidQty, ask, askQty
+}
+
+// Levels returns up to depth levels per side, best first. A depth of zero
+// returns every level.
+func (lb *LocalBook) Levels(depth int) (bids []BookLevel, asks []BookLevel) {
+	bids = sortedLevels(lb.Bids, func(a, b float64) bool { return a > b }, depth)
+	asks = sortedLevels(lb.Asks, func(a, b float64) bool { return a < b }, depth)
+
+	return bids, asks
+}
+
+func sortedLevels(side map[float64]float64, better func(a, b float64) bool, depth int) []BookLevel {
+	levels := make([]BookLevel, 0, len(side))
+	for price, qty := range side {
+		levels = append(levels, BookLevel{Price: price, Qty: qty})
+	}
+	sort.Slice(levels, func(i, j int) bool { return better(levels[i].Price, levels[j].Price) })
+
+	if depth > 0 && len(levels) > depth {
+		levels = levels[:depth]
+	}
+
+	return levels
+}
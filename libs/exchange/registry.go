@@ -0,0 +1,58 @@
+package exchange
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory creates a connector from its config.
+type Factory func(config Config) (ExchangeConnector, error)
+
+var (
+	registryMutex sync.RWMutex
+	factories     = make(map[string]Factory)
+)
+
+// Register makes a connector available by name. Connector packages call it
+// from init, so importing a connector package is all it takes to enable a
+// venue.
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if factory == nil {
+		panic("exchange: Register factory is nil")
+	}
+	if _, exists := factories[name]; exists {
+		panic("exchange: Register called twice for connector " + name)
+	}
+	factories[name] = factory
+}
+
+// Open creates the connector registered under config.Name.
+func Open(config Config) (ExchangeConnector, error) {
+	registryMutex.RLock()
+	factory, ok := factories[config.Name]
+	registryMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("exchange: unknown connector %q (forgotten import?)", config.Name)
+	}
+
+	return factory(config)
+}
+
+// Connectors returns the sorted names of all registered connectors.
+func Connectors() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
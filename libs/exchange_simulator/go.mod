@@ -0,0 +1,20 @@
+module bitnet/exchange_simulator
+
+replace bitnet/kraken_ws_client => ../../libs/kraken_ws_client
+
+require (
+	bitnet/kraken_ws_client v0.0.0-00010101000000-000000000000
+	github.com/gorilla/websocket v1.5.3
+)
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/jackc/pgx/v5 v5.7.1 // indirect
+	github.com/jackc/puddle/v2 v2.2.2 // indirect
+	golang.org/x/crypto v0.27.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/text v0.18.0 // indirect
+)
+
+go 1.23.1
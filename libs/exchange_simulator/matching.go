@@ -0,0 +1,469 @@
+package exchange_simulator
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	krakenwsclient "bitnet/kraken_ws_client"
+)
+
+// simOrder is either an account order or participant liquidity resting at a
+// level (account false).
+type simOrder struct {
+	OrderId     string
+	ClOrdId     string
+	Symbol      string
+	Side        string
+	OrderType   string
+	TimeInForce string
+	Price       float64
+	Qty         float64
+	Filled      float64
+	CumCost     float64
+	Status      string
+	account     bool
+	timestamp   time.Time
+}
+
+func (o *simOrder) remaining() float64 {
+	return o.Qty - o.Filled
+}
+
+func (o *simOrder) avgPrice() float64 {
+	if o.Filled == 0 {
+		return 0
+	}
+
+	return o.CumCost / o.Filled
+}
+
+// simBook is the matching engine of one symbol, levels keep orders in time
+// priority.
+type simBook struct {
+	pair   krakenwsclient.Pair
+	bids   map[float64][]*simOrder
+	asks   map[float64][]*simOrder
+	last   float64
+	open   float64
+	low    float64
+	high   float64
+	volume float64
+	cost   float64 // Traded notional, for the VWAP
+}
+
+func newSimBook(pair krakenwsclient.Pair) *simBook {
+	return &simBook{
+		pair: pair,
+		bids: make(map[float64][]*simOrder),
+		asks: make(map[float64][]*simOrder),
+	}
+}
+
+func (b *simBook) side(side string) map[float64][]*simOrder {
+	if side == "sell" {
+		return b.asks
+	}
+
+	return b.bids
+}
+
+func oppositeSide(side string) string {
+	if side == "buy" {
+		return "sell"
+	}
+
+	return "buy"
+}
+
+// prices returns the prices of one side, best first.
+func (b *simBook) prices(side string) []float64 {
+	levels := b.side(side)
+	prices := make([]float64, 0, len(levels))
+	for price := range levels {
+		prices = append(prices, price)
+	}
+
+	sort.Float64s(prices)
+	if side == "buy" {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	}
+
+	return prices
+}
+
+func (b *simBook) levelQty(side string, price float64) float64 {
+	total := 0.0
+	for _, order := range b.side(side)[price] {
+		total += order.remaining()
+	}
+
+	return total
+}
+
+// levels returns up to depth aggregated levels of one side, best first.
+func (b *simBook) levels(side string, depth int) []krakenwsclient.BookLevel {
+	levels := []krakenwsclient.BookLevel{}
+	for _, price := range b.prices(side) {
+		if depth > 0 && len(levels) == depth {
+			break
+		}
+		levels = append(levels, krakenwsclient.BookLevel{Price: price, Qty: b.levelQty(side, price)})
+	}
+
+	return levels
+}
+
+// window returns the visible levels of one side as a price to quantity map.
+func (b *simBook) window(side string, depth int) map[float64]float64 {
+	window := make(map[float64]float64)
+	for _, level := range b.levels(side, depth) {
+		window[level.Price] = level.Qty
+	}
+
+	return window
+}
+
+func (b *simBook) best(side string) (float64, float64) {
+	prices := b.prices(side)
+	if len(prices) == 0 {
+		return 0, 0
+	}
+
+	return prices[0], b.levelQty(side, prices[0])
+}
+
+func (b *simBook) setLiquidity(side string, price float64, qty float64) {
+	levels := b.side(side)
+
+	kept := []*simOrder{}
+	for _, order := range levels[price] {
+		if order.account {
+			kept = append(kept, order)
+		}
+	}
+	if qty > 0 {
+		kept = append(kept, &simOrder{
+			Symbol:    b.pair.Symbol,
+			Side:      side,
+			OrderType: "limit",
+			Price:     price,
+			Qty:       qty,
+			timestamp: time.Now(),
+		})
+	}
+
+	if len(kept) == 0 {
+		delete(levels, price)
+	} else {
+		levels[price] = kept
+	}
+}
+
+func (b *simBook) rest(order *simOrder) {
+	levels := b.side(order.Side)
+	levels[order.Price] = append(levels[order.Price], order)
+}
+
+func (b *simBook) remove(order *simOrder) {
+	levels := b.side(order.Side)
+	orders := levels[order.Price]
+	for i, resting := range orders {
+		if resting == order {
+			orders = append(orders[:i], orders[i+1:]...)
+			break
+		}
+	}
+
+	if len(orders) == 0 {
+		delete(levels, order.Price)
+	} else {
+		levels[order.Price] = orders
+	}
+}
+
+func (b *simBook) recordTrade(price float64, qty float64) {
+	if b.open == 0 {
+		b.open = price
+	}
+	if b.low == 0 || price < b.low {
+		b.low = price
+	}
+	if price > b.high {
+		b.high = price
+	}
+	b.last = price
+	b.volume += qty
+	b.cost += price * qty
+}
+
+func (b *simBook) ticker() krakenwsclient.Ticker {
+	bid, bidQty := b.best("buy")
+	ask, askQty := b.best("sell")
+
+	ticker := krakenwsclient.Ticker{
+		Symbol: b.pair.Symbol,
+		Bid:    bid,
+		BidQty: bidQty,
+		Ask:    ask,
+		AskQty: askQty,
+		Last:   b.last,
+		Volume: b.volume,
+		Low:    b.low,
+		High:   b.high,
+	}
+	if b.volume > 0 {
+		ticker.VWAP = b.cost / b.volume
+	}
+	if b.open > 0 {
+		ticker.Change = b.last - b.open
+		ticker.ChangePct = math.Round(ticker.Change/b.open*10000) / 100
+	}
+
+	return ticker
+}
+
+// crosses reports whether a taker order may trade at price.
+func crosses(taker *simOrder, price float64) bool {
+	if taker.OrderType == "market" {
+		return true
+	}
+	if taker.Side == "buy" {
+		return price <= taker.Price
+	}
+
+	return price >= taker.Price
+}
+
+// match executes taker against the opposite side of book in price/time
+// priority, publishing trades, executions, balances and book changes.
+// Callers hold s.mutex.
+func (s *Simulator) match(book *simBook, taker *simOrder) {
+	makerSide := oppositeSide(taker.Side)
+	before := book.window(makerSide, s.config.BookDepth)
+	trades := []krakenwsclient.Trade{}
+
+	for _, price := range book.prices(makerSide) {
+		if taker.remaining() <= 0 || !crosses(taker, price) {
+			break
+		}
+
+		for _, maker := range append([]*simOrder{}, book.side(makerSide)[price]...) {
+			if taker.remaining() <= 0 {
+				break
+			}
+
+			qty := math.Min(taker.remaining(), maker.remaining())
+			s.fill(taker, qty, price, "t")
+			s.fill(maker, qty, price, "m")
+			if maker.remaining() <= 0 {
+				book.remove(maker)
+				delete(s.orders, maker.OrderId)
+			}
+
+			book.recordTrade(price, qty)
+			s.tradeSeq++
+			trades = append(trades, krakenwsclient.Trade{
+				Symbol:    book.pair.Symbol,
+				Side:      taker.Side,
+				Price:     price,
+				Qty:       qty,
+				OrdType:   taker.OrderType,
+				TradeId:   s.tradeSeq,
+				Timestamp: time.Now().UTC(),
+			})
+		}
+	}
+
+	if len(trades) == 0 {
+		return
+	}
+
+	s.broadcastBookChanges(book, makerSide, before)
+	s.broadcastTrades(book.pair.Symbol, trades)
+	s.broadcastTicker(book)
+}
+
+// fill applies a fill to an order, and for account orders to the balances,
+// and reports it on the executions channel.
+func (s *Simulator) fill(order *simOrder, qty float64, price float64, liquidity string) {
+	order.Filled += qty
+	order.CumCost += qty * price
+	order.Status = "partially_filled"
+	if order.remaining() <= 1e-12 {
+		order.Status = "filled"
+	}
+
+	if !order.account {
+		return
+	}
+
+	feeRate := s.config.TakerFee
+	if liquidity == "m" {
+		feeRate = s.config.MakerFee
+	}
+	fee := qty * price * feeRate
+
+	book := s.books[order.Symbol]
+	base, quote := book.pair.Base, book.pair.Quote
+	if order.Side == "buy" {
+		s.balances[base] += qty
+		s.balances[quote] -= qty*price + fee
+	} else {
+		s.balances[base] -= qty
+		s.balances[quote] += qty*price - fee
+	}
+
+	execution := s.execution(order, "trade")
+	execution.ExecId = s.nextExecId()
+	execution.LastQty = qty
+	execution.LastPrice = price
+	execution.LiquidityInd = liquidity
+	execution.Fees = []krakenwsclient.ExecutionFee{{Asset: quote, Qty: fee}}
+	s.broadcastExecutions(execution)
+
+	s.broadcastBalanceUpdates(execution.ExecId, order.Side, base, quote, qty, price, fee)
+}
+
+func (s *Simulator) execution(order *simOrder, execType string) krakenwsclient.Execution {
+	return krakenwsclient.Execution{
+		OrderId:     order.OrderId,
+		ClOrdId:     order.ClOrdId,
+		ExecType:    execType,
+		OrderStatus: order.Status,
+		Symbol:      order.Symbol,
+		Side:        order.Side,
+		OrderType:   order.OrderType,
+		OrderQty:    order.Qty,
+		LimitPrice:  order.Price,
+		CumQty:      order.Filled,
+		CumCost:     order.CumCost,
+		AvgPrice:    order.avgPrice(),
+		Timestamp:   time.Now().UTC(),
+	}
+}
+
+// placeOrder validates, matches and, if anything remains, rests or cancels an
+// account order. It returns the Kraken error string on rejection.
+// Callers hold s.mutex.
+func (s *Simulator) placeOrder(order *simOrder) string {
+	if reason := s.scenario.nextReject(); reason != "" {
+		return reason
+	}
+
+	book, ok := s.books[order.Symbol]
+	if !ok {
+		return "EQuery:Unknown asset pair"
+	}
+	if order.Side != "buy" && order.Side != "sell" {
+		return "EGeneral:Invalid arguments:type"
+	}
+	if order.OrderType != "limit" && order.OrderType != "market" {
+		return "EGeneral:Invalid arguments:ordertype"
+	}
+	if order.Qty <= 0 || order.Qty < book.pair.QtyMin {
+		return "EOrder:Order minimum not met"
+	}
+	if order.OrderType == "limit" && order.Price*order.Qty < book.pair.CostMin {
+		return "EOrder:Cost minimum not met"
+	}
+	if !s.hasFunds(book, order) {
+		return "EOrder:Insufficient funds"
+	}
+
+	order.OrderId = s.nextOrderId()
+	order.Status = "new"
+	order.account = true
+	order.timestamp = time.Now()
+	s.broadcastExecutions(s.execution(order, "new"))
+
+	s.match(book, order)
+
+	if order.remaining() <= 1e-12 {
+		return ""
+	}
+
+	if order.OrderType == "market" || order.TimeInForce == "IOC" {
+		order.Status = "canceled"
+		execution := s.execution(order, "canceled")
+		execution.Reason = "remainder of immediate order"
+		s.broadcastExecutions(execution)
+		return ""
+	}
+
+	before := book.window(order.Side, s.config.BookDepth)
+	book.rest(order)
+	s.orders[order.OrderId] = order
+	s.broadcastBookChanges(book, order.Side, before)
+	s.broadcastTicker(book)
+
+	return ""
+}
+
+// hasFunds checks the free balance, net of what open orders hold, covers the
+// order. Market buys are checked against the cost of walking the book.
+func (s *Simulator) hasFunds(book *simBook, order *simOrder) bool {
+	base, quote := book.pair.Base, book.pair.Quote
+	held := s.held()
+
+	if order.Side == "sell" {
+		return s.balances[base]-held[base] >= order.Qty
+	}
+
+	cost := order.Price * order.Qty
+	if order.OrderType == "market" {
+		cost = 0
+		remaining := order.Qty
+		for _, price := range book.prices("sell") {
+			qty := math.Min(remaining, book.levelQty("sell", price))
+			cost += qty * price
+			remaining -= qty
+			if remaining <= 0 {
+				break
+			}
+		}
+	}
+
+	return s.balances[quote]-held[quote] >= cost*(1+s.config.TakerFee)
+}
+
+// held returns the balance held by open account orders per asset.
+func (s *Simulator) held() map[string]float64 {
+	held := make(map[string]float64)
+	for _, order := range s.orders {
+		pair := s.books[order.Symbol].pair
+		if order.Side == "buy" {
+			held[pair.Quote] += order.remaining() * order.Price
+		} else {
+			held[pair.Base] += order.remaining()
+		}
+	}
+
+	return held
+}
+
+// cancelOrder cancels an open account order by order or client order id.
+// Callers hold s.mutex.
+func (s *Simulator) cancelOrder(id string) bool {
+	for orderId, order := range s.orders {
+		if orderId != id && (order.ClOrdId == "" || order.ClOrdId != id) {
+			continue
+		}
+
+		book := s.books[order.Symbol]
+		before := book.window(order.Side, s.config.BookDepth)
+		book.remove(order)
+		delete(s.orders, orderId)
+
+		order.Status = "canceled"
+		execution := s.execution(order, "canceled")
+		execution.Reason = "User requested"
+		s.broadcastExecutions(execution)
+		s.broadcastBookChanges(book, order.Side, before)
+		s.broadcastTicker(book)
+
+		return true
+	}
+
+	return false
+}
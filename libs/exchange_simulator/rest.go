@@ -0,0 +1,114 @@
+package exchange_simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type restResponse struct {
+	Error  []string `json:"error"`
+	Result any      `json:"result,omitempty"`
+}
+
+// handleRest serves the private REST endpoints used by KrakenRestClient and
+// the websocket token request. Signatures are not verified, only the presence
+// of an API key.
+func (s *Simulator) handleRest(w http.ResponseWriter, r *http.Request) {
+	// The response is written once handleRest returns, after s.mutex is
+	// released, so a slow client never blocks the simulator.
+	var response restResponse
+	reply := func(result any, errorMessage string) {
+		response = restResponse{Error: []string{}, Result: result}
+		if errorMessage != "" {
+			response = restResponse{Error: []string{errorMessage}}
+		}
+	}
+	defer func() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}()
+
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/0/private/") {
+		reply(nil, "EGeneral:Unknown method")
+		return
+	}
+	if r.Header.Get("API-Key") == "" {
+		reply(nil, "EAPI:Invalid key")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		reply(nil, "EGeneral:Invalid arguments")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch strings.TrimPrefix(r.URL.Path, "/0/private/") {
+	case "GetWebSocketsToken":
+		reply(map[string]any{"token": s.token, "expires": 900}, "")
+	case "AddOrder":
+		pair, ok := s.pairByRestName(r.PostForm.Get("pair"))
+		if !ok {
+			reply(nil, "EQuery:Unknown asset pair")
+			return
+		}
+
+		volume, err := strconv.ParseFloat(r.PostForm.Get("volume"), 64)
+		if err != nil {
+			reply(nil, "EGeneral:Invalid arguments:volume")
+			return
+		}
+		price := 0.0
+		if r.PostForm.Get("ordertype") == "limit" {
+			if price, err = strconv.ParseFloat(r.PostForm.Get("price"), 64); err != nil {
+				reply(nil, "EGeneral:Invalid arguments:price")
+				return
+			}
+		}
+
+		order := &simOrder{
+			ClOrdId:     r.PostForm.Get("cl_ord_id"),
+			Symbol:      pair.Symbol,
+			Side:        r.PostForm.Get("type"),
+			OrderType:   r.PostForm.Get("ordertype"),
+			TimeInForce: r.PostForm.Get("timeinforce"),
+			Price:       price,
+			Qty:         volume,
+		}
+		if reason := s.placeOrder(order); reason != "" {
+			reply(nil, reason)
+			return
+		}
+
+		description := fmt.Sprintf("%s %s %s @ %s", order.Side, r.PostForm.Get("volume"), strings.ReplaceAll(pair.Symbol, "/", ""), order.OrderType)
+		if order.OrderType == "limit" {
+			description += " " + r.PostForm.Get("price")
+		}
+		reply(map[string]any{
+			"descr": map[string]string{"order": description},
+			"txid":  []string{order.OrderId},
+		}, "")
+	case "CancelOrder":
+		if !s.cancelOrder(r.PostForm.Get("txid")) {
+			reply(nil, "EOrder:Unknown order")
+			return
+		}
+		reply(map[string]any{"count": 1}, "")
+	case "BalanceEx":
+		held := s.held()
+		balances := make(map[string]map[string]string)
+		for asset, balance := range s.balances {
+			balances[asset] = map[string]string{
+				"balance":    strconv.FormatFloat(balance, 'f', -1, 64),
+				"hold_trade": strconv.FormatFloat(held[asset], 'f', -1, 64),
+			}
+		}
+		reply(balances, "")
+	default:
+		reply(nil, "EGeneral:Unknown method")
+	}
+}
@@ -0,0 +1,133 @@
+package exchange_simulator
+
+import (
+	"context"
+	"time"
+)
+
+// scenarioState holds the faults queued by scenario controls, it is guarded
+// by the simulator mutex.
+type scenarioState struct {
+	rejects          []string
+	corruptChecksums map[string]int // Symbol to number of book messages to corrupt
+	sequenceGap      int64
+}
+
+func (ss *scenarioState) nextReject() string {
+	if len(ss.rejects) == 0 {
+		return ""
+	}
+
+	reason := ss.rejects[0]
+	ss.rejects = ss.rejects[1:]
+
+	return reason
+}
+
+func (ss *scenarioState) corruptChecksum(symbol string) bool {
+	if ss.corruptChecksums[symbol] == 0 {
+		return false
+	}
+	ss.corruptChecksums[symbol]--
+
+	return true
+}
+
+func (ss *scenarioState) takeSequenceGap() int64 {
+	gap := ss.sequenceGap
+	ss.sequenceGap = 0
+
+	return gap
+}
+
+// RejectNextOrders rejects the next n orders with the given Kraken error,
+// e.g. "EOrder:Insufficient funds" or "EService:Unavailable".
+func (s *Simulator) RejectNextOrders(n int, reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.scenario.rejects = append(s.scenario.rejects, reason)
+	}
+}
+
+// CorruptNextChecksums sends the next n book messages of symbol with a wrong
+// checksum.
+func (s *Simulator) CorruptNextChecksums(symbol string, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.scenario.corruptChecksums == nil {
+		s.scenario.corruptChecksums = make(map[string]int)
+	}
+	s.scenario.corruptChecksums[symbol] += n
+}
+
+// SkipSequence makes the next private channel message skip n sequence numbers.
+func (s *Simulator) SkipSequence(n int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.scenario.sequenceGap += n
+}
+
+// DropConnections closes every websocket connection, clients are expected to
+// reconnect and subscribe again.
+func (s *Simulator) DropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// Step is one action of a scripted scenario, run After the previous step.
+type Step struct {
+	After  time.Duration
+	Action func(s *Simulator)
+}
+
+type Scenario []Step
+
+// Play runs the scenario steps in order, it returns early with the context
+// error when ctx is cancelled.
+func (s *Simulator) Play(ctx context.Context, scenario Scenario) error {
+	for _, step := range scenario {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(step.After):
+		}
+
+		step.Action(s)
+	}
+
+	return nil
+}
+
+// Common steps.
+
+func Disconnect(after time.Duration) Step {
+	return Step{After: after, Action: func(s *Simulator) { s.DropConnections() }}
+}
+
+func SequenceGap(after time.Duration, n int64) Step {
+	return Step{After: after, Action: func(s *Simulator) { s.SkipSequence(n) }}
+}
+
+func BadChecksum(after time.Duration, symbol string) Step {
+	return Step{After: after, Action: func(s *Simulator) { s.CorruptNextChecksums(symbol, 1) }}
+}
+
+func Reject(after time.Duration, reason string) Step {
+	return Step{After: after, Action: func(s *Simulator) { s.RejectNextOrders(1, reason) }}
+}
+
+func Liquidity(after time.Duration, symbol string, side string, price float64, qty float64) Step {
+	return Step{After: after, Action: func(s *Simulator) { s.SetLiquidity(symbol, side, price, qty) }}
+}
+
+func Trade(after time.Duration, symbol string, side string, qty float64) Step {
+	return Step{After: after, Action: func(s *Simulator) { s.MarketTrade(symbol, side, qty) }}
+}
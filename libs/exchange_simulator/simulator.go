@@ -0,0 +1,211 @@
+package exchange_simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	krakenwsclient "bitnet/kraken_ws_client"
+)
+
+// Config describes the simulated account and market.
+type Config struct {
+	Pairs     []krakenwsclient.Pair // Instruments served on the instrument channel
+	Balances  map[string]float64    // Initial account balances per asset
+	TakerFee  float64               // Fraction of the cost, e.g. 0.0026
+	MakerFee  float64               // Fraction of the cost, e.g. 0.0016
+	BookDepth int                   // Depth of book messages, defaults to 10
+}
+
+// Simulator is an in-process exchange speaking the Kraken v2 websocket
+// protocol (book, ticker, trade, instrument, executions, balances, add_order,
+// cancel_order) and the private REST endpoints used by KrakenRestClient.
+// Point KrakenWsClientConfig.Url at WsUrl and RestUrl at RestUrl.
+//
+// All accepted orders belong to a single simulated account. Liquidity of
+// other market participants is controlled with SetLiquidity and MarketTrade.
+type Simulator struct {
+	config   Config
+	listener net.Listener
+	server   *http.Server
+
+	mutex    sync.Mutex
+	books    map[string]*simBook
+	orders   map[string]*simOrder // Open account orders by order id
+	balances map[string]float64
+	clients  map[*client]struct{}
+	token    string
+	orderSeq int64
+	execSeq  int64
+	tradeSeq int64
+	scenario scenarioState
+}
+
+func New(config Config) *Simulator {
+	if config.BookDepth == 0 {
+		config.BookDepth = 10
+	}
+
+	s := &Simulator{
+		config:   config,
+		books:    make(map[string]*simBook),
+		orders:   make(map[string]*simOrder),
+		balances: make(map[string]float64),
+		clients:  make(map[*client]struct{}),
+		token:    "simulator-token",
+	}
+
+	for _, pair := range config.Pairs {
+		s.books[pair.Symbol] = newSimBook(pair)
+	}
+	for asset, balance := range config.Balances {
+		s.balances[asset] = balance
+	}
+
+	return s
+}
+
+// Start listens on a random local port.
+func (s *Simulator) Start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2", s.handleWebSocket)
+	mux.HandleFunc("/0/", s.handleRest)
+
+	s.listener = listener
+	s.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("exchange simulator stopped: %v\n", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *Simulator) Close() error {
+	s.DropConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return s.server.Shutdown(ctx)
+}
+
+// WsUrl is the websocket url for both public and private channels.
+func (s *Simulator) WsUrl() string {
+	return "ws://" + s.listener.Addr().String() + "/v2"
+}
+
+// RestUrl is the REST API base url.
+func (s *Simulator) RestUrl() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+// Balance returns the current account balance of asset.
+func (s *Simulator) Balance(asset string) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.balances[asset]
+}
+
+// Subscribers returns the number of connections subscribed to channel for
+// symbol, empty for the instrument and private channels. Scenarios wait for
+// clients to subscribe before they act.
+func (s *Simulator) Subscribers(channel krakenwsclient.KrakenWsChannel, symbol string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	subscribers := 0
+	for c := range s.clients {
+		if c.subscribed(channel, symbol) {
+			subscribers++
+		}
+	}
+
+	return subscribers
+}
+
+// SetBalance overrides an account balance and pushes a balances snapshot.
+func (s *Simulator) SetBalance(asset string, balance float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.balances[asset] = balance
+	s.broadcastBalancesSnapshot()
+}
+
+// SetLiquidity sets the quantity other participants rest at a price level,
+// a zero quantity removes it. Account orders at that price are kept.
+func (s *Simulator) SetLiquidity(symbol string, side string, price float64, qty float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	book, ok := s.books[symbol]
+	if !ok {
+		return fmt.Errorf("unknown symbol %s", symbol)
+	}
+
+	before := book.window(side, s.config.BookDepth)
+	book.setLiquidity(side, price, qty)
+	s.broadcastBookChanges(book, side, before)
+	s.broadcastTicker(book)
+
+	return nil
+}
+
+// MarketTrade simulates another participant sending a market order of qty on
+// side. It consumes liquidity and account orders in price/time priority and
+// returns the executed quantity.
+func (s *Simulator) MarketTrade(symbol string, side string, qty float64) (float64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	book, ok := s.books[symbol]
+	if !ok {
+		return 0, fmt.Errorf("unknown symbol %s", symbol)
+	}
+
+	taker := &simOrder{
+		Symbol:    symbol,
+		Side:      side,
+		OrderType: "market",
+		Qty:       qty,
+	}
+	s.match(book, taker)
+
+	return taker.Filled, nil
+}
+
+func (s *Simulator) nextOrderId() string {
+	s.orderSeq++
+
+	return fmt.Sprintf("OSIM%02d-%06d", s.orderSeq%100, s.orderSeq)
+}
+
+func (s *Simulator) nextExecId() string {
+	s.execSeq++
+
+	return fmt.Sprintf("TSIM-%06d", s.execSeq)
+}
+
+func (s *Simulator) pairByRestName(name string) (krakenwsclient.Pair, bool) {
+	for _, pair := range s.config.Pairs {
+		if pair.Symbol == name || strings.ReplaceAll(pair.Symbol, "/", "") == name {
+			return pair, true
+		}
+	}
+
+	return krakenwsclient.Pair{}, false
+}
@@ -0,0 +1,141 @@
+package exchange_simulator
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	krakenwsclient "bitnet/kraken_ws_client"
+
+	"github.com/gorilla/websocket"
+)
+
+func startSimulator(t *testing.T) *Simulator {
+	t.Helper()
+
+	s := New(Config{
+		Pairs: []krakenwsclient.Pair{{
+			Symbol: "BTC/USD", Base: "BTC", Quote: "USD",
+			PricePrecision: 1, QtyPrecision: 8, QtyMin: 0.0001, CostMin: 0.5, Status: "online",
+		}},
+		Balances: map[string]float64{"BTC": 1, "USD": 100000},
+		TakerFee: 0.0026,
+		MakerFee: 0.0016,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func dial(t *testing.T, s *Simulator) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(s.WsUrl(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readPushes reads until n messages of channel were received.
+func readPushes(t *testing.T, conn *websocket.Conn, channel krakenwsclient.KrakenWsChannel, n int) []krakenwsclient.ResponseMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pushes := []krakenwsclient.ResponseMessage{}
+	for len(pushes) < n {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %d of %d %s messages: %v", len(pushes), n, channel, err)
+		}
+
+		var push krakenwsclient.ResponseMessage
+		if err := json.Unmarshal(message, &push); err != nil {
+			t.Fatal(err)
+		}
+		if push.Channel == channel {
+			pushes = append(pushes, push)
+		}
+	}
+
+	return pushes
+}
+
+func TestSkipSequenceLeavesGapInPrivateMessages(t *testing.T) {
+	s := startSimulator(t)
+	if err := s.SetLiquidity("BTC/USD", "sell", 50000, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dial(t, s)
+	conn.WriteJSON(map[string]any{"method": "subscribe", "params": map[string]any{
+		"channel": "executions", "snapshot": false, "token": s.token,
+	}})
+	waitFor(t, "executions subscription", func() bool { return s.Subscribers(krakenwsclient.ExecutionsChannel, "") == 1 })
+
+	s.SkipSequence(3)
+	conn.WriteJSON(map[string]any{"method": "add_order", "params": map[string]any{
+		"order_type": "limit", "side": "buy", "order_qty": 0.1, "symbol": "BTC/USD",
+		"limit_price": 50000, "cl_ord_id": "c1", "token": s.token,
+	}})
+
+	pushes := readPushes(t, conn, krakenwsclient.ExecutionsChannel, 2)
+	if pushes[0].Sequence != 4 || pushes[1].Sequence != 5 {
+		t.Fatalf("sequences = %d, %d", pushes[0].Sequence, pushes[1].Sequence)
+	}
+
+	var executions []krakenwsclient.Execution
+	if err := json.Unmarshal(pushes[1].Data, &executions); err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 1 || executions[0].ExecType != "trade" || executions[0].OrderStatus != "filled" || executions[0].LastQty != 0.1 {
+		t.Fatalf("executions = %+v", executions)
+	}
+	if balance := s.Balance("BTC"); balance != 1.1 {
+		t.Fatalf("BTC balance = %v", balance)
+	}
+}
+
+func TestSlowClientDoesNotBlockSimulator(t *testing.T) {
+	s := startSimulator(t)
+
+	// The client subscribes and then never reads.
+	conn := dial(t, s)
+	conn.WriteJSON(map[string]any{"method": "subscribe", "params": map[string]any{
+		"channel": "book", "symbol": []string{"BTC/USD"}, "snapshot": true,
+	}})
+	waitFor(t, "book subscription", func() bool { return s.Subscribers(krakenwsclient.BookChannel, "BTC/USD") == 1 })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; s.Subscribers(krakenwsclient.BookChannel, "BTC/USD") > 0; i++ {
+			s.SetLiquidity("BTC/USD", "buy", 40000+float64(i%20), float64(i%7+1))
+		}
+	}()
+
+	// Updates keep going until the client lags outboxSize messages behind
+	// and is dropped, a blocking write would stall them forever.
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("simulator blocked on a client that does not read")
+	}
+}
@@ -0,0 +1,466 @@
+package exchange_simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	krakenwsclient "bitnet/kraken_ws_client"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// client is one websocket connection with its subscriptions. Symbol-less
+// channels (instrument, executions, balances) are stored with an empty symbol.
+//
+// Messages are encoded when sent, under the simulator mutex so that every
+// client sees them in the same order, and written by the writer goroutine of
+// the connection so that a slow client never blocks the simulator.
+type client struct {
+	conn          *websocket.Conn
+	outbox        chan []byte
+	done          chan struct{} // Closed when the connection is gone
+	subscriptions map[krakenwsclient.KrakenWsChannel]map[string]bool
+	sequence      int64 // Sequence of private channel messages
+}
+
+// outboxSize is the number of messages a client may lag behind before it is
+// disconnected.
+const outboxSize = 1000
+
+func newClient(conn *websocket.Conn) *client {
+	return &client{
+		conn:          conn,
+		outbox:        make(chan []byte, outboxSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[krakenwsclient.KrakenWsChannel]map[string]bool),
+	}
+}
+
+func (c *client) subscribed(channel krakenwsclient.KrakenWsChannel, symbol string) bool {
+	return c.subscriptions[channel][symbol]
+}
+
+// send queues v for the writer, a client too slow to keep up is dropped.
+func (c *client) send(v any) {
+	message, err := json.Marshal(v)
+	if err != nil {
+		fmt.Printf("exchange simulator: failed to encode %+v: %v\n", v, err)
+		return
+	}
+
+	select {
+	case c.outbox <- message:
+	case <-c.done:
+	default:
+		fmt.Printf("exchange simulator: client lags %d messages behind, disconnecting\n", outboxSize)
+		c.conn.Close()
+	}
+}
+
+func (c *client) write() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.outbox:
+			c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				fmt.Printf("exchange simulator: failed to write to client: %v\n", err)
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+type request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	ReqId  int64           `json:"req_id,omitempty"`
+}
+
+type subscribeParams struct {
+	Channel  krakenwsclient.KrakenWsChannel `json:"channel"`
+	Symbol   []string                       `json:"symbol"`
+	Depth    int                            `json:"depth"`
+	Snapshot *bool                          `json:"snapshot"`
+	Token    string                         `json:"token"`
+}
+
+type addOrderParams struct {
+	OrderType   string  `json:"order_type"`
+	Side        string  `json:"side"`
+	OrderQty    float64 `json:"order_qty"`
+	Symbol      string  `json:"symbol"`
+	LimitPrice  float64 `json:"limit_price"`
+	TimeInForce string  `json:"time_in_force"` // "gtc" or "ioc"
+	ClOrdId     string  `json:"cl_ord_id"`
+	Token       string  `json:"token"`
+}
+
+type cancelOrderParams struct {
+	OrderId []string `json:"order_id"`
+	ClOrdId []string `json:"cl_ord_id"`
+	Token   string   `json:"token"`
+}
+
+type response struct {
+	Method  string    `json:"method"`
+	Result  any       `json:"result,omitempty"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	ReqId   int64     `json:"req_id,omitempty"`
+	TimeIn  time.Time `json:"time_in"`
+	TimeOut time.Time `json:"time_out"`
+}
+
+type push struct {
+	Channel  krakenwsclient.KrakenWsChannel `json:"channel"`
+	Type     string                         `json:"type"`
+	Data     any                            `json:"data"`
+	Sequence int64                          `json:"sequence,omitempty"`
+}
+
+func isPrivate(channel krakenwsclient.KrakenWsChannel) bool {
+	return channel == krakenwsclient.ExecutionsChannel || channel == krakenwsclient.BalancesChannel
+}
+
+func (s *Simulator) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := newClient(conn)
+	go c.write()
+
+	s.mutex.Lock()
+	s.clients[c] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.clients, c)
+		s.mutex.Unlock()
+		close(c.done)
+		conn.Close()
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req request
+		if err := json.Unmarshal(message, &req); err != nil {
+			c.send(response{Error: "EGeneral:Invalid arguments", TimeIn: time.Now(), TimeOut: time.Now()})
+			continue
+		}
+
+		s.handleRequest(c, req)
+	}
+}
+
+func (s *Simulator) handleRequest(c *client, req request) {
+	timeIn := time.Now().UTC()
+	reply := func(result any, errorMessage string) {
+		c.send(response{
+			Method:  req.Method,
+			Result:  result,
+			Success: errorMessage == "",
+			Error:   errorMessage,
+			ReqId:   req.ReqId,
+			TimeIn:  timeIn,
+			TimeOut: time.Now().UTC(),
+		})
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch req.Method {
+	case "ping":
+		c.send(response{Method: "pong", Success: true, ReqId: req.ReqId, TimeIn: timeIn, TimeOut: time.Now().UTC()})
+	case "subscribe", "unsubscribe":
+		var params subscribeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			reply(nil, "EGeneral:Invalid arguments")
+			return
+		}
+		if isPrivate(params.Channel) && params.Token != s.token {
+			reply(nil, "EAccount:Invalid token")
+			return
+		}
+
+		symbols := params.Symbol
+		if len(symbols) == 0 {
+			symbols = []string{""}
+		}
+		if c.subscriptions[params.Channel] == nil {
+			c.subscriptions[params.Channel] = make(map[string]bool)
+		}
+
+		for _, symbol := range symbols {
+			if symbol != "" {
+				if _, ok := s.books[symbol]; !ok {
+					reply(nil, fmt.Sprintf("Currency pair not supported %s", symbol))
+					continue
+				}
+			}
+
+			if req.Method == "unsubscribe" {
+				delete(c.subscriptions[params.Channel], symbol)
+				reply(map[string]any{"channel": params.Channel, "symbol": symbol}, "")
+				continue
+			}
+
+			c.subscriptions[params.Channel][symbol] = true
+			reply(map[string]any{"channel": params.Channel, "symbol": symbol, "snapshot": params.Snapshot == nil || *params.Snapshot}, "")
+			if params.Snapshot == nil || *params.Snapshot {
+				s.sendSnapshot(c, params.Channel, symbol)
+			}
+		}
+	case "add_order":
+		var params addOrderParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			reply(nil, "EGeneral:Invalid arguments")
+			return
+		}
+		if params.Token != s.token {
+			reply(nil, "EAccount:Invalid token")
+			return
+		}
+
+		order := &simOrder{
+			ClOrdId:   params.ClOrdId,
+			Symbol:    params.Symbol,
+			Side:      params.Side,
+			OrderType: params.OrderType,
+			Price:     params.LimitPrice,
+			Qty:       params.OrderQty,
+		}
+		if params.TimeInForce == "ioc" {
+			order.TimeInForce = "IOC"
+		}
+
+		if reason := s.placeOrder(order); reason != "" {
+			reply(nil, reason)
+			return
+		}
+		reply(map[string]any{"order_id": order.OrderId, "cl_ord_id": order.ClOrdId}, "")
+	case "cancel_order":
+		var params cancelOrderParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			reply(nil, "EGeneral:Invalid arguments")
+			return
+		}
+		if params.Token != s.token {
+			reply(nil, "EAccount:Invalid token")
+			return
+		}
+
+		for _, id := range append(params.OrderId, params.ClOrdId...) {
+			if !s.cancelOrder(id) {
+				reply(map[string]any{"order_id": id}, "EOrder:Unknown order")
+				continue
+			}
+			reply(map[string]any{"order_id": id}, "")
+		}
+	default:
+		reply(nil, "Method not found")
+	}
+}
+
+// sendSnapshot sends the initial state of a channel. Callers hold s.mutex.
+func (s *Simulator) sendSnapshot(c *client, channel krakenwsclient.KrakenWsChannel, symbol string) {
+	switch channel {
+	case krakenwsclient.TickerChannel:
+		c.send(push{Channel: channel, Type: "snapshot", Data: []krakenwsclient.Ticker{s.books[symbol].ticker()}})
+	case krakenwsclient.BookChannel:
+		c.send(push{Channel: channel, Type: "snapshot", Data: []krakenwsclient.Book{s.bookMessage(s.books[symbol])}})
+	case krakenwsclient.InstrumentChannel:
+		c.send(push{Channel: channel, Type: "snapshot", Data: krakenwsclient.InstrumentData{
+			Assets: s.assets(),
+			Pairs:  s.config.Pairs,
+		}})
+	case krakenwsclient.ExecutionsChannel:
+		executions := []krakenwsclient.Execution{}
+		for _, order := range s.orders {
+			executions = append(executions, s.execution(order, "status"))
+		}
+		c.sequence++
+		c.send(push{Channel: channel, Type: "snapshot", Data: executions, Sequence: c.sequence})
+	case krakenwsclient.BalancesChannel:
+		c.sequence++
+		c.send(push{Channel: channel, Type: "snapshot", Data: s.balanceAssets(), Sequence: c.sequence})
+	}
+}
+
+func (s *Simulator) assets() []krakenwsclient.Asset {
+	seen := make(map[string]bool)
+	assets := []krakenwsclient.Asset{}
+	for _, pair := range s.config.Pairs {
+		for _, asset := range []string{pair.Base, pair.Quote} {
+			if seen[asset] {
+				continue
+			}
+			seen[asset] = true
+			assets = append(assets, krakenwsclient.Asset{ID: asset, Precision: 8, PrecisionDisplay: 5, Status: "enabled"})
+		}
+	}
+
+	return assets
+}
+
+func (s *Simulator) balanceAssets() []krakenwsclient.BalanceAsset {
+	balances := []krakenwsclient.BalanceAsset{}
+	for asset, balance := range s.balances {
+		balances = append(balances, krakenwsclient.BalanceAsset{
+			Asset:      asset,
+			AssetClass: "currency",
+			Balance:    balance,
+			Wallets:    []krakenwsclient.Wallet{{Balance: balance, Type: "spot", ID: "main"}},
+		})
+	}
+
+	return balances
+}
+
+// bookMessage builds a book message with the full visible depth and its
+// checksum, corrupted when the scenario asks for it.
+func (s *Simulator) bookMessage(book *simBook) krakenwsclient.Book {
+	bids := book.levels("buy", s.config.BookDepth)
+	asks := book.levels("sell", s.config.BookDepth)
+
+	return krakenwsclient.Book{
+		Symbol:    book.pair.Symbol,
+		Bids:      bids,
+		Asks:      asks,
+		Checksum:  s.checksum(book, bids, asks),
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+func (s *Simulator) checksum(book *simBook, bids []krakenwsclient.BookLevel, asks []krakenwsclient.BookLevel) uint32 {
+	checksum := krakenwsclient.BookChecksum(bids, asks, book.pair.PricePrecision, book.pair.QtyPrecision)
+	if s.scenario.corruptChecksum(book.pair.Symbol) {
+		checksum ^= 0xdeadbeef
+	}
+
+	return checksum
+}
+
+// broadcastBookChanges sends the levels of side that changed compared to the
+// visible window before. Levels that left the window while still in the book
+// are not sent, clients truncate their book to the subscribed depth.
+// Callers hold s.mutex.
+func (s *Simulator) broadcastBookChanges(book *simBook, side string, before map[float64]float64) {
+	after := book.window(side, s.config.BookDepth)
+
+	changed := []krakenwsclient.BookLevel{}
+	for price, qty := range after {
+		if before[price] != qty {
+			changed = append(changed, krakenwsclient.BookLevel{Price: price, Qty: qty})
+		}
+	}
+	for price := range before {
+		if _, visible := after[price]; !visible && book.levelQty(side, price) == 0 {
+			changed = append(changed, krakenwsclient.BookLevel{Price: price, Qty: 0})
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	message := krakenwsclient.Book{
+		Symbol:    book.pair.Symbol,
+		Bids:      []krakenwsclient.BookLevel{},
+		Asks:      []krakenwsclient.BookLevel{},
+		Timestamp: time.Now().UTC(),
+	}
+	if side == "buy" {
+		message.Bids = changed
+	} else {
+		message.Asks = changed
+	}
+	message.Checksum = s.checksum(book, book.levels("buy", s.config.BookDepth), book.levels("sell", s.config.BookDepth))
+
+	s.broadcast(krakenwsclient.BookChannel, book.pair.Symbol, push{
+		Channel: krakenwsclient.BookChannel,
+		Type:    "update",
+		Data:    []krakenwsclient.Book{message},
+	})
+}
+
+func (s *Simulator) broadcastTicker(book *simBook) {
+	s.broadcast(krakenwsclient.TickerChannel, book.pair.Symbol, push{
+		Channel: krakenwsclient.TickerChannel,
+		Type:    "update",
+		Data:    []krakenwsclient.Ticker{book.ticker()},
+	})
+}
+
+func (s *Simulator) broadcastTrades(symbol string, trades []krakenwsclient.Trade) {
+	s.broadcast(krakenwsclient.TradeChannel, symbol, push{
+		Channel: krakenwsclient.TradeChannel,
+		Type:    "update",
+		Data:    trades,
+	})
+}
+
+func (s *Simulator) broadcastExecutions(executions ...krakenwsclient.Execution) {
+	s.broadcastPrivate(krakenwsclient.ExecutionsChannel, "update", executions)
+}
+
+func (s *Simulator) broadcastBalancesSnapshot() {
+	s.broadcastPrivate(krakenwsclient.BalancesChannel, "snapshot", s.balanceAssets())
+}
+
+func (s *Simulator) broadcastBalanceUpdates(refId string, side string, base string, quote string, qty float64, price float64, fee float64) {
+	baseAmount, quoteAmount := qty, -qty*price-fee
+	if side == "sell" {
+		baseAmount, quoteAmount = -qty, qty*price-fee
+	}
+
+	now := time.Now().UTC()
+	s.broadcastPrivate(krakenwsclient.BalancesChannel, "update", []krakenwsclient.LedgerTransaction{
+		{
+			Asset: base, AssetClass: "currency", Amount: baseAmount, Balance: s.balances[base],
+			LedgerID: "L" + refId + "-1", RefID: refId, Timestamp: now, Type: "trade", Subtype: "tradespot",
+			Category: "trade", WalletType: "spot", WalletID: "main",
+		},
+		{
+			Asset: quote, AssetClass: "currency", Amount: quoteAmount, Balance: s.balances[quote], Fee: fee,
+			LedgerID: "L" + refId + "-2", RefID: refId, Timestamp: now, Type: "trade", Subtype: "tradespot",
+			Category: "trade", WalletType: "spot", WalletID: "main",
+		},
+	})
+}
+
+// broadcastPrivate sends a private channel message with the per connection
+// sequence, skipping numbers when the scenario asks for a gap.
+func (s *Simulator) broadcastPrivate(channel krakenwsclient.KrakenWsChannel, messageType string, data any) {
+	gap := s.scenario.takeSequenceGap()
+	for c := range s.clients {
+		if !c.subscribed(channel, "") {
+			continue
+		}
+		c.sequence += 1 + gap
+		c.send(push{Channel: channel, Type: messageType, Data: data, Sequence: c.sequence})
+	}
+}
+
+func (s *Simulator) broadcast(channel krakenwsclient.KrakenWsChannel, symbol string, message push) {
+	for c := range s.clients {
+		if c.subscribed(channel, symbol) {
+			c.send(message)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package kraken_connector
+
+import (
+	"fmt"
+
+	"bitnet/exchange"
+	krakenwsclient "bitnet/kraken_ws_client"
+)
+
+// bookValidator keeps a local copy of every Kraken book to verify the CRC32
+// checksum sent with each book message. Checksums can only be verified once
+// the instrument precisions are known.
+type bookValidator struct {
+	depth      int
+	books      map[string]*exchange.LocalBook
+	precisions map[string][2]int // Symbol to price and qty precision
+	resync     func(symbol string) error
+}
+
+func newBookValidator(depth int, resync func(symbol string) error) *bookValidator {
+	return &bookValidator{
+		depth:      depth,
+		books:      make(map[string]*exchange.LocalBook),
+		precisions: make(map[string][2]int),
+		resync:     resync,
+	}
+}
+
+func (bv *bookValidator) setPrecision(pair krakenwsclient.Pair) {
+	bv.precisions[pair.Symbol] = [2]int{pair.PricePrecision, pair.QtyPrecision}
+}
+
+// apply applies a book message. It returns false when the message has to be
+// skipped because the book waits for a snapshot, and an error when the
+// resulting book does not match the message checksum. On mismatch the local
+// book is dropped until the next snapshot.
+func (bv *bookValidator) apply(messageType string, book krakenwsclient.Book) (bool, error) {
+	localBook, ok := bv.books[book.Symbol]
+	if messageType == "snapshot" {
+		localBook = exchange.NewLocalBook()
+		bv.books[book.Symbol] = localBook
+	} else if !ok {
+		return false, nil
+	}
+	localBook.Apply(normalizeLevels(book.Bids), normalizeLevels(book.Asks))
+
+	// Levels pushed out of the subscribed depth are not deleted explicitly.
+	bids, asks := localBook.Levels(bv.depth)
+	localBook.Reset()
+	localBook.Apply(bids, asks)
+
+	precision, ok := bv.precisions[book.Symbol]
+	if !ok {
+		return true, nil
+	}
+
+	checksum := krakenwsclient.BookChecksum(toKrakenLevels(bids), toKrakenLevels(asks), precision[0], precision[1])
+	if checksum != book.Checksum {
+		delete(bv.books, book.Symbol)
+		return false, fmt.Errorf("checksum mismatch: expected %d, got %d", checksum, book.Checksum)
+	}
+
+	return true, nil
+}
+
+func toKrakenLevels(levels []exchange.BookLevel) []krakenwsclient.BookLevel {
+	converted := make([]krakenwsclient.BookLevel, 0, len(levels))
+	for _, level := range levels {
+		converted = append(converted, krakenwsclient.BookLevel(level))
+	}
+
+	return converted
+}
@@ -0,0 +1,512 @@
+package kraken_connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitnet/exchange"
+	krakenwsclient "bitnet/kraken_ws_client"
+)
+
+const Name = "kraken"
+
+func init() {
+	exchange.Register(Name, New)
+}
+
+// KrakenConnector implements exchange.ExchangeConnector on top of the Kraken v2
+// websocket API for market data and the REST API for trading.
+//
+// Options: ws_url, private_ws_url, rest_url, api_key, api_secret and
+// book_depth, falling back to KRAKEN_PUBLIC_WS_URL, KRAKEN_PRIVATE_WS_URL,
+// KRAKEN_REST_API_URL, KRAKEN_API_KEY and KRAKEN_API_SECRET.
+type KrakenConnector struct {
+	wsConfig        krakenwsclient.KrakenWsClientConfig
+	privateWsConfig krakenwsclient.KrakenWsClientConfig
+	rest            *krakenwsclient.KrakenRestClient
+	bookDepth       int
+	mutex           sync.RWMutex
+	health          exchange.Health
+}
+
+func New(config exchange.Config) (exchange.ExchangeConnector, error) {
+	wsConfig := krakenwsclient.KrakenWsClientConfig{
+		Url:     config.Option("ws_url", os.Getenv("KRAKEN_PUBLIC_WS_URL")),
+		RestUrl: config.Option("rest_url", os.Getenv("KRAKEN_REST_API_URL")),
+	}
+
+	apiKey := config.Option("api_key", os.Getenv("KRAKEN_API_KEY"))
+	apiSecret := config.Option("api_secret", os.Getenv("KRAKEN_API_SECRET"))
+	credentials := &krakenwsclient.KrakenWsClientConfigCredentials{
+		ApiKey:    apiKey,
+		ApiSecret: apiSecret,
+	}
+	if apiKey == "" {
+		credentials = nil
+	}
+
+	bookDepth, err := strconv.Atoi(config.Option("book_depth", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("kraken connector: invalid book_depth: %w", err)
+	}
+
+	restConfig := wsConfig
+	restConfig.Credentials = credentials
+
+	privateWsConfig := restConfig
+	privateWsConfig.Url = config.Option("private_ws_url", os.Getenv("KRAKEN_PRIVATE_WS_URL"))
+
+	return &KrakenConnector{
+		wsConfig:        wsConfig,
+		privateWsConfig: privateWsConfig,
+		rest:            krakenwsclient.NewKrakenRestClient(restConfig),
+		bookDepth:       bookDepth,
+	}, nil
+}
+
+func (k *KrakenConnector) Name() string {
+	return Name
+}
+
+func (k *KrakenConnector) touch(message []byte) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.health.Connected = true
+	k.health.LastMessage = time.Now()
+}
+
+func (k *KrakenConnector) setError(err error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.health.Error = err.Error()
+}
+
+func (k *KrakenConnector) Health() exchange.Health {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return k.health
+}
+
+func (k *KrakenConnector) SubscribeMarketData(ctx context.Context, symbols []string) (<-chan exchange.MarketData, error) {
+	config := k.wsConfig
+	config.OnMessage = k.touch
+	krakenWsClient := krakenwsclient.NewKrakenWsClient(config)
+
+	bookParams := krakenwsclient.SubscribeRequestParams{
+		Channel:  krakenwsclient.BookChannel,
+		Symbol:   symbols,
+		Depth:    k.bookDepth,
+		Snapshot: true,
+	}
+	books := newBookValidator(k.bookDepth, func(symbol string) error {
+		params := bookParams
+		params.Symbol = []string{symbol}
+		return krakenWsClient.Resubscribe(params)
+	})
+
+	updates, err := krakenWsClient.Subscribe(
+		krakenwsclient.SubscribeRequestParams{
+			Channel:      krakenwsclient.TickerChannel,
+			EventTrigger: "bbo",
+			Symbol:       symbols,
+			Snapshot:     true,
+		},
+		bookParams,
+		krakenwsclient.SubscribeRequestParams{
+			Channel:  krakenwsclient.TradeChannel,
+			Symbol:   symbols,
+			Snapshot: false,
+		},
+		krakenwsclient.SubscribeRequestParams{
+			Channel:  krakenwsclient.InstrumentChannel,
+			Snapshot: true,
+		},
+	)
+	if err != nil {
+		krakenWsClient.Close()
+		k.setError(err)
+		return nil, err
+	}
+
+	marketData := make(chan exchange.MarketData, 20)
+
+	go func() {
+		<-ctx.Done()
+		krakenWsClient.Close()
+	}()
+
+	go func() {
+		defer close(marketData)
+		defer func() {
+			k.mutex.Lock()
+			k.health.Connected = false
+			k.mutex.Unlock()
+		}()
+
+		for update := range updates {
+			for _, data := range k.normalize(update, books) {
+				select {
+				case marketData <- data:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return marketData, nil
+}
+
+func (k *KrakenConnector) normalize(update krakenwsclient.ResponseMessage, books *bookValidator) []exchange.MarketData {
+	normalized := []exchange.MarketData{}
+
+	switch update.Channel {
+	case krakenwsclient.TickerChannel:
+		var tickersData []krakenwsclient.Ticker
+		if err := json.Unmarshal(update.Data, &tickersData); err != nil {
+			log.Printf("error unmarshalling ticker message: %v\n", err)
+			return nil
+		}
+
+		for _, ticker := range tickersData {
+			normalizedTicker := exchange.Ticker(ticker)
+			normalized = append(normalized, exchange.MarketData{
+				Venue:  Name,
+				Type:   exchange.TickerData,
+				Ticker: &normalizedTicker,
+			})
+		}
+	case krakenwsclient.BookChannel:
+		var booksData []krakenwsclient.Book
+		if err := json.Unmarshal(update.Data, &booksData); err != nil {
+			log.Printf("error unmarshalling book message: %v\n", err)
+			return nil
+		}
+
+		for _, book := range booksData {
+			publish, err := books.apply(update.Type, book)
+			if err != nil {
+				log.Printf("kraken book %s out of sync: %v, resubscribing\n", book.Symbol, err)
+				if err := books.resync(book.Symbol); err != nil {
+					log.Printf("failed to resubscribe to book %s: %v\n", book.Symbol, err)
+				}
+				continue
+			}
+			if !publish {
+				continue
+			}
+
+			normalized = append(normalized, exchange.MarketData{
+				Venue: Name,
+				Type:  exchange.BookData,
+				Book: &exchange.BookUpdate{
+					Venue:     Name,
+					Symbol:    book.Symbol,
+					Type:      update.Type,
+					Bids:      normalizeLevels(book.Bids),
+					Asks:      normalizeLevels(book.Asks),
+					Sequence:  update.Sequence,
+					Timestamp: book.Timestamp,
+				},
+			})
+		}
+	case krakenwsclient.TradeChannel:
+		var tradesData []krakenwsclient.Trade
+		if err := json.Unmarshal(update.Data, &tradesData); err != nil {
+			log.Printf("error unmarshalling trade message: %v\n", err)
+			return nil
+		}
+
+		for _, trade := range tradesData {
+			normalized = append(normalized, exchange.MarketData{
+				Venue: Name,
+				Type:  exchange.TradeData,
+				Trade: &exchange.Trade{
+					Symbol:    trade.Symbol,
+					Side:      trade.Side,
+					Price:     trade.Price,
+					Qty:       trade.Qty,
+					TradeId:   strconv.FormatInt(trade.TradeId, 10),
+					Timestamp: trade.Timestamp,
+				},
+			})
+		}
+	case krakenwsclient.InstrumentChannel:
+		var instrumentData krakenwsclient.InstrumentData
+		if err := json.Unmarshal(update.Data, &instrumentData); err != nil {
+			log.Printf("error unmarshalling instrument message: %v\n", err)
+			return nil
+		}
+
+		for _, pair := range instrumentData.Pairs {
+			books.setPrecision(pair)
+			instrument := normalizeInstrument(pair)
+			normalized = append(normalized, exchange.MarketData{
+				Venue:      Name,
+				Type:       exchange.InstrumentData,
+				Instrument: &instrument,
+			})
+		}
+	default:
+		//
+	}
+
+	return normalized
+}
+
+func normalizeLevels(levels []krakenwsclient.BookLevel) []exchange.BookLevel {
+	normalized := make([]exchange.BookLevel, 0, len(levels))
+	for _, level := range levels {
+		normalized = append(normalized, exchange.BookLevel(level))
+	}
+
+	return normalized
+}
+
+func normalizeInstrument(pair krakenwsclient.Pair) exchange.Instrument {
+	return exchange.Instrument{
+		Symbol:         pair.Symbol,
+		Base:           pair.Base,
+		Quote:          pair.Quote,
+		PriceIncrement: pair.PriceIncrement,
+		PricePrecision: pair.PricePrecision,
+		QtyIncrement:   pair.QtyIncrement,
+		QtyPrecision:   pair.QtyPrecision,
+		QtyMin:         pair.QtyMin,
+		CostMin:        pair.CostMin,
+		Status:         pair.Status,
+	}
+}
+
+// Instruments reads the instrument snapshot from a short lived websocket
+// subscription.
+func (k *KrakenConnector) Instruments(ctx context.Context) ([]exchange.Instrument, error) {
+	krakenWsClient := krakenwsclient.NewKrakenWsClient(k.wsConfig)
+	defer krakenWsClient.Close()
+
+	updates, err := krakenWsClient.Subscribe(krakenwsclient.SubscribeRequestParams{
+		Channel:  krakenwsclient.InstrumentChannel,
+		Snapshot: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case update, ok := <-updates:
+			if !ok {
+				return nil, fmt.Errorf("kraken connector: connection closed before instrument snapshot")
+			}
+			if update.Channel != krakenwsclient.InstrumentChannel || update.Type != "snapshot" {
+				continue
+			}
+
+			var instrumentData krakenwsclient.InstrumentData
+			if err := json.Unmarshal(update.Data, &instrumentData); err != nil {
+				return nil, err
+			}
+
+			instruments := make([]exchange.Instrument, 0, len(instrumentData.Pairs))
+			for _, pair := range instrumentData.Pairs {
+				instruments = append(instruments, normalizeInstrument(pair))
+			}
+
+			return instruments, nil
+		}
+	}
+}
+
+// restPair converts a "BASE/QUOTE" symbol to the REST pair name.
+func restPair(symbol string) string {
+	return strings.ReplaceAll(symbol, "/", "")
+}
+
+func (k *KrakenConnector) PlaceOrder(ctx context.Context, request exchange.OrderRequest) (exchange.OrderAck, error) {
+	params := krakenwsclient.AddOrderParams{
+		Pair:      restPair(request.Symbol),
+		Type:      request.Side,
+		OrderType: request.OrderType,
+		Volume:    request.Quantity,
+		Price:     request.Price,
+		ClOrdId:   request.ClientOrderId,
+	}
+
+	switch request.TimeInForce {
+	case "", "gtc":
+	case "ioc":
+		params.TimeInForce = "IOC"
+	default:
+		return exchange.OrderAck{}, fmt.Errorf("kraken connector: time in force %q: %w", request.TimeInForce, exchange.ErrNotSupported)
+	}
+
+	result, err := k.rest.AddOrder(params)
+	if err != nil {
+		return exchange.OrderAck{}, err
+	}
+	if len(result.TxId) == 0 {
+		return exchange.OrderAck{}, fmt.Errorf("kraken connector: AddOrder returned no txid")
+	}
+
+	return exchange.OrderAck{
+		OrderId:       result.TxId[0],
+		ClientOrderId: request.ClientOrderId,
+	}, nil
+}
+
+func (k *KrakenConnector) CancelOrder(ctx context.Context, orderId string) error {
+	_, err := k.rest.CancelOrder(orderId)
+
+	return err
+}
+
+func (k *KrakenConnector) Balances(ctx context.Context) ([]exchange.Balance, error) {
+	extendedBalances, err := k.rest.BalanceEx()
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]exchange.Balance, 0, len(extendedBalances))
+	for asset, extendedBalance := range extendedBalances {
+		total, err := strconv.ParseFloat(extendedBalance.Balance, 64)
+		if err != nil {
+			return nil, err
+		}
+		held, _ := strconv.ParseFloat(extendedBalance.HoldTrade, 64)
+
+		balances = append(balances, exchange.Balance{
+			Asset:     NormalizeAsset(asset),
+			Total:     total,
+			Available: total - held,
+		})
+	}
+
+	return balances, nil
+}
+
+// SubscribeExecutions streams the executions of our orders from the private
+// executions channel until ctx is cancelled.
+func (k *KrakenConnector) SubscribeExecutions(ctx context.Context) (<-chan exchange.Execution, error) {
+	if k.privateWsConfig.Credentials == nil {
+		return nil, fmt.Errorf("kraken connector: executions require api credentials")
+	}
+
+	krakenWsClient := krakenwsclient.NewKrakenWsClient(k.privateWsConfig)
+	if krakenWsClient == nil {
+		return nil, fmt.Errorf("kraken connector: unable to get a websocket token")
+	}
+
+	params := krakenwsclient.SubscribeRequestParams{
+		Channel:  krakenwsclient.ExecutionsChannel,
+		Snapshot: false,
+	}
+	updates, err := krakenWsClient.Subscribe(params)
+	if err != nil {
+		krakenWsClient.Close()
+		return nil, err
+	}
+
+	executions := make(chan exchange.Execution, 20)
+
+	go func() {
+		<-ctx.Done()
+		krakenWsClient.Close()
+	}()
+
+	go func() {
+		defer close(executions)
+
+		// Private messages are numbered per connection. A gap means reports
+		// were lost, subscribing again with a snapshot resends the state of
+		// every open order. A lower number is a new connection.
+		var sequence int64
+		for update := range updates {
+			if update.Sequence != 0 {
+				if sequence != 0 && update.Sequence > sequence+1 {
+					log.Printf("kraken executions: sequence gap, expected %d, got %d, resubscribing\n", sequence+1, update.Sequence)
+					resync := params
+					resync.Snapshot = true
+					if err := krakenWsClient.Resubscribe(resync); err != nil {
+						log.Printf("failed to resubscribe to kraken executions: %v\n", err)
+					}
+				}
+				sequence = update.Sequence
+			}
+
+			if update.Channel != krakenwsclient.ExecutionsChannel {
+				continue
+			}
+
+			var executionsData []krakenwsclient.Execution
+			if err := json.Unmarshal(update.Data, &executionsData); err != nil {
+				log.Printf("failed to unmarshal kraken executions: %v\n", err)
+				continue
+			}
+
+			for _, execution := range executionsData {
+				select {
+				case executions <- normalizeExecution(execution):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return executions, nil
+}
+
+func normalizeExecution(execution krakenwsclient.Execution) exchange.Execution {
+	normalized := exchange.Execution{
+		Venue:         Name,
+		OrderId:       execution.OrderId,
+		ClientOrderId: execution.ClOrdId,
+		ExecId:        execution.ExecId,
+		Symbol:        execution.Symbol,
+		Side:          execution.Side,
+		Status:        execution.OrderStatus,
+		Quantity:      execution.OrderQty,
+		LastQty:       execution.LastQty,
+		LastPrice:     execution.LastPrice,
+		CumQty:        execution.CumQty,
+		AvgPrice:      execution.AvgPrice,
+		Liquidity:     execution.LiquidityInd,
+		Reason:        execution.Reason,
+		Timestamp:     execution.Timestamp,
+	}
+
+	for _, fee := range execution.Fees {
+		normalized.Fee += fee.Qty
+		normalized.FeeAsset = fee.Asset
+	}
+
+	return normalized
+}
+
+// NormalizeAsset maps Kraken REST asset codes such as "XXBT" or "ZUSD" to
+// the websocket names ("BTC", "USD") used everywhere else.
+func NormalizeAsset(asset string) string {
+	if len(asset) == 4 && (asset[0] == 'X' || asset[0] == 'Z') {
+		asset = asset[1:]
+	}
+	if asset == "XBT" {
+		return "BTC"
+	}
+	if asset == "XDG" {
+		return "DOGE"
+	}
+
+	return asset
+}
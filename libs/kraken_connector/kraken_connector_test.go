@@ -0,0 +1,89 @@
+package kraken_connector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bitnet/exchange"
+	exchangesimulator "bitnet/exchange_simulator"
+	krakenwsclient "bitnet/kraken_ws_client"
+)
+
+func nextExecution(t *testing.T, executions <-chan exchange.Execution) exchange.Execution {
+	t.Helper()
+
+	select {
+	case execution := <-executions:
+		return execution
+	case <-time.After(5 * time.Second):
+		t.Fatal("no execution received")
+		return exchange.Execution{}
+	}
+}
+
+func TestSubscribeExecutionsResyncsOnSequenceGap(t *testing.T) {
+	simulator := exchangesimulator.New(exchangesimulator.Config{
+		Pairs: []krakenwsclient.Pair{{
+			Symbol: "BTC/USD", Base: "BTC", Quote: "USD",
+			PricePrecision: 1, QtyPrecision: 8, QtyMin: 0.0001, CostMin: 0.5, Status: "online",
+		}},
+		Balances: map[string]float64{"BTC": 1, "USD": 100000},
+	})
+	if err := simulator.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer simulator.Close()
+
+	connector, err := exchange.Open(exchange.Config{
+		Name: Name,
+		Options: map[string]string{
+			"ws_url":         simulator.WsUrl(),
+			"private_ws_url": simulator.WsUrl(),
+			"rest_url":       simulator.RestUrl(),
+			"api_key":        "key",
+			"api_secret":     "c2VjcmV0",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	executions, err := connector.(exchange.ExecutionReporter).SubscribeExecutions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for simulator.Subscribers(krakenwsclient.ExecutionsChannel, "") == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ack, err := connector.PlaceOrder(ctx, exchange.OrderRequest{
+		Symbol: "BTC/USD", Side: "buy", OrderType: "limit", Quantity: 0.1, Price: 49000, ClientOrderId: "c1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execution := nextExecution(t, executions); execution.OrderId != ack.OrderId || execution.Status != "new" {
+		t.Fatalf("new execution = %+v", execution)
+	}
+
+	// The trade report skips sequence numbers, the connector subscribes
+	// again with a snapshot that resends the state of the open order.
+	simulator.SkipSequence(3)
+	if _, err := simulator.MarketTrade("BTC/USD", "sell", 0.04); err != nil {
+		t.Fatal(err)
+	}
+
+	trade := nextExecution(t, executions)
+	if trade.ExecId == "" || trade.LastQty != 0.04 || trade.Status != "partially_filled" {
+		t.Fatalf("trade execution = %+v", trade)
+	}
+
+	state := nextExecution(t, executions)
+	if state.OrderId != ack.OrderId || state.ExecId != "" || state.CumQty != 0.04 || state.Status != "partially_filled" {
+		t.Fatalf("execution after resync = %+v", state)
+	}
+}
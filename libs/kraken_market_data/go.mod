@@ -1,13 +1,22 @@
 module bitnet/kraken_market_data
 
+replace bitnet/exchange => ../../libs/exchange
+
+replace bitnet/kraken_connector => ../../libs/kraken_connector
+
 replace bitnet/kraken_ws_client => ../../libs/kraken_ws_client
 
+replace bitnet/market_data => ../../libs/market_data
+
 require (
-	bitnet/kraken_ws_client v0.0.0-00010101000000-000000000000
+	bitnet/exchange v0.0.0-00010101000000-000000000000
+	bitnet/kraken_connector v0.0.0-00010101000000-000000000000
+	bitnet/market_data v0.0.0-00010101000000-000000000000
 	github.com/nats-io/nats.go v1.37.0
 )
 
 require (
+	bitnet/kraken_ws_client v0.0.0-00010101000000-000000000000 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
@@ -0,0 +1,40 @@
+package kraken_ws_client
+
+import (
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// checksumDepth is the number of levels per side covered by book checksums.
+const checksumDepth = 10
+
+// BookChecksum computes the CRC32 checksum Kraken attaches to v2 book
+// messages. asks must be sorted by ascending and bids by descending price,
+// only the top 10 levels of each side are used. Prices and quantities are
+// formatted with the instrument precisions, stripped of the decimal point
+// and of leading zeros.
+func BookChecksum(bids []BookLevel, asks []BookLevel, pricePrecision int, qtyPrecision int) uint32 {
+	var builder strings.Builder
+
+	write := func(levels []BookLevel) {
+		for i, level := range levels {
+			if i == checksumDepth {
+				break
+			}
+			builder.WriteString(checksumField(level.Price, pricePrecision))
+			builder.WriteString(checksumField(level.Qty, qtyPrecision))
+		}
+	}
+	write(asks)
+	write(bids)
+
+	return crc32.ChecksumIEEE([]byte(builder.String()))
+}
+
+func checksumField(value float64, precision int) string {
+	formatted := strconv.FormatFloat(value, 'f', precision, 64)
+	formatted = strings.Replace(formatted, ".", "", 1)
+
+	return strings.TrimLeft(formatted, "0")
+}
@@ -0,0 +1,155 @@
+package kraken_ws_client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+type RestResponse struct {
+	Error  []string        `json:"error"`
+	Result json.RawMessage `json:"result"`
+}
+
+type AddOrderParams struct {
+	Pair        string  // REST pair name or "BASE/QUOTE" websocket name
+	Type        string  // "buy" or "sell"
+	OrderType   string  // "limit" or "market"
+	Volume      float64 // Order quantity in base currency
+	Price       float64 // Limit price, ignored for market orders
+	TimeInForce string  // "GTC", "IOC" or "GTD", empty means GTC
+	ClOrdId     string  // Optional client order id
+}
+
+type AddOrderResult struct {
+	Descr struct {
+		Order string `json:"order"`
+	} `json:"descr"`
+	TxId []string `json:"txid"`
+}
+
+type CancelOrderResult struct {
+	Count int `json:"count"`
+}
+
+type ExtendedBalance struct {
+	Balance   string `json:"balance"`
+	HoldTrade string `json:"hold_trade"`
+}
+
+type KrakenRestClient struct {
+	baseUrl     string
+	credentials *KrakenWsClientConfigCredentials
+	httpClient  *http.Client
+}
+
+func restBaseUrl(config KrakenWsClientConfig) string {
+	if config.RestUrl != "" {
+		return config.RestUrl
+	}
+
+	return os.Getenv("KRAKEN_REST_API_URL")
+}
+
+// NewKrakenRestClient creates a client for Kraken's private REST endpoints
+// using the REST url and credentials of the websocket client config.
+func NewKrakenRestClient(config KrakenWsClientConfig) *KrakenRestClient {
+	return &KrakenRestClient{
+		baseUrl:     restBaseUrl(config),
+		credentials: config.Credentials,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (r *KrakenRestClient) privateRequest(path string, params map[string]interface{}, result any) error {
+	if r.credentials == nil {
+		return fmt.Errorf("kraken private request %s requires credentials", path)
+	}
+
+	params["nonce"] = fmt.Sprintf("%d", time.Now().UnixNano())
+
+	signature, err := createSignature(path, params, r.credentials.ApiSecret)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	for key, value := range params {
+		form.Set(key, fmt.Sprintf("%v", value))
+	}
+
+	req, err := http.NewRequest("POST", r.baseUrl+path, bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("API-Key", r.credentials.ApiKey)
+	req.Header.Set("API-Sign", signature)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var restResponse RestResponse
+	if err := json.Unmarshal(body, &restResponse); err != nil {
+		return err
+	}
+
+	if len(restResponse.Error) > 0 {
+		return fmt.Errorf("kraken API error: %v", restResponse.Error)
+	}
+
+	return json.Unmarshal(restResponse.Result, result)
+}
+
+func (r *KrakenRestClient) AddOrder(params AddOrderParams) (AddOrderResult, error) {
+	request := map[string]interface{}{
+		"pair":      params.Pair,
+		"type":      params.Type,
+		"ordertype": params.OrderType,
+		"volume":    strconv.FormatFloat(params.Volume, 'f', -1, 64),
+	}
+	if params.OrderType == "limit" {
+		request["price"] = strconv.FormatFloat(params.Price, 'f', -1, 64)
+	}
+	if params.TimeInForce != "" {
+		request["timeinforce"] = params.TimeInForce
+	}
+	if params.ClOrdId != "" {
+		request["cl_ord_id"] = params.ClOrdId
+	}
+
+	var result AddOrderResult
+	err := r.privateRequest("/0/private/AddOrder", request, &result)
+
+	return result, err
+}
+
+func (r *KrakenRestClient) CancelOrder(txId string) (CancelOrderResult, error) {
+	var result CancelOrderResult
+	err := r.privateRequest("/0/private/CancelOrder", map[string]interface{}{"txid": txId}, &result)
+
+	return result, err
+}
+
+// BalanceEx returns per asset balances together with the amount held by
+// open orders.
+func (r *KrakenRestClient) BalanceEx() (map[string]ExtendedBalance, error) {
+	var result map[string]ExtendedBalance
+	err := r.privateRequest("/0/private/BalanceEx", map[string]interface{}{}, &result)
+
+	return result, err
+}
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,6 +23,8 @@ type KrakenWsChannel string
 
 const (
 	TickerChannel     KrakenWsChannel = "ticker"
+	BookChannel       KrakenWsChannel = "book"
+	TradeChannel      KrakenWsChannel = "trade"
 	InstrumentChannel KrakenWsChannel = "instrument"
 	BalancesChannel   KrakenWsChannel = "balances"
 	ExecutionsChannel KrakenWsChannel = "executions"
@@ -29,8 +32,9 @@ const (
 
 type SubscribeRequestParams struct {
 	Channel      KrakenWsChannel `json:"channel"`
-	EventTrigger string          `json:"event_trigger"`
-	Symbol       []string        `json:"symbol"`
+	EventTrigger string          `json:"event_trigger,omitempty"`
+	Symbol       []string        `json:"symbol,omitempty"`
+	Depth        int             `json:"depth,omitempty"` // Book channel only: 10, 25, 100, 500 or 1000
 	Snapshot     bool            `json:"snapshot"`
 }
 
@@ -83,6 +87,29 @@ func (t Ticker) GetSymbol() string {
 	return t.Symbol
 }
 
+type BookLevel struct {
+	Price float64 `json:"price"`
+	Qty   float64 `json:"qty"`
+}
+
+type Book struct {
+	Symbol    string      `json:"symbol"`
+	Bids      []BookLevel `json:"bids"`
+	Asks      []BookLevel `json:"asks"`
+	Checksum  uint32      `json:"checksum"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+type Trade struct {
+	Symbol    string    `json:"symbol"`
+	Side      string    `json:"side"`
+	Price     float64   `json:"price"`
+	Qty       float64   `json:"qty"`
+	OrdType   string    `json:"ord_type"`
+	TradeId   int64     `json:"trade_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 type Asset struct {
 	Borrowable       bool    `json:"borrowable"`
 	CollateralValue  float64 `json:"collateral_value"`
@@ -155,6 +182,36 @@ type BalanceUpdate struct {
 	Transactions []LedgerTransaction `json:"data"`
 }
 
+type ExecutionFee struct {
+	Asset string  `json:"asset"`
+	Qty   float64 `json:"qty"`
+}
+
+// Execution is an executions channel report. ExecType tells what happened
+// ("new", "trade", "filled", "canceled", "expired", ...), OrderStatus is the
+// resulting order state.
+type Execution struct {
+	OrderId      string         `json:"order_id"`
+	ClOrdId      string         `json:"cl_ord_id,omitempty"`
+	ExecId       string         `json:"exec_id,omitempty"`
+	ExecType     string         `json:"exec_type"`
+	OrderStatus  string         `json:"order_status"`
+	Symbol       string         `json:"symbol"`
+	Side         string         `json:"side"`
+	OrderType    string         `json:"order_type"`
+	OrderQty     float64        `json:"order_qty"`
+	LimitPrice   float64        `json:"limit_price,omitempty"`
+	LastQty      float64        `json:"last_qty,omitempty"`
+	LastPrice    float64        `json:"last_price,omitempty"`
+	CumQty       float64        `json:"cum_qty"`
+	CumCost      float64        `json:"cum_cost"`
+	AvgPrice     float64        `json:"avg_price"`
+	Fees         []ExecutionFee `json:"fees,omitempty"`
+	LiquidityInd string         `json:"liquidity_ind,omitempty"` // "t" taker or "m" maker
+	Reason       string         `json:"reason,omitempty"`
+	Timestamp    time.Time      `json:"timestamp"`
+}
+
 type TokenResponse struct {
 	Error  []string `json:"error"`
 	Result struct {
@@ -169,15 +226,20 @@ type KrakenWsClientConfigCredentials struct {
 
 type KrakenWsClientConfig struct {
 	Url         string
+	RestUrl     string // REST API base url, defaults to KRAKEN_REST_API_URL
 	Credentials *KrakenWsClientConfigCredentials
+	OnMessage   func(message []byte) // Optional hook called with every raw frame received
 }
 
 type KrakenWsClient struct {
-	config    KrakenWsClientConfig
-	Conn      *websocket.Conn
-	isPrivate bool
-	token     string
-	Db        *pgxpool.Pool
+	config     KrakenWsClientConfig
+	Conn       *websocket.Conn
+	isPrivate  bool
+	token      string
+	Db         *pgxpool.Pool
+	params     []SubscribeRequestParams
+	closed     chan struct{}
+	writeMutex sync.Mutex
 }
 
 func createSignature(urlPath string, data interface{}, secret string) (string, error) {
@@ -212,7 +274,7 @@ func createSignature(urlPath string, data interface{}, secret string) (string, e
 	return sigDigest, nil
 }
 
-func getWebSocketToken(credentials KrakenWsClientConfigCredentials) (string, error) {
+func getWebSocketToken(restUrl string, credentials KrakenWsClientConfigCredentials) (string, error) {
 	nonce := fmt.Sprintf("%d", time.Now().UnixNano())
 	data := "nonce=" + nonce
 
@@ -225,7 +287,11 @@ func getWebSocketToken(credentials KrakenWsClientConfigCredentials) (string, err
 		return "", err
 	}
 
-	wsGetTokenUrl := os.Getenv("KRAKEN_REST_API_URL") + os.Getenv("KRAKEN_GET_WEBSOCKET_TOKEN_PATH")
+	tokenPath := os.Getenv("KRAKEN_GET_WEBSOCKET_TOKEN_PATH")
+	if tokenPath == "" {
+		tokenPath = "/0/private/GetWebSocketsToken"
+	}
+	wsGetTokenUrl := restUrl + tokenPath
 	req, err := http.NewRequest("POST", wsGetTokenUrl, bytes.NewBufferString(data))
 	if err != nil {
 		return "", err
@@ -263,12 +329,14 @@ func NewKrakenWsClient(config KrakenWsClientConfig) *KrakenWsClient {
 	krakenWsClient := KrakenWsClient{
 		config: config,
 		Conn:   conn,
+		closed: make(chan struct{}),
 	}
 
 	if config.Credentials != nil {
 		krakenWsClient.isPrivate = true
-		token, err := getWebSocketToken(*config.Credentials)
+		token, err := getWebSocketToken(restBaseUrl(config), *config.Credentials)
 		if err != nil {
+			conn.Close()
 			return nil
 		}
 
@@ -295,15 +363,20 @@ func reconnect(url string) *websocket.Conn {
 	return wsConn
 }
 
-func (k *KrakenWsClient) Subscribe(paramsSet ...SubscribeRequestParams) (chan ResponseMessage, error) {
-	responseMessages := make(chan ResponseMessage, 20)
+func (k *KrakenWsClient) sendSubscribe(paramsSet ...SubscribeRequestParams) error {
+	return k.sendRequest("subscribe", paramsSet...)
+}
+
+func (k *KrakenWsClient) sendRequest(method string, paramsSet ...SubscribeRequestParams) error {
+	k.writeMutex.Lock()
+	defer k.writeMutex.Unlock()
 
 	for _, params := range paramsSet {
 		var subscribeRequest any
 
 		if k.isPrivate {
 			subscribeRequest = SubscribeRequestToPrivate{
-				Method: "subscribe",
+				Method: method,
 				Params: SubscribeRequestToPrivateParams{
 					SubscribeRequestParams: params,
 					Token:                  k.token,
@@ -311,33 +384,105 @@ func (k *KrakenWsClient) Subscribe(paramsSet ...SubscribeRequestParams) (chan Re
 			}
 		} else {
 			subscribeRequest = SubscribeRequest{
-				Method: "subscribe",
+				Method: method,
 				Params: params,
 			}
 		}
 
 		if err := k.Conn.WriteJSON(subscribeRequest); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
+	return nil
+}
+
+// Subscribe subscribes to the given channels and returns a channel with every
+// message received. When the connection drops it is re-established and the
+// channels are subscribed again. The returned channel is closed by Close.
+func (k *KrakenWsClient) Subscribe(paramsSet ...SubscribeRequestParams) (chan ResponseMessage, error) {
+	responseMessages := make(chan ResponseMessage, 20)
+
+	k.params = paramsSet
+	if err := k.sendSubscribe(paramsSet...); err != nil {
+		return nil, err
+	}
+
 	go func() {
+		defer close(responseMessages)
+
 		for {
 			_, mesasge, err := k.Conn.ReadMessage()
 			if err != nil {
-				fmt.Printf("error reading message: %v..\n", err)
+				select {
+				case <-k.closed:
+					return
+				default:
+				}
+
+				fmt.Printf("error reading message: %v, reconnecting..\n", err)
+				k.Conn.Close()
+				conn := reconnect(k.config.Url)
+				k.writeMutex.Lock()
+				select {
+				case <-k.closed:
+					// Closed while reconnecting, Close did not see conn.
+					k.writeMutex.Unlock()
+					conn.Close()
+					return
+				default:
+				}
+				k.Conn = conn
+				k.writeMutex.Unlock()
+				if err := k.sendSubscribe(k.params...); err != nil {
+					fmt.Printf("error resubscribing: %v\n", err)
+				}
 				continue
 			}
 
+			if k.config.OnMessage != nil {
+				k.config.OnMessage(mesasge)
+			}
+
 			var responseMessage ResponseMessage
 			if err = json.Unmarshal(mesasge, &responseMessage); err != nil {
 				fmt.Printf("Error unmarshalling ticker message: %v\n", err)
 				continue
 			}
 
-			responseMessages <- responseMessage
+			select {
+			case responseMessages <- responseMessage:
+			case <-k.closed:
+				return
+			}
 		}
 	}()
 
 	return responseMessages, nil
 }
+
+// Resubscribe unsubscribes and subscribes again to the given channels, which
+// makes Kraken send fresh snapshots. It is used to resync a broken book.
+func (k *KrakenWsClient) Resubscribe(paramsSet ...SubscribeRequestParams) error {
+	if err := k.sendRequest("unsubscribe", paramsSet...); err != nil {
+		return err
+	}
+
+	return k.sendRequest("subscribe", paramsSet...)
+}
+
+// Close closes the connection and stops the subscription started by Subscribe.
+// It holds writeMutex, the read loop replaces Conn under it on reconnect.
+func (k *KrakenWsClient) Close() error {
+	k.writeMutex.Lock()
+	defer k.writeMutex.Unlock()
+
+	select {
+	case <-k.closed:
+		return nil
+	default:
+	}
+	close(k.closed)
+
+	return k.Conn.Close()
+}
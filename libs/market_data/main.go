@@ -0,0 +1,66 @@
+package market_data
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"bitnet/exchange"
+
+	"github.com/nats-io/nats.go"
+)
+
+// MarketDataProvider publishes the normalized market data of any
+// exchange.ExchangeConnector on NATS:
+//
+//	market.<venue>.<symbol>       tickers
+//	market_book.<venue>.<symbol>  order book snapshots and updates
+//	market_trade.<venue>.<symbol> public trades
+//	market_info.<venue>.<symbol>  instruments
+type MarketDataProvider struct {
+	natsClient *nats.Conn
+	connector  exchange.ExchangeConnector
+}
+
+func New(natsClient *nats.Conn, connector exchange.ExchangeConnector) *MarketDataProvider {
+	return &MarketDataProvider{
+		natsClient: natsClient,
+		connector:  connector,
+	}
+}
+
+func (m *MarketDataProvider) Run(ctx context.Context, enabledPairs []string) {
+	updates, err := m.connector.SubscribeMarketData(ctx, enabledPairs)
+	if err != nil {
+		log.Printf("can not subscribe to %s market data: %v\n", m.connector.Name(), err)
+		return
+	}
+
+	venue := m.connector.Name()
+	for update := range updates {
+		switch update.Type {
+		case exchange.TickerData:
+			m.publish(exchange.TickerSubject(venue, update.Ticker.Symbol), update.Ticker)
+		case exchange.BookData:
+			m.publish(exchange.BookSubject(venue, update.Book.Symbol), update.Book)
+		case exchange.TradeData:
+			m.publish(exchange.TradeSubject(venue, update.Trade.Symbol), update.Trade)
+		case exchange.InstrumentData:
+			m.publish(exchange.InstrumentSubject(venue, update.Instrument.Symbol), update.Instrument)
+		default:
+			//
+		}
+	}
+}
+
+func (m *MarketDataProvider) publish(subject string, v any) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("failed to marshal %+v: %+v\n", v, err)
+		return
+	}
+
+	if err := m.natsClient.Publish(subject, encoded); err != nil {
+		log.Printf("unable to publish to %s: %+v\n", subject, err)
+	}
+}
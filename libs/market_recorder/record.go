@@ -0,0 +1,87 @@
+package market_recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+type Source string
+
+const (
+	WsFrame     Source = "ws"   // Raw websocket frame as received from the venue
+	NatsMessage Source = "nats" // Normalized message published on NATS
+)
+
+// Record is one captured message. Files are gzip compressed JSON lines, one
+// record per line, in the order they were received.
+type Record struct {
+	Time    time.Time       `json:"time"`
+	Source  Source          `json:"source"`
+	Subject string          `json:"subject,omitempty"` // NATS subject, or the venue for websocket frames
+	Data    json.RawMessage `json:"data"`
+}
+
+// Reader reads the records of a file written by Recorder.
+type Reader struct {
+	file    *os.File
+	gzip    *gzip.Reader
+	decoder *json.Decoder
+}
+
+func OpenReader(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gzipReader, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Reader{
+		file:    file,
+		gzip:    gzipReader,
+		decoder: json.NewDecoder(gzipReader),
+	}, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the file. A file cut
+// short by a crash of the recorder also ends with io.EOF.
+func (r *Reader) Next() (Record, error) {
+	var record Record
+	err := r.decoder.Decode(&record)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return record, io.EOF
+	}
+
+	return record, err
+}
+
+// ReadAll reads every remaining record.
+func (r *Reader) ReadAll() ([]Record, error) {
+	records := []Record{}
+	for {
+		record, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+
+		records = append(records, record)
+	}
+}
+
+func (r *Reader) Close() error {
+	r.gzip.Close()
+
+	return r.file.Close()
+}
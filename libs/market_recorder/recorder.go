@@ -0,0 +1,128 @@
+package market_recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Recorder captures raw websocket frames and NATS messages to a compressed
+// file. It is safe for concurrent use, e.g. several websocket clients and NATS
+// subscriptions writing to the same file.
+type Recorder struct {
+	mutex         sync.Mutex
+	file          *os.File
+	buffer        *bufio.Writer
+	gzip          *gzip.Writer
+	encoder       *json.Encoder
+	subscriptions []*nats.Subscription
+	closed        bool
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := bufio.NewWriter(file)
+	gzipWriter := gzip.NewWriter(buffer)
+
+	return &Recorder{
+		file:    file,
+		buffer:  buffer,
+		gzip:    gzipWriter,
+		encoder: json.NewEncoder(gzipWriter),
+	}, nil
+}
+
+// FrameHook returns a function recording raw frames of venue, meant for
+// KrakenWsClientConfig.OnMessage:
+//
+//	config.OnMessage = recorder.FrameHook("kraken")
+func (r *Recorder) FrameHook(venue string) func(message []byte) {
+	return func(message []byte) {
+		r.write(Record{Time: time.Now().UTC(), Source: WsFrame, Subject: venue, Data: message})
+	}
+}
+
+// RecordNats records every message published on the given subjects, e.g.
+// "market.>" or "market_book.kraken.*".
+func (r *Recorder) RecordNats(natsClient *nats.Conn, subjects ...string) error {
+	for _, subject := range subjects {
+		subscription, err := natsClient.Subscribe(subject, func(msg *nats.Msg) {
+			r.write(Record{Time: time.Now().UTC(), Source: NatsMessage, Subject: msg.Subject, Data: msg.Data})
+		})
+		if err != nil {
+			return err
+		}
+
+		r.mutex.Lock()
+		r.subscriptions = append(r.subscriptions, subscription)
+		r.mutex.Unlock()
+	}
+
+	return nil
+}
+
+func (r *Recorder) write(record Record) {
+	if !json.Valid(record.Data) {
+		log.Printf("recorder: skipping non JSON %s message on %s\n", record.Source, record.Subject)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return
+	}
+	if err := r.encoder.Encode(record); err != nil {
+		log.Printf("recorder: unable to write record: %v\n", err)
+	}
+}
+
+// Flush writes buffered records to the file, the file stays readable up to
+// the last flush if the process dies.
+func (r *Recorder) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.gzip.Flush(); err != nil {
+		return err
+	}
+
+	return r.buffer.Flush()
+}
+
+// Close stops the NATS subscriptions and finishes the file.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	for _, subscription := range r.subscriptions {
+		subscription.Unsubscribe()
+	}
+
+	if err := r.gzip.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	if err := r.buffer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+
+	return r.file.Close()
+}
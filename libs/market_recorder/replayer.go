@@ -0,0 +1,233 @@
+package market_recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+)
+
+type ReplayConfig struct {
+	Speed float64 // 1 replays in real time, 10 ten times faster, 0 without any delay
+	Step  bool    // Wait for Advance before every record, Speed is ignored
+	Venue string  // Only replay websocket frames of this venue, all when empty
+}
+
+// Replayer plays back a recorded file in the recorded order, so a run fed
+// from the same file and configuration always sees the same input.
+type Replayer struct {
+	path   string
+	config ReplayConfig
+	step   chan struct{}
+}
+
+func NewReplayer(path string, config ReplayConfig) *Replayer {
+	return &Replayer{
+		path:   path,
+		config: config,
+		step:   make(chan struct{}),
+	}
+}
+
+// Advance releases the next record in step mode. It blocks until a running
+// replay takes it.
+func (r *Replayer) Advance() {
+	r.step <- struct{}{}
+}
+
+func (r *Replayer) wait(ctx context.Context, previous time.Time, current time.Time) error {
+	if r.config.Step {
+		select {
+		case <-r.step:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if r.config.Speed <= 0 || previous.IsZero() {
+		return ctx.Err()
+	}
+
+	delay := time.Duration(float64(current.Sub(previous)) / r.config.Speed)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// replay calls emit with every record of source, paced by the configuration.
+func (r *Replayer) replay(ctx context.Context, source Source, emit func(record Record) error) error {
+	reader, err := OpenReader(r.path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var previous time.Time
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if record.Source != source || (source == WsFrame && r.config.Venue != "" && record.Subject != r.config.Venue) {
+			continue
+		}
+
+		if err := r.wait(ctx, previous, record.Time); err != nil {
+			return err
+		}
+		previous = record.Time
+
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+}
+
+// Publish re-publishes the recorded NATS messages on their original subjects.
+// It returns once the whole file was replayed.
+func (r *Replayer) Publish(ctx context.Context, natsClient *nats.Conn) error {
+	err := r.replay(ctx, NatsMessage, func(record Record) error {
+		return natsClient.Publish(record.Subject, record.Data)
+	})
+	if err != nil {
+		return err
+	}
+
+	return natsClient.Flush()
+}
+
+// WsServer is a local websocket endpoint sending recorded frames, point
+// KrakenWsClientConfig.Url at Url. Requests sent by the client, such as
+// subscriptions, are read and ignored. If the client reconnects, the replay
+// continues where it stopped.
+type WsServer struct {
+	listener net.Listener
+	server   *http.Server
+	conns    chan *websocket.Conn
+	done     chan struct{}
+	mutex    sync.Mutex
+	err      error
+}
+
+// ServeWs starts a websocket server on a random local port and replays the
+// recorded frames to the connected client.
+func (r *Replayer) ServeWs(ctx context.Context) (*WsServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WsServer{
+		listener: listener,
+		conns:    make(chan *websocket.Conn),
+		done:     make(chan struct{}),
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	w.server = &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(rw, req, nil)
+		if err != nil {
+			log.Printf("replayer: upgrade failed: %v\n", err)
+			return
+		}
+
+		select {
+		case w.conns <- conn:
+		case <-w.done:
+			conn.Close()
+		}
+	})}
+
+	go func() {
+		if err := w.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("replayer websocket server stopped: %v\n", err)
+		}
+	}()
+
+	go func() {
+		defer close(w.done)
+
+		var conn *websocket.Conn
+		err := r.replay(ctx, WsFrame, func(record Record) error {
+			for {
+				if conn == nil {
+					select {
+					case conn = <-w.conns:
+						go discard(conn)
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+
+				if err := conn.WriteMessage(websocket.TextMessage, record.Data); err != nil {
+					conn.Close()
+					conn = nil
+					continue
+				}
+
+				return nil
+			}
+		})
+
+		w.mutex.Lock()
+		w.err = err
+		w.mutex.Unlock()
+	}()
+
+	return w, nil
+}
+
+func discard(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (w *WsServer) Url() string {
+	return "ws://" + w.listener.Addr().String()
+}
+
+// Done is closed when every frame was sent or the replay failed, see Err.
+func (w *WsServer) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *WsServer) Err() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.err
+}
+
+// Close stops the server and drops the connected client.
+func (w *WsServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return w.server.Shutdown(ctx)
+}
@@ -0,0 +1,161 @@
+package market_recorder
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// recordFrames records frames of kraken with the pauses before each, and a
+// frame of another venue after each, through the hooks of a Recorder.
+func recordFrames(t *testing.T, pauses []time.Duration) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "session.jsonl.gz")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kraken, bybit := recorder.FrameHook("kraken"), recorder.FrameHook("bybit")
+	for i, pause := range pauses {
+		time.Sleep(pause)
+		kraken([]byte(fmt.Sprintf(`{"seq":%d}`, i)))
+		bybit([]byte(fmt.Sprintf(`{"other":%d}`, i)))
+	}
+	kraken([]byte("not json")) // Skipped
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRecordedFramesReadBackInOrder(t *testing.T) {
+	path := recordFrames(t, []time.Duration{0, 0, 0})
+
+	reader, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{`{"seq":0}`, `{"other":0}`, `{"seq":1}`, `{"other":1}`, `{"seq":2}`, `{"other":2}`}
+	if len(records) != len(expected) {
+		t.Fatalf("%d records", len(records))
+	}
+	for i, record := range records {
+		if string(record.Data) != expected[i] || record.Source != WsFrame || (i > 0 && record.Time.Before(records[i-1].Time)) {
+			t.Fatalf("record %d = %+v", i, record)
+		}
+	}
+}
+
+func TestReplayKeepsOrderAndTiming(t *testing.T) {
+	pauses := []time.Duration{0, 60 * time.Millisecond, 120 * time.Millisecond}
+	path := recordFrames(t, pauses)
+
+	for _, speed := range []float64{1, 2} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, err := NewReplayer(path, ReplayConfig{Speed: speed, Venue: "kraken"}).ServeWs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial(server.Url(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		arrivals := []time.Time{}
+		for i := range pauses {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			arrivals = append(arrivals, time.Now())
+
+			if expected := fmt.Sprintf(`{"seq":%d}`, i); string(message) != expected {
+				t.Fatalf("speed %v: frame %d = %s, expected %s", speed, i, message, expected)
+			}
+		}
+
+		select {
+		case <-server.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("speed %v: replay not done", speed)
+		}
+		if err := server.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 1; i < len(pauses); i++ {
+			expected := time.Duration(float64(pauses[i]) / speed)
+			if gap := arrivals[i].Sub(arrivals[i-1]); gap < expected/2 || gap > expected+100*time.Millisecond {
+				t.Fatalf("speed %v: gap %d of %v, recorded %v", speed, i, gap, pauses[i])
+			}
+		}
+	}
+}
+
+func TestStepReplaysOneRecordPerAdvance(t *testing.T) {
+	path := recordFrames(t, []time.Duration{0, 0})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	replayer := NewReplayer(path, ReplayConfig{Step: true, Speed: 1})
+	server, err := replayer.ServeWs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(server.Url(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- string(message)
+		}
+	}()
+
+	for _, expected := range []string{`{"seq":0}`, `{"other":0}`, `{"seq":1}`, `{"other":1}`} {
+		select {
+		case message := <-messages:
+			t.Fatalf("%s sent without Advance", message)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		replayer.Advance()
+		select {
+		case message := <-messages:
+			if message != expected {
+				t.Fatalf("frame %s, expected %s", message, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not sent after Advance", expected)
+		}
+	}
+}